@@ -1,5 +1,9 @@
 package n
 
+import (
+	"unicode"
+)
+
 // Char wraps the Go rune providing a way to distinguish it from an int32
 // where as a rune is indistinguishable from an int32. Provides convenience
 // methods on par with rapid development languages.
@@ -85,3 +89,257 @@ func (p *Char) String() string {
 	}
 	return string(*p)
 }
+
+// Classification methods
+//--------------------------------------------------------------------------------------------------
+
+// IsDigit returns true if the char is a Unicode decimal digit.
+func (p *Char) IsDigit() bool {
+	return p != nil && unicode.IsDigit(rune(*p))
+}
+
+// IsHex returns true if the char is a hexadecimal digit i.e. 0-9, a-f or A-F.
+func (p *Char) IsHex() bool {
+	return p != nil && CharClassHex.Contains(rune(*p))
+}
+
+// IsIdentStart returns true if the char may start a Go identifier i.e. a letter or underscore.
+func (p *Char) IsIdentStart() bool {
+	return p != nil && (*p == '_' || unicode.IsLetter(rune(*p)))
+}
+
+// IsIdentPart returns true if the char may be part of a Go identifier after the first char
+// i.e. a letter, digit or underscore.
+func (p *Char) IsIdentPart() bool {
+	return p.IsIdentStart() || p.IsDigit()
+}
+
+// IsIDStart returns true if the char has the Unicode ID_Start property as defined by
+// UAX#31 i.e. a letter, letter number or other ID start char excluding pattern syntax
+// and pattern whitespace chars.
+func (p *Char) IsIDStart() bool {
+	if p == nil {
+		return false
+	}
+	r := rune(*p)
+	if unicode.In(r, unicode.Pattern_Syntax, unicode.Pattern_White_Space) {
+		return false
+	}
+	return unicode.In(r, unicode.L, unicode.Nl, unicode.Other_ID_Start)
+}
+
+// IsIDContinue returns true if the char has the Unicode ID_Continue property as defined by
+// UAX#31 i.e. an ID_Start char, non spacing or spacing combining mark, decimal number,
+// connector punctuation or other ID continue char.
+func (p *Char) IsIDContinue() bool {
+	if p == nil {
+		return false
+	}
+	r := rune(*p)
+	if unicode.In(r, unicode.Pattern_Syntax, unicode.Pattern_White_Space) {
+		return false
+	}
+	return p.IsIDStart() || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue)
+}
+
+// IsLetter returns true if the char is a Unicode letter.
+func (p *Char) IsLetter() bool {
+	return p != nil && unicode.IsLetter(rune(*p))
+}
+
+// IsLower returns true if the char is a Unicode lower case letter.
+func (p *Char) IsLower() bool {
+	return p != nil && unicode.IsLower(rune(*p))
+}
+
+// IsPunct returns true if the char is a Unicode punctuation char.
+func (p *Char) IsPunct() bool {
+	return p != nil && unicode.IsPunct(rune(*p))
+}
+
+// IsSpace returns true if the char is a Unicode white space char.
+func (p *Char) IsSpace() bool {
+	return p != nil && unicode.IsSpace(rune(*p))
+}
+
+// IsUpper returns true if the char is a Unicode upper case letter.
+func (p *Char) IsUpper() bool {
+	return p != nil && unicode.IsUpper(rune(*p))
+}
+
+// In returns true if the char is contained in any of the given char classes.
+func (p *Char) In(classes ...CharClass) bool {
+	if p == nil {
+		return false
+	}
+	for _, class := range classes {
+		if class.Contains(rune(*p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unicode property methods
+//--------------------------------------------------------------------------------------------------
+
+// Category returns the two letter Unicode general category of the char e.g. Lu, Nd or Zs.
+// Unassigned chars return Cn.
+func (p *Char) Category() string {
+	if p != nil {
+		r := rune(*p)
+		for name, table := range unicode.Categories {
+			// Skip the major categories and the LC cased letter grouping
+			if len(name) == 2 && name != "LC" && unicode.Is(table, r) {
+				return name
+			}
+		}
+	}
+	return "Cn"
+}
+
+// Script returns the name of the Unicode script the char belongs to e.g. Latin, Greek or
+// Han. Chars not belonging to a specific script return Unknown.
+func (p *Char) Script() string {
+	if p != nil {
+		r := rune(*p)
+		for name, table := range unicode.Scripts {
+			if unicode.Is(table, r) {
+				return name
+			}
+		}
+	}
+	return "Unknown"
+}
+
+// ToLower returns a new Char mapped to lower case.
+func (p *Char) ToLower() *Char {
+	if p == nil {
+		return NewCharV()
+	}
+	new := Char(unicode.ToLower(rune(*p)))
+	return &new
+}
+
+// ToTitle returns a new Char mapped to title case.
+func (p *Char) ToTitle() *Char {
+	if p == nil {
+		return NewCharV()
+	}
+	new := Char(unicode.ToTitle(rune(*p)))
+	return &new
+}
+
+// ToUpper returns a new Char mapped to upper case.
+func (p *Char) ToUpper() *Char {
+	if p == nil {
+		return NewCharV()
+	}
+	new := Char(unicode.ToUpper(rune(*p)))
+	return &new
+}
+
+// Width returns the number of monospace terminal columns needed to display the char.
+// Null, control, non spacing and enclosing marks and format chars are 0 columns wide,
+// East Asian wide and fullwidth chars are 2 columns wide and all others 1 column wide.
+func (p *Char) Width() int {
+	if p == nil || *p == 0 {
+		return 0
+	}
+	r := rune(*p)
+	switch {
+	case unicode.In(r, unicode.Cc, unicode.Mn, unicode.Me) || (unicode.Is(unicode.Cf, r) && r != 0x00AD):
+		return 0
+	case charClassWide.Contains(r):
+		return 2
+	}
+	return 1
+}
+
+// CharRange
+//--------------------------------------------------------------------------------------------------
+
+// CharRange provides an inclusive range of runes for declaring character classes
+// e.g. CharRange{'a', 'z'}
+type CharRange struct {
+	Lo rune // first rune in the range
+	Hi rune // last rune in the range
+}
+
+// Contains returns true if the given rune falls within the range
+func (x CharRange) Contains(r rune) bool {
+	return r >= x.Lo && r <= x.Hi
+}
+
+// CharClass provides a set of char ranges that can be used to declaratively describe
+// token classes for lexers e.g. CharClass{{'a', 'z'}, {'A', 'Z'}, {'_', '_'}}
+type CharClass []CharRange
+
+var (
+	// CharClassAlpha matches the ascii letters a-z and A-Z
+	CharClassAlpha = CharClass{{'A', 'Z'}, {'a', 'z'}}
+
+	// CharClassAlphaNum matches the ascii letters a-z, A-Z and digits 0-9
+	CharClassAlphaNum = CharClass{{'0', '9'}, {'A', 'Z'}, {'a', 'z'}}
+
+	// CharClassDigit matches the ascii digits 0-9
+	CharClassDigit = CharClass{{'0', '9'}}
+
+	// CharClassHex matches the hexadecimal digits 0-9, a-f and A-F
+	CharClassHex = CharClass{{'0', '9'}, {'A', 'F'}, {'a', 'f'}}
+
+	// CharClassSpace matches the ascii white space chars
+	CharClassSpace = CharClass{{'\t', '\r'}, {' ', ' '}}
+
+	// charClassWide matches the East Asian wide and fullwidth chars
+	charClassWide = CharClass{
+		{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC}, {0x23F0, 0x23F0},
+		{0x23F3, 0x23F3}, {0x25FD, 0x25FE}, {0x2614, 0x2615}, {0x2648, 0x2653}, {0x267F, 0x267F},
+		{0x2693, 0x2693}, {0x26A1, 0x26A1}, {0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5},
+		{0x26CE, 0x26CE}, {0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3}, {0x26F5, 0x26F5},
+		{0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705}, {0x270A, 0x270B}, {0x2728, 0x2728},
+		{0x274C, 0x274C}, {0x274E, 0x274E}, {0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797},
+		{0x27B0, 0x27B0}, {0x27BF, 0x27BF}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+		{0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF}, {0xA000, 0xA4CF},
+		{0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE10, 0xFE19}, {0xFE30, 0xFE6F},
+		{0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x16FE0, 0x16FE4}, {0x17000, 0x18CFF}, {0x1B000, 0x1B2FF},
+		{0x1F004, 0x1F004}, {0x1F0CF, 0x1F0CF}, {0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F200, 0x1F251},
+		{0x1F300, 0x1F64F}, {0x1F680, 0x1F6FF}, {0x1F7E0, 0x1F7EB}, {0x1F90C, 0x1F9FF}, {0x1FA70, 0x1FAFF},
+		{0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+	}
+)
+
+// NewCharClass creates a new char class from the given regex style bracket expression
+// content e.g. "a-zA-Z_" without the brackets. A literal '-' may be given first or last.
+func NewCharClass(spec string) (class CharClass) {
+	class = CharClass{}
+	runes := []rune(spec)
+	for i := 0; i < len(runes); i++ {
+		if i+2 < len(runes) && runes[i+1] == '-' {
+			class = append(class, CharRange{runes[i], runes[i+2]})
+			i += 2
+		} else {
+			class = append(class, CharRange{runes[i], runes[i]})
+		}
+	}
+	return
+}
+
+// Contains returns true if the given rune falls within any of the class's ranges
+func (x CharClass) Contains(r rune) bool {
+	for _, rng := range x {
+		if rng.Contains(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Union returns a new char class combining this class with the given classes
+func (x CharClass) Union(classes ...CharClass) (new CharClass) {
+	new = append(CharClass{}, x...)
+	for _, class := range classes {
+		new = append(new, class...)
+	}
+	return
+}
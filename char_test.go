@@ -95,3 +95,184 @@ func TestChar_String(t *testing.T) {
 		assert.Equal(t, "1", NewChar("1").String())
 	}
 }
+
+// Classification
+//--------------------------------------------------------------------------------------------------
+func ExampleChar_IsLetter() {
+	fmt.Println(NewChar('a').IsLetter())
+	// Output: true
+}
+
+func TestChar_Classification(t *testing.T) {
+
+	// nil
+	{
+		var char *Char
+		assert.False(t, char.IsDigit())
+		assert.False(t, char.IsHex())
+		assert.False(t, char.IsIdentStart())
+		assert.False(t, char.IsIdentPart())
+		assert.False(t, char.IsIDStart())
+		assert.False(t, char.IsIDContinue())
+		assert.False(t, char.IsLetter())
+		assert.False(t, char.IsLower())
+		assert.False(t, char.IsPunct())
+		assert.False(t, char.IsSpace())
+		assert.False(t, char.IsUpper())
+		assert.False(t, char.In(CharClassAlpha))
+	}
+
+	// letters, digits and hex
+	{
+		assert.True(t, NewChar('a').IsLetter())
+		assert.True(t, NewChar('ß').IsLetter())
+		assert.False(t, NewChar('1').IsLetter())
+		assert.True(t, NewChar('1').IsDigit())
+		assert.True(t, NewChar('٣').IsDigit())
+		assert.False(t, NewChar('a').IsDigit())
+		assert.True(t, NewChar('f').IsHex())
+		assert.True(t, NewChar('F').IsHex())
+		assert.True(t, NewChar('9').IsHex())
+		assert.False(t, NewChar('g').IsHex())
+		assert.True(t, NewChar('A').IsUpper())
+		assert.False(t, NewChar('a').IsUpper())
+		assert.True(t, NewChar('a').IsLower())
+	}
+
+	// space and punctuation
+	{
+		assert.True(t, NewChar(' ').IsSpace())
+		assert.True(t, NewChar('\t').IsSpace())
+		assert.True(t, NewChar(' ').IsSpace())
+		assert.False(t, NewChar('a').IsSpace())
+		assert.True(t, NewChar('!').IsPunct())
+		assert.True(t, NewChar('«').IsPunct())
+		assert.False(t, NewChar('+').IsPunct())
+	}
+
+	// go identifiers
+	{
+		assert.True(t, NewChar('_').IsIdentStart())
+		assert.True(t, NewChar('é').IsIdentStart())
+		assert.False(t, NewChar('1').IsIdentStart())
+		assert.True(t, NewChar('1').IsIdentPart())
+		assert.False(t, NewChar('-').IsIdentPart())
+	}
+
+	// unicode identifiers
+	{
+		assert.True(t, NewChar('a').IsIDStart())
+		assert.True(t, NewChar('Ⅻ').IsIDStart())
+		assert.True(t, NewChar('℘').IsIDStart())
+		assert.False(t, NewChar('_').IsIDStart())
+		assert.False(t, NewChar('1').IsIDStart())
+		assert.True(t, NewChar('_').IsIDContinue())
+		assert.True(t, NewChar('1').IsIDContinue())
+		assert.True(t, NewChar('́').IsIDContinue())
+		assert.False(t, NewChar('-').IsIDContinue())
+		assert.False(t, NewChar(' ').IsIDContinue())
+	}
+}
+
+// Unicode properties
+//--------------------------------------------------------------------------------------------------
+func ExampleChar_Script() {
+	fmt.Println(NewChar('λ').Script())
+	// Output: Greek
+}
+
+func TestChar_Category(t *testing.T) {
+	assert.Equal(t, "Cn", (*Char)(nil).Category())
+	assert.Equal(t, "Lu", NewChar('A').Category())
+	assert.Equal(t, "Ll", NewChar('a').Category())
+	assert.Equal(t, "Nd", NewChar('1').Category())
+	assert.Equal(t, "Zs", NewChar(' ').Category())
+	assert.Equal(t, "Po", NewChar('!').Category())
+	assert.Equal(t, "Sm", NewChar('+').Category())
+	assert.Equal(t, "Cc", NewChar('\n').Category())
+	assert.Equal(t, "Cn", NewChar('\U000E0080').Category())
+}
+
+func TestChar_Script(t *testing.T) {
+	assert.Equal(t, "Unknown", (*Char)(nil).Script())
+	assert.Equal(t, "Latin", NewChar('a').Script())
+	assert.Equal(t, "Greek", NewChar('λ').Script())
+	assert.Equal(t, "Cyrillic", NewChar('ж').Script())
+	assert.Equal(t, "Han", NewChar('中').Script())
+	assert.Equal(t, "Common", NewChar('1').Script())
+	assert.Equal(t, "Inherited", NewChar('́').Script())
+	assert.Equal(t, "Unknown", NewChar('\U000E0080').Script())
+}
+
+func TestChar_ToCase(t *testing.T) {
+	assert.Equal(t, NewCharV(), (*Char)(nil).ToUpper())
+	assert.Equal(t, NewCharV(), (*Char)(nil).ToLower())
+	assert.Equal(t, NewCharV(), (*Char)(nil).ToTitle())
+	assert.Equal(t, "A", NewChar('a').ToUpper().String())
+	assert.Equal(t, "a", NewChar('A').ToLower().String())
+	assert.Equal(t, "ǅ", NewChar('ǆ').ToTitle().String())
+	assert.Equal(t, "Ǆ", NewChar('ǆ').ToUpper().String())
+	assert.Equal(t, "1", NewChar('1').ToUpper().String())
+}
+
+func TestChar_Width(t *testing.T) {
+	assert.Equal(t, 0, (*Char)(nil).Width())
+	assert.Equal(t, 0, NewCharV().Width())
+	assert.Equal(t, 0, NewChar('\n').Width())
+	assert.Equal(t, 0, NewChar('́').Width())
+	assert.Equal(t, 0, NewChar('​').Width())
+	assert.Equal(t, 1, NewChar('a').Width())
+	assert.Equal(t, 1, NewChar('­').Width())
+	assert.Equal(t, 1, NewChar('λ').Width())
+	assert.Equal(t, 2, NewChar('中').Width())
+	assert.Equal(t, 2, NewChar('가').Width())
+	assert.Equal(t, 2, NewChar('Ａ').Width())
+	assert.Equal(t, 2, NewChar('😀').Width())
+}
+
+// CharClass
+//--------------------------------------------------------------------------------------------------
+func ExampleCharClass_Contains() {
+	ident := NewCharClass("a-zA-Z_")
+	fmt.Println(ident.Contains('_'))
+	// Output: true
+}
+
+func TestCharClass(t *testing.T) {
+
+	// range
+	{
+		rng := CharRange{'a', 'c'}
+		assert.True(t, rng.Contains('a'))
+		assert.True(t, rng.Contains('c'))
+		assert.False(t, rng.Contains('d'))
+	}
+
+	// parse class
+	{
+		assert.Equal(t, CharClass{}, NewCharClass(""))
+		assert.Equal(t, CharClass{{'a', 'z'}, {'A', 'Z'}, {'_', '_'}}, NewCharClass("a-zA-Z_"))
+		assert.Equal(t, CharClass{{'-', '-'}, {'a', 'a'}, {'-', '-'}}, NewCharClass("-a-"))
+	}
+
+	// contains
+	{
+		ident := NewCharClass("a-zA-Z_")
+		assert.True(t, ident.Contains('q'))
+		assert.True(t, ident.Contains('Q'))
+		assert.False(t, ident.Contains('1'))
+		assert.False(t, CharClass{}.Contains('a'))
+		assert.True(t, CharClassSpace.Contains('\n'))
+		assert.False(t, CharClassSpace.Contains('a'))
+	}
+
+	// union and char membership
+	{
+		ident := CharClassAlpha.Union(CharClass{{'_', '_'}}, CharClassDigit)
+		assert.Len(t, CharClassAlpha, 2)
+		assert.True(t, NewChar('_').In(ident))
+		assert.True(t, NewChar('7').In(ident))
+		assert.False(t, NewChar('-').In(ident))
+		assert.True(t, NewChar('-').In(CharClassAlpha, NewCharClass("-")))
+	}
+}
@@ -1899,6 +1899,12 @@ func ToDurationE(obj interface{}) (val time.Duration, err error) {
 		val = time.Duration(ToInt64(x))
 	case float32, float64:
 		val = time.Duration(ToFloat64(x))
+	case Str:
+		return ToDurationE(string(x))
+	case string:
+		if val, err = time.ParseDuration(x); err != nil {
+			err = errors.Wrapf(err, "failed to convert string to time.Duration")
+		}
 	default:
 		err = errors.Errorf("failed to convert type %T to time.Duration", obj)
 	}
@@ -4762,6 +4768,11 @@ func ToStringMapE(obj interface{}) (val *StringMap, err error) {
 			val = x
 		}
 
+	// types exporting themselves as a map e.g. sys.Process
+	//----------------------------------------------------------------------------------------------
+	case interface{ ToMap() map[string]interface{} }:
+		val, err = ToStringMapE(x.ToMap())
+
 	// fall back on reflection
 	//----------------------------------------------------------------------------------------------
 	default:
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/phR0ze/n/pkg/sys"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -1551,6 +1552,52 @@ func TestToChar(t *testing.T) {
 	}
 }
 
+// ToDurationE
+// --------------------------------------------------------------------------------------------------
+func ExampleToDurationE() {
+	fmt.Println(ToDurationE("1m30s"))
+	// Output: 1m30s <nil>
+}
+
+func TestToDurationE(t *testing.T) {
+
+	// invalid
+	{
+		val, err := ToDurationE(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), val)
+
+		val, err = ToDurationE("bogus")
+		assert.Equal(t, `failed to convert string to time.Duration: time: invalid duration "bogus"`, err.Error())
+		assert.Equal(t, time.Duration(0), val)
+
+		val, err = ToDurationE(true)
+		assert.Equal(t, "failed to convert type bool to time.Duration", err.Error())
+	}
+
+	// numbers
+	{
+		val, err := ToDurationE(5)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(5), val)
+
+		val, err = ToDurationE(time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Second, val)
+	}
+
+	// strings
+	{
+		val, err := ToDurationE("1m30s")
+		assert.Nil(t, err)
+		assert.Equal(t, 90*time.Second, val)
+
+		val, err = ToDurationE(NewStr("250ms"))
+		assert.Nil(t, err)
+		assert.Equal(t, 250*time.Millisecond, val)
+	}
+}
+
 // ToFloat32
 // --------------------------------------------------------------------------------------------------
 func ExampleToFloat32() {
@@ -3839,6 +3886,13 @@ func ExampleToStringMapE() {
 
 func TestToStringMapE(t *testing.T) {
 
+	// types exporting themselves as a map
+	{
+		val, err := ToStringMapE(&sys.LoadAvg{One: 1.5, Running: 2, Entities: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"one": 1.5, "five": float64(0), "fifteen": float64(0), "running": 2, "entities": 10}, val.G())
+	}
+
 	// map[string]uint64
 	{
 		val, err := ToStringMapE(map[string]uint64{"1": uint64(1)})
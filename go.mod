@@ -8,6 +8,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.9.0
 	github.com/valyala/bytebufferpool v1.0.0
+	golang.org/x/crypto v0.23.0
 	golang.org/x/sys v0.20.0
 )
 
@@ -27,6 +27,7 @@ func If(state bool, err ...error) *IF {
 // state of the current Do execution returning all return values from the function
 // given to Do in the IF.Return slice and the first identified return bool will be
 // used for IF.State and the first identified return error will be used for IF.Error.
+// Signatures are only validated at runtime, see Try and Then for a typed alternative.
 func (cond *IF) Do(f interface{}, params ...interface{}) *IF {
 	if !cond.State {
 		return cond
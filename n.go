@@ -65,6 +65,8 @@ import (
 	"encoding/json"
 	"os"
 
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/phR0ze/n/pkg/sys"
 	yaml "github.com/phR0ze/yaml/v2"
 	"github.com/pkg/errors"
 )
@@ -243,3 +245,42 @@ func YAMLArray(obj interface{}) bool {
 		return false
 	}
 }
+
+// Filesystem helper functions
+//--------------------------------------------------------------------------------------------------
+
+// CompareTrees compares the trees rooted at a and b returning a *SliceOfMap with an entry per
+// path that differs. Pass through to sys.CompareTrees see it for options and entry keys.
+func CompareTrees(a, b string, opts ...*opt.Opt) (slice *SliceOfMap) {
+	slice, _ = CompareTreesE(a, b, opts...)
+	return
+}
+
+// CompareTreesE compares the trees rooted at a and b returning a *SliceOfMap with an entry per
+// path that differs. Pass through to sys.CompareTrees see it for options and entry keys.
+func CompareTreesE(a, b string, opts ...*opt.Opt) (slice *SliceOfMap, err error) {
+	slice = NewSliceOfMapV()
+	var result []map[string]interface{}
+	if result, err = sys.CompareTrees(a, b, opts...); err != nil {
+		return
+	}
+	return ToSliceOfMapE(result)
+}
+
+// FindDuplicates finds the files under the given roots with identical content returning a
+// *SliceOfMap with an entry per group of duplicates. Pass through to sys.FindDuplicates.
+func FindDuplicates(roots ...string) (slice *SliceOfMap) {
+	slice, _ = FindDuplicatesE(roots...)
+	return
+}
+
+// FindDuplicatesE finds the files under the given roots with identical content returning a
+// *SliceOfMap with an entry per group of duplicates. Pass through to sys.FindDuplicates.
+func FindDuplicatesE(roots ...string) (slice *SliceOfMap, err error) {
+	slice = NewSliceOfMapV()
+	var result []map[string]interface{}
+	if result, err = sys.FindDuplicates(roots...); err != nil {
+		return
+	}
+	return ToSliceOfMapE(result)
+}
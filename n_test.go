@@ -2,6 +2,7 @@ package n
 
 import (
 	"fmt"
+	"path"
 	"testing"
 
 	"github.com/phR0ze/n/pkg/sys"
@@ -109,6 +110,43 @@ func TestRange(t *testing.T) {
 	assert.Equal(t, []int{3, 4, 5, 6, 7, 8}, Range(3, 8))
 }
 
+func TestCompareTrees(t *testing.T) {
+	clearTmpDir()
+	sys.MkdirP(path.Join(tmpDir, "a"))
+	sys.MkdirP(path.Join(tmpDir, "b"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "a", "same"), "same"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "b", "same"), "same"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "a", "diff"), "a"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "b", "diff"), "b"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "b", "new"), "b"))
+
+	result, err := CompareTreesE(path.Join(tmpDir, "a"), path.Join(tmpDir, "b"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Len())
+	assert.Equal(t, "diff", result.First().ToStringMap().Get("path").A())
+	assert.Equal(t, "content", result.First().ToStringMap().Get("reason").A())
+	assert.Equal(t, []string{"new"}, result.Select(func(x O) bool {
+		return x.(*StringMap).Get("status").A() == sys.TreeOnlyB
+	}).Map(func(x O) O { return x.(*StringMap).Get("path").A() }).ToStrs())
+
+	assert.Equal(t, 0, CompareTrees(path.Join(tmpDir, "bogus"), path.Join(tmpDir, "b")).Len())
+}
+
+func TestFindDuplicates(t *testing.T) {
+	clearTmpDir()
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "a"), "dupe"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "b"), "dupe"))
+	assert.NoError(t, sys.WriteString(path.Join(tmpDir, "c"), "diff"))
+
+	result, err := FindDuplicatesE(tmpDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Len())
+	paths := result.First().ToStringMap().Get("paths").ToStrs()
+	assert.Equal(t, []string{"a", "b"}, []string{path.Base(paths[0]), path.Base(paths[1])})
+
+	assert.Equal(t, 0, FindDuplicates(path.Join(tmpDir, "bogus")).Len())
+}
+
 func TestLoadJSON(t *testing.T) {
 	clearTmpDir()
 
@@ -4,7 +4,10 @@
 // properties with the values of the property names. In this way we can mimic the
 // ability to initialize nested consts available in other languages. It is based
 // on reflection so does have a performance penalty however it is meant to be
-// used as a global var or initialized with init for a one time cost
+// used as a global var or initialized with init for a one time cost.
+//
+// structs also provides tag driven defaults, environment binding and validation as well as
+// utilities for walking, copying, diffing and flattening nested structs by field path.
 package structs
 
 import (
@@ -0,0 +1,461 @@
+package structs
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/phR0ze/n"
+	"github.com/pkg/errors"
+)
+
+var (
+	gDurationType = reflect.TypeOf(time.Duration(0))
+	gTimeType     = reflect.TypeOf(time.Time{})
+)
+
+// FieldError provides details about a single validation failure
+type FieldError struct {
+	Path    string // Dotted path to the field e.g. DB.Servers[0].Port
+	Rule    string // Validation rule that failed e.g. min=1
+	Message string // Human readable description of the failure
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors provides all the validation failures found by Validate
+type ValidationErrors []*FieldError
+
+// Error implements the error interface
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, x := range e {
+		msgs = append(msgs, x.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Defaults sets fields tagged with `default:"..."` to the tag's value when the field is
+// currently its zero value; errors if obj is not a non nil pointer to a struct. Values are
+// converted to the field's type using n's conversion functions. Slices are given as comma
+// separated values e.g. `default:"a,b"` and maps as comma separated key=value pairs e.g.
+// `default:"a=1,b=2"`. Nested structs, pointers, slices and maps of structs are recursed
+// into and nil pointers to structs are allocated only if a default was applied within them.
+func Defaults(obj interface{}) (err error) {
+	var v reflect.Value
+	if v, err = structPtr(obj, "Defaults"); err != nil {
+		return
+	}
+	_, err = defaults(v, "")
+	return
+}
+
+// defaults applies default tags to the given struct value returning true if any were set
+func defaults(v reflect.Value, path string) (set bool, err error) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field, fv := typ.Field(i), v.Field(i)
+		if !fv.CanSet() {
+			continue
+		}
+		fpath := joinPath(path, field.Name)
+
+		// Apply the default to zero value fields
+		if tag, ok := field.Tag.Lookup("default"); ok && fv.IsZero() {
+			if err = setString(fv, tag); err != nil {
+				err = errors.Wrapf(err, "failed to set default for %s", fpath)
+				return
+			}
+			set = true
+		}
+
+		// Recurse on nested types
+		var nested bool
+		if nested, err = recurse(fv, fpath, true, defaults); err != nil {
+			return
+		}
+		set = set || nested
+	}
+	return
+}
+
+// FromEnv sets fields tagged with `env:"NAME"` to the value of the environment variable
+// prefix_NAME when it exists; errors if obj is not a non nil pointer to a struct. The prefix
+// is optional and values are converted the same way as Defaults. Nested structs with an env
+// tag extend the prefix for their fields e.g. DB with `env:"DB"` and Port with `env:"PORT"`
+// under prefix APP reads APP_DB_PORT. Nil pointers to structs are allocated only if a
+// variable was found for one of their fields.
+func FromEnv(obj interface{}, prefix string) (err error) {
+	var v reflect.Value
+	if v, err = structPtr(obj, "FromEnv"); err != nil {
+		return
+	}
+	_, err = fromEnv(v, "", prefix)
+	return
+}
+
+// fromEnv applies env tags to the given struct value returning true if any were set
+func fromEnv(v reflect.Value, path, prefix string) (set bool, err error) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field, fv := typ.Field(i), v.Field(i)
+		if !fv.CanSet() {
+			continue
+		}
+		fpath := joinPath(path, field.Name)
+		tag, tagged := field.Tag.Lookup("env")
+
+		// Nested structs extend the prefix rather than being set directly
+		if isStruct(fv.Type()) {
+			nprefix := prefix
+			if tagged && tag != "" {
+				nprefix = joinEnv(prefix, tag)
+			}
+			var nested bool
+			if nested, err = recurse(fv, fpath, true, func(x reflect.Value, p string) (bool, error) {
+				return fromEnv(x, p, nprefix)
+			}); err != nil {
+				return
+			}
+			set = set || nested
+			continue
+		}
+
+		if !tagged || tag == "" {
+			continue
+		}
+		name := joinEnv(prefix, tag)
+		if val, ok := os.LookupEnv(name); ok {
+			if err = setString(fv, val); err != nil {
+				err = errors.Wrapf(err, "failed to set %s from env var %s", fpath, name)
+				return
+			}
+			set = true
+		}
+	}
+	return
+}
+
+// Validate checks fields tagged with `validate:"..."` against their comma separated rules
+// returning a ValidationErrors listing every violation with its field path or nil if valid;
+// errors if obj is not a struct or pointer to a struct. Nested structs, pointers, slices and
+// maps are validated recursively. Supported rules:
+// * required - field must not be its zero value, nil or empty
+// * min=N - numbers must be >= N, strings, slices and maps must have a length >= N
+// * max=N - numbers must be <= N, strings, slices and maps must have a length <= N
+// * len=N - strings, slices and maps must have a length of exactly N
+// * oneof=a b c - field's string value must be one of the space separated values
+func Validate(obj interface{}) (err error) {
+	var v reflect.Value
+	if v, err = structVal(obj, "Validate"); err != nil {
+		return
+	}
+
+	errs := ValidationErrors{}
+	validate(v, "", &errs)
+	if len(errs) > 0 {
+		err = errs
+	}
+	return
+}
+
+// validate the given struct value appending any violations to errs
+func validate(v reflect.Value, path string, errs *ValidationErrors) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field, fv := typ.Field(i), v.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fpath := joinPath(path, field.Name)
+		if tag := field.Tag.Get("validate"); tag != "" {
+			for _, rule := range strings.Split(tag, ",") {
+				if msg := checkRule(fv, rule); msg != "" {
+					*errs = append(*errs, &FieldError{Path: fpath, Rule: rule, Message: msg})
+				}
+			}
+		}
+		recurse(fv, fpath, false, func(x reflect.Value, p string) (bool, error) {
+			validate(x, p, errs)
+			return false, nil
+		})
+	}
+}
+
+// checkRule checks the given value against the rule returning a message if it fails
+func checkRule(v reflect.Value, rule string) (msg string) {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i != -1 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	// Required is the only rule that applies to nil pointers
+	if name == "required" {
+		if v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+			msg = "is required"
+		}
+		return
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch name {
+	case "min", "max", "len":
+		limit, err := n.ToFloat64E(arg)
+		if err != nil {
+			return fmt.Sprintf("invalid rule %s", rule)
+		}
+
+		// Numbers compare by value and everything else by length
+		var val float64
+		isLen := true
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			val, isLen = float64(v.Int()), false
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			val, isLen = float64(v.Uint()), false
+		case reflect.Float32, reflect.Float64:
+			val, isLen = v.Float(), false
+		case reflect.String:
+			val = float64(utf8.RuneCountInString(v.String()))
+		case reflect.Slice, reflect.Array, reflect.Map:
+			val = float64(v.Len())
+		default:
+			return fmt.Sprintf("rule %s is not supported for type %v", rule, v.Type())
+		}
+
+		switch {
+		case name == "len" && !isLen:
+			msg = fmt.Sprintf("rule %s is not supported for type %v", rule, v.Type())
+		case name == "len" && val != limit:
+			msg = fmt.Sprintf("must have a length of %s", arg)
+		case name == "min" && val < limit && isLen:
+			msg = fmt.Sprintf("must have a length of at least %s", arg)
+		case name == "min" && val < limit:
+			msg = fmt.Sprintf("must be at least %s", arg)
+		case name == "max" && val > limit && isLen:
+			msg = fmt.Sprintf("must have a length of at most %s", arg)
+		case name == "max" && val > limit:
+			msg = fmt.Sprintf("must be at most %s", arg)
+		}
+
+	case "oneof":
+		options := strings.Fields(arg)
+		val := n.ToString(v.Interface())
+		for _, option := range options {
+			if val == option {
+				return
+			}
+		}
+		msg = fmt.Sprintf("must be one of [%s]", strings.Join(options, " "))
+
+	default:
+		msg = fmt.Sprintf("unknown rule %s", rule)
+	}
+	return
+}
+
+// recurse calls the given function for any structs nested in the given value including
+// those behind pointers and in slices, arrays and maps in a deterministic order. Nil pointers
+// to structs are skipped unless alloc is true in which case they are allocated and kept only
+// if the function returns true.
+func recurse(v reflect.Value, path string, alloc bool, f func(reflect.Value, string) (bool, error)) (set bool, err error) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() != gTimeType {
+			return f(v, path)
+		}
+
+	case reflect.Ptr:
+		if !isStruct(v.Type()) {
+			return
+		}
+		if !v.IsNil() {
+			return f(v.Elem(), path)
+		}
+		if alloc && v.CanSet() {
+			x := reflect.New(v.Type().Elem())
+			if set, err = f(x.Elem(), path); set && err == nil {
+				v.Set(x)
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		if !isStruct(v.Type().Elem()) {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			var nested bool
+			if nested, err = recurse(v.Index(i), fmt.Sprintf("%s[%d]", path, i), alloc, f); err != nil {
+				return
+			}
+			set = set || nested
+		}
+
+	case reflect.Map:
+		if !isStruct(v.Type().Elem()) {
+			return
+		}
+
+		// Map values aren't addressable so work on a copy and set it back
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			x := reflect.New(v.Type().Elem()).Elem()
+			x.Set(v.MapIndex(key))
+			var nested bool
+			if nested, err = recurse(x, fmt.Sprintf("%s[%v]", path, key), alloc, f); err != nil {
+				return
+			}
+			if nested {
+				v.SetMapIndex(key, x)
+				set = true
+			}
+		}
+	}
+	return
+}
+
+// setString converts the given string to the value's type and sets it
+func setString(v reflect.Value, str string) (err error) {
+	typ := v.Type()
+	switch {
+	case typ == gDurationType:
+		var x time.Duration
+		if x, err = n.ToDurationE(str); err == nil {
+			v.SetInt(int64(x))
+		}
+		return
+	case typ == gTimeType:
+		var x time.Time
+		if x, err = n.ToTimeE(str); err == nil {
+			v.Set(reflect.ValueOf(x))
+		}
+		return
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		x := reflect.New(typ.Elem())
+		if err = setString(x.Elem(), str); err == nil {
+			v.Set(x)
+		}
+	case reflect.Bool:
+		var x bool
+		if x, err = n.ToBoolE(str); err == nil {
+			v.SetBool(x)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var x int64
+		if x, err = n.ToInt64E(str); err == nil {
+			if v.OverflowInt(x) {
+				err = errors.Errorf("value %s overflows %v", str, typ)
+				return
+			}
+			v.SetInt(x)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var x uint64
+		if x, err = n.ToUint64E(str); err == nil {
+			if v.OverflowUint(x) {
+				err = errors.Errorf("value %s overflows %v", str, typ)
+				return
+			}
+			v.SetUint(x)
+		}
+	case reflect.Float32, reflect.Float64:
+		var x float64
+		if x, err = n.ToFloat64E(str); err == nil {
+			v.SetFloat(x)
+		}
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Slice:
+		x := reflect.MakeSlice(typ, 0, 0)
+		for _, item := range splitList(str) {
+			elem := reflect.New(typ.Elem()).Elem()
+			if err = setString(elem, item); err != nil {
+				return
+			}
+			x = reflect.Append(x, elem)
+		}
+		v.Set(x)
+	case reflect.Map:
+		x := reflect.MakeMap(typ)
+		for _, item := range splitList(str) {
+			pair := strings.SplitN(item, "=", 2)
+			if len(pair) != 2 {
+				err = errors.Errorf("invalid map entry %s, expected key=value", item)
+				return
+			}
+			key, val := reflect.New(typ.Key()).Elem(), reflect.New(typ.Elem()).Elem()
+			if err = setString(key, strings.TrimSpace(pair[0])); err != nil {
+				return
+			}
+			if err = setString(val, strings.TrimSpace(pair[1])); err != nil {
+				return
+			}
+			x.SetMapIndex(key, val)
+		}
+		v.Set(x)
+	default:
+		err = errors.Errorf("unsupported field type %v", typ)
+	}
+	return
+}
+
+// isStruct returns true if the given type is a struct or pointer to a struct other than time.Time
+func isStruct(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Struct && typ != gTimeType
+}
+
+// joinEnv joins the prefix and name with an underscore if the prefix is not empty
+func joinEnv(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// joinPath joins the path and name with a dot if the path is not empty
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// splitList splits the comma separated list trimming whitespace and dropping empty values
+func splitList(str string) (result []string) {
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return
+}
+
+// structPtr returns the struct value for the given pointer to a struct or an error
+func structPtr(obj interface{}, caller string) (v reflect.Value, err error) {
+	v = reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		err = errors.Errorf("structs.%s requires a non nil pointer to a struct not a %T", caller, obj)
+		return
+	}
+	v = v.Elem()
+	return
+}
@@ -0,0 +1,216 @@
+package structs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TagsDB struct {
+	Host    string        `default:"localhost" env:"HOST" validate:"required"`
+	Port    int           `default:"5432" env:"PORT" validate:"min=1,max=65535"`
+	Timeout time.Duration `default:"5s" env:"TIMEOUT"`
+}
+
+type TagsConfig struct {
+	Name     string            `default:"app" env:"NAME" validate:"required,min=3"`
+	Mode     string            `default:"dev" env:"MODE" validate:"oneof=dev prod"`
+	Debug    bool              `default:"true" env:"DEBUG"`
+	Ratio    float64           `default:"0.5" env:"RATIO" validate:"max=1"`
+	Tags     []string          `default:"a,b" env:"TAGS" validate:"max=3"`
+	Labels   map[string]int    `default:"x=1,y=2" env:"LABELS"`
+	Level    *int              `default:"3" env:"LEVEL"`
+	DB       TagsDB            `env:"DB"`
+	Backup   *TagsDB           `env:"BACKUP"`
+	Replicas []TagsDB          `validate:"len=2"`
+	Shards   map[string]TagsDB `validate:"required"`
+	private  string            `default:"private"`
+}
+
+func TestDefaults(t *testing.T) {
+
+	// invalid types
+	{
+		assert.Equal(t, "structs.Defaults requires a non nil pointer to a struct not a structs.TagsConfig", Defaults(TagsConfig{}).Error())
+		assert.Equal(t, "structs.Defaults requires a non nil pointer to a struct not a *structs.TagsConfig", Defaults((*TagsConfig)(nil)).Error())
+		assert.Equal(t, "structs.Defaults requires a non nil pointer to a struct not a string", Defaults("foo").Error())
+	}
+
+	// zero values get defaults
+	{
+		cfg := &TagsConfig{Replicas: []TagsDB{{Port: 1}, {}}, Shards: map[string]TagsDB{"a": {Host: "shard"}}}
+		assert.Nil(t, Defaults(cfg))
+		assert.Equal(t, "app", cfg.Name)
+		assert.Equal(t, "dev", cfg.Mode)
+		assert.Equal(t, true, cfg.Debug)
+		assert.Equal(t, 0.5, cfg.Ratio)
+		assert.Equal(t, []string{"a", "b"}, cfg.Tags)
+		assert.Equal(t, map[string]int{"x": 1, "y": 2}, cfg.Labels)
+		assert.Equal(t, 3, *cfg.Level)
+		assert.Equal(t, TagsDB{Host: "localhost", Port: 5432, Timeout: 5 * time.Second}, cfg.DB)
+		assert.Equal(t, &TagsDB{Host: "localhost", Port: 5432, Timeout: 5 * time.Second}, cfg.Backup)
+		assert.Equal(t, TagsDB{Host: "localhost", Port: 1, Timeout: 5 * time.Second}, cfg.Replicas[0])
+		assert.Equal(t, TagsDB{Host: "localhost", Port: 5432, Timeout: 5 * time.Second}, cfg.Replicas[1])
+		assert.Equal(t, TagsDB{Host: "shard", Port: 5432, Timeout: 5 * time.Second}, cfg.Shards["a"])
+		assert.Equal(t, "", cfg.private)
+	}
+
+	// existing values are retained
+	{
+		cfg := &TagsConfig{Name: "foo", DB: TagsDB{Port: 1}}
+		assert.Nil(t, Defaults(cfg))
+		assert.Equal(t, "foo", cfg.Name)
+		assert.Equal(t, 1, cfg.DB.Port)
+	}
+
+	// nil pointers without defaults are left nil
+	{
+		type Foo struct{ Bar *struct{ Name string } }
+		foo := &Foo{}
+		assert.Nil(t, Defaults(foo))
+		assert.Nil(t, foo.Bar)
+	}
+
+	// conversion failures
+	{
+		type Foo struct {
+			Port int `default:"bob"`
+		}
+		err := Defaults(&Foo{})
+		assert.Equal(t, `failed to set default for Port: failed to convert string to int64: strconv.ParseInt: parsing "bob": invalid syntax`, err.Error())
+
+		type Bar struct {
+			Port int8 `default:"1000"`
+		}
+		assert.Equal(t, "failed to set default for Port: value 1000 overflows int8", Defaults(&Bar{}).Error())
+
+		type Baz struct {
+			Labels map[string]int `default:"a"`
+		}
+		assert.Equal(t, "failed to set default for Labels: invalid map entry a, expected key=value", Defaults(&Baz{}).Error())
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	// invalid types
+	{
+		assert.Equal(t, "structs.FromEnv requires a non nil pointer to a struct not a int", FromEnv(1, "").Error())
+	}
+
+	// bind with prefix
+	{
+		t.Setenv("APP_NAME", "foo")
+		t.Setenv("APP_DEBUG", "true")
+		t.Setenv("APP_TAGS", "x, y,z")
+		t.Setenv("APP_LEVEL", "7")
+		t.Setenv("APP_DB_HOST", "db")
+		t.Setenv("APP_DB_TIMEOUT", "1m")
+		t.Setenv("APP_BACKUP_PORT", "6543")
+		t.Setenv("NAME", "ignored")
+
+		cfg := &TagsConfig{Mode: "prod"}
+		assert.Nil(t, FromEnv(cfg, "APP"))
+		assert.Equal(t, "foo", cfg.Name)
+		assert.Equal(t, "prod", cfg.Mode)
+		assert.Equal(t, true, cfg.Debug)
+		assert.Equal(t, []string{"x", "y", "z"}, cfg.Tags)
+		assert.Equal(t, 7, *cfg.Level)
+		assert.Equal(t, TagsDB{Host: "db", Timeout: time.Minute}, cfg.DB)
+		assert.Equal(t, &TagsDB{Port: 6543}, cfg.Backup)
+	}
+
+	// bind without prefix and nil pointers without vars are left nil
+	{
+		t.Setenv("NAME", "bar")
+		cfg := &TagsConfig{}
+		assert.Nil(t, FromEnv(cfg, ""))
+		assert.Equal(t, "bar", cfg.Name)
+		assert.Nil(t, cfg.Backup)
+	}
+
+	// conversion failures
+	{
+		t.Setenv("DB_PORT", "bob")
+		err := FromEnv(&TagsConfig{}, "")
+		assert.Equal(t, `failed to set DB.Port from env var DB_PORT: failed to convert string to int64: strconv.ParseInt: parsing "bob": invalid syntax`, err.Error())
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	// invalid types
+	{
+		assert.Equal(t, "structs.Validate requires a non nil struct type not a *structs.TagsConfig", Validate((*TagsConfig)(nil)).Error())
+		assert.Equal(t, "structs.Validate requires a non nil struct type not a string", Validate("foo").Error())
+	}
+
+	// valid
+	{
+		cfg := TagsConfig{Replicas: []TagsDB{{}, {}}, Shards: map[string]TagsDB{"a": {}}}
+		assert.Nil(t, Defaults(&cfg))
+		assert.Nil(t, Validate(cfg))
+		assert.Nil(t, Validate(&cfg))
+	}
+
+	// every violation is reported with its path
+	{
+		cfg := &TagsConfig{
+			Name:     "ab",
+			Mode:     "test",
+			Ratio:    1.5,
+			Tags:     []string{"a", "b", "c", "d"},
+			DB:       TagsDB{Port: 70000},
+			Backup:   &TagsDB{Host: "backup", Port: -1},
+			Replicas: []TagsDB{{Host: "a", Port: 1}},
+			Shards:   map[string]TagsDB{"b": {Port: 1}, "a": {Host: "a"}},
+		}
+		err := Validate(cfg)
+		assert.NotNil(t, err)
+		errs, ok := err.(ValidationErrors)
+		assert.True(t, ok)
+
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		assert.Equal(t, []string{"Name", "Mode", "Ratio", "Tags", "DB.Host", "DB.Port", "Backup.Port",
+			"Replicas", "Shards[a].Port", "Shards[b].Host"}, paths)
+		assert.Equal(t, "min=3", errs[0].Rule)
+		assert.Equal(t, "Name: must have a length of at least 3", errs[0].Error())
+		assert.Equal(t, "Mode: must be one of [dev prod]", errs[1].Error())
+		assert.Equal(t, "Ratio: must be at most 1", errs[2].Error())
+		assert.Equal(t, "Tags: must have a length of at most 3", errs[3].Error())
+		assert.Equal(t, "DB.Host: is required", errs[4].Error())
+		assert.Equal(t, "DB.Port: must be at most 65535", errs[5].Error())
+		assert.Equal(t, "Backup.Port: must be at least 1", errs[6].Error())
+		assert.Equal(t, "Replicas: must have a length of 2", errs[7].Error())
+		assert.Equal(t, "Name: must have a length of at least 3; Mode: must be one of [dev prod]",
+			ValidationErrors(errs[:2]).Error())
+	}
+
+	// required on pointers, slices and maps
+	{
+		type Foo struct {
+			Ptr   *int           `validate:"required"`
+			Slice []string       `validate:"required"`
+			Map   map[string]int `validate:"required"`
+			Min   *int           `validate:"min=1"`
+		}
+		err := Validate(Foo{Slice: []string{}})
+		assert.Equal(t, "Ptr: is required; Slice: is required; Map: is required", err.Error())
+	}
+
+	// invalid rules
+	{
+		type Foo struct {
+			A int    `validate:"min=bob"`
+			B int    `validate:"len=1"`
+			C bool   `validate:"max=1"`
+			D string `validate:"bogus"`
+		}
+		err := Validate(Foo{})
+		assert.Equal(t, "A: invalid rule min=bob; B: rule len=1 is not supported for type int; "+
+			"C: rule max=1 is not supported for type bool; D: unknown rule bogus", err.Error())
+	}
+}
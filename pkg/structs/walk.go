@@ -0,0 +1,453 @@
+package structs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phR0ze/n"
+	"github.com/pkg/errors"
+)
+
+// SkipStruct is used as a return value from a WalkFunc to indicate that the nested struct
+// named in the call is to be skipped. It is not returned as an error by any function.
+var SkipStruct = errors.New("skip this struct")
+
+// WalkFunc is the type of the function called by Walk for each exported field visited. The
+// path is the dotted path to the field e.g. DB.Servers[0].Port and v is the field's value.
+type WalkFunc func(path string, field reflect.StructField, v reflect.Value) error
+
+// Change provides details about a single field that differs between two structs
+type Change struct {
+	Path string      // Dotted path to the field e.g. DB.Servers[0].Port
+	Old  interface{} // Value of the field in the original struct or nil if it didn't exist
+	New  interface{} // Value of the field in the new struct or nil if it doesn't exist
+}
+
+// String returns a human readable description of the change suitable for audit logs
+func (c *Change) String() string {
+	return fmt.Sprintf("%s: %v => %v", c.Path, c.Old, c.New)
+}
+
+// Walk calls fn for every exported field of the given struct in field order, recursing into
+// nested structs including those behind pointers and in slices, arrays and maps; errors if obj
+// is not a struct or pointer to a struct. Map entries are visited in sorted key order and are
+// copies so changes to them are not retained. Nil pointers are not recursed into. If fn returns
+// SkipStruct for a nested struct field its fields are not visited, any other error stops the
+// walk and is returned.
+func Walk(obj interface{}, fn WalkFunc) (err error) {
+	var v reflect.Value
+	if v, err = structVal(obj, "Walk"); err != nil {
+		return
+	}
+	_, err = walk(v, "", fn)
+	return
+}
+
+// walk calls fn for each exported field of the given struct value then recurses
+func walk(v reflect.Value, path string, fn WalkFunc) (set bool, err error) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field, fv := typ.Field(i), v.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fpath := joinPath(path, field.Name)
+		if err = fn(fpath, field, fv); err == SkipStruct {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		if _, err = recurse(fv, fpath, false, func(x reflect.Value, p string) (bool, error) {
+			return walk(x, p, fn)
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Copy sets the exported fields of dst from the exported fields of src with the same name;
+// errors if dst is not a non nil pointer to a struct, src is not a struct or pointer to a struct
+// or a matching field's type can't be copied. The structs need not be the same type, fields
+// missing from either are ignored. Values are assigned when the types match, converted when
+// convertible and nested structs, slices and maps of structs of differing types are copied
+// field by field. Assigned slices, maps and pointers are shared with src not deep copied.
+func Copy(dst, src interface{}) (err error) {
+	var dv, sv reflect.Value
+	if dv, err = structPtr(dst, "Copy"); err != nil {
+		return
+	}
+	if sv, err = structVal(src, "Copy"); err != nil {
+		return
+	}
+	return copyStruct(dv, sv, "")
+}
+
+// copyStruct copies the matching exported fields from sv to dv
+func copyStruct(dv, sv reflect.Value, path string) (err error) {
+	typ := dv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field, fv := typ.Field(i), dv.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		sfield, ok := sv.Type().FieldByName(field.Name)
+		if !ok || sfield.PkgPath != "" || len(sfield.Index) != 1 {
+			continue
+		}
+		if err = copyValue(fv, sv.FieldByIndex(sfield.Index), joinPath(path, field.Name)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// copyValue copies the src value to the dst value converting between types where possible
+func copyValue(dst, src reflect.Value, path string) (err error) {
+	dtyp, styp := dst.Type(), src.Type()
+	switch {
+	case styp.AssignableTo(dtyp):
+		dst.Set(src)
+
+	// Nil pointers result in the zero value
+	case styp.Kind() == reflect.Ptr && src.IsNil():
+		dst.Set(reflect.Zero(dtyp))
+
+	// Pointers are dereferenced and allocated as needed
+	case styp.Kind() == reflect.Ptr:
+		return copyValue(dst, src.Elem(), path)
+	case dtyp.Kind() == reflect.Ptr:
+		x := reflect.New(dtyp.Elem())
+		if err = copyValue(x.Elem(), src, path); err == nil {
+			dst.Set(x)
+		}
+
+	// Structs of different types are copied field by field
+	case dtyp.Kind() == reflect.Struct && styp.Kind() == reflect.Struct && dtyp != gTimeType && styp != gTimeType:
+		return copyStruct(dst, src, path)
+
+	// Numbers and strings are converted but numbers aren't turned into runes
+	case styp.ConvertibleTo(dtyp) && (dtyp.Kind() != reflect.String || styp.Kind() == reflect.String):
+		dst.Set(src.Convert(dtyp))
+
+	// Slices are copied element by element
+	case dtyp.Kind() == reflect.Slice && (styp.Kind() == reflect.Slice || styp.Kind() == reflect.Array):
+		if styp.Kind() == reflect.Slice && src.IsNil() {
+			dst.Set(reflect.Zero(dtyp))
+			return
+		}
+		x := reflect.MakeSlice(dtyp, src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err = copyValue(x.Index(i), src.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return
+			}
+		}
+		dst.Set(x)
+
+	// Maps are copied entry by entry
+	case dtyp.Kind() == reflect.Map && styp.Kind() == reflect.Map && styp.Key().AssignableTo(dtyp.Key()):
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dtyp))
+			return
+		}
+		x := reflect.MakeMapWithSize(dtyp, src.Len())
+		for _, key := range src.MapKeys() {
+			val := reflect.New(dtyp.Elem()).Elem()
+			if err = copyValue(val, src.MapIndex(key), fmt.Sprintf("%s[%v]", path, key)); err != nil {
+				return
+			}
+			x.SetMapIndex(key, val)
+		}
+		dst.Set(x)
+
+	default:
+		err = errors.Errorf("failed to copy %s: can't copy %v to %v", path, styp, dtyp)
+	}
+	return
+}
+
+// Diff compares the leaf fields of a and b as flattened by Flatten returning a Change for each
+// path whose value differs, exists only in a or exists only in b; errors if a or b is not a
+// struct or pointer to a struct. Changes are ordered by a's field order followed by any paths
+// that only exist in b e.g. new slice elements.
+func Diff(a, b interface{}) (changes []*Change, err error) {
+	var am, bm *n.StringMap
+	if am, err = Flatten(a); err != nil {
+		return
+	}
+	if bm, err = Flatten(b); err != nil {
+		return
+	}
+
+	changes = []*Change{}
+	for _, item := range *am {
+		key := n.ToString(item.Key)
+		if !bm.Exists(key) {
+			changes = append(changes, &Change{Path: key, Old: item.Value})
+		} else if val := bm.Get(key).O(); !reflect.DeepEqual(item.Value, val) {
+			changes = append(changes, &Change{Path: key, Old: item.Value, New: val})
+		}
+	}
+	for _, item := range *bm {
+		if key := n.ToString(item.Key); !am.Exists(key) {
+			changes = append(changes, &Change{Path: key, New: item.Value})
+		}
+	}
+	return
+}
+
+// Flatten converts the given struct into a StringMap of dotted paths to leaf field values in
+// field order e.g. DB.Servers[0].Port; errors if obj is not a struct or pointer to a struct.
+// Nested structs, slices and maps of structs are flattened using the same rules as Walk.
+// Pointers to leaf values are dereferenced with nil pointers resulting in nil values.
+func Flatten(obj interface{}) (m *n.StringMap, err error) {
+	var v reflect.Value
+	if v, err = structVal(obj, "Flatten"); err != nil {
+		return
+	}
+	m = n.M()
+	walk(v, "", func(path string, field reflect.StructField, v reflect.Value) error {
+		if !isNested(v.Type()) {
+			m.Set(path, leafValue(v))
+		}
+		return nil
+	})
+	return
+}
+
+// Unflatten sets the fields of the given struct from the StringMap of dotted paths as created
+// by Flatten; errors if obj is not a non nil pointer to a struct, a path doesn't exist in obj or
+// a value can't be set. Nil pointers and maps along a path are allocated and slices are grown as
+// needed. String values are converted to the field's type the same way as Defaults.
+func Unflatten(m *n.StringMap, obj interface{}) (err error) {
+	var v reflect.Value
+	if v, err = structPtr(obj, "Unflatten"); err != nil {
+		return
+	}
+	if m == nil {
+		return
+	}
+	for _, item := range *m {
+		key := n.ToString(item.Key)
+		var segs []string
+		if segs, err = splitPath(key); err != nil {
+			return
+		}
+		if err = setPath(v, segs, item.Value); err != nil {
+			err = errors.WithMessagef(err, "failed to set %s", key)
+			return
+		}
+	}
+	return
+}
+
+// ToStringMap converts the given struct into a StringMap keyed by field name preserving the
+// struct's field order; errors if obj is not a struct or pointer to a struct. Nested structs
+// become nested maps, slices of structs become slices of maps and maps of structs become maps
+// keyed by the sorted map keys. Pointers are dereferenced with nil pointers resulting in nil.
+func ToStringMap(obj interface{}) (m *n.StringMap, err error) {
+	var v reflect.Value
+	if v, err = structVal(obj, "ToStringMap"); err != nil {
+		return
+	}
+	m = toStringMap(v)
+	return
+}
+
+// toStringMap converts the given struct value into a StringMap
+func toStringMap(v reflect.Value) (m *n.StringMap) {
+	m = n.M()
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.PkgPath == "" {
+			m.Set(field.Name, toMapValue(v.Field(i)))
+		}
+	}
+	return
+}
+
+// toMapValue converts the given value into a StringMap friendly value
+func toMapValue(v reflect.Value) interface{} {
+	if !isNested(v.Type()) {
+		return leafValue(v)
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return toStringMap(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		x := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			x = append(x, toMapValue(v.Index(i)))
+		}
+		return x
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		x := n.M()
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			x.Set(fmt.Sprint(key), toMapValue(v.MapIndex(key)))
+		}
+		return x
+	}
+	return toStringMap(v)
+}
+
+// isNested returns true if the given type is a struct or pointer to a struct or a slice, array
+// or map of them i.e. a type that Walk will recurse into
+func isNested(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return isStruct(typ.Elem())
+	}
+	return isStruct(typ)
+}
+
+// leafValue returns the interface of the given value dereferencing pointers
+func leafValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
+
+// splitPath splits the dotted path into field names and bracketed indexes or keys
+// e.g. DB.Servers[0].Port => DB, Servers, [0], Port
+func splitPath(path string) (segs []string, err error) {
+	for _, part := range strings.Split(path, ".") {
+		name, rest := part, ""
+		if i := strings.Index(part, "["); i != -1 {
+			name, rest = part[:i], part[i:]
+		}
+		if name == "" {
+			err = errors.Errorf("invalid path %s", path)
+			return
+		}
+		segs = append(segs, name)
+		for rest != "" {
+			i := strings.Index(rest, "]")
+			if rest[0] != '[' || i == -1 {
+				err = errors.Errorf("invalid path %s", path)
+				return
+			}
+			segs, rest = append(segs, rest[:i+1]), rest[i+1:]
+		}
+	}
+	return
+}
+
+// setPath walks the given path segments from the given value allocating as needed then sets
+// the final value to val
+func setPath(v reflect.Value, segs []string, val interface{}) (err error) {
+	if len(segs) == 0 {
+		return setValue(v, val)
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	seg := segs[0]
+	if !strings.HasPrefix(seg, "[") {
+		if v.Kind() != reflect.Struct {
+			return errors.Errorf("%v has no field %s", v.Type(), seg)
+		}
+		field, ok := v.Type().FieldByName(seg)
+		if !ok || field.PkgPath != "" || len(field.Index) != 1 {
+			return errors.Errorf("%v has no field %s", v.Type(), seg)
+		}
+		return setPath(v.FieldByIndex(field.Index), segs[1:], val)
+	}
+
+	key := seg[1 : len(seg)-1]
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		var i int
+		if i, err = strconv.Atoi(key); err != nil || i < 0 {
+			return errors.Errorf("invalid index %s for %v", seg, v.Type())
+		}
+		if i >= v.Len() {
+			if v.Kind() == reflect.Array {
+				return errors.Errorf("index %s out of range for %v", seg, v.Type())
+			}
+			v.Set(reflect.AppendSlice(v, reflect.MakeSlice(v.Type(), i+1-v.Len(), i+1-v.Len())))
+		}
+		return setPath(v.Index(i), segs[1:], val)
+
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		k := reflect.New(v.Type().Key()).Elem()
+		if err = setString(k, key); err != nil {
+			return
+		}
+
+		// Map values aren't addressable so work on a copy and set it back
+		x := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(k); existing.IsValid() {
+			x.Set(existing)
+		}
+		if err = setPath(x, segs[1:], val); err != nil {
+			return
+		}
+		v.SetMapIndex(k, x)
+		return
+	}
+	return errors.Errorf("%v can't be indexed with %s", v.Type(), seg)
+}
+
+// setValue sets the given value to val converting where possible
+func setValue(v reflect.Value, val interface{}) (err error) {
+	typ := v.Type()
+	if val == nil {
+		v.Set(reflect.Zero(typ))
+		return
+	}
+	x := reflect.ValueOf(val)
+	switch {
+	case x.Type().AssignableTo(typ):
+		v.Set(x)
+	case x.Kind() == reflect.String:
+		return setString(v, x.String())
+	case typ.Kind() == reflect.Ptr:
+		elem := reflect.New(typ.Elem())
+		if err = setValue(elem.Elem(), val); err == nil {
+			v.Set(elem)
+		}
+	case x.Type().ConvertibleTo(typ) && typ.Kind() != reflect.String:
+		v.Set(x.Convert(typ))
+	default:
+		err = errors.Errorf("can't set %T to %v", val, typ)
+	}
+	return
+}
+
+// structVal returns the struct value for the given struct or pointer to a struct or an error
+func structVal(obj interface{}, caller string) (v reflect.Value, err error) {
+	v = reflect.ValueOf(obj)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		err = errors.Errorf("structs.%s requires a non nil struct type not a %T", caller, obj)
+	}
+	return
+}
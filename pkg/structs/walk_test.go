@@ -0,0 +1,306 @@
+package structs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/phR0ze/n"
+	"github.com/pkg/errors"
+	yaml "github.com/phR0ze/yaml/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type WalkServer struct {
+	Host string
+	Port int
+}
+
+type WalkConfig struct {
+	Name    string
+	Level   *int
+	DB      WalkServer
+	Backup  *WalkServer
+	Servers []WalkServer
+	Shards  map[string]WalkServer
+	Tags    []string
+	private string
+}
+
+type WalkServerDTO struct {
+	Port int64
+	Host string
+	Zone string
+}
+
+type WalkConfigDTO struct {
+	Name    string
+	Level   int
+	DB      *WalkServerDTO
+	Backup  WalkServerDTO
+	Servers []WalkServerDTO
+	Shards  map[string]*WalkServerDTO
+	Tags    []string
+	Extra   string
+}
+
+func walkConfig() *WalkConfig {
+	level := 3
+	return &WalkConfig{
+		Name:    "app",
+		Level:   &level,
+		DB:      WalkServer{Host: "db", Port: 5432},
+		Servers: []WalkServer{{Host: "a", Port: 1}, {Host: "b", Port: 2}},
+		Shards:  map[string]WalkServer{"y": {Host: "y"}, "x": {Host: "x"}},
+		Tags:    []string{"foo"},
+		private: "private",
+	}
+}
+
+func TestWalk(t *testing.T) {
+
+	// invalid types
+	{
+		assert.Equal(t, "structs.Walk requires a non nil struct type not a *structs.WalkConfig",
+			Walk((*WalkConfig)(nil), nil).Error())
+		assert.Equal(t, "structs.Walk requires a non nil struct type not a int", Walk(1, nil).Error())
+	}
+
+	// paths in field order
+	{
+		paths := []string{}
+		assert.Nil(t, Walk(walkConfig(), func(path string, field reflect.StructField, v reflect.Value) error {
+			paths = append(paths, path)
+			return nil
+		}))
+		assert.Equal(t, []string{"Name", "Level", "DB", "DB.Host", "DB.Port", "Backup", "Servers",
+			"Servers[0].Host", "Servers[0].Port", "Servers[1].Host", "Servers[1].Port",
+			"Shards", "Shards[x].Host", "Shards[x].Port", "Shards[y].Host", "Shards[y].Port", "Tags"}, paths)
+	}
+
+	// skip nested structs and set values
+	{
+		paths := []string{}
+		cfg := walkConfig()
+		assert.Nil(t, Walk(cfg, func(path string, field reflect.StructField, v reflect.Value) error {
+			paths = append(paths, path)
+			if field.Type.Kind() == reflect.String {
+				v.SetString("set")
+			}
+			if path == "DB" || path == "Servers" || path == "Shards" {
+				return SkipStruct
+			}
+			return nil
+		}))
+		assert.Equal(t, []string{"Name", "Level", "DB", "Backup", "Servers", "Shards", "Tags"}, paths)
+		assert.Equal(t, "set", cfg.Name)
+	}
+
+	// errors stop the walk
+	{
+		paths := []string{}
+		err := Walk(walkConfig(), func(path string, field reflect.StructField, v reflect.Value) error {
+			paths = append(paths, path)
+			if path == "DB.Host" {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		assert.Equal(t, "boom", err.Error())
+		assert.Equal(t, []string{"Name", "Level", "DB", "DB.Host"}, paths)
+	}
+}
+
+func TestCopy(t *testing.T) {
+
+	// invalid types
+	{
+		assert.Equal(t, "structs.Copy requires a non nil pointer to a struct not a structs.WalkConfigDTO",
+			Copy(WalkConfigDTO{}, walkConfig()).Error())
+		assert.Equal(t, "structs.Copy requires a non nil struct type not a string", Copy(&WalkConfigDTO{}, "foo").Error())
+	}
+
+	// between different types
+	{
+		dto := &WalkConfigDTO{Extra: "extra", Backup: WalkServerDTO{Host: "old"}}
+		assert.Nil(t, Copy(dto, walkConfig()))
+		assert.Equal(t, "app", dto.Name)
+		assert.Equal(t, 3, dto.Level)
+		assert.Equal(t, &WalkServerDTO{Host: "db", Port: 5432}, dto.DB)
+		assert.Equal(t, WalkServerDTO{}, dto.Backup)
+		assert.Equal(t, []WalkServerDTO{{Host: "a", Port: 1}, {Host: "b", Port: 2}}, dto.Servers)
+		assert.Equal(t, map[string]*WalkServerDTO{"x": {Host: "x"}, "y": {Host: "y"}}, dto.Shards)
+		assert.Equal(t, []string{"foo"}, dto.Tags)
+		assert.Equal(t, "extra", dto.Extra)
+	}
+
+	// and back again
+	{
+		cfg := &WalkConfig{}
+		assert.Nil(t, Copy(cfg, &WalkConfigDTO{Name: "app", Level: 2, Backup: WalkServerDTO{Host: "b", Zone: "z"}}))
+		assert.Equal(t, "app", cfg.Name)
+		assert.Equal(t, 2, *cfg.Level)
+		assert.Equal(t, WalkServer{}, cfg.DB)
+		assert.Equal(t, &WalkServer{Host: "b"}, cfg.Backup)
+		assert.Nil(t, cfg.Servers)
+		assert.Nil(t, cfg.Shards)
+	}
+
+	// incompatible types
+	{
+		type Foo struct{ Name int }
+		assert.Equal(t, "failed to copy Name: can't copy string to int", Copy(&Foo{}, walkConfig()).Error())
+		type Bar struct{ DB struct{ Host []int } }
+		assert.Equal(t, "failed to copy DB.Host: can't copy string to []int", Copy(&Bar{}, walkConfig()).Error())
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	// invalid types
+	{
+		_, err := Diff(walkConfig(), 1)
+		assert.Equal(t, "structs.Flatten requires a non nil struct type not a int", err.Error())
+	}
+
+	// no changes
+	{
+		changes, err := Diff(walkConfig(), walkConfig())
+		assert.Nil(t, err)
+		assert.Equal(t, []*Change{}, changes)
+	}
+
+	// changed, removed and added paths
+	{
+		a, b := walkConfig(), walkConfig()
+		level := 4
+		b.Level = &level
+		b.DB.Port = 5433
+		b.Servers = b.Servers[:1]
+		b.Shards["z"] = WalkServer{Host: "z"}
+		b.Tags = append(b.Tags, "bar")
+		b.private = "changed"
+
+		changes, err := Diff(a, b)
+		assert.Nil(t, err)
+		assert.Equal(t, []*Change{
+			{Path: "Level", Old: 3, New: 4},
+			{Path: "DB.Port", Old: 5432, New: 5433},
+			{Path: "Servers[1].Host", Old: "b"},
+			{Path: "Servers[1].Port", Old: 2},
+			{Path: "Tags", Old: []string{"foo"}, New: []string{"foo", "bar"}},
+			{Path: "Shards[z].Host", New: "z"},
+			{Path: "Shards[z].Port", New: 0},
+		}, changes)
+		assert.Equal(t, "Level: 3 => 4", changes[0].String())
+		assert.Equal(t, "Servers[1].Host: b => <nil>", changes[2].String())
+	}
+}
+
+func TestFlatten(t *testing.T) {
+
+	// invalid types
+	{
+		m, err := Flatten("foo")
+		assert.Nil(t, m)
+		assert.Equal(t, "structs.Flatten requires a non nil struct type not a string", err.Error())
+	}
+
+	// leaf values in field order
+	{
+		m, err := Flatten(walkConfig())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Name", "Level", "DB.Host", "DB.Port", "Servers[0].Host", "Servers[0].Port",
+			"Servers[1].Host", "Servers[1].Port", "Shards[x].Host", "Shards[x].Port", "Shards[y].Host",
+			"Shards[y].Port", "Tags"}, m.Keys().ToStrs())
+		assert.Equal(t, 3, m.Get("Level").O())
+		assert.Equal(t, 5432, m.Get("DB.Port").O())
+		assert.Equal(t, "y", m.Get("Shards[y].Host").O())
+		assert.Equal(t, []string{"foo"}, m.Get("Tags").O())
+	}
+
+	// nil leaf pointers
+	{
+		m, err := Flatten(WalkConfig{})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Name", "Level", "DB.Host", "DB.Port", "Tags"}, m.Keys().ToStrs())
+		assert.Nil(t, m.Get("Level").O())
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+
+	// invalid types
+	{
+		assert.Equal(t, "structs.Unflatten requires a non nil pointer to a struct not a structs.WalkConfig",
+			Unflatten(n.M(), WalkConfig{}).Error())
+		assert.Nil(t, Unflatten(nil, &WalkConfig{}))
+	}
+
+	// round trip
+	{
+		m, err := Flatten(walkConfig())
+		assert.Nil(t, err)
+		cfg := &WalkConfig{}
+		assert.Nil(t, Unflatten(m, cfg))
+		expected := walkConfig()
+		expected.private = ""
+		assert.Equal(t, expected, cfg)
+	}
+
+	// allocation, conversion and growth
+	{
+		cfg := &WalkConfig{Servers: []WalkServer{{Host: "a"}}}
+		m := n.MV(yaml.MapSlice{
+			{Key: "Level", Value: "7"},
+			{Key: "Backup.Port", Value: int64(80)},
+			{Key: "Servers[2].Host", Value: "c"},
+			{Key: "Shards[x].Port", Value: 1.0},
+			{Key: "Tags", Value: "a,b"},
+		})
+		assert.Nil(t, Unflatten(m, cfg))
+		assert.Equal(t, 7, *cfg.Level)
+		assert.Equal(t, &WalkServer{Port: 80}, cfg.Backup)
+		assert.Equal(t, []WalkServer{{Host: "a"}, {}, {Host: "c"}}, cfg.Servers)
+		assert.Equal(t, map[string]WalkServer{"x": {Port: 1}}, cfg.Shards)
+		assert.Equal(t, []string{"a", "b"}, cfg.Tags)
+	}
+
+	// invalid paths and values
+	{
+		cfg := &WalkConfig{}
+		assert.Equal(t, "failed to set Bogus: structs.WalkConfig has no field Bogus",
+			Unflatten(n.M().Add("Bogus", 1), cfg).Error())
+		assert.Equal(t, "failed to set private: structs.WalkConfig has no field private",
+			Unflatten(n.M().Add("private", 1), cfg).Error())
+		assert.Equal(t, "failed to set Name.Foo: string has no field Foo",
+			Unflatten(n.M().Add("Name.Foo", 1), cfg).Error())
+		assert.Equal(t, "failed to set Name[0]: string can't be indexed with [0]",
+			Unflatten(n.M().Add("Name[0]", 1), cfg).Error())
+		assert.Equal(t, "failed to set Servers[a].Host: invalid index [a] for []structs.WalkServer",
+			Unflatten(n.M().Add("Servers[a].Host", 1), cfg).Error())
+		assert.Equal(t, "invalid path DB..Host", Unflatten(n.M().Add("DB..Host", 1), cfg).Error())
+		assert.Equal(t, "invalid path Servers[0", Unflatten(n.M().Add("Servers[0", 1), cfg).Error())
+		assert.Equal(t, "failed to set DB.Port: can't set []int to int",
+			Unflatten(n.M().Add("DB.Port", []int{1}), cfg).Error())
+	}
+}
+
+func TestToStringMap(t *testing.T) {
+
+	// invalid types
+	{
+		m, err := ToStringMap(1)
+		assert.Nil(t, m)
+		assert.Equal(t, "structs.ToStringMap requires a non nil struct type not a int", err.Error())
+	}
+
+	// field order is preserved
+	{
+		m, err := ToStringMap(walkConfig())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Name", "Level", "DB", "Backup", "Servers", "Shards", "Tags"}, m.Keys().ToStrs())
+		assert.Equal(t, "Name: app\nLevel: 3\nDB:\n  Host: db\n  Port: 5432\nBackup: null\nServers:\n- Host: a\n  Port: 1\n"+
+			"- Host: b\n  Port: 2\nShards:\n  x:\n    Host: x\n    Port: 0\n  \"y\":\n    Host: \"y\"\n    Port: 0\nTags:\n- foo\n", m.YAML())
+		assert.Equal(t, 5432, m.Query("DB.Port").O())
+	}
+}
@@ -0,0 +1,195 @@
+package sys
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+)
+
+// AtomicUpdate performs a read-modify-write of the given file while holding an exclusive
+// advisory flock on a sidecar lock file i.e. path.lock to serialize cooperating writers. The
+// update func is given the file's current content, nil if it doesn't exist yet, and its result
+// is written atomically via WriteBytesAtomic. Returning an error from the update func aborts
+// without writing and unchanged content is not re-written.
+//
+// Supported options: BackupOpt, ModeOpt and SyncDirOpt as with WriteBytesAtomic and TryOpt and
+// TimeoutOpt to limit waiting on the lock as with Lock
+func AtomicUpdate(filepath string, update func([]byte) ([]byte, error), opts ...*opt.Opt) (err error) {
+	if filepath, err = Abs(filepath); err != nil {
+		return
+	}
+
+	// Take the lock
+	var lock *FileLock
+	if lock, err = Lock(filepath+".lock", TryOpt(getTryOpt(opts)), TimeoutOpt(getTimeoutOpt(opts))); err != nil {
+		return
+	}
+	defer lock.Unlock()
+
+	// Read the current content if any
+	var data []byte
+	if data, err = os.ReadFile(filepath); err != nil {
+		if !os.IsNotExist(err) {
+			err = errors.Wrapf(err, "failed reading file %s", filepath)
+			return
+		}
+		data, err = nil, nil
+	}
+
+	// Update and write the result
+	var result []byte
+	if result, err = update(data); err != nil {
+		err = errors.Wrapf(err, "failed updating file %s", filepath)
+		return
+	}
+	if data != nil && bytes.Equal(data, result) {
+		return
+	}
+	return WriteBytesAtomic(filepath, result, opts...)
+}
+
+// WriteBytesAtomic writes the data to the given file atomically by first writing to a temp file
+// in the same directory, syncing it to disk then renaming it over the target. Readers will see
+// either the old or the new content never a partial write. Links are resolved so that the
+// target of a link is replaced rather than the link itself.
+// * Preserves the mode and owner of an existing file, new files default to 0644
+// * Override the mode by passing in ModeOpt(mode)
+// * Keep the previous content as path.bak by passing in BackupOpt(true)
+// * Sync the parent directory to make the rename durable by passing in SyncDirOpt(true)
+func WriteBytesAtomic(filepath string, data []byte, opts ...*opt.Opt) (err error) {
+	if err = writeAtomic(bytes.NewReader(data), filepath, opts); err != nil {
+		err = errors.Wrapf(err, "failed writing bytes to file %s", filepath)
+	}
+	return
+}
+
+// WriteLinesAtomic writes the lines to the given file atomically joined with newlines.
+// Supports the same options as WriteBytesAtomic.
+func WriteLinesAtomic(filepath string, lines []string, opts ...*opt.Opt) (err error) {
+	if err = writeAtomic(strings.NewReader(strings.Join(lines, "\n")), filepath, opts); err != nil {
+		err = errors.Wrapf(err, "failed writing lines to file %s", filepath)
+	}
+	return
+}
+
+// WriteStreamAtomic reads from the io.Reader and writes to the given file atomically using
+// io.Copy thus never filling memory i.e. streaming. Supports the same options as WriteBytesAtomic.
+func WriteStreamAtomic(reader io.Reader, filepath string, opts ...*opt.Opt) (err error) {
+	if err = writeAtomic(reader, filepath, opts); err != nil {
+		err = errors.Wrapf(err, "failed writing stream to file %s", filepath)
+	}
+	return
+}
+
+// WriteStringAtomic writes the string to the given file atomically.
+// Supports the same options as WriteBytesAtomic.
+func WriteStringAtomic(filepath string, data string, opts ...*opt.Opt) (err error) {
+	if err = writeAtomic(strings.NewReader(data), filepath, opts); err != nil {
+		err = errors.Wrapf(err, "failed writing string to file %s", filepath)
+	}
+	return
+}
+
+// writeAtomic supports the public atomic write functions by streaming the reader to a temp file
+// in the target's directory then renaming it over the target.
+func writeAtomic(reader io.Reader, target string, opts []*opt.Opt) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	if resolved, e := filepath.EvalSymlinks(target); e == nil {
+		target = resolved
+	}
+	dir := path.Dir(target)
+
+	// Use the existing file's mode and owner if it exists
+	mode := os.FileMode(0644)
+	uid, gid := -1, -1
+	if info, e := os.Stat(target); e == nil {
+		mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+	if opt.Exists(opts, "mode") {
+		mode = getModeOpt(opts)
+	}
+
+	// Stream to a temp file removing it on any failure
+	var fw *os.File
+	if fw, err = os.CreateTemp(dir, "."+path.Base(target)+".tmp*"); err != nil {
+		err = errors.Wrap(err, "failed creating temp file")
+		return
+	}
+	tmp := fw.Name()
+	defer func() {
+		if err != nil {
+			fw.Close()
+			os.Remove(tmp)
+		}
+	}()
+	if _, err = io.Copy(fw, reader); err != nil {
+		err = errors.Wrapf(err, "failed copying data to temp file %s", tmp)
+		return
+	}
+
+	// Owner must be set first as chown clears the setuid and setgid bits. Unprivileged users
+	// can't give files away so in that case the new file keeps the current user as owner.
+	if uid != -1 {
+		if err = fw.Chown(uid, gid); err != nil && !os.IsPermission(err) {
+			err = errors.Wrapf(err, "failed to chown temp file %s", tmp)
+			return
+		}
+	}
+	if err = fw.Chmod(mode); err != nil {
+		err = errors.Wrapf(err, "failed to chmod temp file %s", tmp)
+		return
+	}
+	if err = fw.Sync(); err != nil {
+		err = errors.Wrapf(err, "failed syncing temp file %s", tmp)
+		return
+	}
+	if err = fw.Close(); err != nil {
+		err = errors.Wrapf(err, "failed to close temp file %s", tmp)
+		return
+	}
+
+	// Hard link the existing file as the backup as the rename only replaces the directory entry
+	if getBackupOpt(opts) && Exists(target) {
+		backup := target + ".bak"
+		if err = os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			err = errors.Wrapf(err, "failed removing old backup %s", backup)
+			return
+		}
+		if err = os.Link(target, backup); err != nil {
+			err = errors.Wrapf(err, "failed creating backup %s", backup)
+			return
+		}
+	}
+
+	if err = os.Rename(tmp, target); err != nil {
+		err = errors.Wrapf(err, "failed renaming temp file %s", tmp)
+		return
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	if getSyncDirOpt(opts) {
+		var fd *os.File
+		if fd, err = os.Open(dir); err != nil {
+			err = errors.Wrapf(err, "failed opening directory %s", dir)
+			return
+		}
+		defer fd.Close()
+		if err = fd.Sync(); err != nil {
+			err = errors.Wrapf(err, "failed syncing directory %s", dir)
+			return
+		}
+	}
+	return
+}
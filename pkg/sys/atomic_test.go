@@ -0,0 +1,106 @@
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	resetTest()
+	target := path.Join(tmpDir, "config")
+
+	// new files default to 0644 and leave no temp files behind
+	{
+		assert.Nil(t, WriteStringAtomic(target, "one"))
+		data, err := ReadString(target)
+		assert.Nil(t, err)
+		assert.Equal(t, "one", data)
+		assert.Equal(t, os.FileMode(0644), Mode(target))
+		names, _ := ReadDirnames(tmpDir)
+		assert.Equal(t, []string{"config"}, names)
+	}
+
+	// existing mode is preserved unless overridden
+	{
+		assert.Nil(t, os.Chmod(target, 0600))
+		assert.Nil(t, WriteBytesAtomic(target, []byte("two")))
+		assert.Equal(t, os.FileMode(0600), Mode(target))
+		assert.Nil(t, WriteLinesAtomic(target, []string{"a", "b"}, ModeOpt(0640)))
+		assert.Equal(t, os.FileMode(0640), Mode(target))
+		data, _ := ReadString(target)
+		assert.Equal(t, "a\nb", data)
+	}
+
+	// backup and sync dir
+	{
+		assert.Nil(t, WriteStreamAtomic(strings.NewReader("three"), target, BackupOpt(true), SyncDirOpt(true)))
+		data, _ := ReadString(target)
+		assert.Equal(t, "three", data)
+		data, _ = ReadString(target + ".bak")
+		assert.Equal(t, "a\nb", data)
+	}
+
+	// links are written through
+	{
+		link := path.Join(tmpDir, "link")
+		assert.Nil(t, os.Symlink("config", link))
+		assert.Nil(t, WriteStringAtomic(link, "four"))
+		assert.True(t, IsSymlink(link))
+		data, _ := ReadString(target)
+		assert.Equal(t, "four", data)
+	}
+
+	// failures
+	{
+		err := WriteStringAtomic(path.Join(tmpDir, "bogus", "file"), "")
+		assert.True(t, strings.HasPrefix(err.Error(), "failed writing string to file ../../test/temp/bogus/file: failed creating temp file"))
+	}
+}
+
+func TestAtomicUpdate(t *testing.T) {
+	resetTest()
+	target := path.Join(tmpDir, "counter")
+
+	// concurrent updates are serialized
+	{
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Nil(t, AtomicUpdate(target, func(data []byte) ([]byte, error) {
+					count, _ := strconv.Atoi(string(data))
+					return []byte(fmt.Sprint(count + 1)), nil
+				}))
+			}()
+		}
+		wg.Wait()
+		data, err := ReadString(target)
+		assert.Nil(t, err)
+		assert.Equal(t, "10", data)
+	}
+
+	// update errors abort the write
+	{
+		err := AtomicUpdate(target, func(data []byte) ([]byte, error) {
+			return []byte("bogus"), errors.New("boom")
+		})
+		assert.True(t, strings.HasSuffix(err.Error(), "counter: boom"))
+		data, _ := ReadString(target)
+		assert.Equal(t, "10", data)
+	}
+
+	// unchanged content isn't re-written
+	{
+		assert.Nil(t, AtomicUpdate(target, func(data []byte) ([]byte, error) { return data, nil }, BackupOpt(true)))
+		assert.False(t, Exists(target+".bak"))
+	}
+}
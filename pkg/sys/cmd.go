@@ -0,0 +1,521 @@
+package sys
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// gStderrTail is the maximum number of trailing stderr bytes retained for an ExitError
+const gStderrTail = 4096
+
+// Cmd provides a builder for executing external commands with control over the command's
+// stdin, environment, working directory, timeout and context. Commands are run in their own
+// process group so that the entire group is killed when cancelled or timed out.
+type Cmd struct {
+	name     string            // name or path of the executable
+	args     []string          // arguments to pass to the executable
+	dir      string            // working directory to run in
+	env      []string          // KEY=VALUE pairs added to the current environment
+	environ  *Env              // environment to use instead of the current environment
+	stdin    io.Reader         // input stream to read from
+	timeout  time.Duration     // maximum time to run before killing the process group
+	ctx      context.Context   // context to kill the process group on cancel
+	onStdout func(line string) // callback for each line of stdout
+	onStderr func(line string) // callback for each line of stderr
+	inFile   string            // file to redirect stdin from
+	outFile  *redirect         // file to redirect stdout to
+	errFile  *redirect         // file to redirect stderr to
+	errToOut bool              // redirect stderr to the same destination as stdout
+	user     *User             // user to run the command as
+}
+
+// redirect provides the details of an output redirect to a file
+type redirect struct {
+	path   string // file to write to
+	append bool   // append to the file rather than truncating it
+}
+
+// String returns the redirect in sh form for the given file descriptor prefix e.g. " 2>> file"
+func (r *redirect) String(fd string) string {
+	op := fd + ">"
+	if r.append {
+		op += ">"
+	}
+	return fmt.Sprintf(" %s %s", op, Quote(r.path))
+}
+
+// ExitError provides details about a command that ran but did not exit successfully
+type ExitError struct {
+	Cmd    string         // Command line that was executed
+	Code   int            // Exit code of the process or -1 if killed by a signal
+	Signal syscall.Signal // Signal that killed the process or 0 if it exited normally
+	Stderr string         // Trailing output the process wrote to stderr
+	Err    error          // Underlying error e.g. *exec.ExitError or context.DeadlineExceeded
+}
+
+// Error implements the error interface
+func (e *ExitError) Error() string {
+	var msg string
+	if e.Signal != 0 {
+		msg = fmt.Sprintf("command %s killed by signal %v", e.Cmd, e.Signal)
+	} else {
+		msg = fmt.Sprintf("command %s exited with code %d", e.Cmd, e.Code)
+	}
+	if e.Err == context.Canceled || e.Err == context.DeadlineExceeded {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg = fmt.Sprintf("%s: %s", msg, stderr)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the underlying error for github.com/pkg/errors compatibility
+func (e *ExitError) Cause() error {
+	return e.Err
+}
+
+// NewCmd creates a new command builder for the given executable and arguments. Arguments are
+// passed as is without any shell interpretation. Use SplitWords to parse a command string.
+func NewCmd(name string, args ...string) *Cmd {
+	return &Cmd{name: name, args: args}
+}
+
+// Arg appends the given arguments to the command's arguments and returns a reference to the command
+func (c *Cmd) Arg(args ...string) *Cmd {
+	c.args = append(c.args, args...)
+	return c
+}
+
+// Context sets the context for the command. When the context is done the command's process
+// group is killed. Returns a reference to the command.
+func (c *Cmd) Context(ctx context.Context) *Cmd {
+	c.ctx = ctx
+	return c
+}
+
+// Dir sets the working directory for the command and returns a reference to the command
+func (c *Cmd) Dir(dir string) *Cmd {
+	c.dir = dir
+	return c
+}
+
+// Env adds the given KEY=VALUE pairs to the command's environment which otherwise inherits
+// the current process's environment. Later values override earlier ones with the same key.
+// Returns a reference to the command.
+func (c *Cmd) Env(env ...string) *Cmd {
+	c.env = append(c.env, env...)
+	return c
+}
+
+// Environ sets the environment the command starts from instead of the current process's
+// environment. Values added with Env still override it. Returns a reference to the command.
+func (c *Cmd) Environ(env *Env) *Cmd {
+	c.environ = env
+	return c
+}
+
+// OnStdout sets a callback to be called with each line the command writes to stdout without
+// the trailing newline. Returns a reference to the command.
+func (c *Cmd) OnStdout(f func(line string)) *Cmd {
+	c.onStdout = f
+	return c
+}
+
+// OnStderr sets a callback to be called with each line the command writes to stderr without
+// the trailing newline. Note stdout and stderr callbacks are called from different goroutines.
+// Returns a reference to the command.
+func (c *Cmd) OnStderr(f func(line string)) *Cmd {
+	c.onStderr = f
+	return c
+}
+
+// RedirectStdin reads the command's stdin from the given file like sh's < file overriding
+// any opt.InOpt passed to Run. Returns a reference to the command.
+func (c *Cmd) RedirectStdin(path string) *Cmd {
+	c.inFile = path
+	return c
+}
+
+// RedirectStdout writes the command's stdout to the given file using WriteStream like sh's
+// > file or >> file when append is true. Returns a reference to the command.
+func (c *Cmd) RedirectStdout(path string, append bool) *Cmd {
+	c.outFile = &redirect{path: path, append: append}
+	return c
+}
+
+// RedirectStderr writes the command's stderr to the given file using WriteStream like sh's
+// 2> file or 2>> file when append is true. Returns a reference to the command.
+func (c *Cmd) RedirectStderr(path string, append bool) *Cmd {
+	c.errFile = &redirect{path: path, append: append}
+	return c
+}
+
+// StderrToStdout sends the command's stderr to the same destination as its stdout like sh's
+// 2>&1 regardless of the order the redirects were given in. Returns a reference to the command.
+func (c *Cmd) StderrToStdout() *Cmd {
+	c.errToOut = true
+	return c
+}
+
+// Stdin sets the input stream for the command overriding any opt.InOpt passed to Run.
+// Returns a reference to the command.
+func (c *Cmd) Stdin(r io.Reader) *Cmd {
+	c.stdin = r
+	return c
+}
+
+// Timeout sets the maximum time the command is allowed to run before its process group is
+// killed. Returns a reference to the command.
+func (c *Cmd) Timeout(timeout time.Duration) *Cmd {
+	c.timeout = timeout
+	return c
+}
+
+// User sets the user to run the command as including the user's supplementary groups and sets
+// HOME, USER and LOGNAME for the user ahead of any Env values. Running as another user requires
+// root. Returns a reference to the command.
+func (c *Cmd) User(u *User) *Cmd {
+	c.user = u
+	return c
+}
+
+// String returns the command line with arguments quoted as needed for sh including redirects
+func (c *Cmd) String() string {
+	str := JoinWords(append([]string{c.name}, c.args...))
+	if c.inFile != "" {
+		str += " < " + Quote(c.inFile)
+	}
+	if c.outFile != nil {
+		str += c.outFile.String("")
+	}
+	if c.errFile != nil {
+		str += c.errFile.String("2")
+	}
+	if c.errToOut {
+		str += " 2>&1"
+	}
+	return str
+}
+
+// Run executes the command and waits for it to complete. Returns an *ExitError if the command
+// ran but exited unsuccessfully or was killed.
+//
+// Supported options: opt.InOpt, opt.OutOpt and opt.ErrOpt to connect the command's stdin,
+// stdout and stderr streams. Streams not given or redirected are connected to the null device.
+func (c *Cmd) Run(opts ...*opt.Opt) (err error) {
+	var p *process
+	if p, err = c.prepare(nil, opt.DefaultInOpt(opts, nil), opt.DefaultOutOpt(opts, nil), opt.DefaultErrOpt(opts, nil)); err != nil {
+		return
+	}
+	if err = p.start(); err != nil {
+		return
+	}
+	return p.wait()
+}
+
+// Output executes the command and returns its stdout as a string.
+//
+// Supported options: opt.InOpt and opt.ErrOpt to connect the command's stdin and stderr
+func (c *Cmd) Output(opts ...*opt.Opt) (out string, err error) {
+	var buf bytes.Buffer
+	opts = opt.Copy(opts)
+	opt.OverwriteOutOpt(&opts, &buf)
+	err = c.Run(opts...)
+	out = buf.String()
+	return
+}
+
+// CombinedOutput executes the command and returns its stdout and stderr interleaved as a string.
+//
+// Supported options: opt.InOpt to connect the command's stdin
+func (c *Cmd) CombinedOutput(opts ...*opt.Opt) (out string, err error) {
+	var buf bytes.Buffer
+	w := &lockedWriter{w: &buf}
+	opts = opt.Copy(opts)
+	opt.OverwriteOutOpt(&opts, w)
+	opt.OverwriteErrOpt(&opts, w)
+	err = c.Run(opts...)
+	out = buf.String()
+	return
+}
+
+// process tracks the state of a single running command
+type process struct {
+	cmd    *Cmd
+	exec   *exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	tail   *tailBuffer
+	lines  []*lineWriter
+	files  []io.Closer  // redirect files and pipes to close once the process completes
+	errs   []chan error // results of the output redirects
+}
+
+// prepare creates the underlying exec.Cmd connected to the given streams or redirects. The
+// given context overrides the command's context when not nil.
+func (c *Cmd) prepare(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) (p *process, err error) {
+	p = &process{cmd: c, tail: &tailBuffer{max: gStderrTail}}
+
+	// Configure timeout and cancellation
+	p.ctx = ctx
+	if p.ctx == nil {
+		p.ctx = c.ctx
+	}
+	if p.ctx == nil {
+		p.ctx = context.Background()
+	}
+	if c.timeout > 0 {
+		p.ctx, p.cancel = context.WithTimeout(p.ctx, c.timeout)
+	} else {
+		p.ctx, p.cancel = context.WithCancel(p.ctx)
+	}
+
+	// Disable creation of .DS_Store ._* files on OSX during file copy
+	env := c.env
+	if Darwin() {
+		env = append([]string{"COPYFILE_DISABLE=1"}, env...)
+	}
+
+	// Run as the given user
+	var cred *syscall.Credential
+	if u := c.user; u != nil {
+		if cred, err = userCredential(u); err != nil {
+			p.cancel()
+			return
+		}
+		env = append([]string{"HOME=" + u.Home, "USER=" + u.Name, "LOGNAME=" + u.Name}, env...)
+	}
+
+	x := exec.CommandContext(p.ctx, c.name, c.args...)
+	x.Dir = c.dir
+	if c.environ != nil {
+		x.Env = append(c.environ.Environ(), env...)
+	} else if len(env) > 0 {
+		x.Env = append(os.Environ(), env...)
+	}
+
+	// Kill the entire process group on cancel so children don't outlive the command
+	x.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+	x.Cancel = func() error {
+		return unix.Kill(-x.Process.Pid, unix.SIGKILL)
+	}
+	x.WaitDelay = time.Second
+
+	// Connect streams and redirects
+	x.Stdin = stdin
+	if c.stdin != nil {
+		x.Stdin = c.stdin
+	}
+	if c.inFile != "" {
+		var f *os.File
+		if f, err = os.Open(c.inFile); err != nil {
+			p.cancel()
+			err = errors.Wrapf(err, "failed to open %s for redirect", c.inFile)
+			return
+		}
+		p.files = append(p.files, f)
+		x.Stdin = f
+	}
+	if c.outFile != nil {
+		stdout = p.redirect(c.outFile)
+	}
+	if c.errFile != nil {
+		stderr = p.redirect(c.errFile)
+	}
+	if c.errToOut {
+		if _, ok := stdout.(*os.File); !ok && stdout != nil {
+			stdout = &lockedWriter{w: stdout}
+		}
+		stderr = stdout
+	}
+	x.Stdout = p.writer(stdout, c.onStdout)
+	x.Stderr = p.writer(stderr, c.onStderr, p.tail)
+	p.exec = x
+	return
+}
+
+// userCredential returns the credential to start a process as the given user or nil if the
+// user is the current user as only root may set the supplementary groups
+func userCredential(u *User) (cred *syscall.Credential, err error) {
+	if u.UID == os.Geteuid() && u.GID == os.Getegid() {
+		return
+	}
+	var gids []int
+	if gids, err = u.GroupIds(); err != nil {
+		err = errors.Wrapf(err, "failed to run command as user %s", u.Name)
+		return
+	}
+	cred = &syscall.Credential{Uid: uint32(u.UID), Gid: uint32(u.GID)}
+	for _, gid := range gids {
+		cred.Groups = append(cred.Groups, uint32(gid))
+	}
+	return
+}
+
+// redirect streams everything written to the returned writer to the given file using WriteStream
+func (p *process) redirect(r *redirect) io.Writer {
+	pr, pw := io.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		err := writeStream(pr, r.path, r.append)
+		pr.CloseWithError(err)
+		result <- err
+	}()
+	p.files = append(p.files, pw)
+	p.errs = append(p.errs, result)
+	return pw
+}
+
+// release closes any redirect files and pipes returning the first redirect error
+func (p *process) release() (err error) {
+	for _, f := range p.files {
+		f.Close()
+	}
+	for _, result := range p.errs {
+		if e := <-result; e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// writer combines the given stream, line callback and extra writers into a single writer.
+// Files are returned as is when possible so that they are passed directly to the process.
+func (p *process) writer(w io.Writer, f func(line string), extra ...io.Writer) io.Writer {
+	writers := []io.Writer{}
+	if w != nil {
+		writers = append(writers, w)
+	}
+	if f != nil {
+		lw := &lineWriter{f: f}
+		p.lines = append(p.lines, lw)
+		writers = append(writers, lw)
+	}
+	writers = append(writers, extra...)
+	switch len(writers) {
+	case 0:
+		return nil
+	case 1:
+		return writers[0]
+	}
+	return io.MultiWriter(writers...)
+}
+
+// start the process
+func (p *process) start() (err error) {
+	if err = p.exec.Start(); err != nil {
+		p.cancel()
+		p.release()
+		err = errors.Wrapf(err, "failed to start command %s", p.cmd)
+	}
+	return
+}
+
+// wait for the process to complete and convert the result into an error if needed
+func (p *process) wait() (err error) {
+	err = p.exec.Wait()
+	for _, lw := range p.lines {
+		lw.Flush()
+	}
+	redirectErr := p.release()
+	ctxErr := p.ctx.Err()
+	p.cancel()
+	if err == nil && redirectErr == nil {
+		return
+	}
+
+	e, ok := err.(*exec.ExitError)
+	if !ok {
+		if redirectErr != nil {
+			return errors.Wrapf(redirectErr, "failed to redirect output of command %s", p.cmd)
+		}
+		return errors.Wrapf(err, "failed waiting on command %s", p.cmd)
+	}
+	exitErr := &ExitError{Cmd: p.cmd.String(), Code: e.ExitCode(), Stderr: p.tail.String(), Err: err}
+	if status, ok := e.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exitErr.Signal = status.Signal()
+	}
+	if ctxErr != nil {
+		exitErr.Err = ctxErr
+	}
+	return exitErr
+}
+
+// lineWriter calls f for each complete line written to it
+type lineWriter struct {
+	f   func(line string)
+	buf []byte
+}
+
+// Write implements the io.Writer interface
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+		w.f(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush calls f with any trailing partial line
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.f(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// tailBuffer retains only the last max bytes written to it
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+// Write implements the io.Writer interface
+func (w *tailBuffer) Write(p []byte) (n int, err error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = append(w.buf[:0], w.buf[len(w.buf)-w.max:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the retained bytes as a string
+func (w *tailBuffer) String() string {
+	return string(w.buf)
+}
+
+// lockedWriter serializes writes to the underlying writer so it can be shared by goroutines
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write implements the io.Writer interface
+func (w *lockedWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,149 @@
+package sys
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmd_Run(t *testing.T) {
+
+	// streams from options
+	{
+		var stdout, stderr bytes.Buffer
+		err := NewCmd("sh", "-c", "cat; echo err >&2").Run(opt.InOpt(strings.NewReader("in\n")),
+			opt.OutOpt(&stdout), opt.ErrOpt(&stderr))
+		assert.Nil(t, err)
+		assert.Equal(t, "in\n", stdout.String())
+		assert.Equal(t, "err\n", stderr.String())
+	}
+
+	// stdin overrides option
+	{
+		var stdout bytes.Buffer
+		err := NewCmd("cat").Stdin(strings.NewReader("foo")).Run(opt.InOpt(strings.NewReader("bar")), opt.OutOpt(&stdout))
+		assert.Nil(t, err)
+		assert.Equal(t, "foo", stdout.String())
+	}
+
+	// start failure
+	{
+		err := NewCmd("footmp").Run()
+		assert.Equal(t, `failed to start command footmp: exec: "footmp": executable file not found in $PATH`, err.Error())
+	}
+
+	// exit code and stderr tail
+	{
+		err := NewCmd("sh", "-c", "echo first >&2; echo boom >&2; exit 3").Run()
+		exitErr, ok := err.(*ExitError)
+		assert.True(t, ok)
+		assert.Equal(t, 3, exitErr.Code)
+		assert.Equal(t, syscall.Signal(0), exitErr.Signal)
+		assert.Equal(t, "first\nboom\n", exitErr.Stderr)
+		assert.Equal(t, "command sh -c 'echo first >&2; echo boom >&2; exit 3' exited with code 3: first\nboom", err.Error())
+	}
+
+	// stderr tail is bounded
+	{
+		err := NewCmd("sh", "-c", "head -c 10000 /dev/zero | tr '\\0' a >&2; echo end >&2; exit 1").Run()
+		exitErr := err.(*ExitError)
+		assert.Equal(t, gStderrTail, len(exitErr.Stderr))
+		assert.True(t, strings.HasSuffix(exitErr.Stderr, "aaaend\n"))
+	}
+}
+
+func TestCmd_Env(t *testing.T) {
+	t.Setenv("SYS_CMD_TEST", "inherited")
+	out, err := NewCmd("sh", "-c", "echo $SYS_CMD_TEST $FOO").Env("FOO=foo", "FOO=bar").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "inherited bar\n", out)
+
+	out, err = NewCmd("sh", "-c", "echo $SYS_CMD_TEST").Env("SYS_CMD_TEST=override").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "override\n", out)
+
+	env := NewEnv("PATH="+os.Getenv("PATH"), "FOO=foo")
+	out, err = NewCmd("sh", "-c", "echo $SYS_CMD_TEST $FOO").Environ(env).Env("FOO=bar").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar\n", out)
+}
+
+func TestCmd_Dir(t *testing.T) {
+	out, err := NewCmd("ls", "-1").Dir("../net").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "agent\nmech\nnet.go\nnet_test.go\n", out)
+
+	out, err = NewCmd("pwd").Arg("-P").Dir("/").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "/\n", out)
+}
+
+func TestCmd_Output(t *testing.T) {
+
+	// stdout only
+	{
+		var stderr bytes.Buffer
+		out, err := NewCmd("sh", "-c", "echo out; echo err >&2").Output(opt.ErrOpt(&stderr), opt.OutOpt(&bytes.Buffer{}))
+		assert.Nil(t, err)
+		assert.Equal(t, "out\n", out)
+		assert.Equal(t, "err\n", stderr.String())
+	}
+
+	// combined
+	{
+		out, err := NewCmd("sh", "-c", "echo out; echo err >&2").CombinedOutput()
+		assert.Nil(t, err)
+		assert.Contains(t, out, "out\n")
+		assert.Contains(t, out, "err\n")
+	}
+}
+
+func TestCmd_Callbacks(t *testing.T) {
+	var stdout, stderr []string
+	var buf bytes.Buffer
+	err := NewCmd("sh", "-c", "printf 'a\\nb\\r\\nc'; printf 'x\\n' >&2").
+		OnStdout(func(line string) { stdout = append(stdout, line) }).
+		OnStderr(func(line string) { stderr = append(stderr, line) }).
+		Run(opt.OutOpt(&buf))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, stdout)
+	assert.Equal(t, []string{"x"}, stderr)
+	assert.Equal(t, "a\nb\r\nc", buf.String())
+}
+
+func TestCmd_Timeout(t *testing.T) {
+
+	// the whole process group is killed
+	{
+		start := time.Now()
+		out, err := NewCmd("sh", "-c", "sleep 10 & echo started; wait").Timeout(200 * time.Millisecond).Output()
+		assert.True(t, time.Since(start) < 5*time.Second)
+		assert.Equal(t, "started\n", out)
+		exitErr, ok := err.(*ExitError)
+		assert.True(t, ok)
+		assert.Equal(t, -1, exitErr.Code)
+		assert.Equal(t, syscall.SIGKILL, exitErr.Signal)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, "command sh -c 'sleep 10 & echo started; wait' killed by signal killed: context deadline exceeded", err.Error())
+	}
+
+	// context cancellation
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		err := NewCmd("sleep", "10").Context(ctx).Run()
+		assert.True(t, errors.Is(err, context.Canceled))
+	}
+}
+
+func TestCmd_String(t *testing.T) {
+	assert.Equal(t, "echo 'hello world' foo", NewCmd("echo", "hello world").Arg("foo").String())
+}
@@ -0,0 +1,526 @@
+package sys
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Copy actions reported to the ProgressOpt callback
+const (
+	CopyActionCopy     = "copy"     // copy a file's content
+	CopyActionHardlink = "hardlink" // hard link to an already copied file sharing the source's inode
+	CopyActionLink     = "link"     // re-create a symbolic link
+	CopyActionMkdir    = "mkdir"    // create a directory
+	CopyActionMknod    = "mknod"    // re-create a FIFO, socket or device
+	CopyActionMove     = "move"     // rename a path in place
+	CopyActionSkip     = "skip"     // skip a path due to a conflict policy or being unchanged
+)
+
+// Compare provides the methods used to detect unchanged files during a copy
+type Compare int
+
+const (
+	// CompareNone always copies files regardless of the destination
+	CompareNone Compare = iota
+
+	// CompareMtime skips files with the same size and modification time like rsync's default
+	CompareMtime
+
+	// CompareChecksum skips files with the same size and content checksum like rsync's --checksum
+	CompareChecksum
+)
+
+// Conflict provides the policies for handling destination files that already exist
+type Conflict int
+
+const (
+	// ConflictOverwrite replaces the existing destination
+	ConflictOverwrite Conflict = iota
+
+	// ConflictSkip keeps the existing destination
+	ConflictSkip
+
+	// ConflictNewer replaces the existing destination only if the source is newer
+	ConflictNewer
+
+	// ConflictRename copies to a new destination name e.g. file_1.txt
+	ConflictRename
+
+	// ConflictError fails the copy
+	ConflictError
+)
+
+// Preserve provides flags for the file attributes to preserve during a copy
+type Preserve int
+
+const (
+	// PreserveMode preserves permission bits
+	PreserveMode Preserve = 1 << iota
+
+	// PreserveOwner preserves the owning user and group
+	PreserveOwner
+
+	// PreserveTimes preserves modification times
+	PreserveTimes
+
+	// PreserveXattrs preserves extended attributes
+	PreserveXattrs
+
+	// PreserveAll preserves all supported attributes
+	PreserveAll = PreserveMode | PreserveOwner | PreserveTimes | PreserveXattrs
+)
+
+// CopyProgress provides details about the progress of a copy or move
+type CopyProgress struct {
+	Action     string // Action being taken e.g. copy, link, mkdir, move or skip
+	Src        string // Source path being processed
+	Dst        string // Destination path being written to
+	Bytes      int64  // Bytes copied so far for the current file
+	Size       int64  // Total size of the current file
+	Done       bool   // True once the action for the current path is complete
+	TotalBytes int64  // Bytes copied so far across all files
+	TotalFiles int    // Files and links completed or skipped so far across all files
+}
+
+// copyReport reports the given progress to the ProgressOpt callback and when in dry run mode
+// prints completed actions to the OutOpt stream if given
+func copyReport(opts []*opt.Opt, p *CopyProgress) {
+	if progress := getProgressOpt(opts); progress != nil {
+		progress(p)
+	}
+	if p.Done && opt.GetDryrunOpt(opts) {
+		if out := opt.DefaultOutOpt(opts, nil); out != nil {
+			fmt.Fprintf(out, "%s %s => %s\n", p.Action, p.Src, p.Dst)
+		}
+	}
+}
+
+// copyTotals wraps the ProgressOpt callback in the given options to track totals across files
+func copyTotals(opts []*opt.Opt) []*opt.Opt {
+	progress := getProgressOpt(opts)
+	if progress == nil {
+		return opts
+	}
+	var src string
+	var last, totalBytes int64
+	var totalFiles int
+	opts = opt.Copy(opts)
+	opt.Overwrite(&opts, ProgressOpt(func(p *CopyProgress) {
+		if p.Src != src {
+			src, last = p.Src, 0
+		}
+		totalBytes += p.Bytes - last
+		last = p.Bytes
+		if p.Done && p.Action != CopyActionMkdir {
+			totalFiles++
+			src, last = "", 0
+		}
+		p.TotalBytes, p.TotalFiles = totalBytes, totalFiles
+		progress(p)
+	}))
+	return opts
+}
+
+// copyInode identifies a file by its device and inode
+type copyInode struct {
+	dev uint64
+	ino uint64
+}
+
+// copyHardlinks adds tracking of the destinations of copied files to the given options so
+// files with multiple hard links are only copied once and linked to after that
+func copyHardlinks(opts []*opt.Opt) []*opt.Opt {
+	opts = opt.Copy(opts)
+	opt.Overwrite(&opts, &opt.Opt{Key: "hardlinks", Val: map[copyInode]string{}})
+	return opts
+}
+
+// copyHardlink returns the OS destination already used for the file described by srcInfo
+// when it has multiple hard links, otherwise dstPath is recorded as its destination
+func copyHardlink(srcInfo *FileInfo, dstPath string, opts []*opt.Opt) (prev string) {
+	o := opt.Get(opts, "hardlinks")
+	if o == nil || !srcInfo.Obj.Mode().IsRegular() {
+		return
+	}
+	stat, ok := srcInfo.Sys().(*syscall.Stat_t)
+	if !ok || uint64(stat.Nlink) < 2 {
+		return
+	}
+	links := o.Val.(map[copyInode]string)
+	key := copyInode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+	if prev = links[key]; prev == "" {
+		links[key] = dstPath
+	}
+	return
+}
+
+// copyAction returns the action to take for the given source file or link
+func copyAction(srcInfo *FileInfo, skip bool, hardlink string) string {
+	switch {
+	case skip:
+		return CopyActionSkip
+	case srcInfo.IsSymlink():
+		return CopyActionLink
+	case hardlink != "":
+		return CopyActionHardlink
+	case isNode(srcInfo):
+		return CopyActionMknod
+	}
+	return CopyActionCopy
+}
+
+// copyData copies the content of r to w reporting progress. Between OS files the data is cloned
+// with a reflink when supported, only the data of sparse files is copied leaving holes in place
+// and the copy is done within the kernel when possible falling back on reading and writing.
+func copyData(w io.Writer, r io.Reader, p *CopyProgress, opts []*opt.Opt) (err error) {
+	fw, wok := w.(*os.File)
+	fr, rok := r.(*os.File)
+	if wok && rok {
+		if info, e := fr.Stat(); e == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			return copyFile(fw, fr, info.Size(), p, opts)
+		}
+	}
+	if getProgressOpt(opts) != nil {
+		w = &progressWriter{w: w, p: p, opts: opts}
+	}
+	_, err = io.Copy(w, r)
+	return
+}
+
+// copyFile copies size bytes of the src file to the dst file as described by copyData
+func copyFile(dst, src *os.File, size int64, p *CopyProgress, opts []*opt.Opt) (err error) {
+
+	// Share the data using a reflink when supported e.g. btrfs or xfs
+	if cloneFile(dst, src) == nil {
+		p.Bytes = size
+		copyReport(opts, p)
+		return
+	}
+
+	// Copy the data segments leaving holes in place then extend the file over any trailing hole
+	for off := int64(0); off < size; {
+		start, end := dataSegment(src, off, size)
+		if err = copyRange(dst, src, start, end-start, p, opts); err != nil {
+			return
+		}
+		off = end
+	}
+	return dst.Truncate(size)
+}
+
+// copyRange copies n bytes at the given offset of src to the same offset of dst within the kernel
+// when possible falling back on reading and writing
+func copyRange(dst, src *os.File, off, n int64, p *CopyProgress, opts []*opt.Opt) (err error) {
+	for n > 0 {
+		var written int64
+		if written, err = copyFileRange(dst, src, off, n); err != nil || written == 0 {
+			var w io.Writer = io.NewOffsetWriter(dst, off)
+			if getProgressOpt(opts) != nil {
+				w = &progressWriter{w: w, p: p, opts: opts}
+			}
+			_, err = io.Copy(w, io.NewSectionReader(src, off, n))
+			return
+		}
+		off, n = off+written, n-written
+		p.Bytes += written
+		copyReport(opts, p)
+	}
+	return
+}
+
+// dataSegment returns the start and end of the next segment of data at or after the given
+// offset of the file. Filesystems without support for holes treat the rest of the file as data.
+func dataSegment(f *os.File, off, size int64) (start, end int64) {
+	var err error
+	if start, err = f.Seek(off, unix.SEEK_DATA); err != nil {
+		if errors.Is(err, syscall.ENXIO) {
+			return size, size
+		}
+		return off, size
+	}
+	if end, err = f.Seek(start, unix.SEEK_HOLE); err != nil || end > size {
+		end = size
+	}
+	return
+}
+
+// copyNode re-creates the FIFO, socket or device described by srcInfo at dstPath. Only root
+// may create devices.
+func copyNode(srcInfo *FileInfo, dstPath string) (err error) {
+	stat, ok := srcInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.Errorf("failed to get the file type of %s", srcInfo.Path)
+	}
+	if err = unix.Mknod(dstPath, uint32(stat.Mode), int(stat.Rdev)); err != nil {
+		err = errors.Wrapf(err, "failed to create node %s", dstPath)
+	}
+	return
+}
+
+// isNode returns true if the info is for a FIFO, socket or device
+func isNode(info *FileInfo) bool {
+	return info.Obj.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice) != 0
+}
+
+// copyConflict determines the destination to use for the given source according to the
+// CompareOpt and ConflictOpt options. The source is read from the filesystem its info came from
+// and the destination from the given filesystem or the OS if nil. Returns skip true if the
+// source shouldn't be copied.
+func copyConflict(src string, srcInfo *FileInfo, dstFS FS, dstPath string, opts []*opt.Opt) (dst string, skip bool, err error) {
+	dst = dstPath
+	dstInfo, e := LstatFS(dstFS, dstPath)
+	if e != nil {
+		return
+	}
+
+	// Skip unchanged files
+	if srcInfo.Obj.Mode().IsRegular() && dstInfo.Mode().IsRegular() && srcInfo.Size() == dstInfo.Size() {
+		switch getCompareOpt(opts) {
+		case CompareMtime:
+			if srcInfo.ModTime().Equal(dstInfo.ModTime()) {
+				skip = true
+				return
+			}
+		case CompareChecksum:
+			srcSum, e1 := hashFS(srcInfo.fsys, srcInfo.Path, HashMD5)
+			dstSum, e2 := hashFS(dstFS, dstPath, HashMD5)
+			if e1 == nil && e2 == nil && srcSum == dstSum {
+				skip = true
+				return
+			}
+		}
+	}
+
+	switch getConflictOpt(opts) {
+	case ConflictSkip:
+		skip = true
+	case ConflictNewer:
+		skip = !srcInfo.ModTime().After(dstInfo.ModTime())
+	case ConflictRename:
+		dst = renamePath(dstFS, dstPath)
+	case ConflictError:
+		err = errors.Errorf("failed to copy %s: destination %s already exists", src, dstPath)
+	}
+	return
+}
+
+// copyFilter returns true if the given path relative to the copy root should be skipped
+// according to the IncludeOpt and ExcludeOpt options. Includes only apply to non directories.
+func copyFilter(rel string, isDir bool, opts []*opt.Opt) bool {
+	if rel == "" {
+		return false
+	}
+	if matchPatterns(rel, getExcludeOpt(opts)) {
+		return true
+	}
+	if includes := getIncludeOpt(opts); !isDir && len(includes) > 0 && !matchPatterns(rel, includes) {
+		return true
+	}
+	return false
+}
+
+// copyPath copies the file or link described by srcInfo to the exact dstPath honoring the
+// conflict, compare, dry run, progress and preserve options. The src path is only used for
+// reporting as it may differ from srcInfo.Path when following links. Returns the destination
+// path used which will differ from dstPath when renaming due to a conflict.
+func copyPath(src string, srcInfo *FileInfo, dstPath string, opts []*opt.Opt) (result string, err error) {
+	var skip bool
+	if dstPath, skip, err = copyConflict(src, srcInfo, nil, dstPath, opts); err != nil {
+		return
+	}
+	hardlink := copyHardlink(srcInfo, dstPath, opts)
+	action := copyAction(srcInfo, skip, hardlink)
+	p := &CopyProgress{Action: action, Src: src, Dst: dstPath, Size: srcInfo.Size()}
+	if skip || opt.GetDryrunOpt(opts) {
+		p.Done = true
+		copyReport(opts, p)
+		result = dstPath
+		return
+	}
+
+	// Create any missing parent directories using the source's parent directory permissions
+	if _, e := os.Stat(path.Dir(dstPath)); os.IsNotExist(e) {
+		mode := os.FileMode(0755)
+		if info, e := os.Stat(path.Dir(srcInfo.Path)); e == nil {
+			mode = info.Mode()
+		}
+		if err = os.MkdirAll(path.Dir(dstPath), mode); err != nil {
+			return
+		}
+	}
+
+	// Only regular files can be written through so remove any other existing destination first
+	if dstInfo, e := os.Lstat(dstPath); e == nil && (action != CopyActionCopy || !dstInfo.Mode().IsRegular()) {
+		if err = os.Remove(dstPath); err != nil {
+			err = errors.Wrapf(err, "failed to remove existing destination %s", dstPath)
+			return
+		}
+	}
+
+	// Handle links a bit differently
+	if srcInfo.IsSymlink() {
+		var target string
+		if target, err = srcInfo.SymlinkTarget(); err != nil {
+			return
+		}
+		if err = os.Symlink(target, dstPath); err != nil {
+			return
+		}
+	} else if hardlink != "" {
+		if err = os.Link(hardlink, dstPath); err != nil {
+			err = errors.Wrapf(err, "failed to create hard link %s", dstPath)
+			return
+		}
+	} else if isNode(srcInfo) {
+		if err = copyNode(srcInfo, dstPath); err != nil {
+			return
+		}
+	} else {
+		// Open srcPath for reading
+		var fr *os.File
+		if fr, err = os.Open(srcInfo.Path); err != nil {
+			err = errors.Wrapf(err, "failed to open file %s for reading", srcInfo.Path)
+			return
+		}
+		defer fr.Close()
+
+		// Create dstPath for writing
+		var fw *os.File
+		if fw, err = os.Create(dstPath); err != nil {
+			err = errors.Wrapf(err, "failed to create file %s", dstPath)
+			return
+		}
+
+		// Copy srcPath to dstPath
+		if err = copyData(fw, fr, p, opts); err != nil {
+			err = errors.Wrapf(err, "failed to copy data to file %s", dstPath)
+			if e := fw.Close(); e != nil {
+				err = errors.Wrapf(err, "failed to close file %s", dstPath)
+			}
+			return
+		}
+
+		// Sync to disk
+		if err = fw.Sync(); err != nil {
+			err = errors.Wrapf(err, "failed to sync data to file %s", dstPath)
+			if e := fw.Close(); e != nil {
+				err = errors.Wrapf(err, "failed to close file %s", dstPath)
+			}
+			return
+		}
+
+		// Close file for writing
+		if err = fw.Close(); err != nil {
+			err = errors.Wrapf(err, "failed to close file %s", dstPath)
+			return
+		}
+	}
+
+	if err = copyPreserve(srcInfo, dstPath, opts); err != nil {
+		return
+	}
+	p.Done = true
+	copyReport(opts, p)
+	result = dstPath
+	return
+}
+
+// copyPreserve applies the src attributes called out by the PreserveOpt to the dst path
+func copyPreserve(srcInfo *FileInfo, dstPath string, opts []*opt.Opt) (err error) {
+	preserve := getPreserveOpt(opts)
+	link := srcInfo.IsSymlink()
+
+	// Owner must be set first as chown clears the setuid and setgid bits
+	if preserve&PreserveOwner != 0 {
+		if stat, ok := srcInfo.Sys().(*syscall.Stat_t); ok {
+			if err = os.Lchown(dstPath, int(stat.Uid), int(stat.Gid)); err != nil {
+				return errors.Wrapf(err, "failed to preserve owner of %s", dstPath)
+			}
+		}
+	}
+	if preserve&PreserveMode != 0 && !link {
+		if err = os.Chmod(dstPath, srcInfo.Mode()); err != nil {
+			return errors.Wrapf(err, "failed to chmod file %s", dstPath)
+		}
+	}
+	if preserve&PreserveXattrs != 0 {
+		if err = copyXattrs(srcInfo.Path, dstPath); err != nil {
+			return
+		}
+	}
+	if preserve&PreserveTimes != 0 {
+		ts := unix.NsecToTimespec(srcInfo.ModTime().UnixNano())
+		if err = unix.UtimesNanoAt(unix.AT_FDCWD, dstPath, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return errors.Wrapf(err, "failed to preserve times of %s", dstPath)
+		}
+	}
+	return
+}
+
+// copyXattrs copies the extended attributes of the src path to the dst path without following
+// links. Filesystems that don't support extended attributes are ignored.
+func copyXattrs(src, dst string) (err error) {
+	var size int
+	if size, err = unix.Llistxattr(src, nil); err != nil || size == 0 {
+		if err == unix.ENOTSUP {
+			err = nil
+		}
+		return errors.Wrapf(err, "failed to list xattrs of %s", src)
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(src, buf); err != nil {
+		return errors.Wrapf(err, "failed to list xattrs of %s", src)
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		var val []byte
+		if size, err = unix.Lgetxattr(src, name, nil); err == nil {
+			val = make([]byte, size)
+			size, err = unix.Lgetxattr(src, name, val)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to get xattr %s of %s", name, src)
+		}
+		if err = unix.Lsetxattr(dst, name, val[:size], 0); err != nil && err != unix.ENOTSUP {
+			return errors.Wrapf(err, "failed to set xattr %s of %s", name, dst)
+		}
+		err = nil
+	}
+	return
+}
+
+// renamePath returns the first path that doesn't exist in the given filesystem or the OS if nil
+// of the form dir/name_N.ext
+func renamePath(fsys FS, target string) string {
+	dir, base := path.Split(target)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	for i := 1; ; i++ {
+		candidate := path.Join(dir, fmt.Sprintf("%s_%d%s", name, i, ext))
+		if _, err := LstatFS(fsys, candidate); err != nil {
+			return candidate
+		}
+	}
+}
+
+// progressWriter reports the bytes written through it to the ProgressOpt callback
+type progressWriter struct {
+	w    io.Writer
+	p    *CopyProgress
+	opts []*opt.Opt
+}
+
+// Write implements the io.Writer interface
+func (w *progressWriter) Write(b []byte) (n int, err error) {
+	n, err = w.w.Write(b)
+	w.p.Bytes += int64(n)
+	copyReport(w.opts, w.p)
+	return
+}
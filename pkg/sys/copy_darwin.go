@@ -0,0 +1,20 @@
+//go:build darwin
+// +build darwin
+
+package sys
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile isn't supported for already open files on darwin
+func cloneFile(dst, src *os.File) error {
+	return unix.ENOTSUP
+}
+
+// copyFileRange isn't supported on darwin
+func copyFileRange(dst, src *os.File, off, n int64) (int64, error) {
+	return 0, unix.ENOTSUP
+}
@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package sys
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile shares the data of src with dst using a reflink on filesystems that support it
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
+
+// copyFileRange copies up to n bytes at the given offset of src to the same offset of dst
+// within the kernel
+func copyFileRange(dst, src *os.File, off, n int64) (int64, error) {
+	roff, woff := off, off
+	written, err := unix.CopyFileRange(int(src.Fd()), &roff, int(dst.Fd()), &woff, int(n), 0)
+	return int64(written), err
+}
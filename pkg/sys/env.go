@@ -0,0 +1,492 @@
+package sys
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Env provides an ordered set of environment variables for building the environment of the
+// current process or of commands see Cmd.Environ. Variables keep the order they were first set.
+type Env struct {
+	keys []string          // variable names in the order they were set
+	vals map[string]string // variable values by name
+}
+
+// NewEnv creates a new Env from the given KEY=VALUE pairs ignoring those without an =. Later
+// values override earlier ones with the same key.
+func NewEnv(pairs ...string) *Env {
+	env := &Env{vals: map[string]string{}}
+	for _, pair := range pairs {
+		if i := strings.Index(pair, "="); i > 0 {
+			env.Set(pair[:i], pair[i+1:])
+		}
+	}
+	return env
+}
+
+// OSEnv creates a new Env from the current process's environment
+func OSEnv() *Env {
+	return NewEnv(os.Environ()...)
+}
+
+// ReadEnv reads the given .env file into a new Env see ParseEnv
+func ReadEnv(target string) (env *Env, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = os.ReadFile(target); err != nil {
+		err = errors.Wrapf(err, "failed to read the .env file %s", target)
+		return
+	}
+	if env, err = ParseEnv(string(data)); err != nil {
+		err = errors.Wrapf(err, "failed to parse the .env file %s", target)
+	}
+	return
+}
+
+// ParseEnv parses the given .env formatted data into a new Env.
+// * Blank lines and lines starting with # are ignored as are # comments after values
+// * Lines are of the form KEY=VALUE with an optional export prefix e.g. export KEY=VALUE
+// * Single quoted values are taken literally and may span lines
+// * Double quoted values may span lines, support the \n \r \t \" \\ and \$ escapes and expand variables
+// * Unquoted values are trimmed of surrounding whitespace and expand variables
+// * Variables expand as with ExpandVars against those defined earlier then the current environment
+func ParseEnv(data string) (env *Env, err error) {
+	p := &envParser{runes: []rune(data), line: 1, vars: environ()}
+	env = NewEnv()
+	for {
+		var key, val string
+		if key, val, err = p.next(); err != nil || key == "" {
+			break
+		}
+		env.Set(key, val)
+		p.vars[key] = val
+	}
+	if err != nil {
+		env = nil
+	}
+	return
+}
+
+// Apply sets the variables of the Env in the current process's environment. Variables not in
+// the Env are left as is.
+func (e *Env) Apply() (err error) {
+	for _, key := range e.keys {
+		if val, ok := os.LookupEnv(key); !ok || val != e.vals[key] {
+			if err = os.Setenv(key, e.vals[key]); err != nil {
+				return errors.Wrapf(err, "failed to set environment variable %s", key)
+			}
+		}
+	}
+	return
+}
+
+// Clone returns a copy of the Env
+func (e *Env) Clone() *Env {
+	return NewEnv(e.Environ()...)
+}
+
+// Diff returns the variables of the Env that are new or differ from the given base and the
+// names of the base's variables not in the Env e.g. Diff(OSEnv()) for the changes the Env
+// would make to the current process.
+func (e *Env) Diff(base *Env) (set *Env, unset []string) {
+	set, unset = NewEnv(), []string{}
+	for _, key := range e.keys {
+		if val, ok := base.Lookup(key); !ok || val != e.vals[key] {
+			set.Set(key, e.vals[key])
+		}
+	}
+	for _, key := range base.keys {
+		if _, ok := e.vals[key]; !ok {
+			unset = append(unset, key)
+		}
+	}
+	return
+}
+
+// Environ returns the variables as KEY=VALUE pairs in order as with os.Environ
+func (e *Env) Environ() (pairs []string) {
+	pairs = make([]string, 0, len(e.keys))
+	for _, key := range e.keys {
+		pairs = append(pairs, key+"="+e.vals[key])
+	}
+	return
+}
+
+// Expand expands the variables in the given string against the Env see ExpandVars
+func (e *Env) Expand(str string) (string, error) {
+	return ExpandVars(str, e.vals)
+}
+
+// Get returns the value of the given variable or empty if not set
+func (e *Env) Get(key string) string {
+	return e.vals[key]
+}
+
+// GetBool returns the value of the given variable as a bool using strconv.ParseBool.
+// Returns false if not set.
+func (e *Env) GetBool(key string) (val bool, err error) {
+	if str, ok := e.vals[key]; ok {
+		if val, err = strconv.ParseBool(str); err != nil {
+			err = errors.Wrapf(err, "failed to convert %s to bool", key)
+		}
+	}
+	return
+}
+
+// GetDuration returns the value of the given variable as a time.Duration using
+// time.ParseDuration. Returns 0 if not set.
+func (e *Env) GetDuration(key string) (val time.Duration, err error) {
+	if str, ok := e.vals[key]; ok {
+		if val, err = time.ParseDuration(str); err != nil {
+			err = errors.Wrapf(err, "failed to convert %s to time.Duration", key)
+		}
+	}
+	return
+}
+
+// GetFloat returns the value of the given variable as a float64. Returns 0 if not set.
+func (e *Env) GetFloat(key string) (val float64, err error) {
+	if str, ok := e.vals[key]; ok {
+		if val, err = strconv.ParseFloat(str, 64); err != nil {
+			err = errors.Wrapf(err, "failed to convert %s to float64", key)
+		}
+	}
+	return
+}
+
+// GetInt returns the value of the given variable as an int also converting true and false to
+// 1 and 0 as with n.ToInt. Returns 0 if not set.
+func (e *Env) GetInt(key string) (val int, err error) {
+	if str, ok := e.vals[key]; ok {
+		if val, err = strconv.Atoi(str); err != nil {
+			if b, perr := strconv.ParseBool(str); perr == nil {
+				val, err = 0, nil
+				if b {
+					val = 1
+				}
+			} else {
+				err = errors.Wrapf(err, "failed to convert %s to int", key)
+			}
+		}
+	}
+	return
+}
+
+// GetList returns the value of the given variable split into a list e.g. the dirs of PATH.
+// Empty elements are dropped.
+func (e *Env) GetList(key string) (list []string) {
+	list = []string{}
+	for _, elem := range filepath.SplitList(e.vals[key]) {
+		if elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return
+}
+
+// Keys returns the names of the variables in order
+func (e *Env) Keys() []string {
+	return append([]string{}, e.keys...)
+}
+
+// Len returns the number of variables
+func (e *Env) Len() int {
+	return len(e.keys)
+}
+
+// Lookup returns the value of the given variable and true if it is set
+func (e *Env) Lookup(key string) (val string, ok bool) {
+	val, ok = e.vals[key]
+	return
+}
+
+// Map returns the variables as a map
+func (e *Env) Map() (env map[string]string) {
+	env = make(map[string]string, len(e.vals))
+	for key, val := range e.vals {
+		env[key] = val
+	}
+	return
+}
+
+// Merge sets the variables of the given Env in this Env and returns a reference to this Env
+func (e *Env) Merge(other *Env) *Env {
+	for _, key := range other.keys {
+		e.Set(key, other.vals[key])
+	}
+	return e
+}
+
+// PathAppend appends the given dirs to the list in the given variable e.g. PATH dropping
+// duplicates such that existing dirs stay where they are. Returns a reference to the Env.
+func (e *Env) PathAppend(key string, dirs ...string) *Env {
+	return e.setList(key, append(e.GetList(key), dirs...))
+}
+
+// PathDedupe drops duplicate dirs from the list in the given variable e.g. PATH keeping the
+// first of each. Returns a reference to the Env.
+func (e *Env) PathDedupe(key string) *Env {
+	if _, ok := e.vals[key]; ok {
+		e.setList(key, e.GetList(key))
+	}
+	return e
+}
+
+// PathPrepend prepends the given dirs to the list in the given variable e.g. PATH dropping
+// duplicates such that existing dirs move to the front. Returns a reference to the Env.
+func (e *Env) PathPrepend(key string, dirs ...string) *Env {
+	return e.setList(key, append(append([]string{}, dirs...), e.GetList(key)...))
+}
+
+// Set sets the given variable and returns a reference to the Env
+func (e *Env) Set(key, val string) *Env {
+	if _, ok := e.vals[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+	e.vals[key] = val
+	return e
+}
+
+// String returns the variables in .env format with values double quoted as needed such that
+// ParseEnv would return the same variables
+func (e *Env) String() string {
+	var b strings.Builder
+	for _, key := range e.keys {
+		b.WriteString(key + "=" + envQuote(e.vals[key]) + "\n")
+	}
+	return b.String()
+}
+
+// ToMap returns the variables as a map[string]interface{} e.g. for n.ToStringMap
+func (e *Env) ToMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(e.vals))
+	for key, val := range e.vals {
+		m[key] = val
+	}
+	return m
+}
+
+// Unset removes the given variables and returns a reference to the Env
+func (e *Env) Unset(keys ...string) *Env {
+	for _, key := range keys {
+		if _, ok := e.vals[key]; ok {
+			delete(e.vals, key)
+			for i := range e.keys {
+				if e.keys[i] == key {
+					e.keys = append(e.keys[:i], e.keys[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return e
+}
+
+// setList sets the given variable to the given list dropping empty and duplicate elements
+func (e *Env) setList(key string, list []string) *Env {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, elem := range list {
+		if elem != "" && !seen[elem] {
+			seen[elem] = true
+			result = append(result, elem)
+		}
+	}
+	return e.Set(key, strings.Join(result, string(os.PathListSeparator)))
+}
+
+// envQuote double quotes the given value if needed for the .env format
+func envQuote(val string) string {
+	if val != "" && !strings.ContainsAny(val, " \t\r\n\"'\\$#") {
+		return val
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(val) + `"`
+}
+
+// envParser parses .env formatted data one entry at a time
+type envParser struct {
+	runes []rune            // runes to process
+	pos   int               // current position in the runes
+	line  int               // current line for error messages
+	vars  map[string]string // variables to expand against
+}
+
+// next returns the next entry or an empty key when there are no more
+func (p *envParser) next() (key, val string, err error) {
+
+	// Skip blank lines and comments
+	for p.pos < len(p.runes) {
+		r := p.runes[p.pos]
+		if r == '#' {
+			p.skipComment()
+			continue
+		}
+		if r != ' ' && r != '\t' && r != '\r' && r != '\n' {
+			break
+		}
+		if r == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.runes) {
+		return
+	}
+	line := p.line
+	if key = p.name(); key == "export" && p.pos < len(p.runes) && (p.runes[p.pos] == ' ' || p.runes[p.pos] == '\t') {
+		p.skipBlank()
+		key = p.name()
+	}
+	p.skipBlank()
+	if key == "" || p.pos >= len(p.runes) || p.runes[p.pos] != '=' {
+		return "", "", errors.Errorf("invalid .env entry at line %d", line)
+	}
+	p.pos++
+	p.skipBlank()
+
+	// Parse the value by its quoting
+	if p.pos < len(p.runes) && p.runes[p.pos] == '\'' {
+		val, err = p.singleQuoted()
+	} else if p.pos < len(p.runes) && p.runes[p.pos] == '"' {
+		val, err = p.doubleQuoted()
+	} else {
+		val, err = p.unquoted()
+	}
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid .env entry at line %d", line)
+	}
+
+	// Only a comment may follow the value
+	p.skipBlank()
+	if p.pos < len(p.runes) && p.runes[p.pos] == '#' {
+		p.skipComment()
+	}
+	if p.pos < len(p.runes) && p.runes[p.pos] != '\n' {
+		return "", "", errors.Errorf("invalid .env entry at line %d: unexpected %q after value", line, p.runes[p.pos])
+	}
+	return
+}
+
+// name returns the variable name at the current position
+func (p *envParser) name() string {
+	start := p.pos
+	for p.pos < len(p.runes) {
+		r := p.runes[p.pos]
+		if r != '_' && r != '.' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(p.pos > start && r >= '0' && r <= '9') {
+			break
+		}
+		p.pos++
+	}
+	return string(p.runes[start:p.pos])
+}
+
+// skipBlank skips spaces, tabs and carriage returns
+func (p *envParser) skipBlank() {
+	for p.pos < len(p.runes) && (p.runes[p.pos] == ' ' || p.runes[p.pos] == '\t' || p.runes[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// skipComment skips to the end of the line leaving the newline
+func (p *envParser) skipComment() {
+	for p.pos < len(p.runes) && p.runes[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// singleQuoted returns the literal value between single quotes
+func (p *envParser) singleQuoted() (string, error) {
+	p.pos++
+	start := p.pos
+	for ; p.pos < len(p.runes); p.pos++ {
+		switch p.runes[p.pos] {
+		case '\n':
+			p.line++
+		case '\'':
+			p.pos++
+			return string(p.runes[start : p.pos-1]), nil
+		}
+	}
+	return "", errors.Errorf("unterminated single quote")
+}
+
+// doubleQuoted returns the value between double quotes processing escapes and expansions
+func (p *envParser) doubleQuoted() (string, error) {
+	var result []rune
+	for p.pos++; p.pos < len(p.runes); {
+		switch r := p.runes[p.pos]; r {
+		case '"':
+			p.pos++
+			return string(result), nil
+		case '\\':
+			if p.pos+1 >= len(p.runes) {
+				p.pos++
+				continue
+			}
+			switch next := p.runes[p.pos+1]; next {
+			case 'n':
+				result = append(result, '\n')
+			case 'r':
+				result = append(result, '\r')
+			case 't':
+				result = append(result, '\t')
+			case '"', '\\', '$':
+				result = append(result, next)
+			default:
+				result = append(result, r, next)
+			}
+			p.pos += 2
+		case '$':
+			val, err := p.expand()
+			if err != nil {
+				return "", err
+			}
+			result = append(result, []rune(val)...)
+		default:
+			if r == '\n' {
+				p.line++
+			}
+			result = append(result, r)
+			p.pos++
+		}
+	}
+	return "", errors.Errorf("unterminated double quote")
+}
+
+// unquoted returns the trimmed value up to the end of the line or a comment expanding variables
+func (p *envParser) unquoted() (string, error) {
+	var result []rune
+	for p.pos < len(p.runes) {
+		r := p.runes[p.pos]
+		if r == '\n' || (r == '#' && (len(result) == 0 || result[len(result)-1] == ' ' || result[len(result)-1] == '\t')) {
+			break
+		}
+		if r == '$' {
+			val, err := p.expand()
+			if err != nil {
+				return "", err
+			}
+			result = append(result, []rune(val)...)
+			continue
+		}
+		result = append(result, r)
+		p.pos++
+	}
+	return strings.TrimRight(string(result), " \t\r"), nil
+}
+
+// expand processes a variable expansion at the current $ using the shellLexer
+func (p *envParser) expand() (val string, err error) {
+	l := &shellLexer{runes: p.runes, pos: p.pos, env: p.vars}
+	val, err = l.expand()
+	p.pos = l.pos
+	return
+}
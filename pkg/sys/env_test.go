@@ -0,0 +1,153 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+
+	// ordered variables
+	{
+		env := NewEnv("B=1", "A=2", "bogus", "B=3")
+		assert.Equal(t, []string{"B", "A"}, env.Keys())
+		assert.Equal(t, []string{"B=3", "A=2"}, env.Environ())
+		assert.Equal(t, map[string]string{"A": "2", "B": "3"}, env.Map())
+		assert.Equal(t, map[string]interface{}{"A": "2", "B": "3"}, env.ToMap())
+		env.Set("C", "4").Unset("B", "D")
+		assert.Equal(t, []string{"A=2", "C=4"}, env.Environ())
+		_, ok := env.Lookup("B")
+		assert.False(t, ok)
+		assert.Equal(t, []string{"A=2", "C=4", "B=5"}, env.Clone().Merge(NewEnv("B=5", "A=2")).Environ())
+		assert.Equal(t, 2, env.Len())
+	}
+
+	// typed getters
+	{
+		env := NewEnv("BOOL=true", "INT=12", "INTB=false", "FLOAT=1.5", "DUR=2s", "BAD=x")
+		b, err := env.GetBool("BOOL")
+		assert.Nil(t, err)
+		assert.True(t, b)
+		i, err := env.GetInt("INT")
+		assert.Nil(t, err)
+		assert.Equal(t, 12, i)
+		i, err = env.GetInt("INTB")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, i)
+		f, err := env.GetFloat("FLOAT")
+		assert.Nil(t, err)
+		assert.Equal(t, 1.5, f)
+		d, err := env.GetDuration("DUR")
+		assert.Nil(t, err)
+		assert.Equal(t, 2*time.Second, d)
+		i, err = env.GetInt("UNSET")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, i)
+
+		_, err = env.GetInt("BAD")
+		assert.Equal(t, `failed to convert BAD to int: strconv.Atoi: parsing "x": invalid syntax`, err.Error())
+		_, err = env.GetBool("BAD")
+		assert.Equal(t, `failed to convert BAD to bool: strconv.ParseBool: parsing "x": invalid syntax`, err.Error())
+	}
+
+	// path lists
+	{
+		env := NewEnv("PATH=/usr/bin:/bin::/usr/bin")
+		assert.Equal(t, []string{"/usr/bin", "/bin", "/usr/bin"}, env.GetList("PATH"))
+		assert.Equal(t, "/usr/bin:/bin", env.PathDedupe("PATH").Get("PATH"))
+		assert.Equal(t, "/opt/bin:/bin:/usr/bin", env.PathPrepend("PATH", "/opt/bin", "/bin").Get("PATH"))
+		assert.Equal(t, "/opt/bin:/bin:/usr/bin:/sbin", env.PathAppend("PATH", "/usr/bin", "/sbin").Get("PATH"))
+		assert.Equal(t, "/a", env.PathAppend("NEW", "/a").Get("NEW"))
+		env.PathDedupe("UNSET")
+		_, ok := env.Lookup("UNSET")
+		assert.False(t, ok)
+	}
+
+	// expand
+	{
+		result, err := NewEnv("HOME=/home/foo").Expand("${HOME}/bin:${UNSET:-/bin}")
+		assert.Nil(t, err)
+		assert.Equal(t, "/home/foo/bin:/bin", result)
+	}
+
+	// diff and apply against the current process
+	{
+		t.Setenv("SYS_ENV_TEST", "a")
+		t.Setenv("SYS_ENV_GONE", "b")
+		env := OSEnv().Set("SYS_ENV_TEST", "c").Set("SYS_ENV_NEW", "d").Unset("SYS_ENV_GONE")
+		set, unset := env.Diff(OSEnv())
+		assert.Equal(t, []string{"SYS_ENV_TEST=c", "SYS_ENV_NEW=d"}, set.Environ())
+		assert.Equal(t, []string{"SYS_ENV_GONE"}, unset)
+
+		t.Setenv("SYS_ENV_NEW", "")
+		assert.Nil(t, set.Apply())
+		assert.Equal(t, "c", os.Getenv("SYS_ENV_TEST"))
+		assert.Equal(t, "d", os.Getenv("SYS_ENV_NEW"))
+		assert.Equal(t, "b", os.Getenv("SYS_ENV_GONE"))
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	t.Setenv("SYS_ENV_TEST", "os")
+
+	// quoting, comments, export and interpolation
+	{
+		env, err := ParseEnv(`
+# comment
+export A=plain value  # trailing comment
+B='single $A # kept
+line'
+C="double\t$A\n\"${SYS_ENV_TEST}\" \$A # kept"
+D=${A}-${B:+set}-${UNSET:-default}
+E=
+F=a#b
+	G = "spaced"
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"A", "B", "C", "D", "E", "F", "G"}, env.Keys())
+		assert.Equal(t, "plain value", env.Get("A"))
+		assert.Equal(t, "single $A # kept\nline", env.Get("B"))
+		assert.Equal(t, "double\tplain value\n\"os\" $A # kept", env.Get("C"))
+		assert.Equal(t, "plain value-set-default", env.Get("D"))
+		assert.Equal(t, "", env.Get("E"))
+		assert.Equal(t, "a#b", env.Get("F"))
+		assert.Equal(t, "spaced", env.Get("G"))
+
+		// round trip
+		result, err := ParseEnv(env.String())
+		assert.Nil(t, err)
+		assert.Equal(t, env.Environ(), result.Environ())
+		assert.Equal(t, "A=\"plain value\"\n", NewEnv("A=plain value").String())
+	}
+
+	// invalid entries
+	{
+		_, err := ParseEnv("A=1\n\nB")
+		assert.Equal(t, "invalid .env entry at line 3", err.Error())
+		_, err = ParseEnv("A='1\nB=2")
+		assert.Equal(t, "invalid .env entry at line 1: unterminated single quote", err.Error())
+		_, err = ParseEnv(`A="1" 2`)
+		assert.Equal(t, `invalid .env entry at line 1: unexpected '2' after value`, err.Error())
+		_, err = ParseEnv("1A=2")
+		assert.Equal(t, "invalid .env entry at line 1", err.Error())
+	}
+
+	// files
+	{
+		resetTest()
+		target := path.Join(tmpDir, ".env")
+		assert.Nil(t, WriteString(target, "A=1\n"))
+		env, err := ReadEnv(target)
+		assert.Nil(t, err)
+		assert.Equal(t, "1", env.Get("A"))
+
+		assert.Nil(t, WriteString(target, "A\n"))
+		_, err = ReadEnv(target)
+		abs, _ := Abs(target)
+		assert.Equal(t, "failed to parse the .env file "+abs+": invalid .env entry at line 1", err.Error())
+	}
+}
@@ -2,9 +2,9 @@ package sys
 
 import (
 	"os"
-	"path/filepath"
 	"time"
 
+	"github.com/phR0ze/n/pkg/opt"
 	"github.com/pkg/errors"
 )
 
@@ -12,11 +12,17 @@ import (
 type FileInfo struct {
 	Path string      // absolute path to the file set when created
 	Obj  os.FileInfo // handle on the actual OS object to use where needed
+	fsys FS          // filesystem the info came from or nil for the OS
 }
 
 // Lstat wraps os.Lstat to give back a FileInfo
 // Resolves home dir and relative dir pathing into absolute paths
-func Lstat(src string) (result *FileInfo, err error) {
+// Resolves the path within a directory by passing in ChrootOpt(dir) see RootFS
+// Stats a filesystem other than the OS by passing in FSOpt(fsys) see LstatFS
+func Lstat(src string, opts ...*opt.Opt) (result *FileInfo, err error) {
+	if fsys := getFSOpt(opts); fsys != nil {
+		return LstatFS(fsys, src)
+	}
 	if src, err = Abs(src); err != nil {
 		return
 	}
@@ -145,8 +151,8 @@ func IsSymlink(src string) bool {
 // IsSymlinkDir returns true if the symlink's target is a directory
 func (info *FileInfo) IsSymlinkDir() bool {
 	if info.Obj.Mode()&os.ModeSymlink != 0 {
-		if target, err := filepath.EvalSymlinks(info.Path); err == nil {
-			if subinfo, err := Lstat(target); err == nil {
+		if target, err := fsEvalSymlinks(info.fsys, info.Path); err == nil {
+			if subinfo, err := LstatFS(info.fsys, target); err == nil {
 				if subinfo.IsDir() {
 					return true
 				}
@@ -167,8 +173,8 @@ func IsSymlinkDir(src string) bool {
 // IsSymlinkFile returns true if the symlink's target is a file
 func (info *FileInfo) IsSymlinkFile() bool {
 	if info.Obj.Mode()&os.ModeSymlink != 0 {
-		if target, err := filepath.EvalSymlinks(info.Path); err == nil {
-			if subinfo, err := Lstat(target); err == nil {
+		if target, err := fsEvalSymlinks(info.fsys, info.Path); err == nil {
+			if subinfo, err := LstatFS(info.fsys, target); err == nil {
 				if !subinfo.IsDir() {
 					return true
 				}
@@ -192,7 +198,11 @@ func (info *FileInfo) SymlinkTarget() (target string, err error) {
 		err = errors.Errorf("not a symlink")
 		return
 	}
-	if target, err = os.Readlink(info.Path); err != nil {
+	readlink := os.Readlink
+	if info.fsys != nil {
+		readlink = info.fsys.Readlink
+	}
+	if target, err = readlink(info.Path); err != nil {
 		err = errors.Errorf("failed to read the link target")
 		return
 	}
@@ -212,7 +222,7 @@ func SymlinkTarget(src string) (target string, err error) {
 // SymlinkTargetExists returns true if the symlink's target exists
 func (info *FileInfo) SymlinkTargetExists() bool {
 	if info.Obj.Mode()&os.ModeSymlink != 0 {
-		if _, err := filepath.EvalSymlinks(info.Path); err == nil {
+		if target, err := fsEvalSymlinks(info.fsys, info.Path); err == nil && (info.fsys == nil || ExistsFS(info.fsys, target)) {
 			return true
 		}
 	}
@@ -3,13 +3,12 @@ package sys
 import (
 	"bufio"
 	"bytes"
-	"crypto/md5"
-	"encoding/hex"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/phR0ze/n/pkg/opt"
 	"github.com/pkg/errors"
@@ -19,19 +18,25 @@ import (
 // For each resulting path if the file is a symbolic link, it changes the mode of the link's
 // target. Recursively apply chmod to all files and directories by passing in RecurseOpt(true)
 // Apply chmod to only directories or files with OnlyDirsOpt(true) and/or OnlyFilesOpt(true)
+// Apply chmod to a filesystem other than the OS by passing in FSOpt(fsys)
 func Chmod(path string, mode os.FileMode, opts ...*opt.Opt) (err error) {
 	recurse := getRecurseOpt(opts)
 	onlyDirs := getOnlyDirsOpt(opts)
 	onlyFiles := getOnlyFilesOpt(opts)
+	fsys := getFSOpt(opts)
 
 	// Path expansion
-	if path, err = Abs(path); err != nil {
+	if path, err = fsAbs(fsys, path); err != nil {
 		return
 	}
 
 	// Handle globbing
 	var sources []string
-	if sources, err = filepath.Glob(path); err != nil {
+	if fsys != nil {
+		if sources, err = Glob(path, FSOpt(fsys)); err != nil {
+			return
+		}
+	} else if sources, err = filepath.Glob(path); err != nil {
 		err = errors.Wrapf(err, "failed to get glob for %s", path)
 		return
 	}
@@ -45,16 +50,13 @@ func Chmod(path string, mode os.FileMode, opts ...*opt.Opt) (err error) {
 	// Execute the chmod for all sources
 	for _, source := range sources {
 
-		// Only check if dir if we are required to
+		// Only check if dir and get old mode if we are required to
 		isDir := false
-		if onlyDirs || onlyFiles || recurse {
-			isDir = IsDir(source)
-		}
-
-		// Only get old mode if we have to
 		var oldMode os.FileMode
-		if recurse {
-			oldMode = Mode(source)
+		if onlyDirs || onlyFiles || recurse {
+			if info, e := LstatFS(fsys, source); e == nil {
+				isDir, oldMode = info.IsDir(), info.Mode()
+			}
 		}
 
 		// We have to be careful of the order of applying permissions we'll get into a
@@ -65,7 +67,7 @@ func Chmod(path string, mode os.FileMode, opts ...*opt.Opt) (err error) {
 
 			// Chmod on the way in if not recursing or recursing and adding permissions
 			if !recurse || !isDir || (recurse && !revokingMode(oldMode, mode)) {
-				if err = os.Chmod(source, mode); err != nil {
+				if err = chmodFS(fsys, source, mode); err != nil {
 					err = errors.Wrapf(err, "failed to add permissions with chmod %s", path)
 					return
 				}
@@ -75,7 +77,15 @@ func Chmod(path string, mode os.FileMode, opts ...*opt.Opt) (err error) {
 		// Handle recursion only one dir at a time as permissions need set first
 		// incase we are adding read/execute permissions as we go.
 		if recurse && isDir {
-			for _, path := range Paths(source) {
+			paths := Paths(source)
+			if fsys != nil {
+				names, _ := fsReadDirnames(fsys, source)
+				paths = []string{}
+				for _, name := range names {
+					paths = append(paths, filepath.Join(source, name))
+				}
+			}
+			for _, path := range paths {
 				if err = Chmod(path, mode, opts...); err != nil {
 					return
 				}
@@ -85,7 +95,7 @@ func Chmod(path string, mode os.FileMode, opts ...*opt.Opt) (err error) {
 		// Chmod on the way out if recursing and revoking permissions
 		if (!onlyDirs && !onlyFiles) || (onlyDirs && isDir) || (onlyFiles && !isDir) {
 			if recurse && isDir && revokingMode(oldMode, mode) {
-				if err = os.Chmod(source, mode); err != nil {
+				if err = chmodFS(fsys, source, mode); err != nil {
 					err = errors.Wrapf(err, "failed to revoke permissions with chmod %s", path)
 					return
 				}
@@ -96,6 +106,14 @@ func Chmod(path string, mode os.FileMode, opts ...*opt.Opt) (err error) {
 	return
 }
 
+// chmodFS changes the mode of the given path in the given filesystem or the OS if nil
+func chmodFS(fsys FS, path string, mode os.FileMode) error {
+	if fsys == nil {
+		return os.Chmod(path, mode)
+	}
+	return fsys.Chmod(path, mode)
+}
+
 // determine if the mode change is revoking permissions or adding permissions.
 // only taking into account read/execute on the directory
 func revokingMode(old, new os.FileMode) bool {
@@ -136,15 +154,31 @@ func Chown(path string, uid, gid int, opts ...*opt.Opt) (err error) {
 // * The dst will be a clone of the src if it doesn't exist.
 // * Doesn't follow links by default but can be turned by passing in FollowOpt(true)
 // * Following links will use the link name but replace its content with the target linked to
+// * Reports copy progress for bytes and files to the ProgressOpt callback
+// * Reports planned actions without making changes when given opt.DryrunOpt(true) writing them to opt.OutOpt if given
+// * Handles existing destination files according to ConflictOpt defaulting to ConflictOverwrite
+// * Skips unchanged files according to CompareOpt defaulting to CompareNone
+// * Filters paths relative to the src with the IncludeOpt and ExcludeOpt glob patterns
+// * Preserves the attributes called out by PreserveOpt defaulting to PreserveMode, PreserveXattrs includes ACLs and capabilities
+// * Preserves hard links between files within the copied tree
+// * Preserves holes in sparse files and clones data with reflinks or copies it within the kernel when supported
+// * Re-creates FIFOs, sockets and when run as root devices
+// * Copies within a filesystem other than the OS by passing in FSOpt(fsys) see CopyFS
+// * Copies within a rootfs directory resolving links inside it by passing in ChrootOpt(dir)
 func Copy(src, dst string, opts ...*opt.Opt) (err error) {
 	clone := true
 	var sources []string
+	if fsys := getFSOpt(opts); fsys != nil {
+		return CopyFS(fsys, src, fsys, dst, opts...)
+	}
 
 	// Trim trailing slashes
 	src = strings.TrimSuffix(src, "/")
 
 	// Set following links to false by default
 	defaultFollowOpt(&opts, false)
+	opts = copyHardlinks(copyTotals(opts))
+	dryrun := opt.GetDryrunOpt(opts)
 
 	// Get Abs src and dst roots
 	var dstAbs, srcAbs string
@@ -180,6 +214,9 @@ func Copy(src, dst string, opts ...*opt.Opt) (err error) {
 		// following links.
 		links := [][]string{}
 
+		// Directories to preserve attributes for once their content has been copied
+		dirInfos, dirPaths := []*FileInfo{}, []string{}
+
 		// Walk over file structure
 		err = Walk(root, func(srcPath string, srcInfo *FileInfo, e error) error {
 			if e != nil {
@@ -204,16 +241,37 @@ func Copy(src, dst string, opts ...*opt.Opt) (err error) {
 				dstPath = path.Join(dstAbs, TrimShared(srcPath, path.Dir(root)))
 			}
 
+			// Filter on the path relative to the source root or the root's name
+			rel := TrimShared(srcPath, root)
+			if rel == "" {
+				rel = path.Base(root)
+			}
+
 			switch {
 
 			// Dirs
 			case srcInfo.IsDir():
-				if e = os.MkdirAll(dstPath, srcInfo.Mode()); e != nil {
-					return e
+				if copyFilter(rel, true, opts) {
+					return filepath.SkipDir
+				}
+				// Any error other than existing e.g. permission denied is left for MkdirAll to report
+				if _, e = os.Lstat(dstPath); e != nil {
+					if !dryrun {
+						if e = os.MkdirAll(dstPath, srcInfo.Mode()|0700); e != nil {
+							return e
+						}
+					}
+					copyReport(opts, &CopyProgress{Action: CopyActionMkdir, Src: srcPath, Dst: dstPath, Done: true})
+				}
+				if !dryrun {
+					dirInfos, dirPaths = append(dirInfos, srcInfo), append(dirPaths, dstPath)
 				}
 
 			// Links
 			case srcInfo.IsSymlinkDir():
+				if copyFilter(rel, false, opts) {
+					return filepath.SkipDir
+				}
 				if follow {
 					// Using EvalSymlinks to get full abs path to actual target for path replacement
 					var target string
@@ -223,21 +281,32 @@ func Copy(src, dst string, opts ...*opt.Opt) (err error) {
 					links = append(links, []string{target, srcPath})
 				} else {
 					// Re-create link using SymlinkTarget to retain relative links
-					var target string
-					if target, e = srcInfo.SymlinkTarget(); e != nil {
-						return e
-					}
-					if e = os.Symlink(target, dstPath); e != nil {
+					if _, e = copyPath(srcPath, srcInfo, dstPath, opts); e != nil {
 						return e
 					}
 				}
 
 			// Files
 			default:
-				CopyFile(srcPath, dstPath, InfoOpt(srcInfo))
+				if copyFilter(rel, false, opts) {
+					return nil
+				}
+				if _, e = copyPath(srcPath, srcInfo, dstPath, opts); e != nil {
+					return e
+				}
 			}
 			return nil
 		}, opts...)
+		if err != nil {
+			return
+		}
+
+		// Preserve directory attributes deepest first so times aren't changed by later writes
+		for i := len(dirInfos) - 1; i >= 0; i-- {
+			if err = copyPreserve(dirInfos[i], dirPaths[i], opts); err != nil {
+				return
+			}
+		}
 	}
 	return
 }
@@ -246,10 +315,12 @@ func Copy(src, dst string, opts ...*opt.Opt) (err error) {
 // The dst will be copied to if it is an existing directory.
 // The dst will be a clone of the src if it doesn't exist.
 // Supports passing in the FileInfo object directly with FollowOpt(true)
+// Supports the same progress, dry run, conflict, compare and preserve options as Copy
+// Supports the same sparse file, reflink and FIFO, socket and device handling as Copy
 // Returns the destination path for copied file
 func CopyFile(src, dst string, opts ...*opt.Opt) (result string, err error) {
 	var srcPath, dstPath string
-	var srcInfo, srcDirInfo *FileInfo
+	var srcInfo *FileInfo
 
 	// Set following links to false by default
 	defaultFollowOpt(&opts, false)
@@ -268,8 +339,8 @@ func CopyFile(src, dst string, opts ...*opt.Opt) (result string, err error) {
 		}
 	}
 
-	// Source dir permissions to use for destination directories
-	if srcDirInfo, err = Lstat(path.Dir(srcPath)); err != nil {
+	// Source dir must exist as its permissions are used for destination directories
+	if _, err = Lstat(path.Dir(srcPath)); err != nil {
 		return
 	}
 
@@ -286,11 +357,8 @@ func CopyFile(src, dst string, opts ...*opt.Opt) (result string, err error) {
 	dstInfo, e := os.Stat(dstPath)
 	switch {
 
-	// Doesn't exist so this is the new destination name, ensure all paths exist
+	// Doesn't exist so this is the new destination name, paths are created on copy
 	case os.IsNotExist(e):
-		if err = os.MkdirAll(path.Dir(dstPath), srcDirInfo.Mode()); err != nil {
-			return
-		}
 
 	// Destination exists and is either a file to overwrite or a dir to copy into
 	case e == nil:
@@ -304,64 +372,7 @@ func CopyFile(src, dst string, opts ...*opt.Opt) (result string, err error) {
 		return
 	}
 
-	// Handle links a bit differently
-	if srcInfo.IsSymlink() {
-		var target string
-		if target, err = srcInfo.SymlinkTarget(); err != nil {
-			return
-		}
-		if err = os.Symlink(target, dstPath); err != nil {
-			return
-		}
-	} else {
-		// Open srcPath for reading
-		var fr *os.File
-		if fr, err = os.Open(srcPath); err != nil {
-			err = errors.Wrapf(err, "failed to open file %s for reading", srcPath)
-			return
-		}
-		defer fr.Close()
-
-		// Create dstPath for writing
-		var fw *os.File
-		if fw, err = os.Create(dstPath); err != nil {
-			err = errors.Wrapf(err, "failed to create file %s", dstPath)
-			return
-		}
-
-		// Copy srcPath to dstPath
-		if _, err = io.Copy(fw, fr); err != nil {
-			err = errors.Wrapf(err, "failed to copy data to file %s", dstPath)
-			if e := fw.Close(); e != nil {
-				err = errors.Wrapf(err, "failed to close file %s", dstPath)
-			}
-			return
-		}
-
-		// Sync to disk
-		if err = fw.Sync(); err != nil {
-			err = errors.Wrapf(err, "failed to sync data to file %s", dstPath)
-			if e := fw.Close(); e != nil {
-				err = errors.Wrapf(err, "failed to close file %s", dstPath)
-			}
-			return
-		}
-
-		// Close file for writing
-		if err = fw.Close(); err != nil {
-			err = errors.Wrapf(err, "failed to close file %s", dstPath)
-			return
-		}
-
-		// Set permissions of dstPath same as srcPath
-		if err = os.Chmod(dstPath, srcInfo.Mode()); err != nil {
-			err = errors.Wrapf(err, "failed to chmod file %s", dstPath)
-			return
-		}
-	}
-
-	result = dstPath
-	return
+	return copyPath(srcPath, srcInfo, dstPath, copyTotals(opts))
 }
 
 // Exists return true if the given path exists
@@ -405,51 +416,90 @@ func MkdirP(dirname string, perms ...uint32) (dir string, err error) {
 
 // MD5 returns the md5 of the given file
 func MD5(filename string) (result string, err error) {
-	if filename, err = Abs(filename); err != nil {
-		return
-	}
-	if !Exists(filename) {
-		return "", os.ErrNotExist
-	}
-
-	// Open file for reading
-	var fr *os.File
-	if fr, err = os.Open(filename); err != nil {
-		err = errors.Wrapf(err, "failed opening target file %s", filename)
-		return
-	}
-	defer fr.Close()
-
-	// Create a new md5 hash and copy in file bits
-	hash := md5.New()
-	if _, err = io.Copy(hash, fr); err != nil {
-		err = errors.Wrapf(err, "failed copying file data into hash from %s", filename)
-		return
-	}
-
-	// Compute 32 byte hash
-	result = hex.EncodeToString(hash.Sum(nil))
-
-	return
+	return HashFile(filename, HashMD5)
 }
 
 // Move the src path to the dst path. If the dst already exists and is not a directory
-// src will replace it. If there is an error it will be of type *LinkError. Wraps
-// os.Rename but fixes the issue where dst name is required. Returns the new location
-func Move(src, dst string) (result string, err error) {
+// src will replace it. Wraps os.Rename but fixes the issue where dst name is required.
+// Returns the new location.
+// * Falls back on copying then removing src when renaming across devices
+// * Supports the same options as Copy, options that require inspecting each file i.e. opt.DryrunOpt, IncludeOpt, ExcludeOpt, CompareOpt or a ConflictOpt other than ConflictOverwrite with an existing dst always copy then remove
+// * Only removes sources that were copied leaving skipped and filtered paths in place
+func Move(src, dst string, opts ...*opt.Opt) (result string, err error) {
 
 	// Add src base name to dst directory to fix golang oversight
 	if IsDir(dst) {
 		dst = path.Join(dst, path.Base(src))
 	}
-	if err = os.Rename(src, dst); err != nil {
-		err = errors.Wrapf(err, "failed renaming file %s", src)
+
+	// Rename in place when possible
+	if !moveByCopy(dst, opts) {
+		if err = os.Rename(src, dst); err == nil {
+			copyReport(opts, &CopyProgress{Action: CopyActionMove, Src: src, Dst: dst, Done: true, TotalFiles: 1})
+			result = dst
+			return
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			err = errors.Wrapf(err, "failed renaming file %s", src)
+			return
+		}
+	}
+
+	// Track the sources that were copied
+	copied := []string{}
+	progress := getProgressOpt(opts)
+	opts = opt.Copy(opts)
+	opt.Overwrite(&opts, ProgressOpt(func(p *CopyProgress) {
+		if p.Done && p.Action != CopyActionMkdir && p.Action != CopyActionSkip {
+			copied = append(copied, p.Src)
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}))
+
+	// Copy into the parent when merging with an existing directory of the same name
+	copyDst := dst
+	if IsDir(dst) {
+		copyDst = path.Dir(dst)
+	}
+	if err = Copy(src, copyDst, opts...); err != nil || opt.GetDryrunOpt(opts) {
 		return
 	}
+
+	// Remove the copied sources then any source directories left empty deepest first
+	for _, target := range copied {
+		if err = os.Remove(target); err != nil {
+			err = errors.Wrapf(err, "failed removing moved file %s", target)
+			return
+		}
+	}
+	if IsDir(src) {
+		dirs, _ := AllDirs(src, RootOpt(true), FollowOpt(false))
+		for i := len(dirs) - 1; i >= 0; i-- {
+			os.Remove(dirs[i])
+		}
+	}
 	result = dst
 	return
 }
 
+// moveByCopy returns true if the given options require Move to copy then remove
+func moveByCopy(dst string, opts []*opt.Opt) bool {
+	if opt.GetDryrunOpt(opts) || len(getIncludeOpt(opts)) > 0 || len(getExcludeOpt(opts)) > 0 {
+		return true
+	}
+	if getCompareOpt(opts) != CompareNone {
+		return true
+	}
+	if getConflictOpt(opts) != ConflictOverwrite {
+		if _, err := os.Lstat(dst); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Pwd returns the current working directory
 func Pwd() (pwd string) {
 	pwd, _ = os.Getwd()
@@ -597,6 +647,12 @@ func WriteLines(filepath string, lines []string, perms ...uint32) (err error) {
 // WriteStream reads from the io.Reader and writes to the given file using io.Copy
 // thus never filling memory i.e. streaming.  dest will be overwritten if it exists.
 func WriteStream(reader io.Reader, filepath string, perms ...uint32) (err error) {
+	return writeStream(reader, filepath, false, perms...)
+}
+
+// writeStream reads from the io.Reader and writes to the given file using io.Copy either
+// truncating or appending to the file if it exists.
+func writeStream(reader io.Reader, filepath string, append bool, perms ...uint32) (err error) {
 	if filepath, err = Abs(filepath); err != nil {
 		return
 	}
@@ -608,6 +664,9 @@ func WriteStream(reader io.Reader, filepath string, perms ...uint32) (err error)
 
 	var fw *os.File
 	flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	if append {
+		flags = os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	}
 	if fw, err = os.OpenFile(filepath, flags, perm); err != nil {
 		err = errors.Wrapf(err, "failed opening file %s for writing", filepath)
 		return
@@ -1,13 +1,17 @@
 package sys
 
 import (
+	"bytes"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/phR0ze/n/pkg/opt"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -533,6 +537,367 @@ func TestCopyLinkedDirNested(t *testing.T) {
 	assert.Equal(t, []string{"temp/LinkedDirNested/dir3", "LinkedDirNested/dir3/link1", "dir3/link1/file1", "dir3/link1/file2"}, files)
 }
 
+func TestCopyProgress(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	writeTestFile(t, path.Join(src, "a"), "hello")
+	writeTestFile(t, path.Join(src, "sub", "b"), "world!")
+
+	// totals accumulate across files
+	{
+		var done []CopyProgress
+		err := Copy(src, path.Join(tmpDir, "dst"), ProgressOpt(func(p *CopyProgress) {
+			if p.Done {
+				done = append(done, *p)
+			}
+		}))
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(done))
+		assert.Equal(t, CopyActionMkdir, done[0].Action)
+		assert.Equal(t, CopyActionCopy, done[1].Action)
+		assert.Equal(t, "a", path.Base(done[1].Dst))
+		assert.Equal(t, int64(5), done[1].Bytes)
+		assert.Equal(t, int64(5), done[1].Size)
+		assert.Equal(t, 1, done[1].TotalFiles)
+		assert.Equal(t, CopyActionMkdir, done[2].Action)
+		assert.Equal(t, int64(11), done[3].TotalBytes)
+		assert.Equal(t, 2, done[3].TotalFiles)
+	}
+
+	// single file
+	{
+		var last CopyProgress
+		result, err := CopyFile(path.Join(src, "a"), path.Join(tmpDir, "file"), ProgressOpt(func(p *CopyProgress) { last = *p }))
+		assert.Nil(t, err)
+		assert.Equal(t, "file", path.Base(result))
+		assert.True(t, last.Done)
+		assert.Equal(t, int64(5), last.TotalBytes)
+	}
+}
+
+func TestCopyDryrun(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	dst := path.Join(tmpDir, "dst")
+	writeTestFile(t, path.Join(src, "a"), "a")
+	assert.Nil(t, os.Symlink("a", path.Join(src, "link")))
+
+	var out bytes.Buffer
+	assert.Nil(t, Copy(src, dst, opt.DryrunOpt(true), opt.OutOpt(&out)))
+	assert.False(t, Exists(dst))
+	srcAbs, _ := Abs(src)
+	dstAbs, _ := Abs(dst)
+	assert.Equal(t, strings.Join([]string{
+		"mkdir " + srcAbs + " => " + dstAbs,
+		"copy " + srcAbs + "/a => " + dstAbs + "/a",
+		"link " + srcAbs + "/link => " + dstAbs + "/link",
+	}, "\n")+"\n", out.String())
+}
+
+func TestCopyConflict(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "a.txt")
+	dst := path.Join(tmpDir, "b.txt")
+	assert.Nil(t, WriteString(src, "new"))
+
+	reset := func(mtime time.Time) {
+		assert.Nil(t, WriteString(dst, "old"))
+		assert.Nil(t, os.Chtimes(dst, mtime, mtime))
+	}
+
+	// overwrite by default
+	{
+		reset(time.Now())
+		_, err := CopyFile(src, dst)
+		assert.Nil(t, err)
+		data, _ := ReadString(dst)
+		assert.Equal(t, "new", data)
+	}
+
+	// skip
+	{
+		reset(time.Now())
+		result, err := CopyFile(src, dst, ConflictOpt(ConflictSkip))
+		assert.Nil(t, err)
+		assert.Equal(t, "b.txt", path.Base(result))
+		data, _ := ReadString(dst)
+		assert.Equal(t, "old", data)
+	}
+
+	// newer only
+	{
+		reset(time.Now().Add(time.Hour))
+		_, err := CopyFile(src, dst, ConflictOpt(ConflictNewer))
+		assert.Nil(t, err)
+		data, _ := ReadString(dst)
+		assert.Equal(t, "old", data)
+
+		reset(time.Now().Add(-time.Hour))
+		_, err = CopyFile(src, dst, ConflictOpt(ConflictNewer))
+		assert.Nil(t, err)
+		data, _ = ReadString(dst)
+		assert.Equal(t, "new", data)
+	}
+
+	// rename
+	{
+		reset(time.Now())
+		result, err := CopyFile(src, dst, ConflictOpt(ConflictRename))
+		assert.Nil(t, err)
+		assert.Equal(t, "b_1.txt", path.Base(result))
+		result, err = CopyFile(src, dst, ConflictOpt(ConflictRename))
+		assert.Nil(t, err)
+		assert.Equal(t, "b_2.txt", path.Base(result))
+		data, _ := ReadString(dst)
+		assert.Equal(t, "old", data)
+	}
+
+	// error
+	{
+		reset(time.Now())
+		_, err := CopyFile(src, dst, ConflictOpt(ConflictError))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to copy "))
+		assert.True(t, strings.HasSuffix(err.Error(), "b.txt already exists"))
+
+		err = Copy(src, dst, ConflictOpt(ConflictError))
+		assert.True(t, strings.HasSuffix(err.Error(), "b.txt already exists"))
+	}
+
+	// links are replaced rather than written through
+	{
+		target := path.Join(tmpDir, "target")
+		assert.Nil(t, WriteString(target, "target"))
+		assert.Nil(t, os.Remove(dst))
+		assert.Nil(t, os.Symlink(target, dst))
+		_, err := CopyFile(src, dst)
+		assert.Nil(t, err)
+		assert.False(t, IsSymlink(dst))
+		data, _ := ReadString(target)
+		assert.Equal(t, "target", data)
+	}
+}
+
+func TestCopyFilters(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	for _, name := range []string{"a.go", "a_test.go", "b.txt", "sub/c.go", "vendor/d.go"} {
+		writeTestFile(t, path.Join(src, name), name)
+	}
+	names := func(dir string) (result []string) {
+		paths, err := AllPaths(dir)
+		assert.Nil(t, err)
+		for _, p := range paths[1:] {
+			result = append(result, TrimShared(p, paths[0]))
+		}
+		return
+	}
+
+	// include only applies to files
+	{
+		dst := path.Join(tmpDir, "include")
+		assert.Nil(t, Copy(src, dst, IncludeOpt("*.go")))
+		assert.Equal(t, []string{"a.go", "a_test.go", "sub", "sub/c.go", "vendor", "vendor/d.go"}, names(dst))
+	}
+
+	// exclude files by name and dirs by relative path
+	{
+		dst := path.Join(tmpDir, "exclude")
+		assert.Nil(t, Copy(src, dst, ExcludeOpt("*_test.go", "vendor"), IncludeOpt("*.go")))
+		assert.Equal(t, []string{"a.go", "sub", "sub/c.go"}, names(dst))
+	}
+
+	// globbed sources are filtered by name
+	{
+		dst := path.Join(tmpDir, "glob")
+		MkdirP(dst)
+		assert.Nil(t, Copy(path.Join(src, "*"), dst, ExcludeOpt("*.txt", "sub")))
+		assert.Equal(t, []string{"a.go", "a_test.go", "vendor", "vendor/d.go"}, names(dst))
+	}
+}
+
+func TestCopyPreserve(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	file := path.Join(src, "file")
+	writeTestFile(t, file, "data")
+	assert.Nil(t, os.Chmod(file, 0640))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Nil(t, os.Chtimes(file, mtime, mtime))
+	assert.Nil(t, os.Chtimes(src, mtime, mtime))
+
+	// mode only by default
+	{
+		dst := path.Join(tmpDir, "dst1")
+		assert.Nil(t, Copy(src, dst))
+		info, err := os.Stat(path.Join(dst, "file"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode())
+		assert.False(t, info.ModTime().Equal(mtime))
+	}
+
+	// times for files and dirs
+	{
+		dst := path.Join(tmpDir, "dst2")
+		assert.Nil(t, Copy(src, dst, PreserveOpt(PreserveAll)))
+		info, err := os.Stat(path.Join(dst, "file"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode())
+		assert.True(t, info.ModTime().Equal(mtime))
+		info, err = os.Stat(dst)
+		assert.Nil(t, err)
+		assert.True(t, info.ModTime().Equal(mtime))
+	}
+
+	// no mode
+	{
+		dst := path.Join(tmpDir, "dst3")
+		assert.Nil(t, Copy(src, dst, PreserveOpt(PreserveTimes)))
+		info, err := os.Stat(path.Join(dst, "file"))
+		assert.Nil(t, err)
+		assert.NotEqual(t, os.FileMode(0640), info.Mode())
+	}
+}
+
+func TestCopyCompare(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	dst := path.Join(tmpDir, "dst")
+	writeTestFile(t, path.Join(src, "a"), "aaa")
+	writeTestFile(t, path.Join(src, "b"), "bbb")
+	assert.Nil(t, Copy(src, dst, PreserveOpt(PreserveAll)))
+	actions := func(opts ...*opt.Opt) (result []string) {
+		opts = append(opts, ProgressOpt(func(p *CopyProgress) {
+			if p.Done && p.Action != CopyActionMkdir {
+				result = append(result, path.Base(p.Src)+" "+p.Action)
+			}
+		}))
+		assert.Nil(t, Copy(path.Join(src, "*"), dst, opts...))
+		return
+	}
+
+	// mtime skips unchanged files
+	{
+		writeTestFile(t, path.Join(src, "b"), "BBB")
+		assert.Equal(t, []string{"a skip", "b copy"}, actions(CompareOpt(CompareMtime), PreserveOpt(PreserveAll)))
+		assert.Equal(t, []string{"a skip", "b skip"}, actions(CompareOpt(CompareMtime)))
+	}
+
+	// checksum ignores times
+	{
+		now := time.Now().Add(time.Hour)
+		assert.Nil(t, os.Chtimes(path.Join(src, "a"), now, now))
+		assert.Equal(t, []string{"a copy", "b skip"}, actions(CompareOpt(CompareMtime)))
+		writeTestFile(t, path.Join(src, "b"), "bbb")
+		assert.Equal(t, []string{"a skip", "b copy"}, actions(CompareOpt(CompareChecksum)))
+	}
+}
+
+// writeTestFile writes the given data to the target creating parent directories as needed
+func writeTestFile(t *testing.T, target, data string) {
+	_, err := MkdirP(path.Dir(target))
+	assert.Nil(t, err)
+	assert.Nil(t, WriteString(target, data))
+}
+
+func TestCopyHardlinks(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	writeTestFile(t, path.Join(src, "a"), "hello")
+	assert.Nil(t, os.MkdirAll(path.Join(src, "sub"), 0755))
+	assert.Nil(t, os.Link(path.Join(src, "a"), path.Join(src, "sub", "b")))
+
+	// links within the tree are preserved
+	{
+		var actions []string
+		dst := path.Join(tmpDir, "dst")
+		assert.Nil(t, Copy(src, dst, ProgressOpt(func(p *CopyProgress) {
+			if p.Done && p.Action != CopyActionMkdir {
+				actions = append(actions, path.Base(p.Src)+" "+p.Action)
+			}
+		})))
+		assert.Equal(t, []string{"a copy", "b hardlink"}, actions)
+		a, _ := os.Stat(path.Join(dst, "a"))
+		b, _ := os.Stat(path.Join(dst, "sub", "b"))
+		assert.True(t, os.SameFile(a, b))
+		orig, _ := os.Stat(path.Join(src, "a"))
+		assert.False(t, os.SameFile(a, orig))
+	}
+
+	// single files are copied
+	{
+		dst := path.Join(tmpDir, "file")
+		_, err := CopyFile(path.Join(src, "sub", "b"), dst)
+		assert.Nil(t, err)
+		data, err := ReadString(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", data)
+	}
+}
+
+func TestCopySparse(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "sparse")
+	f, err := os.Create(src)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("data"), 4<<20)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Truncate(16<<20))
+	assert.Nil(t, f.Close())
+
+	var last CopyProgress
+	dst := path.Join(tmpDir, "copy")
+	_, err = CopyFile(src, dst, ProgressOpt(func(p *CopyProgress) { last = *p }))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(16<<20), last.Size)
+	srcData, _ := os.ReadFile(src)
+	dstData, _ := os.ReadFile(dst)
+	assert.Equal(t, srcData, dstData)
+
+	// holes are only kept if the filesystem supports them
+	srcInfo, _ := os.Stat(src)
+	if srcInfo.Sys().(*syscall.Stat_t).Blocks*512 >= srcInfo.Size() {
+		return
+	}
+	dstInfo, _ := os.Stat(dst)
+	assert.Less(t, dstInfo.Sys().(*syscall.Stat_t).Blocks*512, dstInfo.Size())
+}
+
+func TestCopyNodes(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	assert.Nil(t, os.MkdirAll(src, 0755))
+	assert.Nil(t, syscall.Mkfifo(path.Join(src, "fifo"), 0640))
+
+	// fifos
+	{
+		dst := path.Join(tmpDir, "dst")
+		assert.Nil(t, Copy(src, dst))
+		info, err := os.Lstat(path.Join(dst, "fifo"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.ModeNamedPipe|0640, info.Mode())
+
+		// existing destinations are replaced rather than opened
+		assert.Nil(t, Copy(src, dst))
+		root, _ := Abs(tmpDir)
+		assert.Nil(t, Copy("/src", "/chroot", ChrootOpt(root)))
+		info, err = os.Lstat(path.Join(tmpDir, "chroot", "fifo"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.ModeNamedPipe|0640, info.Mode())
+	}
+
+	// devices when run as root
+	{
+		if os.Geteuid() != 0 || syscall.Mknod(path.Join(src, "null"), syscall.S_IFCHR|0666, 1<<8|3) != nil {
+			return
+		}
+		assert.Nil(t, Copy(src, path.Join(tmpDir, "dev")))
+		info, err := os.Lstat(path.Join(tmpDir, "dev", "null"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.ModeDevice|os.ModeCharDevice, info.Mode().Type())
+		assert.Equal(t, uint64(1<<8|3), uint64(info.Sys().(*syscall.Stat_t).Rdev))
+	}
+}
+
 func TestDarwin(t *testing.T) {
 	if runtime.GOOS == "darwin" {
 		assert.True(t, Darwin())
@@ -1054,6 +1419,72 @@ func TestMove(t *testing.T) {
 	assert.Nil(t, os.Chmod(subDir, 0755))
 }
 
+func TestMoveOpts(t *testing.T) {
+	resetTest()
+	src := path.Join(tmpDir, "src")
+	dst := path.Join(tmpDir, "dst")
+	writeTestFile(t, path.Join(src, "a.txt"), "a")
+	writeTestFile(t, path.Join(src, "sub", "b.log"), "b")
+
+	// dry run makes no changes
+	{
+		var out bytes.Buffer
+		result, err := Move(src, dst, opt.DryrunOpt(true), opt.OutOpt(&out))
+		assert.Nil(t, err)
+		assert.Equal(t, "", result)
+		assert.False(t, Exists(dst))
+		assert.True(t, Exists(path.Join(src, "a.txt")))
+		assert.Equal(t, 4, strings.Count(out.String(), "\n"))
+	}
+
+	// filtered paths stay in place
+	{
+		result, err := Move(src, dst, ExcludeOpt("*.log"))
+		assert.Nil(t, err)
+		assert.Equal(t, dst, result)
+		assert.True(t, Exists(path.Join(dst, "a.txt")))
+		assert.False(t, Exists(path.Join(dst, "sub", "b.log")))
+		assert.False(t, Exists(path.Join(src, "a.txt")))
+		assert.True(t, Exists(path.Join(src, "sub", "b.log")))
+	}
+
+	// merge into an existing directory of the same name skipping conflicts
+	{
+		merge := path.Join(tmpDir, "merge")
+		writeTestFile(t, path.Join(merge, "src", "a.txt"), "old")
+		writeTestFile(t, path.Join(src, "a.txt"), "new")
+		var progress []string
+		result, err := Move(src, merge, ConflictOpt(ConflictSkip), ProgressOpt(func(p *CopyProgress) {
+			if p.Done && p.Action != CopyActionMkdir {
+				progress = append(progress, path.Base(p.Src)+" "+p.Action)
+			}
+		}))
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(merge, "src"), result)
+		assert.Equal(t, []string{"a.txt skip", "b.log copy"}, progress)
+		assert.True(t, Exists(path.Join(merge, "src", "sub", "b.log")))
+		assert.True(t, Exists(path.Join(src, "a.txt")))
+		assert.False(t, Exists(path.Join(src, "sub")))
+		data, _ := ReadString(path.Join(merge, "src", "a.txt"))
+		assert.Equal(t, "old", data)
+
+		_, err = Move(path.Join(src, "a.txt"), dst, ConflictOpt(ConflictSkip))
+		assert.Nil(t, err)
+		data, _ = ReadString(path.Join(dst, "a.txt"))
+		assert.Equal(t, "a", data)
+		assert.True(t, Exists(path.Join(src, "a.txt")))
+	}
+
+	// rename reports a move
+	{
+		var last CopyProgress
+		result, err := Move(path.Join(src, "a.txt"), path.Join(dst, "c.txt"), ProgressOpt(func(p *CopyProgress) { last = *p }))
+		assert.Nil(t, err)
+		assert.Equal(t, "c.txt", path.Base(result))
+		assert.Equal(t, CopyActionMove, last.Action)
+	}
+}
+
 func TestPwd(t *testing.T) {
 	assert.Equal(t, "sys", path.Base(Pwd()))
 }
@@ -0,0 +1,722 @@
+package sys
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+)
+
+// ErrReadOnly indicates a write was attempted against a read-only filesystem
+var ErrReadOnly = errors.New("read-only filesystem")
+
+// FS provides a writable filesystem compatible with io/fs.FS. Names are slash separated and
+// may be absolute or relative except for the io/fs methods Open, ReadDir, ReadFile and Stat
+// which only accept names valid for fs.ValidPath relative to the root of the filesystem. The
+// sys helpers accept an FS with FSOpt or through their FS suffixed variants e.g. ReadLinesFS.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	fs.ReadFileFS
+
+	// Chmod changes the mode of the named file following links
+	Chmod(name string, mode fs.FileMode) error
+
+	// Chtimes changes the access and modification times of the named file following links
+	Chtimes(name string, atime, mtime time.Time) error
+
+	// Lstat returns the file info for the named file without following links
+	Lstat(name string) (fs.FileInfo, error)
+
+	// Mkdir creates the named directory with the given permissions
+	Mkdir(name string, perm fs.FileMode) error
+
+	// MkdirAll creates the named directory and any parents needed with the given permissions
+	MkdirAll(name string, perm fs.FileMode) error
+
+	// OpenFile opens the named file with the given os.O_* flags creating it with the given
+	// permissions if needed
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// Readlink returns the target of the named link
+	Readlink(name string) (string, error)
+
+	// Remove removes the named file or empty directory
+	Remove(name string) error
+
+	// RemoveAll removes the named path and anything it contains ignoring paths that don't exist
+	RemoveAll(name string) error
+
+	// Rename moves oldname to newname replacing newname if it exists and isn't a directory
+	Rename(oldname, newname string) error
+
+	// Symlink creates newname as a link to oldname
+	Symlink(oldname, newname string) error
+
+	// WriteFile writes the data to the named file creating it with the given permissions if needed
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// File provides a writable file returned by FS.OpenFile
+type File interface {
+	fs.File
+	io.Writer
+	io.Seeker
+}
+
+// OsFS implements FS using the os package optionally confined to a root directory
+type OsFS struct {
+	root string // directory names are relative to or empty to use names as is
+}
+
+// NewOsFS creates a new OsFS with names relative to the given root directory. An empty root
+// uses names as is i.e. absolute or relative to the current working directory except for the
+// io/fs methods whose names are always relative to the root or / when no root is set.
+func NewOsFS(root string) *OsFS {
+	return &OsFS{root: root}
+}
+
+// path returns the OS path for the given name
+func (o *OsFS) path(name string) string {
+	if o.root == "" {
+		return filepath.FromSlash(name)
+	}
+	return filepath.Join(o.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// name returns the OS path for the given io/fs name failing with fs.ErrInvalid if not valid
+func (o *OsFS) name(op, name string) (string, error) {
+	if err := fsInvalid(op, name); err != nil {
+		return "", err
+	}
+	root := o.root
+	if root == "" {
+		root = string(filepath.Separator)
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), nil
+}
+
+// Chmod implements FS.Chmod
+func (o *OsFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(o.path(name), mode)
+}
+
+// Chtimes implements FS.Chtimes
+func (o *OsFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(o.path(name), atime, mtime)
+}
+
+// Lstat implements FS.Lstat
+func (o *OsFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(o.path(name))
+}
+
+// Mkdir implements FS.Mkdir
+func (o *OsFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(o.path(name), perm)
+}
+
+// MkdirAll implements FS.MkdirAll
+func (o *OsFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(o.path(name), perm)
+}
+
+// Open implements fs.FS
+func (o *OsFS) Open(name string) (fs.File, error) {
+	target, err := o.name("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
+
+// OpenFile implements FS.OpenFile
+func (o *OsFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := os.OpenFile(o.path(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (o *OsFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	target, err := o.name("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(target)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (o *OsFS) ReadFile(name string) ([]byte, error) {
+	target, err := o.name("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(target)
+}
+
+// Readlink implements FS.Readlink
+func (o *OsFS) Readlink(name string) (string, error) {
+	return os.Readlink(o.path(name))
+}
+
+// Remove implements FS.Remove
+func (o *OsFS) Remove(name string) error {
+	return os.Remove(o.path(name))
+}
+
+// RemoveAll implements FS.RemoveAll
+func (o *OsFS) RemoveAll(name string) error {
+	return os.RemoveAll(o.path(name))
+}
+
+// Rename implements FS.Rename
+func (o *OsFS) Rename(oldname, newname string) error {
+	return os.Rename(o.path(oldname), o.path(newname))
+}
+
+// Stat implements fs.StatFS
+func (o *OsFS) Stat(name string) (fs.FileInfo, error) {
+	target, err := o.name("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(target)
+}
+
+// Symlink implements FS.Symlink
+func (o *OsFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, o.path(newname))
+}
+
+// WriteFile implements FS.WriteFile
+func (o *OsFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(o.path(name), data, perm)
+}
+
+// ReadOnlyFS wraps an FS failing all writes with ErrReadOnly
+type ReadOnlyFS struct {
+	fsys FS // underlying filesystem to read from
+}
+
+// NewReadOnlyFS creates a new read-only view of the given FS
+func NewReadOnlyFS(fsys FS) *ReadOnlyFS {
+	return &ReadOnlyFS{fsys: fsys}
+}
+
+// readOnly returns the read-only error for the given operation and name
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+}
+
+// Chmod implements FS.Chmod failing with ErrReadOnly
+func (r *ReadOnlyFS) Chmod(name string, mode fs.FileMode) error {
+	return readOnly("chmod", name)
+}
+
+// Chtimes implements FS.Chtimes failing with ErrReadOnly
+func (r *ReadOnlyFS) Chtimes(name string, atime, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
+
+// Lstat implements FS.Lstat
+func (r *ReadOnlyFS) Lstat(name string) (fs.FileInfo, error) {
+	return r.fsys.Lstat(name)
+}
+
+// Mkdir implements FS.Mkdir failing with ErrReadOnly
+func (r *ReadOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return readOnly("mkdir", name)
+}
+
+// MkdirAll implements FS.MkdirAll failing with ErrReadOnly
+func (r *ReadOnlyFS) MkdirAll(name string, perm fs.FileMode) error {
+	return readOnly("mkdir", name)
+}
+
+// Open implements fs.FS
+func (r *ReadOnlyFS) Open(name string) (fs.File, error) {
+	return r.fsys.Open(name)
+}
+
+// OpenFile implements FS.OpenFile failing with ErrReadOnly for any write flags
+func (r *ReadOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, readOnly("open", name)
+	}
+	return r.fsys.OpenFile(name, flag, perm)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (r *ReadOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return r.fsys.ReadDir(name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (r *ReadOnlyFS) ReadFile(name string) ([]byte, error) {
+	return r.fsys.ReadFile(name)
+}
+
+// Readlink implements FS.Readlink
+func (r *ReadOnlyFS) Readlink(name string) (string, error) {
+	return r.fsys.Readlink(name)
+}
+
+// Remove implements FS.Remove failing with ErrReadOnly
+func (r *ReadOnlyFS) Remove(name string) error {
+	return readOnly("remove", name)
+}
+
+// RemoveAll implements FS.RemoveAll failing with ErrReadOnly
+func (r *ReadOnlyFS) RemoveAll(name string) error {
+	return readOnly("remove", name)
+}
+
+// Rename implements FS.Rename failing with ErrReadOnly
+func (r *ReadOnlyFS) Rename(oldname, newname string) error {
+	return readOnly("rename", oldname)
+}
+
+// Stat implements fs.StatFS
+func (r *ReadOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return r.fsys.Stat(name)
+}
+
+// Symlink implements FS.Symlink failing with ErrReadOnly
+func (r *ReadOnlyFS) Symlink(oldname, newname string) error {
+	return readOnly("symlink", newname)
+}
+
+// WriteFile implements FS.WriteFile failing with ErrReadOnly
+func (r *ReadOnlyFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return readOnly("open", name)
+}
+
+// fsInvalid returns a fs.ErrInvalid path error for the given operation if the given name isn't
+// valid for the io/fs methods
+func fsInvalid(op, name string) error {
+	if fs.ValidPath(name) {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+}
+
+// fsName converts the given slash separated name into a valid io/fs name relative to the root
+// of the filesystem for calling the io/fs methods which don't accept absolute or unclean names
+func fsName(name string) string {
+	if name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/"); name == "" {
+		return "."
+	}
+	return name
+}
+
+// fsAbs resolves the given path for the given filesystem. OS backed paths get home dir and
+// relative dir expansion while other filesystems simply get a clean slash separated path.
+func fsAbs(fsys FS, target string) (result string, err error) {
+	if o, ok := fsys.(*OsFS); fsys == nil || ok && o.root == "" {
+		return Abs(target)
+	}
+	return path.Clean(filepath.ToSlash(target)), nil
+}
+
+// LstatFS wraps FS.Lstat to give back a FileInfo from the given filesystem. Falls back on Lstat
+// when no filesystem is given.
+func LstatFS(fsys FS, src string) (result *FileInfo, err error) {
+	if fsys == nil {
+		return Lstat(src)
+	}
+	if src, err = fsAbs(fsys, src); err != nil {
+		return
+	}
+	result = &FileInfo{Path: src, fsys: fsys}
+	if result.Obj, err = fsys.Lstat(src); err != nil {
+		result = nil
+		err = errors.Wrapf(err, "failed to execute Lstat against %s", src)
+	}
+	return
+}
+
+// ExistsFS return true if the given path exists in the given filesystem following links.
+// Falls back on Exists when no filesystem is given.
+func ExistsFS(fsys FS, src string) bool {
+	if fsys == nil {
+		return Exists(src)
+	}
+	if target, err := fsAbs(fsys, src); err == nil {
+		if _, err := fsys.Stat(fsName(target)); err == nil || os.IsPermission(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadBytesFS returns the entire file as []byte from the given filesystem
+func ReadBytesFS(fsys FS, filepath string) (result []byte, err error) {
+	if filepath, err = fsAbs(fsys, filepath); err != nil {
+		return
+	}
+
+	if result, err = fsys.ReadFile(fsName(filepath)); err != nil {
+		err = errors.Wrapf(err, "failed reading the file %s", filepath)
+		return
+	}
+	return
+}
+
+// ReadLinesFS returns a new slice of string representing lines from the given filesystem
+func ReadLinesFS(fsys FS, filepath string) (result []string, err error) {
+	var data []byte
+	if data, err = ReadBytesFS(fsys, filepath); err != nil {
+		return
+	}
+	result = ReadLinesP(bytes.NewReader(data))
+	return
+}
+
+// ReadStringFS returns the entire file as a string from the given filesystem
+func ReadStringFS(fsys FS, filepath string) (result string, err error) {
+	var data []byte
+	if data, err = ReadBytesFS(fsys, filepath); err != nil {
+		return
+	}
+	result = string(data)
+	return
+}
+
+// WriteBytesFS is a pass through to FS.WriteFile with default permissions
+func WriteBytesFS(fsys FS, filepath string, data []byte, perms ...uint32) (err error) {
+	return writeFS(fsys, filepath, data, "bytes", perms...)
+}
+
+// WriteLinesFS is a pass through to FS.WriteFile with default permissions
+func WriteLinesFS(fsys FS, filepath string, lines []string, perms ...uint32) (err error) {
+	return writeFS(fsys, filepath, []byte(strings.Join(lines, "\n")), "lines", perms...)
+}
+
+// WriteStringFS is a pass through to FS.WriteFile with default permissions
+func WriteStringFS(fsys FS, filepath string, data string, perms ...uint32) (err error) {
+	return writeFS(fsys, filepath, []byte(data), "string", perms...)
+}
+
+// writeFS writes the data to the given file in the given filesystem describing the data as
+// the given kind in errors
+func writeFS(fsys FS, filepath string, data []byte, kind string, perms ...uint32) (err error) {
+	if filepath, err = fsAbs(fsys, filepath); err != nil {
+		return
+	}
+
+	perm := os.FileMode(0644)
+	if len(perms) > 0 {
+		perm = os.FileMode(perms[0])
+	}
+	if err = fsys.WriteFile(filepath, data, perm); err != nil {
+		err = errors.Wrapf(err, "failed writing %s to file %s", kind, filepath)
+		return
+	}
+	return
+}
+
+// fsEvalSymlinks resolves the given link in the given filesystem returning the final target
+// path. Falls back on filepath.EvalSymlinks when no filesystem is given.
+func fsEvalSymlinks(fsys FS, name string) (result string, err error) {
+	if fsys == nil {
+		return filepath.EvalSymlinks(name)
+	}
+	result = name
+	for i := 0; i < 255; i++ {
+		var info fs.FileInfo
+		if info, err = fsys.Lstat(result); err != nil {
+			return
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return
+		}
+		var target string
+		if target, err = fsys.Readlink(result); err != nil {
+			return
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(result), target)
+		}
+		result = target
+	}
+	err = &fs.PathError{Op: "lstat", Path: name, Err: syscall.ELOOP}
+	return
+}
+
+// fsReadDirnames reads the sorted names of the given directory from the given filesystem.
+// Falls back on ReadDirnames when no filesystem is given.
+func fsReadDirnames(fsys FS, dirname string) (names []string, err error) {
+	if fsys == nil {
+		return ReadDirnames(dirname)
+	}
+	var entries []fs.DirEntry
+	if entries, err = fsys.ReadDir(fsName(dirname)); err != nil {
+		err = errors.Wrapf(err, "failed to read directory names for %s", dirname)
+		return
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return
+}
+
+// hashFS returns the hex encoded hash of the given file in the given filesystem using the given
+// algorithm. Falls back on HashFile when no filesystem is given.
+func hashFS(fsys FS, filename string, algo Hash) (result string, err error) {
+	if fsys == nil {
+		return HashFile(filename, algo)
+	}
+	var fr fs.File
+	if fr, err = fsys.Open(fsName(filename)); err != nil {
+		err = errors.Wrapf(err, "failed opening target file %s", filename)
+		return
+	}
+	defer fr.Close()
+	return HashReader(fr, algo)
+}
+
+// CopyFS copies src from the srcFS to dst in the dstFS recursively, creating destination
+// directories as needed. Either filesystem may be nil to use the OS.
+// * Handles globbing e.g. CopyFS(mem, "/src/*", nil, "../")
+// * The dst will be copied to if it is an existing directory.
+// * The dst will be a clone of the src if it doesn't exist.
+// * Doesn't follow links by default but can be turned by passing in FollowOpt(true)
+// * Following links will use the link name but replace its content with the target linked to
+// * Supports the same progress, dry run, conflict, compare and filter options as Copy
+// * Preserves hard links and re-creates FIFOs, sockets and devices when the dst is backed by the OS
+// * Preserves the attributes called out by PreserveOpt defaulting to PreserveMode, though only the mode and times unless both filesystems are backed by the OS
+func CopyFS(srcFS FS, src string, dstFS FS, dst string, opts ...*opt.Opt) (err error) {
+	clone := true
+	if srcFS == nil {
+		srcFS = NewOsFS("")
+	}
+	if dstFS == nil {
+		dstFS = NewOsFS("")
+	}
+
+	// Set following links to false by default
+	opts = opt.Copy(opts)
+	defaultFollowOpt(&opts, false)
+	opts = copyHardlinks(copyTotals(opts))
+
+	// Get Abs src and dst roots
+	var dstAbs, srcAbs string
+	if dstAbs, err = fsAbs(dstFS, dst); err != nil {
+		return
+	}
+	if srcAbs, err = fsAbs(srcFS, strings.TrimSuffix(src, "/")); err != nil {
+		return
+	}
+
+	// Handle globbing
+	var sources []string
+	if sources, err = Glob(srcAbs, FSOpt(srcFS)); err != nil {
+		return
+	}
+
+	// Fail no sources were found
+	if len(sources) == 0 {
+		err = errors.Errorf("failed to get any sources for %s", srcAbs)
+		return
+	}
+
+	// Clone given src as dst vs copy into dst
+	if info, e := LstatFS(dstFS, dstAbs); (e == nil && info.IsDir()) || len(sources) > 1 {
+		clone = false
+	}
+
+	// Copy all sources to dst
+	for _, root := range sources {
+		dstRoot := dstAbs
+		if !clone {
+			dstRoot = path.Join(dstAbs, path.Base(root))
+		}
+		if err = copyFSPath(srcFS, root, dstFS, dstRoot, "", map[string]bool{}, opts); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// copyFSPath copies the given src path to the dst path recursing into directories. Following
+// links replaces the link's information with its target's and copies linked directories under
+// the link name skipping those that loop back to a parent.
+func copyFSPath(srcFS FS, srcPath string, dstFS FS, dstPath, rel string, parents map[string]bool, opts []*opt.Opt) (err error) {
+	var srcInfo *FileInfo
+	if srcInfo, err = LstatFS(srcFS, srcPath); err != nil {
+		return
+	}
+	if srcInfo.IsSymlink() && getFollowOpt(opts) {
+		var obj fs.FileInfo
+		if obj, err = srcFS.Stat(fsName(srcPath)); err != nil {
+			return errors.Wrapf(err, "failed to follow link %s", srcPath)
+		}
+		srcInfo = &FileInfo{Path: srcPath, Obj: obj, fsys: srcFS}
+	}
+	if copyFilter(rel, srcInfo.IsDir(), opts) {
+		return
+	}
+	if !srcInfo.IsDir() {
+		return copyFSFile(srcInfo, dstFS, dstPath, opts)
+	}
+
+	// Skip directories looping back to a parent
+	var resolved string
+	if resolved, err = fsEvalSymlinks(srcFS, srcPath); err != nil {
+		return errors.Wrapf(err, "failed to resolve %s", srcPath)
+	}
+	if parents[resolved] {
+		return
+	}
+	parents[resolved] = true
+	defer delete(parents, resolved)
+
+	dryrun := opt.GetDryrunOpt(opts)
+	if _, e := dstFS.Lstat(dstPath); e != nil {
+		if !dryrun {
+			if err = dstFS.MkdirAll(dstPath, srcInfo.Mode().Perm()|0700); err != nil {
+				return errors.Wrapf(err, "failed to create directory %s", dstPath)
+			}
+		}
+		copyReport(opts, &CopyProgress{Action: CopyActionMkdir, Src: srcPath, Dst: dstPath, Done: true})
+	}
+
+	var names []string
+	if names, err = fsReadDirnames(srcFS, srcPath); err != nil {
+		return
+	}
+	for _, name := range names {
+		err = copyFSPath(srcFS, path.Join(srcPath, name), dstFS, path.Join(dstPath, name), path.Join(rel, name), parents, opts)
+		if err != nil {
+			return
+		}
+	}
+
+	// Preserve directory attributes once their content has been copied
+	if !dryrun {
+		err = copyFSPreserve(srcInfo, dstFS, dstPath, opts)
+	}
+	return
+}
+
+// copyFSFile copies the file or link described by srcInfo from its filesystem to the exact
+// dstPath in the dstFS honoring the conflict, compare, dry run, progress and preserve options
+func copyFSFile(srcInfo *FileInfo, dstFS FS, dstPath string, opts []*opt.Opt) (err error) {
+	var skip bool
+	if dstPath, skip, err = copyConflict(srcInfo.Path, srcInfo, dstFS, dstPath, opts); err != nil {
+		return
+	}
+
+	// Hard links and nodes can only be re-created on filesystems backed by the OS
+	var hardlink string
+	dstHost, osDst := fsOSPath(dstFS, dstPath, false)
+	if osDst {
+		hardlink = copyHardlink(srcInfo, dstHost, opts)
+	} else if isNode(srcInfo) {
+		return errors.Errorf("failed to copy %s: unsupported file type for destination filesystem", srcInfo.Path)
+	}
+	action := copyAction(srcInfo, skip, hardlink)
+	p := &CopyProgress{Action: action, Src: srcInfo.Path, Dst: dstPath, Size: srcInfo.Size()}
+	if skip || opt.GetDryrunOpt(opts) {
+		p.Done = true
+		copyReport(opts, p)
+		return
+	}
+
+	// Create any missing parent directories
+	if _, e := dstFS.Stat(path.Dir(dstPath)); os.IsNotExist(e) {
+		if err = dstFS.MkdirAll(path.Dir(dstPath), 0755); err != nil {
+			return errors.Wrapf(err, "failed to create directory %s", path.Dir(dstPath))
+		}
+	}
+
+	// Only regular files can be written through so remove any other existing destination first
+	if dstInfo, e := dstFS.Lstat(dstPath); e == nil && (action != CopyActionCopy || !dstInfo.Mode().IsRegular()) {
+		if err = dstFS.Remove(dstPath); err != nil {
+			return errors.Wrapf(err, "failed to remove existing destination %s", dstPath)
+		}
+	}
+
+	if srcInfo.IsSymlink() {
+		var target string
+		if target, err = srcInfo.fsys.Readlink(srcInfo.Path); err != nil {
+			return errors.Wrapf(err, "failed reading link %s", srcInfo.Path)
+		}
+		if err = dstFS.Symlink(target, dstPath); err != nil {
+			return errors.Wrapf(err, "failed to create link %s", dstPath)
+		}
+	} else if hardlink != "" {
+		if err = os.Link(hardlink, dstHost); err != nil {
+			return errors.Wrapf(err, "failed to create hard link %s", dstPath)
+		}
+	} else if isNode(srcInfo) {
+		if err = copyNode(srcInfo, dstHost); err != nil {
+			return
+		}
+	} else {
+		var fr fs.File
+		if fr, err = srcInfo.fsys.Open(fsName(srcInfo.Path)); err != nil {
+			return errors.Wrapf(err, "failed to open file %s for reading", srcInfo.Path)
+		}
+		defer fr.Close()
+
+		var fw File
+		if fw, err = dstFS.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			return errors.Wrapf(err, "failed to create file %s", dstPath)
+		}
+		if err = copyData(fw, fr, p, opts); err != nil {
+			err = errors.Wrapf(err, "failed to copy data to file %s", dstPath)
+			if e := fw.Close(); e != nil {
+				err = errors.Wrapf(err, "failed to close file %s", dstPath)
+			}
+			return
+		}
+		if err = fw.Close(); err != nil {
+			return errors.Wrapf(err, "failed to close file %s", dstPath)
+		}
+	}
+
+	if err = copyFSPreserve(srcInfo, dstFS, dstPath, opts); err != nil {
+		return
+	}
+	p.Done = true
+	copyReport(opts, p)
+	return
+}
+
+// copyFSPreserve applies the src mode and times called out by the PreserveOpt to the dst path.
+// Links are skipped as FS has no way to change a link's own attributes. Filesystems backed by
+// the OS support all attributes as with Copy.
+func copyFSPreserve(srcInfo *FileInfo, dstFS FS, dstPath string, opts []*opt.Opt) (err error) {
+	if src, ok := fsOSPath(srcInfo.fsys, srcInfo.Path, !srcInfo.IsSymlink()); ok {
+		if dst, ok := fsOSPath(dstFS, dstPath, false); ok {
+			return copyPreserve(&FileInfo{Path: src, Obj: srcInfo.Obj}, dst, opts)
+		}
+	}
+	if srcInfo.IsSymlink() {
+		return
+	}
+	preserve := getPreserveOpt(opts)
+	if preserve&PreserveMode != 0 {
+		if err = dstFS.Chmod(dstPath, srcInfo.Mode()); err != nil {
+			return errors.Wrapf(err, "failed to chmod file %s", dstPath)
+		}
+	}
+	if preserve&PreserveTimes != 0 {
+		if err = dstFS.Chtimes(dstPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+			return errors.Wrapf(err, "failed to preserve times of %s", dstPath)
+		}
+	}
+	return
+}
@@ -0,0 +1,236 @@
+package sys
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/stretchr/testify/assert"
+)
+
+// memTree creates a new in memory filesystem with a small tree under /src
+func memTree(t *testing.T) (mem *MemFS) {
+	mem = NewMemFS()
+	assert.Nil(t, mem.MkdirAll("/src/sub", 0755))
+	assert.Nil(t, WriteStringFS(mem, "/src/file", "file"))
+	assert.Nil(t, WriteLinesFS(mem, "/src/sub/lines", []string{"one", "two"}))
+	assert.Nil(t, WriteBytesFS(mem, "/src/sub/script.sh", []byte("#!/bin/sh"), 0755))
+	assert.Nil(t, mem.Symlink("file", "/src/link"))
+	return
+}
+
+func TestOsFS(t *testing.T) {
+	resetTest()
+	root, _ := Abs(tmpDir)
+	osfs := NewOsFS(root)
+	assert.Nil(t, osfs.MkdirAll("/dir", 0755))
+	assert.Nil(t, osfs.WriteFile("/dir/file", []byte("file"), 0644))
+	assert.Nil(t, osfs.Symlink("file", "dir/link"))
+
+	data, err := ReadBytes(path.Join(tmpDir, "dir", "file"))
+	assert.Nil(t, err)
+	assert.Equal(t, "file", string(data))
+	lines, err := ReadLinesFS(osfs, "../../dir/link")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"file"}, lines)
+	assert.Equal(t, []string{"/dir/file", "/dir/link"}, func() []string {
+		paths, _ := Glob("/dir/*", FSOpt(osfs))
+		return paths
+	}())
+
+	// io/fs names are relative to the root or / when no root is set
+	assert.Nil(t, osfs.Remove("dir/link"))
+	assert.Nil(t, fstest.TestFS(osfs, "dir/file"))
+	_, err = osfs.Stat("/dir")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	info, err := NewOsFS("").Stat(fsName(path.Join(root, "dir")))
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	ro := NewReadOnlyFS(memTree(t))
+
+	data, err := ReadStringFS(ro, "/src/file")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", data)
+
+	err = WriteStringFS(ro, "/src/file", "data")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Equal(t, "failed writing string to file /src/file: open /src/file: read-only filesystem", err.Error())
+	assert.ErrorIs(t, ro.Remove("/src/file"), ErrReadOnly)
+	assert.ErrorIs(t, ro.Mkdir("/dir", 0755), ErrReadOnly)
+	_, err = ro.OpenFile("/src/file", os.O_RDWR, 0)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, Chmod("/src/file", 0600, FSOpt(ro)), ErrReadOnly)
+}
+
+func TestFSHelpers(t *testing.T) {
+
+	// read and write
+	{
+		mem := memTree(t)
+		lines, err := ReadLinesFS(mem, "/src/sub/lines")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"one", "two"}, lines)
+		info, err := LstatFS(mem, "/src/sub/script.sh")
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+		assert.True(t, ExistsFS(mem, "/src/link"))
+		assert.False(t, ExistsFS(mem, "/src/bogus"))
+
+		_, err = ReadBytesFS(mem, "/src/bogus")
+		assert.Equal(t, "failed reading the file /src/bogus: open src/bogus: file does not exist", err.Error())
+	}
+
+	// link helpers use the filesystem the info came from
+	{
+		mem := memTree(t)
+		info, err := LstatFS(mem, "/src/link")
+		assert.Nil(t, err)
+		assert.True(t, info.IsSymlinkFile())
+		assert.False(t, info.IsSymlinkDir())
+		assert.True(t, info.SymlinkTargetExists())
+	}
+
+	// full disk
+	{
+		mem := NewMemFS()
+		mem.SetCapacity(4)
+		err := WriteStringFS(mem, "/file", "too much")
+		assert.ErrorIs(t, err, syscall.ENOSPC)
+		assert.True(t, strings.HasPrefix(err.Error(), "failed writing string to file /file"))
+	}
+
+	// walk
+	{
+		mem := memTree(t)
+		paths := []string{}
+		assert.Nil(t, Walk("/src", func(p string, info *FileInfo, err error) error {
+			paths = append(paths, p)
+			return err
+		}, FSOpt(mem)))
+		assert.Equal(t, []string{"/src", "/src/file", "/src/link", "/src/file", "/src/sub", "/src/sub/lines", "/src/sub/script.sh"}, paths)
+
+		assert.Nil(t, mem.WriteFile("/src/.gitignore", []byte("*.sh\n"), 0644))
+		files, err := AllFiles("/src", FSOpt(mem), FollowOpt(false), IgnoreFileOpt(".gitignore"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/src/.gitignore", "/src/file", "/src/sub/lines"}, files)
+	}
+
+	// glob
+	{
+		mem := memTree(t)
+		paths, err := Glob("/src/*", FSOpt(mem))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/src/file", "/src/link", "/src/sub"}, paths)
+		paths, err = Glob("/src/**/*.{sh,txt}", FSOpt(mem))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/src/sub/script.sh"}, paths)
+		paths, err = Glob("/src/sub", FSOpt(mem), RecurseOpt(true))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/src/sub", "/src/sub/lines", "/src/sub/script.sh"}, paths)
+		paths, err = Glob("/bogus/*", FSOpt(mem))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, paths)
+	}
+
+	// chmod
+	{
+		mem := memTree(t)
+		assert.Nil(t, Chmod("/src", 0700, FSOpt(mem), RecurseOpt(true), OnlyDirsOpt(true)))
+		info, _ := mem.Stat("src/sub")
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+		info, _ = mem.Stat("src/sub/lines")
+		assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+		assert.Nil(t, Chmod("/src/sub/*", 0600, FSOpt(mem)))
+		info, _ = mem.Stat("src/sub/script.sh")
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestCopyFS(t *testing.T) {
+
+	// clone within a filesystem
+	{
+		mem := memTree(t)
+		assert.Nil(t, Copy("/src", "/dst", FSOpt(mem)))
+		assert.Equal(t, []string{"file", "link", "sub"}, layerNames(t, mem, "/dst"))
+		target, err := mem.Readlink("/dst/link")
+		assert.Nil(t, err)
+		assert.Equal(t, "file", target)
+		info, _ := mem.Stat("dst/sub/script.sh")
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	}
+
+	// copy into an existing directory following links with filters
+	{
+		mem := memTree(t)
+		assert.Nil(t, mem.Mkdir("/dst", 0755))
+		assert.Nil(t, Copy("/src", "/dst", FSOpt(mem), FollowOpt(true), ExcludeOpt("*.sh")))
+		assert.Equal(t, []string{"file", "link", "sub"}, layerNames(t, mem, "/dst/src"))
+		assert.Equal(t, []string{"lines"}, layerNames(t, mem, "/dst/src/sub"))
+		info, _ := mem.Lstat("/dst/src/link")
+		assert.True(t, info.Mode().IsRegular())
+	}
+
+	// conflicts
+	{
+		mem := memTree(t)
+		assert.Nil(t, mem.Mkdir("/dst", 0755))
+		assert.Nil(t, WriteStringFS(mem, "/dst/file", "existing"))
+		err := Copy("/src/file", "/dst/file", FSOpt(mem), ConflictOpt(ConflictError))
+		assert.Equal(t, "failed to copy /src/file: destination /dst/file already exists", err.Error())
+		assert.Nil(t, Copy("/src/file", "/dst/file", FSOpt(mem), ConflictOpt(ConflictSkip)))
+		data, _ := ReadStringFS(mem, "/dst/file")
+		assert.Equal(t, "existing", data)
+		assert.Nil(t, Copy("/src/file", "/dst/file", FSOpt(mem), ConflictOpt(ConflictRename)))
+		data, _ = ReadStringFS(mem, "/dst/file_1")
+		assert.Equal(t, "file", data)
+		assert.Nil(t, Copy("/src/file", "/dst/file", FSOpt(mem)))
+		data, _ = ReadStringFS(mem, "/dst/file")
+		assert.Equal(t, "file", data)
+	}
+
+	// dry run and progress
+	{
+		mem := memTree(t)
+		actions := []string{}
+		assert.Nil(t, Copy("/src/sub", "/dst", FSOpt(mem), opt.DryrunOpt(true), ProgressOpt(func(p *CopyProgress) {
+			if p.Done {
+				actions = append(actions, p.Action+" "+p.Dst)
+			}
+		})))
+		assert.Equal(t, []string{"mkdir /dst", "copy /dst/lines", "copy /dst/script.sh"}, actions)
+		assert.False(t, ExistsFS(mem, "/dst"))
+	}
+
+	// across filesystems including the OS
+	{
+		resetTest()
+		mem := memTree(t)
+		assert.Nil(t, CopyFS(mem, "/src", nil, tmpDir))
+		data, err := ReadString(path.Join(tmpDir, "src", "sub", "lines"))
+		assert.Nil(t, err)
+		assert.Equal(t, "one\ntwo", data)
+
+		back := NewMemFS()
+		assert.Nil(t, CopyFS(nil, path.Join(tmpDir, "src", "sub"), back, "/back"))
+		lines, err := ReadLinesFS(back, "/back/lines")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"one", "two"}, lines)
+	}
+
+	// full disk
+	{
+		mem := memTree(t)
+		mem.SetCapacity(mem.Used() + 4)
+		err := Copy("/src", "/dst", FSOpt(mem))
+		assert.ErrorIs(t, err, syscall.ENOSPC)
+	}
+}
@@ -0,0 +1,268 @@
+package sys
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExpandBraces expands the shell style braces in the given pattern e.g. *.{yml,yaml} expands
+// to [*.yml, *.yaml]. Braces may be nested and escaped with a backslash. Braces without a
+// comma or without a matching close are left as is.
+func ExpandBraces(pattern string) (result []string) {
+	start, depth := -1, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth > 0 {
+				continue
+			}
+			alts := splitBraces(pattern[start+1 : i])
+			if len(alts) < 2 {
+				continue
+			}
+			for _, alt := range alts {
+				result = append(result, ExpandBraces(pattern[:start]+alt+pattern[i+1:])...)
+			}
+			return
+		}
+	}
+	return []string{pattern}
+}
+
+// splitBraces splits the given brace contents on the commas that aren't nested or escaped
+func splitBraces(contents string) (alts []string) {
+	start, depth := 0, 0
+	for i := 0; i < len(contents); i++ {
+		switch contents[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alts = append(alts, contents[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(alts, contents[start:])
+}
+
+// Match reports whether the given slash separated name matches the shell pattern. Extends
+// filepath.Match with doublestar ** segments that match zero or more directories e.g.
+// src/**/*.go and brace expansion e.g. *.{yml,yaml}. The only possible error returned is
+// filepath.ErrBadPattern.
+func Match(pattern, name string) (matched bool, err error) {
+	for _, expanded := range ExpandBraces(pattern) {
+		if matched, err = matchSegments(splitSegments(expanded), splitSegments(name)); err != nil || matched {
+			return
+		}
+	}
+	return
+}
+
+// matchSegments matches the pattern segments against the name segments allowing ** segments
+// to consume zero or more name segments
+func matchSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true, nil
+			}
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchSegments(pattern, name[i:]); err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		if ok, err := filepath.Match(pattern[0], name[0]); err != nil || !ok {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// splitSegments splits the given slash separated path into its segments ignoring empty ones
+func splitSegments(target string) (segments []string) {
+	for _, segment := range strings.Split(target, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return
+}
+
+// matchPatterns returns true if the patterns match either the given relative path or its base
+// name. Patterns are evaluated in order with the last match winning such that patterns prefixed
+// with ! negate earlier matches e.g. *.go, !main.go matches all go files except main.go.
+func matchPatterns(rel string, patterns []string) (matched bool) {
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		if matched == negate && (matchPattern(pattern, rel) || matchPattern(pattern, path.Base(rel))) {
+			matched = !negate
+		}
+	}
+	return
+}
+
+// matchPattern wraps Match ignoring bad patterns
+func matchPattern(pattern, name string) bool {
+	ok, _ := Match(pattern, name)
+	return ok
+}
+
+// hasMeta returns true if the given path segment contains any glob meta characters
+func hasMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[{\`)
+}
+
+// globBase splits the given absolute pattern into the leading directory without any glob meta
+// characters and the remaining pattern relative to it
+func globBase(pattern string) (base, rel string) {
+	segments := strings.Split(pattern, "/")
+	i := 0
+	for ; i < len(segments)-1 && !hasMeta(segments[i]); i++ {
+	}
+	base = strings.Join(segments[:i], "/")
+	if base == "" {
+		base = "/"
+	}
+	rel = strings.Join(segments[i:], "/")
+	return
+}
+
+// ignoreRule provides a single gitignore style pattern scoped to the directory of its ignore file
+type ignoreRule struct {
+	dir      string // directory of the ignore file the rule came from
+	pattern  string // glob pattern relative to dir
+	negate   bool   // rule re-includes paths rather than ignoring them
+	dirOnly  bool   // rule only applies to directories
+	anchored bool   // rule only matches relative to dir rather than at any depth
+}
+
+// match returns true if the rule matches the given path
+func (r *ignoreRule) match(target string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel := strings.TrimPrefix(target, r.dir+"/")
+	if rel == target {
+		return false
+	}
+	if r.anchored {
+		return matchPattern(r.pattern, rel)
+	}
+	return matchPattern("**/"+r.pattern, rel)
+}
+
+// readIgnoreFile parses the gitignore style rules from the given ignore file. Blank lines and
+// lines starting with # are skipped, ! negates a rule, a trailing / restricts a rule to
+// directories and a leading or inner / anchors the rule to the ignore file's directory.
+func readIgnoreFile(fsys FS, target string) (rules []*ignoreRule, err error) {
+	var file io.ReadCloser
+	if fsys == nil {
+		file, err = os.Open(target)
+	} else {
+		file, err = fsys.Open(fsName(target))
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open ignore file %s", target)
+		return
+	}
+	defer file.Close()
+
+	dir := path.Dir(target)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := &ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if rule.pattern != "" {
+			rules = append(rules, rule)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		err = errors.Wrapf(err, "failed to read ignore file %s", target)
+	}
+	return
+}
+
+// ignoreWalkFn wraps the given walk function to skip paths ignored by the gitignore style
+// ignore files of the given name found in each directory walked. Rules from deeper ignore
+// files take precedence over those from their parents and ignored directories are skipped
+// entirely.
+func ignoreWalkFn(fsys FS, name string, walkFn WalkFunc) WalkFunc {
+	rules := []*ignoreRule{}
+	return func(p string, info *FileInfo, err error) error {
+		if err != nil || info == nil {
+			return walkFn(p, info, err)
+		}
+
+		ignored := false
+		for _, rule := range rules {
+			if ignored == rule.negate && rule.match(p, info.IsDir()) {
+				ignored = !rule.negate
+			}
+		}
+		if ignored {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if ignoreFile := path.Join(p, name); ExistsFS(fsys, ignoreFile) {
+				dirRules, e := readIgnoreFile(fsys, ignoreFile)
+				if e != nil {
+					return walkFn(p, info, e)
+				}
+				rules = append(rules, dirRules...)
+			}
+		}
+		return walkFn(p, info, err)
+	}
+}
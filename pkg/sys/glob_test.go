@@ -0,0 +1,178 @@
+package sys
+
+import (
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// globTree creates a small source tree under the given dir for globbing
+func globTree(t *testing.T, dir string) {
+	for _, name := range []string{"main.go", "main_test.go", "README.md", "cfg/app.yml",
+		"cfg/db.yaml", "src/a.go", "src/pkg/b.go", "src/pkg/b_test.go", "vendor/c/c.go"} {
+		writeTestFile(t, path.Join(dir, name), name)
+	}
+}
+
+// readDirFS records the directories read from the wrapped in memory filesystem
+type readDirFS struct {
+	*MemFS
+	dirs []string // names of the directories read in order
+}
+
+// ReadDir records the directory before reading it
+func (r *readDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	r.dirs = append(r.dirs, name)
+	return r.MemFS.ReadDir(name)
+}
+
+// relPaths returns the given paths relative to the given dir
+func relPaths(dir string, paths []string) (result []string) {
+	dir, _ = Abs(dir)
+	result = []string{}
+	for _, p := range paths {
+		result = append(result, TrimShared(p, dir))
+	}
+	return
+}
+
+func TestExpandBraces(t *testing.T) {
+	assert.Equal(t, []string{"*.go"}, ExpandBraces("*.go"))
+	assert.Equal(t, []string{"*.yml", "*.yaml"}, ExpandBraces("*.{yml,yaml}"))
+	assert.Equal(t, []string{"ab", "acd", "ace", "b"}, ExpandBraces("{a{b,c{d,e}},b}"))
+	assert.Equal(t, []string{"a/x/1", "a/x/2", "a/y/1", "a/y/2"}, ExpandBraces("a/{x,y}/{1,2}"))
+	assert.Equal(t, []string{"{a}", "{a", `\{a,b}`}, []string{ExpandBraces("{a}")[0], ExpandBraces("{a")[0], ExpandBraces(`\{a,b}`)[0]})
+	assert.Equal(t, []string{"", "a"}, ExpandBraces("{,a}"))
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, name string
+		matched       bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "src/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "src/pkg/main.go", true},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/main.go", true},
+		{"src/**/*.go", "lib/main.go", false},
+		{"src/**", "src/a/b", true},
+		{"src/**/b", "src/a/b/c", false},
+		{"**", "a/b/c", true},
+		{"*.{yml,yaml}", "app.yaml", true},
+		{"*.{yml,yaml}", "app.json", false},
+		{"{src,lib}/**/*_test.go", "lib/x/a_test.go", true},
+	} {
+		matched, err := Match(c.pattern, c.name)
+		assert.Nil(t, err)
+		assert.Equal(t, c.matched, matched, c.pattern+" "+c.name)
+	}
+
+	_, err := Match("[", "a")
+	assert.NotNil(t, err)
+}
+
+func TestGlob_Doublestar(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "glob")
+	globTree(t, dir)
+
+	// doublestar
+	{
+		sources, err := Glob(path.Join(dir, "src/**/*.go"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"src/a.go", "src/pkg/b.go", "src/pkg/b_test.go"}, relPaths(dir, sources))
+	}
+
+	// braces
+	{
+		sources, err := Glob(path.Join(dir, "cfg/*.{yml,yaml}"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"cfg/app.yml", "cfg/db.yaml"}, relPaths(dir, sources))
+
+		sources, err = Glob(path.Join(dir, "{cfg,src}/a*"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"cfg/app.yml", "src/a.go"}, relPaths(dir, sources))
+	}
+
+	// exclusions with negation
+	{
+		sources, err := Glob(path.Join(dir, "**/*.go"), ExcludeOpt("vendor/**", "*_test.go", "!main_test.go"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"main.go", "main_test.go", "src/a.go", "src/pkg/b.go"}, relPaths(dir, sources))
+
+		sources, err = Glob(path.Join(dir, "*"), ExcludeOpt("*.md", "cfg"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"main.go", "main_test.go", "src", "vendor"}, relPaths(dir, sources))
+	}
+
+	// walks only as deep as the pattern without a doublestar
+	{
+		fsys := &readDirFS{MemFS: NewMemFS()}
+		assert.Nil(t, fsys.MkdirAll("/usr/a/bin", 0755))
+		assert.Nil(t, fsys.MkdirAll("/usr/a/lib/deep/more", 0755))
+		assert.Nil(t, fsys.MkdirAll("/usr/b/bin", 0755))
+		assert.Nil(t, fsys.WriteFile("/usr/a/lib/deep/more/file", []byte("file"), 0644))
+		sources, err := Glob("/usr/*/bin", FSOpt(fsys))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/usr/a/bin", "/usr/b/bin"}, sources)
+		assert.Equal(t, []string{"usr", "usr/a", "usr/b"}, fsys.dirs)
+
+		fsys.dirs = nil
+		sources, err = Glob("/usr/**/file", FSOpt(fsys))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/usr/a/lib/deep/more/file"}, sources)
+		assert.Contains(t, fsys.dirs, "usr/a/lib/deep/more")
+	}
+
+	// no matches and bad patterns
+	{
+		sources, err := Glob(path.Join(dir, "bogus/**/*.go"))
+		assert.Nil(t, err)
+		assert.Len(t, sources, 0)
+
+		_, err = Glob(path.Join(dir, "**/["))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "glob")
+	globTree(t, dir)
+	writeTestFile(t, path.Join(dir, ".gitignore"), "# comment\n\nvendor/\n*_test.go\n/README.md\ncfg/*.yml\n")
+	writeTestFile(t, path.Join(dir, "src", ".gitignore"), "!b_test.go\n")
+	writeTestFile(t, path.Join(dir, "src", "README.md"), "readme")
+
+	// all files
+	{
+		files, err := AllFiles(dir, IgnoreFileOpt(".gitignore"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{".gitignore", "cfg/db.yaml", "main.go", "src/.gitignore",
+			"src/README.md", "src/a.go", "src/pkg/b.go", "src/pkg/b_test.go"}, relPaths(dir, files))
+
+		files, err = AllFiles(dir)
+		assert.Nil(t, err)
+		assert.Len(t, files, 12)
+	}
+
+	// copy
+	{
+		dst := path.Join(tmpDir, "dst")
+		assert.Nil(t, Copy(dir, dst, IgnoreFileOpt(".gitignore")))
+		files, err := AllFiles(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{".gitignore", "cfg/db.yaml", "main.go", "src/.gitignore",
+			"src/README.md", "src/a.go", "src/pkg/b.go", "src/pkg/b_test.go"}, relPaths(dst, files))
+	}
+
+	// glob
+	{
+		sources, err := Glob(path.Join(dir, "**/*.go"), IgnoreFileOpt(".gitignore"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"main.go", "src/a.go", "src/pkg/b.go", "src/pkg/b_test.go"}, relPaths(dir, sources))
+	}
+}
@@ -0,0 +1,277 @@
+package sys
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash identifies a supported hash algorithm by its conventional name
+type Hash string
+
+const (
+	// HashMD5 is the md5 hash algorithm as used by md5sum
+	HashMD5 Hash = "md5"
+
+	// HashSHA1 is the sha1 hash algorithm as used by sha1sum
+	HashSHA1 Hash = "sha1"
+
+	// HashSHA256 is the sha256 hash algorithm as used by sha256sum
+	HashSHA256 Hash = "sha256"
+
+	// HashSHA512 is the sha512 hash algorithm as used by sha512sum
+	HashSHA512 Hash = "sha512"
+
+	// HashBLAKE2b is the 512 bit blake2b hash algorithm as used by b2sum
+	HashBLAKE2b Hash = "blake2b"
+)
+
+// New creates a new instance of the hash algorithm
+func (h Hash) New() (result hash.Hash, err error) {
+	switch h {
+	case HashMD5:
+		result = md5.New()
+	case HashSHA1:
+		result = sha1.New()
+	case HashSHA256:
+		result = sha256.New()
+	case HashSHA512:
+		result = sha512.New()
+	case HashBLAKE2b:
+		result, err = blake2b.New512(nil)
+	default:
+		err = errors.Errorf("unsupported hash algorithm %s", string(h))
+	}
+	return
+}
+
+// BLAKE2b returns the 512 bit blake2b hash of the given file
+func BLAKE2b(filename string) (result string, err error) {
+	return HashFile(filename, HashBLAKE2b)
+}
+
+// SHA1 returns the sha1 hash of the given file
+func SHA1(filename string) (result string, err error) {
+	return HashFile(filename, HashSHA1)
+}
+
+// SHA256 returns the sha256 hash of the given file
+func SHA256(filename string) (result string, err error) {
+	return HashFile(filename, HashSHA256)
+}
+
+// SHA512 returns the sha512 hash of the given file
+func SHA512(filename string) (result string, err error) {
+	return HashFile(filename, HashSHA512)
+}
+
+// HashFile returns the hex encoded hash of the given file using the given algorithm
+func HashFile(filename string, algo Hash) (result string, err error) {
+	var h hash.Hash
+	if h, err = algo.New(); err != nil {
+		return
+	}
+	if filename, err = Abs(filename); err != nil {
+		return
+	}
+	if !Exists(filename) {
+		return "", os.ErrNotExist
+	}
+
+	// Open file for reading
+	var fr *os.File
+	if fr, err = os.Open(filename); err != nil {
+		err = errors.Wrapf(err, "failed opening target file %s", filename)
+		return
+	}
+	defer fr.Close()
+
+	// Copy in file bits
+	if _, err = io.Copy(h, fr); err != nil {
+		err = errors.Wrapf(err, "failed copying file data into hash from %s", filename)
+		return
+	}
+	result = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// HashReader returns the hex encoded hash of all data read from the given reader using the
+// given algorithm
+func HashReader(reader io.Reader, algo Hash) (result string, err error) {
+	var h hash.Hash
+	if h, err = algo.New(); err != nil {
+		return
+	}
+	if _, err = io.Copy(h, reader); err != nil {
+		err = errors.Wrap(err, "failed copying data into hash")
+		return
+	}
+	result = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// Checksum provides a single entry of a checksum file
+type Checksum struct {
+	Sum  string // Hex encoded hash of the file
+	Path string // Path of the file as given in the checksum file
+}
+
+// ReadChecksums reads the entries of the given checksum file in the format produced by
+// sha256sum and friends i.e. lines of hash followed by two spaces or a space and an asterisk
+// then the path. Blank lines and lines starting with # are skipped.
+func ReadChecksums(target string) (checksums []*Checksum, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+
+	var fr *os.File
+	if fr, err = os.Open(target); err != nil {
+		err = errors.Wrapf(err, "failed opening checksum file %s", target)
+		return
+	}
+	defer fr.Close()
+
+	checksums = []*Checksum{}
+	scanner := bufio.NewScanner(fr)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[1]) < 2 || (fields[1][0] != ' ' && fields[1][0] != '*') {
+			err = errors.Errorf("invalid checksum file %s at line %d", target, i)
+			return
+		}
+		if _, e := hex.DecodeString(fields[0]); e != nil {
+			err = errors.Errorf("invalid checksum file %s at line %d", target, i)
+			return
+		}
+		checksums = append(checksums, &Checksum{Sum: strings.ToLower(fields[0]), Path: fields[1][1:]})
+	}
+	if err = scanner.Err(); err != nil {
+		err = errors.Wrapf(err, "failed reading checksum file %s", target)
+	}
+	return
+}
+
+// VerifyChecksums verifies the files listed in the given checksum file using the given
+// algorithm. Relative paths are resolved against the checksum file's directory. Returns the
+// paths as listed that are missing or don't match along with any error reading the checksum file.
+func VerifyChecksums(target string, algo Hash) (failed []string, err error) {
+	var checksums []*Checksum
+	if checksums, err = ReadChecksums(target); err != nil {
+		return
+	}
+	if _, err = algo.New(); err != nil {
+		return
+	}
+
+	failed = []string{}
+	dir := Dir(target)
+	for _, checksum := range checksums {
+		filename := checksum.Path
+		if !filepath.IsAbs(filename) {
+			filename = path.Join(dir, filename)
+		}
+		if sum, e := HashFile(filename, algo); e != nil || sum != checksum.Sum {
+			failed = append(failed, checksum.Path)
+		}
+	}
+	return
+}
+
+// WriteChecksums writes a checksum file in the format produced by sha256sum and friends for
+// the given files using the given algorithm. Paths are written relative to the checksum
+// file's directory when under it.
+func WriteChecksums(target string, algo Hash, files ...string) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+
+	lines := []string{}
+	dir := path.Dir(target)
+	for _, filename := range files {
+		var sum string
+		if filename, err = Abs(filename); err != nil {
+			return
+		}
+		if sum, err = HashFile(filename, algo); err != nil {
+			err = errors.Wrapf(err, "failed to compute checksum for %s", filename)
+			return
+		}
+		if strings.HasPrefix(filename, dir+"/") {
+			filename = strings.TrimPrefix(filename, dir+"/")
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s", sum, filename))
+	}
+	return WriteLines(target, lines)
+}
+
+// TreeHash returns a deterministic hex encoded hash of the tree rooted at the given path
+// suitable for use as a cache key. The hash covers the sorted relative paths, their types and
+// permissions, the content of files and the targets of links but not times or ownership.
+// Links are not followed.
+// * Filter paths relative to root with the IncludeOpt and ExcludeOpt glob patterns
+// * Skip paths ignored by gitignore style files by passing in IgnoreFileOpt(".gitignore")
+func TreeHash(root string, algo Hash, opts ...*opt.Opt) (result string, err error) {
+	if root, err = Abs(root); err != nil {
+		return
+	}
+	var tree hash.Hash
+	if tree, err = algo.New(); err != nil {
+		return
+	}
+	opts = opt.Copy(opts)
+	opt.Overwrite(&opts, FollowOpt(false))
+
+	err = Walk(root, func(p string, info *FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		rel := TrimShared(p, root)
+		if copyFilter(rel, info.IsDir(), opts) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == "" {
+			rel = "."
+		}
+
+		var content string
+		switch {
+		case info.IsSymlink():
+			if content, e = os.Readlink(p); e != nil {
+				return errors.Wrapf(e, "failed reading link %s", p)
+			}
+		case info.Mode().IsRegular():
+			if content, e = HashFile(p, algo); e != nil {
+				return e
+			}
+		}
+		fmt.Fprintf(tree, "%s %s\x00%s\n", info.Mode().String(), rel, content)
+		return nil
+	}, opts...)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to hash tree %s", root)
+		return
+	}
+	result = hex.EncodeToString(tree.Sum(nil))
+	return
+}
@@ -0,0 +1,173 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const hashTestData = `This is a test of the emergency broadcast system.`
+
+func TestHashFile(t *testing.T) {
+	resetTest()
+	assert.Nil(t, WriteString(tmpfile, hashTestData))
+
+	// known hashes
+	{
+		result, err := SHA1(tmpfile)
+		assert.Nil(t, err)
+		assert.Equal(t, "b061fc86362701ac3396d2b84924fe5e1ff8a365", result)
+
+		result, err = SHA256(tmpfile)
+		assert.Nil(t, err)
+		assert.Equal(t, "68a57150d4c1dc5645e826d78342c80dc349ddd790c2f7d197d747fbdd0f107d", result)
+
+		result, err = SHA512(tmpfile)
+		assert.Nil(t, err)
+		assert.Equal(t, "7f28f6d68b58537f8b35a4aaf0e961848f9857f0a62f9fcdb5628dd8ed49a493483e0af3e1f473578b53d54fbfad5e863fbbb16dcc22bc01783cb0671e45d4bf", result)
+
+		result, err = BLAKE2b(tmpfile)
+		assert.Nil(t, err)
+		assert.Equal(t, "a9dcfd47cc70633fad2ed8f4ae93e1b98e02259680039d110a4d5ac13725e7fed502b24cd948832049ae995b49730e1f2ba5afa634f2905b57b340d6adec2bf6", result)
+
+		result, err = HashFile(tmpfile, HashMD5)
+		assert.Nil(t, err)
+		assert.Equal(t, "067a8c38325b12159844261d16e5cb13", result)
+	}
+
+	// reader
+	{
+		result, err := HashReader(strings.NewReader(hashTestData), HashSHA256)
+		assert.Nil(t, err)
+		assert.Equal(t, "68a57150d4c1dc5645e826d78342c80dc349ddd790c2f7d197d747fbdd0f107d", result)
+	}
+
+	// failures
+	{
+		_, err := HashFile(tmpfile, Hash("crc32"))
+		assert.Equal(t, "unsupported hash algorithm crc32", err.Error())
+		_, err = HashReader(strings.NewReader(""), Hash("crc32"))
+		assert.Equal(t, "unsupported hash algorithm crc32", err.Error())
+		_, err = SHA256(path.Join(tmpDir, "bogus"))
+		assert.Equal(t, os.ErrNotExist, err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "sums")
+	writeTestFile(t, path.Join(dir, "a"), "a")
+	writeTestFile(t, path.Join(dir, "sub", "b"), "b")
+	writeTestFile(t, path.Join(tmpDir, "c"), "c")
+	sums := path.Join(dir, "SHA256SUMS")
+
+	// write and read
+	{
+		assert.Nil(t, WriteChecksums(sums, HashSHA256, path.Join(dir, "a"), path.Join(dir, "sub", "b")))
+		lines, err := ReadLines(sums)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  a",
+			"3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d  sub/b",
+		}, lines)
+
+		checksums, err := ReadChecksums(sums)
+		assert.Nil(t, err)
+		assert.Equal(t, []*Checksum{
+			{Sum: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", Path: "a"},
+			{Sum: "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d", Path: "sub/b"},
+		}, checksums)
+	}
+
+	// verify
+	{
+		failed, err := VerifyChecksums(sums, HashSHA256)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, failed)
+
+		abs, _ := Abs(path.Join(tmpDir, "c"))
+		assert.Nil(t, WriteString(sums, "# comment\n"+
+			"ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb *a\n\n"+
+			"0000000000000000000000000000000000000000000000000000000000000000  sub/b\n"+
+			"2E7D2C03A9507AE265ECF5B5356885A53393A2029D241394997265A1A25AEFC6  "+abs+"\n"+
+			"ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  missing\n"))
+		failed, err = VerifyChecksums(sums, HashSHA256)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sub/b", "missing"}, failed)
+	}
+
+	// failures
+	{
+		assert.Nil(t, WriteString(sums, "zz  a\n"))
+		_, err := VerifyChecksums(sums, HashSHA256)
+		assert.True(t, strings.HasSuffix(err.Error(), "SHA256SUMS at line 1"))
+		assert.Nil(t, WriteString(sums, "ca97 a\n"))
+		_, err = ReadChecksums(sums)
+		assert.True(t, strings.HasPrefix(err.Error(), "invalid checksum file "))
+		_, err = ReadChecksums(path.Join(dir, "bogus"))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed opening checksum file "))
+		err = WriteChecksums(sums, HashSHA256, path.Join(dir, "bogus"))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to compute checksum for "))
+	}
+}
+
+func TestTreeHash(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "tree")
+	writeTestFile(t, path.Join(dir, "a"), "a")
+	writeTestFile(t, path.Join(dir, "sub", "b"), "b")
+	assert.Nil(t, os.Symlink("a", path.Join(dir, "link")))
+
+	first, err := TreeHash(dir, HashSHA256)
+	assert.Nil(t, err)
+	assert.Len(t, first, 64)
+
+	// copies with different times hash the same
+	{
+		dst := path.Join(tmpDir, "copy")
+		assert.Nil(t, Copy(dir, dst, PreserveOpt(PreserveMode)))
+		second, err := TreeHash(dst, HashSHA256)
+		assert.Nil(t, err)
+		assert.Equal(t, first, second)
+	}
+
+	// content, mode, link target and path changes all change the hash
+	{
+		for _, change := range []func(){
+			func() { assert.Nil(t, WriteString(path.Join(dir, "a"), "A")) },
+			func() { assert.Nil(t, os.Chmod(path.Join(dir, "a"), 0600)) },
+			func() {
+				assert.Nil(t, os.Remove(path.Join(dir, "link")))
+				assert.Nil(t, os.Symlink("sub", path.Join(dir, "link")))
+			},
+			func() { assert.Nil(t, os.Rename(path.Join(dir, "sub", "b"), path.Join(dir, "sub", "c"))) },
+		} {
+			change()
+			result, err := TreeHash(dir, HashSHA256)
+			assert.Nil(t, err)
+			assert.NotEqual(t, first, result)
+			first = result
+		}
+	}
+
+	// filtered
+	{
+		filtered, err := TreeHash(dir, HashSHA256, ExcludeOpt("sub"))
+		assert.Nil(t, err)
+		assert.Nil(t, WriteString(path.Join(dir, "sub", "c"), "changed"))
+		result, err := TreeHash(dir, HashSHA256, ExcludeOpt("sub"))
+		assert.Nil(t, err)
+		assert.Equal(t, filtered, result)
+	}
+
+	// failures
+	{
+		_, err := TreeHash(path.Join(tmpDir, "bogus"), HashSHA256)
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to hash tree "))
+		_, err = TreeHash(dir, Hash("crc32"))
+		assert.Equal(t, "unsupported hash algorithm crc32", err.Error())
+	}
+}
@@ -0,0 +1,570 @@
+package sys
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LayerFS implements a copy-on-write FS layering a writable upper filesystem over a lower
+// filesystem that is never modified. Reads fall through to the lower layer for paths that
+// don't exist in the upper layer, writes copy the path and its parent directories up first and
+// removals of lower paths are recorded as whiteouts that hide them from the merged view.
+type LayerFS struct {
+	mutex     sync.Mutex      // protects the whiteouts and opaque directories
+	lower     FS              // read only layer
+	upper     FS              // writable layer
+	whiteouts map[string]bool // clean absolute paths removed from the lower layer
+	opaque    map[string]bool // clean absolute paths of directories hiding the lower layer's entries
+}
+
+// NewLayerFS creates a new copy-on-write filesystem with writes going to upper
+func NewLayerFS(lower, upper FS) *LayerFS {
+	return &LayerFS{lower: lower, upper: upper, whiteouts: map[string]bool{}, opaque: map[string]bool{}}
+}
+
+// Chmod implements FS.Chmod
+func (l *LayerFS) Chmod(name string, mode fs.FileMode) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err = l.copyUp("chmod", name); err != nil {
+		return
+	}
+	return l.upper.Chmod(name, mode)
+}
+
+// Chtimes implements FS.Chtimes
+func (l *LayerFS) Chtimes(name string, atime, mtime time.Time) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err = l.copyUp("chtimes", name); err != nil {
+		return
+	}
+	return l.upper.Chtimes(name, atime, mtime)
+}
+
+// Lstat implements FS.Lstat
+func (l *LayerFS) Lstat(name string) (fs.FileInfo, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lstat(name)
+}
+
+// Mkdir implements FS.Mkdir
+func (l *LayerFS) Mkdir(name string, perm fs.FileMode) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.mkdir(name, perm)
+}
+
+// MkdirAll implements FS.MkdirAll
+func (l *LayerFS) MkdirAll(name string, perm fs.FileMode) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	current := path.Clean(name)
+	missing := []string{}
+	for {
+		var info fs.FileInfo
+		if info, err = l.stat(current); err == nil {
+			if !info.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+			}
+			break
+		}
+		missing = append(missing, current)
+		if parent := path.Dir(current); parent != current {
+			current = parent
+		} else {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err = l.mkdir(missing[i], perm); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Open implements fs.FS
+func (l *LayerFS) Open(name string) (fs.File, error) {
+	if err := fsInvalid("open", name); err != nil {
+		return nil, err
+	}
+	return l.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile implements FS.OpenFile copying the file up first when opened for writing.
+// Directories opened for reading list the merged entries of both layers.
+func (l *LayerFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.openFile(name, flag, perm)
+}
+
+// ReadDir implements fs.ReadDirFS merging the entries of both layers sorted by name
+func (l *LayerFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := fsInvalid("readdirent", name); err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.readDir(name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (l *LayerFS) ReadFile(name string) (data []byte, err error) {
+	if err = fsInvalid("open", name); err != nil {
+		return
+	}
+	var file File
+	if file, err = l.OpenFile(name, os.O_RDONLY, 0); err != nil {
+		return
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Readlink implements FS.Readlink
+func (l *LayerFS) Readlink(name string) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.layer(name).Readlink(name)
+}
+
+// Remove implements FS.Remove recording a whiteout for paths in the lower layer
+func (l *LayerFS) Remove(name string) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var info fs.FileInfo
+	if info, err = l.lstat(name); err != nil {
+		return
+	}
+	if info.IsDir() {
+		var entries []fs.DirEntry
+		if entries, err = l.readDir(name); err != nil {
+			return
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	if _, e := l.upper.Lstat(name); e == nil {
+		if err = l.upper.Remove(name); err != nil {
+			return
+		}
+	}
+	l.whiteout(name)
+	return
+}
+
+// RemoveAll implements FS.RemoveAll recording a whiteout for paths in the lower layer
+func (l *LayerFS) RemoveAll(name string) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.removeAll(name)
+}
+
+// Rename implements FS.Rename. Paths only in the upper layer are renamed in place while paths
+// from the lower layer are copied to the new name and removed.
+func (l *LayerFS) Rename(oldname, newname string) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err = l.lstat(oldname); err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if path.Clean(oldname) == path.Clean(newname) {
+		return
+	}
+	if info, e := l.lstat(newname); e == nil {
+		if info.IsDir() {
+			return &fs.PathError{Op: "rename", Path: newname, Err: syscall.EEXIST}
+		}
+		if err = l.removeAll(newname); err != nil {
+			return
+		}
+	}
+	if err = l.copyUp("rename", path.Dir(newname)); err != nil {
+		return
+	}
+
+	// Paths only in the upper layer can simply be renamed there
+	if _, e := l.upper.Lstat(oldname); e == nil && !l.inLower(oldname) {
+		if err = l.upper.Rename(oldname, newname); err == nil {
+			l.created(newname)
+		}
+		return
+	}
+	if err = l.copyTree(oldname, newname); err != nil {
+		return
+	}
+	return l.removeAll(oldname)
+}
+
+// Stat implements fs.StatFS
+func (l *LayerFS) Stat(name string) (fs.FileInfo, error) {
+	if err := fsInvalid("stat", name); err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stat(name)
+}
+
+// Symlink implements FS.Symlink
+func (l *LayerFS) Symlink(oldname, newname string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.symlink(oldname, newname)
+}
+
+// WriteFile implements FS.WriteFile
+func (l *LayerFS) WriteFile(name string, data []byte, perm fs.FileMode) (err error) {
+	var file File
+	if file, err = l.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm); err != nil {
+		return
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return
+	}
+	return file.Close()
+}
+
+// hidden returns true if the given path in the lower layer is hidden by a whiteout of itself
+// or a parent or by an opaque parent directory
+func (l *LayerFS) hidden(name string) bool {
+	name = path.Clean("/" + name)
+	for p := name; ; p = path.Dir(p) {
+		if l.whiteouts[p] || (p != name && l.opaque[p]) {
+			return true
+		}
+		if path.Dir(p) == p {
+			return false
+		}
+	}
+}
+
+// inLower returns true if the given path exists in the merged view of the lower layer
+func (l *LayerFS) inLower(name string) bool {
+	if l.hidden(name) {
+		return false
+	}
+	_, err := l.lower.Lstat(name)
+	return err == nil
+}
+
+// layer returns the layer the given path is read from
+func (l *LayerFS) layer(name string) FS {
+	if _, err := l.upper.Lstat(name); err == nil || l.hidden(name) {
+		return l.upper
+	}
+	return l.lower
+}
+
+// created clears the whiteout for a newly created path marking it opaque so that any lower
+// entries under the old path stay hidden
+func (l *LayerFS) created(name string) {
+	name = path.Clean("/" + name)
+	if l.whiteouts[name] {
+		delete(l.whiteouts, name)
+		l.opaque[name] = true
+	}
+}
+
+// whiteout records the given removed path as hidden if it exists in the lower layer
+func (l *LayerFS) whiteout(name string) {
+	name = path.Clean("/" + name)
+	if l.inLower(name) {
+		l.whiteouts[name] = true
+	}
+	delete(l.opaque, name)
+}
+
+// copyUp copies the given path and its parent directories from the lower layer to the upper
+// layer if they don't already exist there. File content is copied and times are preserved.
+func (l *LayerFS) copyUp(op, name string) (err error) {
+	if _, err = l.upper.Lstat(name); err == nil {
+		return
+	}
+	if l.hidden(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	var info fs.FileInfo
+	if info, err = l.lower.Lstat(name); err != nil {
+		return
+	}
+	parent := path.Dir(name)
+	if parent != name {
+		if err = l.copyUp(op, parent); err != nil {
+			return
+		}
+	}
+
+	err = l.writable(parent, func() (e error) {
+		switch {
+		case info.IsDir():
+			return l.upper.Mkdir(name, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			var target string
+			if target, e = l.lower.Readlink(name); e != nil {
+				return
+			}
+			return l.upper.Symlink(target, name)
+		default:
+			var data []byte
+			if data, e = l.lower.ReadFile(fsName(name)); e != nil {
+				return
+			}
+			return l.upper.WriteFile(name, data, info.Mode().Perm())
+		}
+	})
+	if err == nil && info.Mode()&fs.ModeSymlink == 0 {
+		err = l.upper.Chtimes(name, info.ModTime(), info.ModTime())
+	}
+	return
+}
+
+// writable runs the given function with the owner write permission temporarily granted on
+// the given upper directory so copying up isn't blocked by the directory's permissions
+func (l *LayerFS) writable(dir string, fn func() error) (err error) {
+	var info fs.FileInfo
+	if info, err = l.upper.Stat(fsName(dir)); err != nil || info.Mode().Perm()&0200 != 0 {
+		return fn()
+	}
+	if err = l.upper.Chmod(dir, info.Mode().Perm()|0200); err != nil {
+		return
+	}
+	err = fn()
+	if e := l.upper.Chmod(dir, info.Mode().Perm()); err == nil {
+		err = e
+	}
+	return
+}
+
+// copyTree copies the merged tree at oldname to newname in the upper layer
+func (l *LayerFS) copyTree(oldname, newname string) (err error) {
+	var info fs.FileInfo
+	if info, err = l.lstat(oldname); err != nil {
+		return
+	}
+	switch {
+	case info.IsDir():
+		if err = l.mkdir(newname, info.Mode().Perm()|0700); err != nil {
+			return
+		}
+		var entries []fs.DirEntry
+		if entries, err = l.readDir(oldname); err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if err = l.copyTree(path.Join(oldname, entry.Name()), path.Join(newname, entry.Name())); err != nil {
+				return
+			}
+		}
+		err = l.upper.Chmod(newname, info.Mode().Perm())
+	case info.Mode()&fs.ModeSymlink != 0:
+		var target string
+		if target, err = l.layer(oldname).Readlink(oldname); err != nil {
+			return
+		}
+		err = l.symlink(target, newname)
+	default:
+		var data []byte
+		if data, err = l.layer(oldname).ReadFile(fsName(oldname)); err != nil {
+			return
+		}
+		var file File
+		if file, err = l.openFile(newname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm()); err != nil {
+			return
+		}
+		if _, err = file.Write(data); err != nil {
+			file.Close()
+			return
+		}
+		err = file.Close()
+	}
+	if err == nil && info.Mode()&fs.ModeSymlink == 0 {
+		err = l.upper.Chtimes(newname, info.ModTime(), info.ModTime())
+	}
+	return
+}
+
+// lstat returns the info of the given path from the layer it is read from
+func (l *LayerFS) lstat(name string) (fs.FileInfo, error) {
+	if info, err := l.upper.Lstat(name); err == nil || l.hidden(name) {
+		return info, err
+	}
+	return l.lower.Lstat(name)
+}
+
+// stat returns the info of the given path following links
+func (l *LayerFS) stat(name string) (fs.FileInfo, error) {
+	if _, err := l.upper.Lstat(name); err == nil || l.hidden(name) {
+		return l.upper.Stat(fsName(name))
+	}
+	return l.lower.Stat(fsName(name))
+}
+
+// mkdir creates the given directory in the upper layer copying up its parents
+func (l *LayerFS) mkdir(name string, perm fs.FileMode) (err error) {
+	if _, e := l.lstat(name); e == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err = l.copyUp("mkdir", path.Dir(name)); err != nil {
+		return
+	}
+	if err = l.upper.Mkdir(name, perm); err == nil {
+		l.created(name)
+	}
+	return
+}
+
+// openFile opens the given path copying it up first if opened for writing
+func (l *LayerFS) openFile(name string, flag int, perm fs.FileMode) (file File, err error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		var info fs.FileInfo
+		if info, err = l.stat(name); err != nil {
+			return
+		}
+		if info.IsDir() {
+			var entries []fs.DirEntry
+			if entries, err = l.readDir(name); err != nil {
+				return
+			}
+			return &layerDir{name: name, info: info, entries: entries}, nil
+		}
+		return l.layer(name).OpenFile(name, flag, perm)
+	}
+
+	if _, err = l.lstat(name); err == nil {
+		err = l.copyUp("open", name)
+	} else if flag&os.O_CREATE != 0 && os.IsNotExist(err) {
+		err = l.copyUp("open", path.Dir(name))
+	}
+	if err != nil {
+		return
+	}
+	if file, err = l.upper.OpenFile(name, flag, perm); err == nil {
+		l.created(name)
+	}
+	return
+}
+
+// readDir merges the entries of the given directory from both layers
+func (l *LayerFS) readDir(name string) (entries []fs.DirEntry, err error) {
+	var info fs.FileInfo
+	if info, err = l.stat(name); err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	merged := map[string]fs.DirEntry{}
+	_, e := l.upper.Lstat(name)
+	inUpper := e == nil
+	if lowerEntries, e := l.lower.ReadDir(fsName(name)); e == nil {
+		for _, entry := range lowerEntries {
+			if !l.hidden(path.Join(name, entry.Name())) {
+				merged[entry.Name()] = entry
+			}
+		}
+	} else if !inUpper {
+		return nil, e
+	}
+	if inUpper {
+		var upperEntries []fs.DirEntry
+		if upperEntries, err = l.upper.ReadDir(fsName(name)); err != nil {
+			return
+		}
+		for _, entry := range upperEntries {
+			merged[entry.Name()] = entry
+		}
+	}
+
+	entries = make([]fs.DirEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return
+}
+
+// removeAll removes the given path from the upper layer and hides it in the lower layer
+func (l *LayerFS) removeAll(name string) (err error) {
+	if _, e := l.upper.Lstat(name); e == nil {
+		if err = l.upper.RemoveAll(name); err != nil {
+			return
+		}
+	}
+	l.whiteout(name)
+	return
+}
+
+// symlink creates the given link in the upper layer copying up its parents
+func (l *LayerFS) symlink(oldname, newname string) (err error) {
+	if _, e := l.lstat(newname); e == nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	if err = l.copyUp("symlink", path.Dir(newname)); err != nil {
+		return
+	}
+	if err = l.upper.Symlink(oldname, newname); err == nil {
+		l.created(newname)
+	}
+	return
+}
+
+// layerDir implements File and fs.ReadDirFile for merged LayerFS directories
+type layerDir struct {
+	name    string        // name the directory was opened with
+	info    fs.FileInfo   // directory's info
+	entries []fs.DirEntry // remaining merged entries
+}
+
+// Close implements fs.File
+func (d *layerDir) Close() error {
+	return nil
+}
+
+// Read implements fs.File failing as directories can't be read
+func (d *layerDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *layerDir) ReadDir(count int) (entries []fs.DirEntry, err error) {
+	if count <= 0 {
+		entries, d.entries = d.entries, nil
+		return
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries, d.entries = d.entries[:count], d.entries[count:]
+	return
+}
+
+// Seek implements io.Seeker
+func (d *layerDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+// Stat implements fs.File
+func (d *layerDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Write implements io.Writer failing as directories can't be written
+func (d *layerDir) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
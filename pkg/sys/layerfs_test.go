@@ -0,0 +1,126 @@
+package sys
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// layerTest creates a new lower filesystem with a few files and a layer over it
+func layerTest(t *testing.T) (lower, upper *MemFS, layer *LayerFS) {
+	lower, upper = NewMemFS(), NewMemFS()
+	assert.Nil(t, lower.MkdirAll("/dir/sub", 0755))
+	assert.Nil(t, lower.WriteFile("/dir/file", []byte("lower"), 0644))
+	assert.Nil(t, lower.WriteFile("/dir/sub/deep", []byte("deep"), 0644))
+	assert.Nil(t, lower.Symlink("file", "/dir/link"))
+	return lower, upper, NewLayerFS(lower, upper)
+}
+
+// layerNames returns the names of the entries of the given directory
+func layerNames(t *testing.T, fsys FS, dir string) (names []string) {
+	names = []string{}
+	entries, err := fsys.ReadDir(fsName(dir))
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return
+}
+
+func TestLayerFS(t *testing.T) {
+
+	// conforms to io/fs
+	{
+		_, _, layer := layerTest(t)
+		assert.Nil(t, layer.WriteFile("/dir/new", []byte("new"), 0644))
+		assert.Nil(t, layer.Remove("/dir/sub/deep"))
+		assert.Nil(t, layer.Remove("/dir/link"))
+		assert.Nil(t, fstest.TestFS(layer, "dir/file", "dir/new", "dir/sub"))
+		_, err := layer.ReadDir("dir/../dir")
+		assert.ErrorIs(t, err, fs.ErrInvalid)
+	}
+
+	// reads fall through to the lower layer
+	{
+		_, upper, layer := layerTest(t)
+		data, err := layer.ReadFile("dir/link")
+		assert.Nil(t, err)
+		assert.Equal(t, "lower", string(data))
+		assert.Equal(t, []string{"file", "link", "sub"}, layerNames(t, layer, "/dir"))
+		assert.Equal(t, []string{}, layerNames(t, upper, "/"))
+	}
+
+	// writes copy up leaving the lower layer untouched
+	{
+		lower, upper, layer := layerTest(t)
+		assert.Nil(t, layer.WriteFile("/dir/file", []byte("upper"), 0644))
+		assert.Nil(t, layer.WriteFile("/dir/new", []byte("new"), 0644))
+		assert.Nil(t, layer.Chmod("/dir/sub/deep", 0600))
+
+		data, _ := layer.ReadFile("dir/file")
+		assert.Equal(t, "upper", string(data))
+		data, _ = lower.ReadFile("dir/file")
+		assert.Equal(t, "lower", string(data))
+		info, _ := layer.Stat("dir/sub/deep")
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		info, _ = lower.Stat("dir/sub/deep")
+		assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+		assert.Equal(t, []string{"file", "link", "new", "sub"}, layerNames(t, layer, "/dir"))
+		assert.Equal(t, []string{"file", "new", "sub"}, layerNames(t, upper, "/dir"))
+		assert.Equal(t, []string{"file", "link", "sub"}, layerNames(t, lower, "/dir"))
+	}
+
+	// removals hide the lower layer until recreated
+	{
+		lower, _, layer := layerTest(t)
+		assert.ErrorIs(t, layer.Remove("/dir/sub"), syscall.ENOTEMPTY)
+		assert.Nil(t, layer.Remove("/dir/link"))
+		assert.Nil(t, layer.RemoveAll("/dir/sub"))
+		assert.Equal(t, []string{"file"}, layerNames(t, layer, "/dir"))
+		_, err := layer.Stat("dir/sub/deep")
+		assert.True(t, os.IsNotExist(err))
+		assert.True(t, ExistsFS(lower, "/dir/sub/deep"))
+
+		assert.Nil(t, layer.Mkdir("/dir/sub", 0755))
+		assert.Equal(t, []string{}, layerNames(t, layer, "/dir/sub"))
+		_, err = layer.Stat("dir/sub/deep")
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// renames copy lower paths up and hide the old name
+	{
+		lower, _, layer := layerTest(t)
+		assert.Nil(t, layer.Rename("/dir/sub", "/moved"))
+		data, err := layer.ReadFile("moved/deep")
+		assert.Nil(t, err)
+		assert.Equal(t, "deep", string(data))
+		assert.Equal(t, []string{"file", "link"}, layerNames(t, layer, "/dir"))
+		assert.True(t, ExistsFS(lower, "/dir/sub/deep"))
+
+		assert.Nil(t, layer.Rename("/moved", "/again"))
+		assert.Equal(t, []string{"again", "dir"}, layerNames(t, layer, "/"))
+	}
+
+	// read only lower directories don't block copying up
+	{
+		lower, _, layer := layerTest(t)
+		assert.Nil(t, lower.Chmod("/dir/sub", 0555))
+		assert.Nil(t, layer.WriteFile("/dir/sub/deep", []byte("changed"), 0644))
+		data, _ := layer.ReadFile("dir/sub/deep")
+		assert.Equal(t, "changed", string(data))
+		assert.True(t, os.IsPermission(layer.WriteFile("/dir/sub/new", []byte("new"), 0644)))
+	}
+
+	// works with io/fs
+	{
+		_, _, layer := layerTest(t)
+		matches, err := fs.Glob(layer, "dir/*")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"dir/file", "dir/link", "dir/sub"}, matches)
+	}
+}
@@ -0,0 +1,263 @@
+package sys
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ErrLocked indicates that a lock is held by someone else and couldn't be acquired
+var ErrLocked = errors.New("resource is locked")
+
+// FileLock provides an advisory lock on a file shared with other processes
+type FileLock struct {
+	path   string   // path of the locked file
+	file   *os.File // open file the lock is held on
+	shared bool     // true if the lock is shared
+	fcntl  bool     // true if the lock is a fcntl record lock rather than a flock
+}
+
+// Lock acquires an advisory lock on the given path creating the file if needed. The lock is
+// exclusive by default and blocks until acquired. The lock file isn't removed on Unlock as
+// doing so would allow another process to lock a new file of the same name at the same time.
+// * Acquire a shared lock by passing in SharedOpt(true)
+// * Fail immediately with ErrLocked rather than waiting by passing in TryOpt(true) which takes precedence over TimeoutOpt
+// * Fail with ErrLocked after the given duration by passing in TimeoutOpt(duration)
+// * Use fcntl record locks rather than flock by passing in FcntlOpt(true). These work over NFS but are per process so don't exclude other locks in the same process.
+func Lock(target string, opts ...*opt.Opt) (lock *FileLock, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+
+	var file *os.File
+	if file, err = os.OpenFile(target, os.O_CREATE|os.O_RDWR, 0644); err != nil {
+		err = errors.Wrapf(err, "failed opening lock file %s", target)
+		return
+	}
+	lock = &FileLock{path: target, file: file, shared: getSharedOpt(opts), fcntl: getFcntlOpt(opts)}
+
+	// Block until locked or poll until the timeout expires
+	timeout, try := getTimeoutOpt(opts), getTryOpt(opts)
+	if try || timeout > 0 {
+		deadline := time.Now().Add(timeout)
+		wait := 5 * time.Millisecond
+		for {
+			if err = lock.lock(false); err != unix.EWOULDBLOCK && err != unix.EAGAIN && err != unix.EACCES {
+				break
+			}
+			if try {
+				err = errors.Wrapf(ErrLocked, "failed to lock %s", target)
+				break
+			}
+			if time.Now().After(deadline) {
+				err = errors.Wrapf(ErrLocked, "timed out after %v waiting to lock %s", timeout, target)
+				break
+			}
+			time.Sleep(wait)
+			if wait < 100*time.Millisecond {
+				wait *= 2
+			}
+		}
+	} else {
+		err = lock.lock(true)
+	}
+
+	if err != nil {
+		if errors.Cause(err) != ErrLocked {
+			err = errors.Wrapf(err, "failed to lock %s", target)
+		}
+		file.Close()
+		lock = nil
+	}
+	return
+}
+
+// Path returns the path of the locked file
+func (l *FileLock) Path() string {
+	return l.path
+}
+
+// Shared returns true if the lock is a shared lock
+func (l *FileLock) Shared() bool {
+	return l.shared
+}
+
+// Unlock releases the lock and closes the lock file
+func (l *FileLock) Unlock() (err error) {
+	if l.file == nil {
+		return
+	}
+	if l.fcntl {
+		err = unix.FcntlFlock(l.file.Fd(), unix.F_SETLK, &unix.Flock_t{Type: unix.F_UNLCK})
+	} else {
+		err = unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to unlock %s", l.path)
+	}
+	if e := l.file.Close(); e != nil && err == nil {
+		err = errors.Wrapf(e, "failed to close lock file %s", l.path)
+	}
+	l.file = nil
+	return
+}
+
+// lock acquires the lock either blocking or failing with EWOULDBLOCK
+func (l *FileLock) lock(block bool) (err error) {
+	if l.fcntl {
+		flock := &unix.Flock_t{Type: unix.F_WRLCK}
+		if l.shared {
+			flock.Type = unix.F_RDLCK
+		}
+		cmd := unix.F_SETLK
+		if block {
+			cmd = unix.F_SETLKW
+		}
+		for {
+			if err = unix.FcntlFlock(l.file.Fd(), cmd, flock); err != unix.EINTR {
+				return
+			}
+		}
+	}
+
+	how := unix.LOCK_EX
+	if l.shared {
+		how = unix.LOCK_SH
+	}
+	if !block {
+		how |= unix.LOCK_NB
+	}
+	for {
+		if err = unix.Flock(int(l.file.Fd()), how); err != unix.EINTR {
+			return
+		}
+	}
+}
+
+// PidFile provides a pid file to ensure only a single instance of a process runs at a time.
+// The pid file is locked with flock while held so that a pid file left behind by a crashed
+// process is detected as stale even if its PID has since been reused.
+type PidFile struct {
+	path string    // path of the pid file
+	lock *FileLock // lock held while the pid file is owned
+}
+
+// NewPidFile creates a new pid file for the given path without acquiring it
+func NewPidFile(target string) *PidFile {
+	return &PidFile{path: target}
+}
+
+// Acquire writes the current process's PID to the pid file replacing any stale pid file.
+// Returns an error wrapping ErrLocked if the pid file is held by another running process.
+func (p *PidFile) Acquire() (err error) {
+	if p.lock != nil {
+		return
+	}
+	var lock *FileLock
+	for {
+		if lock, err = Lock(p.path, TryOpt(true)); err != nil {
+			if errors.Cause(err) == ErrLocked {
+				if pid, e := p.Pid(); e == nil {
+					err = errors.Wrapf(ErrLocked, "process %d already owns pid file %s", pid, p.path)
+				}
+			}
+			return
+		}
+
+		// Retry if the file we locked was removed by its previous owner's Release in the meantime
+		var locked, current unix.Stat_t
+		if unix.Fstat(int(lock.file.Fd()), &locked) == nil && unix.Stat(lock.path, &current) == nil &&
+			locked.Dev == current.Dev && locked.Ino == current.Ino {
+			break
+		}
+		lock.Unlock()
+	}
+
+	// Holding the lock means any existing content is stale so replace it
+	if err = lock.file.Truncate(0); err == nil {
+		if _, err = lock.file.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0); err == nil {
+			err = lock.file.Sync()
+		}
+	}
+	if err != nil {
+		lock.Unlock()
+		err = errors.Wrapf(err, "failed writing pid file %s", p.path)
+		return
+	}
+	p.lock = lock
+	return
+}
+
+// Path returns the path of the pid file
+func (p *PidFile) Path() string {
+	return p.path
+}
+
+// Pid returns the PID stored in the pid file
+func (p *PidFile) Pid() (pid int, err error) {
+	var data string
+	if data, err = ReadString(p.path); err != nil {
+		return
+	}
+	if pid, err = strconv.Atoi(strings.TrimSpace(data)); err != nil || pid <= 0 {
+		err = errors.Errorf("invalid pid file %s", p.path)
+	}
+	return
+}
+
+// Release removes the pid file and releases its lock if held
+func (p *PidFile) Release() (err error) {
+	if p.lock == nil {
+		return
+	}
+
+	// Remove before unlocking so no other process sees our PID after we release
+	if err = os.Remove(p.lock.path); err != nil && !os.IsNotExist(err) {
+		err = errors.Wrapf(err, "failed removing pid file %s", p.path)
+	} else {
+		err = nil
+	}
+	if e := p.lock.Unlock(); e != nil && err == nil {
+		err = e
+	}
+	p.lock = nil
+	return
+}
+
+// Stale returns true if the pid file exists but isn't held by a running process i.e. it was
+// left behind by a process that crashed or was killed
+func (p *PidFile) Stale() bool {
+	if p.lock != nil || !Exists(p.path) {
+		return false
+	}
+
+	// A complete pid of a process that is gone is stale without checking the lock while an
+	// unreadable pid may just be in the middle of being written by Acquire
+	if pid, err := p.Pid(); err == nil && !ProcessRunning(pid) {
+		return true
+	}
+
+	// The lock is held while the pid is written and released when the owning process exits
+	// regardless of PID reuse
+	lock, err := Lock(p.path, TryOpt(true), SharedOpt(true))
+	if err != nil {
+		return false
+	}
+	lock.Unlock()
+	return true
+}
+
+// ProcessRunning returns true if a process with the given PID exists
+func ProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
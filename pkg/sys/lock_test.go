@@ -0,0 +1,166 @@
+package sys
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	resetTest()
+	target := path.Join(tmpDir, "lock")
+
+	// exclusive excludes others
+	{
+		lock, err := Lock(target)
+		assert.Nil(t, err)
+		assert.False(t, lock.Shared())
+		assert.Equal(t, "lock", path.Base(lock.Path()))
+
+		_, err = Lock(target, TryOpt(true))
+		assert.Equal(t, ErrLocked, errors.Cause(err))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to lock "))
+		_, err = Lock(target, TryOpt(true), SharedOpt(true))
+		assert.Equal(t, ErrLocked, errors.Cause(err))
+
+		assert.Nil(t, lock.Unlock())
+		assert.Nil(t, lock.Unlock())
+		assert.True(t, Exists(target))
+	}
+
+	// shared allows other shared locks
+	{
+		first, err := Lock(target, SharedOpt(true))
+		assert.Nil(t, err)
+		assert.True(t, first.Shared())
+		second, err := Lock(target, SharedOpt(true), TryOpt(true))
+		assert.Nil(t, err)
+		_, err = Lock(target, TryOpt(true))
+		assert.Equal(t, ErrLocked, errors.Cause(err))
+		assert.Nil(t, first.Unlock())
+		assert.Nil(t, second.Unlock())
+	}
+
+	// timeout
+	{
+		held, err := Lock(target)
+		assert.Nil(t, err)
+		start := time.Now()
+		_, err = Lock(target, TimeoutOpt(100*time.Millisecond))
+		assert.True(t, time.Since(start) >= 100*time.Millisecond)
+		assert.Equal(t, ErrLocked, errors.Cause(err))
+		assert.True(t, strings.HasPrefix(err.Error(), "timed out after 100ms waiting to lock "))
+
+		// try takes precedence over the timeout
+		start = time.Now()
+		_, err = Lock(target, TryOpt(true), TimeoutOpt(5*time.Second))
+		assert.True(t, time.Since(start) < time.Second)
+		assert.Equal(t, ErrLocked, errors.Cause(err))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to lock "))
+
+		// acquired once released
+		time.AfterFunc(50*time.Millisecond, func() { held.Unlock() })
+		lock, err := Lock(target, TimeoutOpt(5*time.Second))
+		assert.Nil(t, err)
+		assert.Nil(t, lock.Unlock())
+	}
+
+	// fcntl
+	{
+		lock, err := Lock(target, FcntlOpt(true))
+		assert.Nil(t, err)
+		other, err := Lock(target, TryOpt(true), FcntlOpt(true), SharedOpt(true))
+		assert.Nil(t, err)
+		assert.Nil(t, other.Unlock())
+		assert.Nil(t, lock.Unlock())
+	}
+
+	// failure
+	{
+		_, err := Lock(path.Join(tmpDir, "bogus", "lock"))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed opening lock file "))
+	}
+}
+
+func TestPidFile(t *testing.T) {
+	resetTest()
+	target := path.Join(tmpDir, "app.pid")
+
+	// acquire and contend
+	{
+		pidFile := NewPidFile(target)
+		assert.Equal(t, target, pidFile.Path())
+		assert.False(t, pidFile.Stale())
+		assert.Nil(t, pidFile.Acquire())
+		assert.Nil(t, pidFile.Acquire())
+		pid, err := pidFile.Pid()
+		assert.Nil(t, err)
+		assert.Equal(t, os.Getpid(), pid)
+		assert.False(t, pidFile.Stale())
+
+		other := NewPidFile(target)
+		assert.False(t, other.Stale())
+		err = other.Acquire()
+		assert.Equal(t, ErrLocked, errors.Cause(err))
+		assert.True(t, strings.HasPrefix(err.Error(), "process "))
+
+		assert.Nil(t, pidFile.Release())
+		assert.False(t, Exists(target))
+		assert.Nil(t, pidFile.Release())
+	}
+
+	// stale pid from a crashed process
+	{
+		cmd := exec.Command("true")
+		assert.Nil(t, cmd.Run())
+		assert.Nil(t, WriteString(target, strconv.Itoa(cmd.Process.Pid)+"\n"))
+
+		pidFile := NewPidFile(target)
+		assert.True(t, pidFile.Stale())
+		assert.Nil(t, pidFile.Acquire())
+		pid, err := pidFile.Pid()
+		assert.Nil(t, err)
+		assert.Equal(t, os.Getpid(), pid)
+		assert.Nil(t, pidFile.Release())
+	}
+
+	// stale pid reused by a running process
+	{
+		assert.Nil(t, WriteString(target, strconv.Itoa(os.Getppid())))
+		pidFile := NewPidFile(target)
+		assert.True(t, pidFile.Stale())
+		assert.Nil(t, pidFile.Acquire())
+		assert.Nil(t, pidFile.Release())
+	}
+
+	// pid not yet written by the lock holder isn't stale
+	{
+		assert.Nil(t, WriteString(target, ""))
+		held, err := Lock(target)
+		assert.Nil(t, err)
+		assert.False(t, NewPidFile(target).Stale())
+		assert.Nil(t, held.Unlock())
+		assert.True(t, NewPidFile(target).Stale())
+	}
+
+	// invalid
+	{
+		assert.Nil(t, WriteString(target, "foo"))
+		_, err := NewPidFile(target).Pid()
+		assert.Equal(t, "invalid pid file ../../test/temp/app.pid", err.Error())
+	}
+}
+
+func TestProcessRunning(t *testing.T) {
+	assert.True(t, ProcessRunning(os.Getpid()))
+	assert.True(t, ProcessRunning(1))
+	assert.False(t, ProcessRunning(0))
+	assert.False(t, ProcessRunning(-1))
+}
@@ -0,0 +1,581 @@
+package sys
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// gMemFSMaxLinks is the maximum number of links followed resolving a single path
+const gMemFSMaxLinks = 40
+
+// MemFS implements FS in memory for testing. Owner permission bits are enforced regardless of
+// the user running so that permission errors can be simulated and an optional capacity can be
+// set to simulate a full disk. Names are resolved relative to the filesystem's root.
+type MemFS struct {
+	mutex    sync.RWMutex // protects all nodes and usage
+	root     *memNode     // root directory
+	capacity int64        // maximum bytes of file data or 0 for unlimited
+	used     int64        // bytes of file data currently stored
+}
+
+// memNode provides a single file, directory or link in a MemFS
+type memNode struct {
+	name     string              // base name of the node
+	mode     fs.FileMode         // type and permission bits
+	modTime  time.Time           // modification time
+	data     []byte              // file content
+	target   string              // link target
+	children map[string]*memNode // directory entries
+}
+
+// NewMemFS creates a new empty in memory filesystem
+func NewMemFS() *MemFS {
+	return &MemFS{root: newMemNode("/", fs.ModeDir|0755)}
+}
+
+// newMemNode creates a new node with the given name and mode
+func newMemNode(name string, mode fs.FileMode) (node *memNode) {
+	node = &memNode{name: name, mode: mode, modTime: time.Now()}
+	if mode.IsDir() {
+		node.children = map[string]*memNode{}
+	}
+	return
+}
+
+// SetCapacity limits the total bytes of file data the filesystem can store. Writes that would
+// exceed it fail with syscall.ENOSPC. A capacity of 0 is unlimited.
+func (m *MemFS) SetCapacity(bytes int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.capacity = bytes
+}
+
+// Used returns the total bytes of file data stored
+func (m *MemFS) Used() int64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.used
+}
+
+// Chmod implements FS.Chmod
+func (m *MemFS) Chmod(name string, mode fs.FileMode) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var node *memNode
+	if node, _, err = m.resolve("chmod", name, true); err != nil {
+		return
+	}
+	node.mode = node.mode.Type() | mode.Perm()
+	return
+}
+
+// Chtimes implements FS.Chtimes
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var node *memNode
+	if node, _, err = m.resolve("chtimes", name, true); err != nil {
+		return
+	}
+	node.modTime = mtime
+	return
+}
+
+// Lstat implements FS.Lstat
+func (m *MemFS) Lstat(name string) (info fs.FileInfo, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var node *memNode
+	if node, _, err = m.resolve("lstat", name, false); err != nil {
+		return
+	}
+	return node.info(), nil
+}
+
+// Mkdir implements FS.Mkdir
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var dir *memNode
+	var base string
+	if dir, base, err = m.parent("mkdir", name); err != nil {
+		return
+	}
+	if _, ok := dir.children[base]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	dir.add(newMemNode(base, fs.ModeDir|perm.Perm()))
+	return
+}
+
+// MkdirAll implements FS.MkdirAll
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) (err error) {
+	current := "/"
+	for _, segment := range splitSegments(memClean(name)) {
+		current = path.Join(current, segment)
+		var info fs.FileInfo
+		if info, err = m.Stat(fsName(current)); err == nil {
+			if !info.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		if err = m.Mkdir(current, perm); err != nil && !os.IsExist(err) {
+			return
+		}
+	}
+	return nil
+}
+
+// Open implements fs.FS
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if err := fsInvalid("open", name); err != nil {
+		return nil, err
+	}
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile implements FS.OpenFile
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (file File, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var node *memNode
+	created := false
+	if node, _, err = m.resolve("open", name, true); err != nil {
+		if flag&os.O_CREATE == 0 || !os.IsNotExist(err) {
+			return
+		}
+		created = true
+		var dir *memNode
+		var base string
+		if dir, base, err = m.parent("open", name); err != nil {
+			return
+		}
+		if _, ok := dir.children[base]; ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		}
+		node = newMemNode(base, perm.Perm())
+		dir.add(node)
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	read := flag&os.O_WRONLY == 0
+	switch {
+	case node.mode.IsDir() && write:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case created:
+	case read && node.mode.Perm()&0400 == 0, write && node.mode.Perm()&0200 == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	if write && flag&os.O_TRUNC != 0 {
+		m.used -= int64(len(node.data))
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	return &memFile{fs: m, node: node, name: name, read: read, write: write, append: flag&os.O_APPEND != 0}, nil
+}
+
+// ReadDir implements fs.ReadDirFS returning the entries sorted by name
+func (m *MemFS) ReadDir(name string) (entries []fs.DirEntry, err error) {
+	if err = fsInvalid("readdirent", name); err != nil {
+		return
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var node *memNode
+	if node, _, err = m.resolve("readdirent", name, true); err != nil {
+		return
+	}
+	return node.entries("readdirent", name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (m *MemFS) ReadFile(name string) (data []byte, err error) {
+	if err = fsInvalid("open", name); err != nil {
+		return
+	}
+	var file File
+	if file, err = m.OpenFile(name, os.O_RDONLY, 0); err != nil {
+		return
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Readlink implements FS.Readlink
+func (m *MemFS) Readlink(name string) (target string, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var node *memNode
+	if node, _, err = m.resolve("readlink", name, false); err != nil {
+		return
+	}
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return node.target, nil
+}
+
+// Remove implements FS.Remove
+func (m *MemFS) Remove(name string) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var dir *memNode
+	var base string
+	if dir, base, err = m.parent("remove", name); err != nil {
+		return
+	}
+	node, ok := dir.children[base]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() && len(node.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	m.used -= int64(len(node.data))
+	dir.remove(base)
+	return
+}
+
+// RemoveAll implements FS.RemoveAll
+func (m *MemFS) RemoveAll(name string) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var dir *memNode
+	var base string
+	if dir, base, err = m.parent("remove", name); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if node, ok := dir.children[base]; ok {
+		m.used -= node.size()
+		dir.remove(base)
+	}
+	return
+}
+
+// Rename implements FS.Rename
+func (m *MemFS) Rename(oldname, newname string) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var oldDir, newDir *memNode
+	var oldBase, newBase string
+	if oldDir, oldBase, err = m.parent("rename", oldname); err != nil {
+		return
+	}
+	if newDir, newBase, err = m.parent("rename", newname); err != nil {
+		return
+	}
+	node, ok := oldDir.children[oldBase]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if existing, ok := newDir.children[newBase]; ok {
+		if existing == node {
+			return
+		}
+		if existing.mode.IsDir() {
+			return &fs.PathError{Op: "rename", Path: newname, Err: syscall.EEXIST}
+		}
+		m.used -= existing.size()
+	}
+	oldDir.remove(oldBase)
+	node.name = newBase
+	newDir.add(node)
+	return
+}
+
+// Stat implements fs.StatFS
+func (m *MemFS) Stat(name string) (info fs.FileInfo, err error) {
+	if err = fsInvalid("stat", name); err != nil {
+		return
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var node *memNode
+	if node, _, err = m.resolve("stat", name, true); err != nil {
+		return
+	}
+	return node.info(), nil
+}
+
+// Symlink implements FS.Symlink
+func (m *MemFS) Symlink(oldname, newname string) (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var dir *memNode
+	var base string
+	if dir, base, err = m.parent("symlink", newname); err != nil {
+		return
+	}
+	if _, ok := dir.children[base]; ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	node := newMemNode(base, fs.ModeSymlink|0777)
+	node.target = oldname
+	dir.add(node)
+	return
+}
+
+// WriteFile implements FS.WriteFile
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) (err error) {
+	var file File
+	if file, err = m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm); err != nil {
+		return
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return
+	}
+	return file.Close()
+}
+
+// memClean returns the given name as a clean absolute path
+func memClean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// resolve returns the node for the given name and its clean path following links in the
+// parent directories and the final element if follow is set. Must be called with the lock held.
+func (m *MemFS) resolve(op, name string, follow bool) (node *memNode, full string, err error) {
+	segments := splitSegments(memClean(name))
+	for hops := 0; hops <= gMemFSMaxLinks; hops++ {
+		node, full = m.root, "/"
+		restart := false
+		for i, segment := range segments {
+			if !node.mode.IsDir() {
+				return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+			}
+			if node.mode.Perm()&0100 == 0 {
+				return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+			}
+			child, ok := node.children[segment]
+			if !ok {
+				return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			if child.mode&fs.ModeSymlink != 0 && (follow || i < len(segments)-1) {
+				target := child.target
+				if !path.IsAbs(target) {
+					target = path.Join(full, target)
+				}
+				segments = splitSegments(path.Join(append([]string{target}, segments[i+1:]...)...))
+				restart = true
+				break
+			}
+			node, full = child, path.Join(full, segment)
+		}
+		if !restart {
+			return
+		}
+	}
+	return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+}
+
+// parent returns the writable directory node that contains the given name along with the
+// name's base. Must be called with the lock held.
+func (m *MemFS) parent(op, name string) (dir *memNode, base string, err error) {
+	clean := memClean(name)
+	if clean == "/" {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.EBUSY}
+	}
+	if dir, _, err = m.resolve(op, path.Dir(clean), true); err != nil {
+		return
+	}
+	if !dir.mode.IsDir() {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	if dir.mode.Perm()&0200 == 0 {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return dir, path.Base(clean), nil
+}
+
+// add adds the given child to the directory
+func (n *memNode) add(child *memNode) {
+	n.children[child.name] = child
+	n.modTime = time.Now()
+}
+
+// remove removes the given child from the directory
+func (n *memNode) remove(name string) {
+	delete(n.children, name)
+	n.modTime = time.Now()
+}
+
+// size returns the bytes of file data stored by the node and all nodes under it
+func (n *memNode) size() (result int64) {
+	result = int64(len(n.data))
+	for _, child := range n.children {
+		result += child.size()
+	}
+	return
+}
+
+// entries returns the directory's entries sorted by name
+func (n *memNode) entries(op, name string) (entries []fs.DirEntry, err error) {
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	if n.mode.Perm()&0400 == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	entries = make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return
+}
+
+// info returns a snapshot of the node's file info
+func (n *memNode) info() fs.FileInfo {
+	size := int64(len(n.data))
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+	return &memInfo{name: n.name, size: size, mode: n.mode, modTime: n.modTime}
+}
+
+// memInfo implements fs.FileInfo for MemFS nodes
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() interface{}   { return nil }
+
+// memFile implements File and fs.ReadDirFile for MemFS nodes
+type memFile struct {
+	fs      *MemFS        // filesystem the file belongs to
+	node    *memNode      // node opened
+	name    string        // name the file was opened with
+	read    bool          // opened for reading
+	write   bool          // opened for writing
+	append  bool          // writes always go to the end of the file
+	offset  int64         // current read/write offset
+	entries []fs.DirEntry // remaining directory entries for ReadDir
+	listed  bool          // directory entries have been read
+	closed  bool          // file has been closed
+}
+
+// Close implements fs.File
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// Read implements fs.File
+func (f *memFile) Read(p []byte) (n int, err error) {
+	f.fs.mutex.RLock()
+	defer f.fs.mutex.RUnlock()
+	switch {
+	case f.closed:
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	case f.node.mode.IsDir():
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	case !f.read:
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	case f.offset >= int64(len(f.node.data)):
+		return 0, io.EOF
+	}
+	n = copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return
+}
+
+// ReadDir implements fs.ReadDirFile
+func (f *memFile) ReadDir(count int) (entries []fs.DirEntry, err error) {
+	if !f.listed {
+		f.fs.mutex.RLock()
+		f.entries, err = f.node.entries("readdirent", f.name)
+		f.fs.mutex.RUnlock()
+		if err != nil {
+			return
+		}
+		f.listed = true
+	}
+	if count <= 0 {
+		entries, f.entries = f.entries, nil
+		return
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries, f.entries = f.entries[:count], f.entries[count:]
+	return
+}
+
+// Seek implements io.Seeker
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mutex.RLock()
+	defer f.fs.mutex.RUnlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Stat implements fs.File
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mutex.RLock()
+	defer f.fs.mutex.RUnlock()
+	return f.node.info(), nil
+}
+
+// Write implements io.Writer failing with syscall.ENOSPC if the capacity would be exceeded
+func (f *memFile) Write(p []byte) (n int, err error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	switch {
+	case f.closed:
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	case !f.write:
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	end := f.offset + int64(len(p))
+	if grow := end - int64(len(f.node.data)); grow > 0 {
+		if f.fs.capacity > 0 && f.fs.used+grow > f.fs.capacity {
+			return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+		}
+		f.fs.used += grow
+		f.node.data = append(f.node.data, make([]byte, grow)...)
+	}
+	n = copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return
+}
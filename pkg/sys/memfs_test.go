@@ -0,0 +1,156 @@
+package sys
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemFS(t *testing.T) {
+
+	// conforms to io/fs
+	{
+		mem := NewMemFS()
+		assert.Nil(t, mem.MkdirAll("/dir/sub", 0755))
+		assert.Nil(t, mem.WriteFile("/dir/file", []byte("file"), 0644))
+		assert.Nil(t, mem.WriteFile("/dir/sub/other", []byte("other"), 0644))
+		paths := []string{}
+		assert.Nil(t, fs.WalkDir(mem, ".", func(p string, d fs.DirEntry, err error) error {
+			paths = append(paths, p)
+			return err
+		}))
+		assert.Equal(t, []string{".", "dir", "dir/file", "dir/sub", "dir/sub/other"}, paths)
+		matches, err := fs.Glob(mem, "dir/*")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"dir/file", "dir/sub"}, matches)
+		data, err := fs.ReadFile(mem, "dir/sub/other")
+		assert.Nil(t, err)
+		assert.Equal(t, "other", string(data))
+		assert.Nil(t, fstest.TestFS(mem, "dir/file", "dir/sub/other"))
+		_, err = mem.Open("/dir/file")
+		assert.ErrorIs(t, err, fs.ErrInvalid)
+		_, err = mem.OpenFile("/dir/file", os.O_RDONLY, 0)
+		assert.Nil(t, err)
+	}
+
+	// read, write, append and seek
+	{
+		mem := NewMemFS()
+		assert.Nil(t, mem.WriteFile("file", []byte("hello"), 0644))
+		file, err := mem.OpenFile("/file", os.O_WRONLY|os.O_APPEND, 0)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(" world"))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+		data, err := mem.ReadFile("file")
+		assert.Nil(t, err)
+		assert.Equal(t, "hello world", string(data))
+
+		file, err = mem.OpenFile("/file", os.O_RDWR, 0)
+		assert.Nil(t, err)
+		_, err = file.Seek(6, io.SeekStart)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("there"))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+		data, _ = mem.ReadFile("file")
+		assert.Equal(t, "hello there", string(data))
+		assert.Equal(t, int64(11), mem.Used())
+
+		_, err = mem.OpenFile("/file", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		assert.True(t, os.IsExist(err))
+	}
+
+	// links
+	{
+		mem := NewMemFS()
+		assert.Nil(t, mem.MkdirAll("/dir", 0755))
+		assert.Nil(t, mem.WriteFile("/dir/file", []byte("file"), 0644))
+		assert.Nil(t, mem.Symlink("dir", "/link"))
+		assert.Nil(t, mem.Symlink("/loop", "/loop"))
+
+		data, err := mem.ReadFile("link/file")
+		assert.Nil(t, err)
+		assert.Equal(t, "file", string(data))
+
+		info, err := mem.Lstat("/link")
+		assert.Nil(t, err)
+		assert.Equal(t, fs.ModeSymlink, info.Mode().Type())
+		info, err = mem.Stat("link")
+		assert.Nil(t, err)
+		assert.True(t, info.IsDir())
+
+		target, err := mem.Readlink("/link")
+		assert.Nil(t, err)
+		assert.Equal(t, "dir", target)
+
+		_, err = mem.Stat("loop")
+		assert.ErrorIs(t, err, syscall.ELOOP)
+	}
+
+	// remove and rename
+	{
+		mem := NewMemFS()
+		assert.Nil(t, mem.MkdirAll("/dir/sub", 0755))
+		assert.Nil(t, mem.WriteFile("/dir/sub/file", []byte("file"), 0644))
+		assert.ErrorIs(t, mem.Remove("/dir"), syscall.ENOTEMPTY)
+		assert.True(t, os.IsNotExist(mem.Remove("/bogus")))
+
+		assert.Nil(t, mem.Rename("/dir/sub", "/moved"))
+		data, err := mem.ReadFile("moved/file")
+		assert.Nil(t, err)
+		assert.Equal(t, "file", string(data))
+		_, err = mem.Stat("dir/sub")
+		assert.True(t, os.IsNotExist(err))
+
+		assert.Nil(t, mem.RemoveAll("/moved"))
+		assert.Nil(t, mem.RemoveAll("/moved"))
+		assert.Equal(t, int64(0), mem.Used())
+		entries, err := mem.ReadDir(".")
+		assert.Nil(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "dir", entries[0].Name())
+	}
+
+	// permission errors
+	{
+		mem := NewMemFS()
+		assert.Nil(t, mem.WriteFile("/file", []byte("file"), 0644))
+		assert.Nil(t, mem.Mkdir("/dir", 0755))
+
+		assert.Nil(t, mem.Chmod("/file", 0200))
+		_, err := mem.ReadFile("file")
+		assert.True(t, os.IsPermission(err))
+
+		assert.Nil(t, mem.Chmod("/file", 0444))
+		assert.True(t, os.IsPermission(mem.WriteFile("/file", []byte("data"), 0644)))
+
+		assert.Nil(t, mem.Chmod("/dir", 0555))
+		assert.True(t, os.IsPermission(mem.WriteFile("/dir/file", []byte("data"), 0644)))
+		assert.Nil(t, mem.Chmod("/dir", 0300))
+		_, err = mem.ReadDir("dir")
+		assert.True(t, os.IsPermission(err))
+		assert.Nil(t, mem.Chmod("/dir", 0600))
+		_, err = mem.Stat("dir/file")
+		assert.True(t, os.IsPermission(err))
+
+		// newly created read only files can still be written
+		assert.Nil(t, mem.WriteFile("/readonly", []byte("data"), 0444))
+	}
+
+	// full disk
+	{
+		mem := NewMemFS()
+		mem.SetCapacity(8)
+		assert.Nil(t, mem.WriteFile("/first", []byte("1234"), 0644))
+		err := mem.WriteFile("/second", []byte("123456"), 0644)
+		assert.ErrorIs(t, err, syscall.ENOSPC)
+		assert.Nil(t, mem.WriteFile("/first", []byte("12345678"), 0644))
+		assert.Equal(t, int64(8), mem.Used())
+	}
+}
@@ -1,6 +1,10 @@
 package sys
 
 import (
+	"os"
+	"runtime"
+	"time"
+
 	"github.com/phR0ze/n/pkg/opt"
 )
 
@@ -106,3 +110,345 @@ func getRootOpt(opts []*opt.Opt) (result bool) {
 	}
 	return
 }
+
+// BackupOpt creates a new backup option with the given value
+// -------------------------------------------------------------------------------------------------
+func BackupOpt(val bool) *opt.Opt {
+	return &opt.Opt{Key: "backup", Val: val}
+}
+
+// get the backup option from the options slice defaulting to false
+func getBackupOpt(opts []*opt.Opt) (result bool) {
+	if o := opt.Get(opts, "backup"); o != nil {
+		if val, ok := o.Val.(bool); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// ChrootOpt creates a new chroot option with the given directory to resolve paths within
+// -------------------------------------------------------------------------------------------------
+func ChrootOpt(dir string) *opt.Opt {
+	return &opt.Opt{Key: "chroot", Val: dir}
+}
+
+// get the chroot option from the options slice defaulting to empty
+func getChrootOpt(opts []*opt.Opt) (result string) {
+	if o := opt.Get(opts, "chroot"); o != nil {
+		if val, ok := o.Val.(string); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// CompareOpt creates a new compare option with the given value
+// -------------------------------------------------------------------------------------------------
+func CompareOpt(val Compare) *opt.Opt {
+	return &opt.Opt{Key: "compare", Val: val}
+}
+
+// get the compare option from the options slice defaulting to CompareNone
+func getCompareOpt(opts []*opt.Opt) (result Compare) {
+	if o := opt.Get(opts, "compare"); o != nil {
+		if val, ok := o.Val.(Compare); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// ConflictOpt creates a new conflict option with the given value
+// -------------------------------------------------------------------------------------------------
+func ConflictOpt(val Conflict) *opt.Opt {
+	return &opt.Opt{Key: "conflict", Val: val}
+}
+
+// get the conflict option from the options slice defaulting to ConflictOverwrite
+func getConflictOpt(opts []*opt.Opt) (result Conflict) {
+	if o := opt.Get(opts, "conflict"); o != nil {
+		if val, ok := o.Val.(Conflict); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// DebounceOpt creates a new debounce option with the given value
+// -------------------------------------------------------------------------------------------------
+func DebounceOpt(val time.Duration) *opt.Opt {
+	return &opt.Opt{Key: "debounce", Val: val}
+}
+
+// get the debounce option from the options slice defaulting to 0
+func getDebounceOpt(opts []*opt.Opt) (result time.Duration) {
+	if o := opt.Get(opts, "debounce"); o != nil {
+		if val, ok := o.Val.(time.Duration); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// ExcludeOpt creates a new exclude option with the given glob patterns
+// -------------------------------------------------------------------------------------------------
+func ExcludeOpt(patterns ...string) *opt.Opt {
+	return &opt.Opt{Key: "exclude", Val: patterns}
+}
+
+// get the exclude option from the options slice defaulting to nil
+func getExcludeOpt(opts []*opt.Opt) (result []string) {
+	if o := opt.Get(opts, "exclude"); o != nil {
+		if val, ok := o.Val.([]string); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// FcntlOpt creates a new fcntl option with the given value
+// -------------------------------------------------------------------------------------------------
+func FcntlOpt(val bool) *opt.Opt {
+	return &opt.Opt{Key: "fcntl", Val: val}
+}
+
+// get the fcntl option from the options slice defaulting to false
+func getFcntlOpt(opts []*opt.Opt) (result bool) {
+	if o := opt.Get(opts, "fcntl"); o != nil {
+		if val, ok := o.Val.(bool); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// FSOpt creates a new filesystem option with the given value
+// -------------------------------------------------------------------------------------------------
+func FSOpt(val FS) *opt.Opt {
+	return &opt.Opt{Key: "fs", Val: val}
+}
+
+// get the filesystem option from the options slice defaulting to a RootFS when given a
+// ChrootOpt and otherwise nil for the OS
+func getFSOpt(opts []*opt.Opt) (result FS) {
+	if o := opt.Get(opts, "fs"); o != nil {
+		if val, ok := o.Val.(FS); ok {
+			result = val
+		}
+	} else if dir := getChrootOpt(opts); dir != "" {
+		result = NewRootFS(dir)
+	}
+	return
+}
+
+// IgnoreFileOpt creates a new ignore file option with the given file name e.g. .gitignore
+// -------------------------------------------------------------------------------------------------
+func IgnoreFileOpt(name string) *opt.Opt {
+	return &opt.Opt{Key: "ignorefile", Val: name}
+}
+
+// get the ignore file option from the options slice defaulting to empty
+func getIgnoreFileOpt(opts []*opt.Opt) (result string) {
+	if o := opt.Get(opts, "ignorefile"); o != nil {
+		if val, ok := o.Val.(string); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// IncludeOpt creates a new include option with the given glob patterns
+// -------------------------------------------------------------------------------------------------
+func IncludeOpt(patterns ...string) *opt.Opt {
+	return &opt.Opt{Key: "include", Val: patterns}
+}
+
+// get the include option from the options slice defaulting to nil
+func getIncludeOpt(opts []*opt.Opt) (result []string) {
+	if o := opt.Get(opts, "include"); o != nil {
+		if val, ok := o.Val.([]string); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// ModeOpt creates a new mode option with the given value
+// -------------------------------------------------------------------------------------------------
+func ModeOpt(val os.FileMode) *opt.Opt {
+	return &opt.Opt{Key: "mode", Val: val}
+}
+
+// get the mode option from the options slice defaulting to 0644
+func getModeOpt(opts []*opt.Opt) (result os.FileMode) {
+	result = 0644
+	if o := opt.Get(opts, "mode"); o != nil {
+		if val, ok := o.Val.(os.FileMode); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// OrderedOpt creates a new ordered option with the given value
+// -------------------------------------------------------------------------------------------------
+func OrderedOpt(val bool) *opt.Opt {
+	return &opt.Opt{Key: "ordered", Val: val}
+}
+
+// get the ordered option from the options slice defaulting to false
+func getOrderedOpt(opts []*opt.Opt) (result bool) {
+	if o := opt.Get(opts, "ordered"); o != nil {
+		if val, ok := o.Val.(bool); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// PollOpt creates a new poll option with the given value
+// -------------------------------------------------------------------------------------------------
+func PollOpt(val time.Duration) *opt.Opt {
+	return &opt.Opt{Key: "poll", Val: val}
+}
+
+// get the poll option from the options slice defaulting to 0
+func getPollOpt(opts []*opt.Opt) (result time.Duration) {
+	if o := opt.Get(opts, "poll"); o != nil {
+		if val, ok := o.Val.(time.Duration); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// PreserveOpt creates a new preserve option with the given value
+// -------------------------------------------------------------------------------------------------
+func PreserveOpt(val Preserve) *opt.Opt {
+	return &opt.Opt{Key: "preserve", Val: val}
+}
+
+// get the preserve option from the options slice defaulting to PreserveMode
+func getPreserveOpt(opts []*opt.Opt) (result Preserve) {
+	result = PreserveMode
+	if o := opt.Get(opts, "preserve"); o != nil {
+		if val, ok := o.Val.(Preserve); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// ProgressOpt creates a new progress option with the given callback
+// -------------------------------------------------------------------------------------------------
+func ProgressOpt(val func(*CopyProgress)) *opt.Opt {
+	return &opt.Opt{Key: "progress", Val: val}
+}
+
+// get the progress option from the options slice defaulting to nil
+func getProgressOpt(opts []*opt.Opt) (result func(*CopyProgress)) {
+	if o := opt.Get(opts, "progress"); o != nil {
+		if val, ok := o.Val.(func(*CopyProgress)); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// SharedOpt creates a new shared option with the given value
+// -------------------------------------------------------------------------------------------------
+func SharedOpt(val bool) *opt.Opt {
+	return &opt.Opt{Key: "shared", Val: val}
+}
+
+// get the shared option from the options slice defaulting to false
+func getSharedOpt(opts []*opt.Opt) (result bool) {
+	if o := opt.Get(opts, "shared"); o != nil {
+		if val, ok := o.Val.(bool); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// SyncDirOpt creates a new sync dir option with the given value
+// -------------------------------------------------------------------------------------------------
+func SyncDirOpt(val bool) *opt.Opt {
+	return &opt.Opt{Key: "syncDir", Val: val}
+}
+
+// get the sync dir option from the options slice defaulting to false
+func getSyncDirOpt(opts []*opt.Opt) (result bool) {
+	if o := opt.Get(opts, "syncDir"); o != nil {
+		if val, ok := o.Val.(bool); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// TBOpt creates a new testing option with the given test or benchmark e.g. a *testing.T
+// -------------------------------------------------------------------------------------------------
+func TBOpt(tb TB) *opt.Opt {
+	return &opt.Opt{Key: "tb", Val: tb}
+}
+
+// get the testing option from the options slice defaulting to nil
+func getTBOpt(opts []*opt.Opt) (result TB) {
+	if o := opt.Get(opts, "tb"); o != nil {
+		if val, ok := o.Val.(TB); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// TimeoutOpt creates a new timeout option with the given value
+// -------------------------------------------------------------------------------------------------
+func TimeoutOpt(val time.Duration) *opt.Opt {
+	return &opt.Opt{Key: "timeout", Val: val}
+}
+
+// get the timeout option from the options slice defaulting to 0
+func getTimeoutOpt(opts []*opt.Opt) (result time.Duration) {
+	if o := opt.Get(opts, "timeout"); o != nil {
+		if val, ok := o.Val.(time.Duration); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// TryOpt creates a new try option with the given value
+// -------------------------------------------------------------------------------------------------
+func TryOpt(val bool) *opt.Opt {
+	return &opt.Opt{Key: "try", Val: val}
+}
+
+// get the try option from the options slice defaulting to false
+func getTryOpt(opts []*opt.Opt) (result bool) {
+	if o := opt.Get(opts, "try"); o != nil {
+		if val, ok := o.Val.(bool); ok {
+			result = val
+		}
+	}
+	return
+}
+
+// WorkersOpt creates a new workers option with the given value
+// -------------------------------------------------------------------------------------------------
+func WorkersOpt(val int) *opt.Opt {
+	return &opt.Opt{Key: "workers", Val: val}
+}
+
+// get the workers option from the options slice defaulting to the number of CPUs
+func getWorkersOpt(opts []*opt.Opt) (result int) {
+	result = runtime.NumCPU()
+	if o := opt.Get(opts, "workers"); o != nil {
+		if val, ok := o.Val.(int); ok && val > 0 {
+			result = val
+		}
+	}
+	return
+}
@@ -3,7 +3,6 @@ package sys
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
@@ -76,22 +75,26 @@ func ExecExists(target string) (ok bool) {
 }
 
 // ExecOut executes the given command and returns the output as a string.
+// The command is split into arguments with SplitWords so POSIX sh quoting is honored.
 func ExecOut(str string, a ...interface{}) (out string, err error) {
 
-	// Disable creation of .DS_Store ._* files on OSX during file copy
-	if Darwin() {
-		os.Setenv("COPYFILE_DISABLE", "1")
-	}
-
 	// Parse command
-	cmd := fmt.Sprintf(str, a...)
-	pieces := SplitCmd(cmd)
+	var pieces []string
+	if pieces, err = SplitWords(fmt.Sprintf(str, a...)); err != nil {
+		err = errors.Wrap(err, "failed to parse system command")
+		return
+	}
 	if len(pieces) == 0 {
 		err = errors.Errorf("invalid empty command")
 		return
 	}
 
 	p := exec.Command(pieces[0], pieces[1:]...)
+
+	// Disable creation of .DS_Store ._* files on OSX during file copy
+	if Darwin() {
+		p.Env = OSEnv().Set("COPYFILE_DISABLE", "1").Environ()
+	}
 	var output []byte
 	output, err = p.CombinedOutput()
 	out = string(output)
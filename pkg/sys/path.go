@@ -2,6 +2,7 @@ package sys
 
 import (
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
 	"sort"
@@ -11,8 +12,12 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Abs gets the absolute path, taking into account path expansion and protocols
-func Abs(target string) (result string, err error) {
+// Abs gets the absolute path, taking into account path expansion and protocols.
+// * Absolute paths are returned as is without expansion
+// * Only a leading ~ or $VAR style variable is expanded see Expand, any later $ is kept as is
+// Passing in ChrootOpt(dir) instead resolves the path within dir without expansion giving back
+// the OS path with all links but the last element resolved within dir see RootFS.
+func Abs(target string, opts ...*opt.Opt) (result string, err error) {
 
 	// Check for empty string
 	if target == "" {
@@ -20,6 +25,14 @@ func Abs(target string) (result string, err error) {
 		return
 	}
 
+	// Resolve within the chroot directory if given
+	if dir := getChrootOpt(opts); dir != "" {
+		if result, err = NewRootFS(dir).Resolve(target, false); err != nil {
+			err = errors.Wrapf(err, "failed to resolve %s within %s", target, dir)
+		}
+		return
+	}
+
 	// Bail out early if the path is rooted
 	if target[0] == '/' {
 		result = target
@@ -28,10 +41,14 @@ func Abs(target string) (result string, err error) {
 
 	// Trim protocols and expand
 	target = TrimProtocol(target)
-	if result, err = Expand(target); err != nil {
+	if result, err = expandPrefix(target); err != nil {
 		err = errors.Wrapf(err, "failed to expand the given path %s", target)
 		return
 	}
+	if result[0] == '/' {
+		result = filepath.Clean(result)
+		return
+	}
 
 	// Get the absolute path
 	if result, err = filepath.Abs(result); err != nil {
@@ -47,7 +64,8 @@ func Abs(target string) (result string, err error) {
 // included by passing the RootOpt(true).
 func AllDirs(root string, opts ...*opt.Opt) (result []string, err error) {
 	distinct := map[string]bool{}
-	if root, err = Abs(root); err != nil {
+	fsys := getFSOpt(opts)
+	if root, err = fsAbs(fsys, root); err != nil {
 		return
 	}
 
@@ -66,7 +84,7 @@ func AllDirs(root string, opts ...*opt.Opt) (result []string, err error) {
 
 		// IsDir will ignore files and links
 		if p != root && p != "." && p != ".." && i.IsDir() {
-			absPath, e := Abs(p)
+			absPath, e := fsAbs(fsys, p)
 			if e != nil {
 				return e
 			}
@@ -84,10 +102,12 @@ func AllDirs(root string, opts ...*opt.Opt) (result []string, err error) {
 
 // AllFiles returns a list of all files recursively for the given root path
 // in a deterministic order. Follows links by default, but can be stopped
-// by passing FollowOpt(false). Paths are distinct.
+// by passing FollowOpt(false). Paths are distinct. Skip paths ignored by
+// gitignore style files by passing in IgnoreFileOpt(".gitignore").
 func AllFiles(root string, opts ...*opt.Opt) (result []string, err error) {
 	distinct := map[string]bool{}
-	if root, err = Abs(root); err != nil {
+	fsys := getFSOpt(opts)
+	if root, err = fsAbs(fsys, root); err != nil {
 		return
 	}
 
@@ -101,7 +121,7 @@ func AllFiles(root string, opts ...*opt.Opt) (result []string, err error) {
 		// IsFile will ignore both directories and links to files/dirs when not following.
 		// When followOpt is set then Walk will return followed files.
 		if p != root && p != "." && p != ".." && i.IsFile() {
-			absPath, e := Abs(p)
+			absPath, e := fsAbs(fsys, p)
 			if e != nil {
 				return e
 			}
@@ -122,7 +142,8 @@ func AllFiles(root string, opts ...*opt.Opt) (result []string, err error) {
 // by default, but can be stopped by passing FollowOpt(false). Paths are distinct.
 func AllPaths(root string, opts ...*opt.Opt) (result []string, err error) {
 	distinct := map[string]bool{}
-	if root, err = Abs(root); err != nil {
+	fsys := getFSOpt(opts)
+	if root, err = fsAbs(fsys, root); err != nil {
 		return
 	}
 
@@ -135,7 +156,7 @@ func AllPaths(root string, opts ...*opt.Opt) (result []string, err error) {
 			return e
 		}
 		if p != root && p != "." && p != ".." {
-			absPath, e := Abs(p)
+			absPath, e := fsAbs(fsys, p)
 			if e != nil {
 				return e
 			}
@@ -207,41 +228,91 @@ func Executable() (string, error) {
 	return os.Executable()
 }
 
-// Expand the path to include the home prefix if necessary
+// Expand the path using shell style expansion then normalize it.
+// * A leading ~ or ~/ expands to the current user's home directory
+// * A leading ~user or ~user/ expands to the given user's home directory
+// * $VAR, ${VAR} and ${VAR:-default} style variables expand against the environment
+// * . and .. segments are normalized away e.g. ~/foo/../bar expands to $HOME/bar
 func Expand(target string) (path string, err error) {
 	path = target
 
 	// Nothing to do but not invalid
-	if path == "" || !strings.Contains(path, "~") {
+	if path == "" {
 		return
 	}
 
-	// Invalid expansion requested
-	if path[0] != '~' || len(path) < 2 || path[1] != '/' {
-		path = ""
-		err = errors.Errorf("failed to expand invalid path")
-		return
+	// Expand environment variables
+	if strings.Contains(path, "$") {
+		if path, err = ExpandVars(path, environ()); err != nil {
+			path = ""
+			err = errors.Wrap(err, "failed to expand variables")
+			return
+		}
+		if path == "" {
+			err = errors.Errorf("failed to expand %s as it resolved to an empty path", target)
+			return
+		}
 	}
 
-	// Get home directory
-	var home string
-	if home, err = UserHome(); err != nil {
-		path = ""
-		return
+	// Expand the home directory prefix
+	if strings.HasPrefix(path, "~") {
+		name, rest := path[1:], ""
+		if i := strings.Index(name, "/"); i != -1 {
+			name, rest = name[:i], name[i:]
+		}
+		var home string
+		if name == "" {
+			if home, err = UserHome(); err != nil {
+				path = ""
+				return
+			}
+		} else {
+			var u *user.User
+			if u, err = user.Lookup(name); err != nil {
+				path = ""
+				err = errors.Wrapf(err, "failed to expand home directory for user %s", name)
+				return
+			}
+			home = u.HomeDir
+		}
+		path = home + rest
 	}
 
-	// Replace prefix with home directory
-	path = filepath.Join(home, path[1:])
+	path = filepath.Clean(path)
+	return
+}
 
-	// Invalid expansion requested
-	if strings.Contains(path, "~") {
-		path = ""
-		err = errors.Errorf("invalid expansion requested")
-		return
+// expandPrefix expands only a leading ~ or variable of the given path leaving any later $ as is
+// as they are more likely to be a literal part of a file name than a variable
+func expandPrefix(target string) (result string, err error) {
+	switch {
+	case strings.HasPrefix(target, "~"):
+		seg, rest := target, ""
+		if i := strings.Index(target, "/"); i != -1 {
+			seg, rest = target[:i], target[i:]
+		}
+		if result, err = Expand(seg); err == nil {
+			result += rest
+		}
+	case strings.HasPrefix(target, "$"):
+		l := &shellLexer{runes: []rune(target), env: environ()}
+		if result, err = l.expand(); err != nil {
+			return "", errors.Wrap(err, "failed to expand variables")
+		}
+		if result += string(l.runes[l.pos:]); result == "" {
+			err = errors.Errorf("failed to expand %s as it resolved to an empty path", target)
+		}
+	default:
+		result = target
 	}
 	return
 }
 
+// environ returns the current environment as a map
+func environ() map[string]string {
+	return OSEnv().Map()
+}
+
 // Files returns all files from the given target path, sorted by filename.
 // Doesn't include the target itself only its children nor is this recursive.
 func Files(target string) (result []string) {
@@ -262,27 +333,54 @@ func Files(target string) (result []string) {
 
 // Glob wraps filepath.Glob but provides path expansion, recursion and error tracing.
 // If no sources are found an empty string slice will be returned and a nil error.
-// Enable recursion by passing in the option RecurseOpt(true).
+// Supports doublestar ** segments matching zero or more directories e.g. src/**/*.go
+// and brace expansion e.g. *.{yml,yaml}. Exclude matches by passing in ExcludeOpt with
+// patterns relative to the pattern's leading directory, prefixing a pattern with ! to
+// re-include earlier exclusions. Enable recursion by passing in the option RecurseOpt(true).
+// Glob a filesystem other than the OS by passing in FSOpt(fsys).
 func Glob(path string, opts ...*opt.Opt) (sources []string, err error) {
 	recurse := getRecurseOpt(opts)
+	excludes := getExcludeOpt(opts)
 
 	// Path expansion
-	if path, err = Abs(path); err != nil {
+	fsys := getFSOpt(opts)
+	if path, err = fsAbs(fsys, path); err != nil {
 		return
 	}
 
-	// Handle globbing
-	if sources, err = filepath.Glob(path); err != nil {
+	// Handle globbing matching against a walk of the leading directory for other filesystems
+	if fsys != nil && !hasMeta(path) {
+		sources = []string{}
+		if ExistsFS(fsys, path) {
+			sources = append(sources, path)
+		}
+	} else if fsys != nil || strings.Contains(path, "**") || len(ExpandBraces(path)) > 1 {
+		if sources, err = globDoublestar(path, opts); err != nil {
+			return
+		}
+	} else if sources, err = filepath.Glob(path); err != nil {
 		err = errors.Wrapf(err, "failed to get glob for %s", path)
 		return
 	}
 
+	// Remove exclusions relative to the pattern's leading directory
+	if len(excludes) > 0 {
+		base, _ := globBase(path)
+		filtered := []string{}
+		for _, source := range sources {
+			if !matchPatterns(TrimShared(source, base), excludes) {
+				filtered = append(filtered, source)
+			}
+		}
+		sources = filtered
+	}
+
 	// Execute the recursion if requested
 	if recurse {
 		for _, source := range sources {
-			if IsDir(source) {
+			if info, e := LstatFS(fsys, source); e == nil && info.IsDir() {
 				var paths []string
-				if paths, err = AllPaths(source); err != nil {
+				if paths, err = AllPaths(source, FSOpt(fsys)); err != nil {
 					return
 				}
 				sources = append(sources, paths[1:]...)
@@ -292,6 +390,52 @@ func Glob(path string, opts ...*opt.Opt) (sources []string, err error) {
 	return
 }
 
+// globDoublestar walks the leading directory of each brace expanded pattern collecting the
+// paths that match the rest of the pattern in sorted order. The walk only goes as deep as the
+// pattern has segments unless it contains a ** to match any depth.
+func globDoublestar(pattern string, opts []*opt.Opt) (sources []string, err error) {
+	sources = []string{}
+	opts = opt.Copy(opts)
+	defaultFollowOpt(&opts, false)
+	distinct := map[string]bool{}
+	for _, expanded := range ExpandBraces(pattern) {
+		for _, segment := range splitSegments(expanded) {
+			if _, err = filepath.Match(segment, ""); err != nil {
+				err = errors.Wrapf(err, "failed to get glob for %s", pattern)
+				return
+			}
+		}
+		base, rel := globBase(expanded)
+		if !ExistsFS(getFSOpt(opts), base) {
+			continue
+		}
+		depth := -1
+		if !strings.Contains(rel, "**") {
+			depth = len(splitSegments(rel))
+		}
+		err = Walk(base, func(p string, info *FileInfo, e error) error {
+			if e != nil {
+				return nil
+			}
+			trimmed := TrimShared(p, base)
+			if !distinct[p] && matchPattern(rel, trimmed) {
+				distinct[p] = true
+				sources = append(sources, p)
+			}
+			if depth >= 0 && len(splitSegments(trimmed)) >= depth && (info.IsDir() || info.IsSymlinkDir()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get glob for %s", pattern)
+			return
+		}
+	}
+	sort.Strings(sources)
+	return
+}
+
 // Paths returns all directories/files from the given target path, sorted by filename.
 // Doesn't include the target itself only its children nor is this recursive.
 func Paths(target string) (result []string) {
@@ -460,14 +604,23 @@ func TrimShared(target, shared string) string {
 type WalkFunc func(path string, info *FileInfo, err error) error
 
 // Walk extends the filepath.Walk to allow for it to walk symlinks
-// by default but can be turned off by passing in FollowOpt(false)
+// by default but can be turned off by passing in FollowOpt(false).
+// Skip paths ignored by gitignore style files by passing in IgnoreFileOpt(".gitignore").
+// Walk a filesystem other than the OS by passing in FSOpt(fsys).
+// Walk a rootfs directory resolving links inside it by passing in ChrootOpt(dir).
 func Walk(root string, walkFn WalkFunc, opts ...*opt.Opt) (err error) {
 
 	// Set following links by default
 	defaultFollowOpt(&opts, true)
+	fsys := getFSOpt(opts)
+
+	// Skip paths ignored by gitignore style ignore files
+	if name := getIgnoreFileOpt(opts); name != "" {
+		walkFn = ignoreWalkFn(fsys, name, walkFn)
+	}
 
 	var info *FileInfo
-	if info, err = Lstat(root); err != nil {
+	if info, err = LstatFS(fsys, root); err != nil {
 		err = walkFn(root, nil, err)
 	} else {
 		err = walk(root, info, walkFn, opts)
@@ -482,6 +635,7 @@ func Walk(root string, walkFn WalkFunc, opts ...*opt.Opt) (err error) {
 // and following links unlike the filepath.Walk which doesn't follow links.
 func walk(root string, info *FileInfo, walkFn WalkFunc, opts []*opt.Opt) (err error) {
 	targets := []string{}
+	fsys := getFSOpt(opts)
 
 	// First thing pass whatever we've got on to user walkFn so the user has
 	// the ability to skip this path before processing is done on it
@@ -497,14 +651,14 @@ func walk(root string, info *FileInfo, walkFn WalkFunc, opts []*opt.Opt) (err er
 	// Links and directories are similar in that they have other paths to deal with
 	if info.IsDir() {
 		var names []string
-		if names, err = ReadDirnames(root); err == nil {
+		if names, err = fsReadDirnames(fsys, root); err == nil {
 			for _, name := range names {
 				targets = append(targets, filepath.Join(root, name))
 			}
 		}
 	} else {
 		var target string
-		if target, err = filepath.EvalSymlinks(root); err == nil {
+		if target, err = fsEvalSymlinks(fsys, root); err == nil {
 			targets = append(targets, target)
 		}
 	}
@@ -519,14 +673,22 @@ func walk(root string, info *FileInfo, walkFn WalkFunc, opts []*opt.Opt) (err er
 	// Recurse on target paths
 	var targetInfo *FileInfo
 	for _, target := range targets {
-		if targetInfo, err = Lstat(target); err != nil {
+		if targetInfo, err = LstatFS(fsys, target); err != nil {
 			// Return errors to the user walkFn
 			if err = walkFn(target, targetInfo, err); err != nil {
 				return
 			}
 		} else {
-			// No error so recurse on the path
+			// No error so recurse on the path. SkipDir on a directory skips just that directory
+			// while on a file it skips the remaining entries in the containing directory.
 			if err = walk(target, targetInfo, walkFn, opts); err != nil {
+				if err == filepath.SkipDir {
+					if targetInfo.IsDir() || targetInfo.IsSymlinkDir() {
+						err = nil
+						continue
+					}
+					err = nil
+				}
 				return
 			}
 		}
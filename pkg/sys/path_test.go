@@ -511,25 +511,105 @@ func TestAllPaths(t *testing.T) {
 
 func TestExpand(t *testing.T) {
 
-	// More than one ~
+	// ~ not at the start is taken literally
 	{
+		home, _ := UserHome()
 		result, err := Expand("~/foo~")
-		assert.Equal(t, "invalid expansion requested", err.Error())
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(home, "foo~"), result)
+	}
+
+	// other users
+	{
+		result, err := Expand("~root/foo")
+		assert.Nil(t, err)
+		assert.Equal(t, "/root/foo", result)
+
+		result, err = Expand("~bogus-user")
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to expand home directory for user bogus-user"))
 		assert.Equal(t, "", result)
 	}
 
-	// invalid path
+	// variables
 	{
-		result, err := Expand("~foo")
-		assert.Equal(t, "failed to expand invalid path", err.Error())
+		os.Setenv("N_EXPAND_TEST", "/foo/bar")
+		defer os.Unsetenv("N_EXPAND_TEST")
+
+		result, err := Expand("$N_EXPAND_TEST/../baz")
+		assert.Nil(t, err)
+		assert.Equal(t, "/foo/baz", result)
+
+		result, err = Expand("${N_EXPAND_BOGUS:-${N_EXPAND_TEST}}/./file")
+		assert.Nil(t, err)
+		assert.Equal(t, "/foo/bar/file", result)
+
+		result, err = Expand("$N_EXPAND_BOGUS")
+		assert.Equal(t, "failed to expand $N_EXPAND_BOGUS as it resolved to an empty path", err.Error())
 		assert.Equal(t, "", result)
+
+		_, err = Expand("${N_EXPAND_TEST")
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to expand variables"))
+	}
+
+	// used by Abs, Glob and Copy
+	{
+		resetTest()
+		os.Setenv("N_EXPAND_TEST", tmpDir)
+		defer os.Unsetenv("N_EXPAND_TEST")
+		root, _ := Abs(tmpDir)
+
+		result, err := Abs("$N_EXPAND_TEST/sub/..")
+		assert.Nil(t, err)
+		assert.Equal(t, root, result)
+
+		assert.Nil(t, WriteString(path.Join(tmpDir, "file"), "file"))
+		assert.Nil(t, Copy("${N_EXPAND_TEST}/file", "$N_EXPAND_TEST/copy"))
+		paths, err := Glob("$N_EXPAND_TEST/*")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{path.Join(root, "copy"), path.Join(root, "file")}, paths)
+	}
+
+	// literal $ in file names are kept by Abs
+	{
+		resetTest()
+		os.Setenv("b", "bogus")
+		defer os.Unsetenv("b")
+		root, _ := Abs(tmpDir)
+
+		for _, name := range []string{"a$b", "price$5.txt", "x${"} {
+			target := path.Join(root, name)
+			result, err := Abs(target)
+			assert.Nil(t, err)
+			assert.Equal(t, target, result)
+			assert.Nil(t, WriteString(target, name))
+			data, err := os.ReadFile(target)
+			assert.Nil(t, err)
+			assert.Equal(t, name, string(data))
+		}
+		result, err := Abs(path.Join(tmpDir, "a$b"))
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "a$b"), result)
+		assert.Nil(t, Copy(path.Join(root, "a$b"), path.Join(root, "c$b")))
+		assert.True(t, Exists(path.Join(root, "c$b")))
+		assert.False(t, Exists(path.Join(root, "a")))
+	}
+
+	// normalize
+	{
+		result, err := Expand("foo/./bar/../baz/")
+		assert.Nil(t, err)
+		assert.Equal(t, "foo/baz", result)
 	}
 
 	// happy
 	{
+		home, _ := UserHome()
 		result, err := Expand("~/")
 		assert.Nil(t, err)
-		assert.True(t, pathContainsHome(result))
+		assert.Equal(t, home, result)
+		result, err = Expand("~")
+		assert.Nil(t, err)
+		assert.Equal(t, home, result)
 	}
 
 	// HOME not set
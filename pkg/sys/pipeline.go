@@ -0,0 +1,255 @@
+package sys
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+)
+
+// Pipeline provides a native equivalent of a sh pipeline e.g. a | b | c connecting each
+// command's stdout to the next command's stdin without invoking a shell.
+type Pipeline struct {
+	cmds    []*Cmd          // commands to connect in order
+	ctx     context.Context // context to kill all commands on cancel
+	timeout time.Duration   // maximum time to run before killing all commands
+}
+
+// NewPipeline creates a new pipeline connecting the given commands in order
+func NewPipeline(cmds ...*Cmd) *Pipeline {
+	return &Pipeline{cmds: cmds}
+}
+
+// ParsePipeline parses the given sh style command string into a pipeline. Words are split
+// the same as SplitWords including optional expansion against the given env map. Unquoted |
+// separates commands and the redirects < file, > file, >> file, 2> file, 2>> file and 2>&1
+// are supported for each command. No other shell features are supported.
+func ParsePipeline(str string, env ...map[string]string) (p *Pipeline, err error) {
+	l := &shellLexer{runes: []rune(str), split: true, ops: true}
+	if len(env) > 0 {
+		l.env = env[0]
+		if l.env == nil {
+			l.env = map[string]string{}
+		}
+	}
+	var tokens []shellToken
+	if tokens, err = l.tokens(); err != nil {
+		return
+	}
+	if len(tokens) == 0 {
+		err = errors.Errorf("invalid empty command")
+		return
+	}
+
+	// Collect the words and redirects for each command
+	p = NewPipeline()
+	var words []string
+	var redirects []func(*Cmd)
+	complete := func() error {
+		if len(words) == 0 {
+			return errors.Errorf("invalid empty command in pipeline")
+		}
+		cmd := NewCmd(words[0], words[1:]...)
+		for _, f := range redirects {
+			f(cmd)
+		}
+		p.cmds = append(p.cmds, cmd)
+		words, redirects = nil, nil
+		return nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch {
+		case !token.op:
+			words = append(words, token.val)
+		case token.val == "|":
+			if err = complete(); err != nil {
+				p = nil
+				return
+			}
+		case token.val == "2>&1":
+			redirects = append(redirects, func(c *Cmd) { c.StderrToStdout() })
+		case strings.Contains(token.val, "&"):
+			err = errors.Errorf("unsupported redirect %s", token.val)
+			p = nil
+			return
+		default:
+			if i+1 >= len(tokens) || tokens[i+1].op {
+				err = errors.Errorf("missing file for redirect %s", token.val)
+				p = nil
+				return
+			}
+			i++
+			path := tokens[i].val
+			switch token.val {
+			case "<", "0<":
+				redirects = append(redirects, func(c *Cmd) { c.RedirectStdin(path) })
+			case ">", "1>":
+				redirects = append(redirects, func(c *Cmd) { c.RedirectStdout(path, false) })
+			case ">>", "1>>":
+				redirects = append(redirects, func(c *Cmd) { c.RedirectStdout(path, true) })
+			case "2>":
+				redirects = append(redirects, func(c *Cmd) { c.RedirectStderr(path, false) })
+			case "2>>":
+				redirects = append(redirects, func(c *Cmd) { c.RedirectStderr(path, true) })
+			default:
+				err = errors.Errorf("unsupported redirect %s", token.val)
+				p = nil
+				return
+			}
+		}
+	}
+	if err = complete(); err != nil {
+		p = nil
+	}
+	return
+}
+
+// Context sets the context for the pipeline overriding the context of its commands. When the
+// context is done all commands are killed. Returns a reference to the pipeline.
+func (p *Pipeline) Context(ctx context.Context) *Pipeline {
+	p.ctx = ctx
+	return p
+}
+
+// Pipe appends the given commands to the pipeline and returns a reference to the pipeline
+func (p *Pipeline) Pipe(cmds ...*Cmd) *Pipeline {
+	p.cmds = append(p.cmds, cmds...)
+	return p
+}
+
+// Timeout sets the maximum time the pipeline is allowed to run before all commands are
+// killed. Returns a reference to the pipeline.
+func (p *Pipeline) Timeout(timeout time.Duration) *Pipeline {
+	p.timeout = timeout
+	return p
+}
+
+// String returns the pipeline in sh form
+func (p *Pipeline) String() string {
+	cmds := make([]string, 0, len(p.cmds))
+	for _, cmd := range p.cmds {
+		cmds = append(cmds, cmd.String())
+	}
+	return strings.Join(cmds, " | ")
+}
+
+// Run executes the pipeline's commands concurrently with each command's stdout connected to
+// the next command's stdin and waits for them all to complete. Returns the exit code of every
+// command in order and following pipefail semantics the error of the last command to fail.
+// Commands that failed to start or were killed by a signal report an exit code of -1. If a
+// command fails to start the commands already started are killed.
+//
+// Supported options: opt.InOpt to connect the first command's stdin, opt.OutOpt to connect
+// the last command's stdout and opt.ErrOpt to connect all the commands' stderr. Streams not
+// given or redirected are connected to the null device.
+func (p *Pipeline) Run(opts ...*opt.Opt) (codes []int, err error) {
+	if len(p.cmds) == 0 {
+		err = errors.Errorf("invalid empty pipeline")
+		return
+	}
+
+	// Configure timeout and cancellation shared by all commands
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cancel context.CancelFunc
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// Stderr is shared by all commands so serialize writes to it
+	stdin, stdout, stderr := opt.DefaultInOpt(opts, nil), opt.DefaultOutOpt(opts, nil), opt.DefaultErrOpt(opts, nil)
+	if _, ok := stderr.(*os.File); !ok && stderr != nil {
+		stderr = &lockedWriter{w: stderr}
+	}
+
+	// Start each command connecting them with pipes
+	codes = make([]int, len(p.cmds))
+	procs := make([]*process, 0, len(p.cmds))
+	in := stdin
+	for i, cmd := range p.cmds {
+		out := stdout
+		var r, w *os.File
+		if i < len(p.cmds)-1 {
+			if r, w, err = os.Pipe(); err != nil {
+				err = errors.Wrap(err, "failed to create pipe")
+				codes[i] = -1
+				break
+			}
+			out = w
+		}
+
+		var proc *process
+		if proc, err = cmd.prepare(ctx, in, out, stderr); err == nil {
+			if w != nil {
+				proc.files = append(proc.files, w)
+			}
+			err = proc.start()
+		}
+
+		// The previous read end now belongs to the started command
+		if prev, ok := in.(*os.File); ok && i > 0 {
+			prev.Close()
+		}
+		if err != nil {
+			codes[i] = -1
+			for _, f := range []*os.File{r, w} {
+				if f != nil {
+					f.Close()
+				}
+			}
+			break
+		}
+		procs = append(procs, proc)
+		in = r
+	}
+
+	// Kill anything already started on failure
+	if err != nil {
+		cancel()
+		if f, ok := in.(*os.File); ok && in != stdin {
+			f.Close()
+		}
+		for i := len(procs) + 1; i < len(codes); i++ {
+			codes[i] = -1
+		}
+	}
+
+	// Wait on all commands in order so each pipe is closed after its writer completes
+	for i, proc := range procs {
+		if e := proc.wait(); e != nil {
+			codes[i] = -1
+			if exitErr, ok := e.(*ExitError); ok {
+				codes[i] = exitErr.Code
+			}
+			if len(procs) == len(p.cmds) {
+				err = e
+			}
+		}
+	}
+	return
+}
+
+// Output executes the pipeline and returns the last command's stdout as a string along with
+// the exit code of every command and the error of the last command to fail.
+//
+// Supported options: opt.InOpt and opt.ErrOpt to connect the first command's stdin and all the
+// commands' stderr
+func (p *Pipeline) Output(opts ...*opt.Opt) (out string, codes []int, err error) {
+	var buf bytes.Buffer
+	opts = opt.Copy(opts)
+	opt.OverwriteOutOpt(&opts, &buf)
+	codes, err = p.Run(opts...)
+	out = buf.String()
+	return
+}
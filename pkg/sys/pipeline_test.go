@@ -0,0 +1,189 @@
+package sys
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePipeline(t *testing.T) {
+
+	// commands and redirects
+	{
+		p, err := ParsePipeline(`cat < in.txt | grep -v "a | b" 2>>err.log | sort -r >out.txt 2>&1`)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(p.cmds))
+		assert.Equal(t, "in.txt", p.cmds[0].inFile)
+		assert.Equal(t, []string{"-v", "a | b"}, p.cmds[1].args)
+		assert.Equal(t, &redirect{path: "err.log", append: true}, p.cmds[1].errFile)
+		assert.Equal(t, &redirect{path: "out.txt"}, p.cmds[2].outFile)
+		assert.True(t, p.cmds[2].errToOut)
+		assert.Equal(t, `cat < in.txt | grep -v 'a | b' 2>> err.log | sort -r > out.txt 2>&1`, p.String())
+	}
+
+	// fd prefixes only apply at the start of a word and the last redirect wins
+	{
+		p, err := ParsePipeline(`echo a2>f 1>>g 0<h`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a2"}, p.cmds[0].args)
+		assert.Equal(t, &redirect{path: "g", append: true}, p.cmds[0].outFile)
+		assert.Equal(t, "h", p.cmds[0].inFile)
+		assert.Equal(t, "echo a2 < h >> g", NewPipeline(p.cmds[0]).String())
+	}
+
+	// expansion
+	{
+		p, err := ParsePipeline(`echo $FOO | tee "$DIR/out"`, map[string]string{"FOO": "a b", "DIR": "/tmp"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, p.cmds[0].args)
+		assert.Equal(t, []string{"/tmp/out"}, p.cmds[1].args)
+	}
+
+	// errors
+	{
+		_, err := ParsePipeline("  ")
+		assert.Equal(t, "invalid empty command", err.Error())
+		_, err = ParsePipeline("ls |")
+		assert.Equal(t, "invalid empty command in pipeline", err.Error())
+		_, err = ParsePipeline("| ls")
+		assert.Equal(t, "invalid empty command in pipeline", err.Error())
+		_, err = ParsePipeline("ls >")
+		assert.Equal(t, "missing file for redirect >", err.Error())
+		_, err = ParsePipeline("ls > | cat")
+		assert.Equal(t, "missing file for redirect >", err.Error())
+		_, err = ParsePipeline("ls 3> foo")
+		assert.Equal(t, "unsupported redirect 3>", err.Error())
+		_, err = ParsePipeline("ls 0> foo")
+		assert.Equal(t, "unsupported redirect 0>", err.Error())
+		_, err = ParsePipeline("ls 1< foo")
+		assert.Equal(t, "unsupported redirect 1<", err.Error())
+		_, err = ParsePipeline("ls >&2")
+		assert.Equal(t, "unsupported redirect >&2", err.Error())
+		_, err = ParsePipeline("ls || true")
+		assert.Equal(t, `unsupported operator "||" at offset 3`, err.Error())
+		_, err = ParsePipeline("ls & true")
+		assert.Equal(t, `unsupported operator "&" at offset 3`, err.Error())
+		_, err = ParsePipeline("ls 2>&")
+		assert.Equal(t, `invalid file descriptor for operator "2>&" at offset 3`, err.Error())
+		_, err = ParsePipeline("ls 'foo")
+		assert.Equal(t, "unterminated single quote at offset 3", err.Error())
+	}
+}
+
+func TestPipeline_Run(t *testing.T) {
+	resetTest()
+
+	// stdout flows to stdin
+	{
+		p := NewPipeline(NewCmd("printf", `c\na\nb\n`), NewCmd("sort")).Pipe(NewCmd("head", "-n", "2"))
+		out, codes, err := p.Output()
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0, 0, 0}, codes)
+		assert.Equal(t, "a\nb\n", out)
+	}
+
+	// stdin and stderr options
+	{
+		var stderr bytes.Buffer
+		p, err := ParsePipeline(`tr a-z A-Z | sh -c "cat; echo err >&2"`)
+		assert.Nil(t, err)
+		out, codes, err := p.Output(opt.InOpt(strings.NewReader("foo")), opt.ErrOpt(&stderr))
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0, 0}, codes)
+		assert.Equal(t, "FOO", out)
+		assert.Equal(t, "err\n", stderr.String())
+	}
+
+	// pipefail reports the last failure
+	{
+		p, err := ParsePipeline(`sh -c "exit 2" | sh -c "cat; exit 3" | cat`)
+		assert.Nil(t, err)
+		codes, err := p.Run()
+		assert.Equal(t, []int{2, 3, 0}, codes)
+		assert.Equal(t, `command sh -c 'cat; exit 3' exited with code 3`, err.Error())
+	}
+
+	// start failure kills started commands
+	{
+		start := time.Now()
+		codes, err := NewPipeline(NewCmd("sleep", "10"), NewCmd("footmp"), NewCmd("cat")).Run()
+		assert.True(t, time.Since(start) < 5*time.Second)
+		assert.Equal(t, []int{-1, -1, -1}, codes)
+		assert.Equal(t, `failed to start command footmp: exec: "footmp": executable file not found in $PATH`, err.Error())
+	}
+
+	// timeout
+	{
+		start := time.Now()
+		codes, err := NewPipeline(NewCmd("sleep", "10"), NewCmd("cat")).Timeout(100 * time.Millisecond).Run()
+		assert.True(t, time.Since(start) < 5*time.Second)
+		assert.Equal(t, []int{-1, -1}, codes)
+		assert.Equal(t, "command cat killed by signal killed: context deadline exceeded", err.Error())
+	}
+
+	// empty
+	{
+		_, err := NewPipeline().Run()
+		assert.Equal(t, "invalid empty pipeline", err.Error())
+	}
+}
+
+func TestPipeline_Redirects(t *testing.T) {
+	resetTest()
+	out := path.Join(tmpDir, "out")
+	errLog := path.Join(tmpDir, "err")
+
+	// truncate, append and stdin
+	{
+		p, err := ParsePipeline(`echo foo > ` + out)
+		assert.Nil(t, err)
+		_, err = p.Run()
+		assert.Nil(t, err)
+		p, err = ParsePipeline(`echo bar >> ` + out)
+		assert.Nil(t, err)
+		_, err = p.Run()
+		assert.Nil(t, err)
+
+		p, err = ParsePipeline(`cat < ` + out + ` | tr a-z A-Z`)
+		assert.Nil(t, err)
+		result, _, err := p.Output()
+		assert.Nil(t, err)
+		assert.Equal(t, "FOO\nBAR\n", result)
+	}
+
+	// stderr to file and to stdout
+	{
+		_, err := NewCmd("sh", "-c", "echo out; echo err >&2").RedirectStderr(errLog, false).Output()
+		assert.Nil(t, err)
+		data, err := ReadString(errLog)
+		assert.Nil(t, err)
+		assert.Equal(t, "err\n", data)
+
+		p, err := ParsePipeline(`sh -c "echo err >&2" 2>&1 | tr a-z A-Z`)
+		assert.Nil(t, err)
+		result, _, err := p.Output()
+		assert.Nil(t, err)
+		assert.Equal(t, "ERR\n", result)
+
+		err = NewCmd("sh", "-c", "echo out; echo err >&2").RedirectStdout(out, false).StderrToStdout().Run()
+		assert.Nil(t, err)
+		data, err = ReadString(out)
+		assert.Nil(t, err)
+		assert.Contains(t, data, "out\n")
+		assert.Contains(t, data, "err\n")
+	}
+
+	// redirect failures
+	{
+		err := NewCmd("cat").RedirectStdin(path.Join(tmpDir, "bogus")).Run()
+		assert.Equal(t, "failed to open ../../test/temp/bogus for redirect: open ../../test/temp/bogus: no such file or directory", err.Error())
+
+		err = NewCmd("echo", "foo").RedirectStdout(path.Join(tmpDir, "bogus", "out"), false).Run()
+		assert.Contains(t, err.Error(), "failed to redirect output of command echo foo > ../../test/temp/bogus/out: failed opening file")
+	}
+}
@@ -0,0 +1,631 @@
+package sys
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// gProcRoot is the mount point of the proc filesystem
+	gProcRoot = "/proc"
+
+	// gSysRoot is the mount point of the sysfs filesystem
+	gSysRoot = "/sys"
+)
+
+// gClockTicks is the USER_HZ rate the kernel reports process times in which is fixed at 100 on
+// all Linux architectures regardless of the kernel's internal tick rate
+const gClockTicks = 100
+
+// Process provides details about a running process parsed from /proc/<pid>
+type Process struct {
+	PID     int           // process id
+	PPID    int           // parent process id
+	UID     int           // real user id of the process owner
+	Name    string        // name of the executable truncated to 15 characters by the kernel
+	State   string        // single character state e.g. R running, S sleeping, Z zombie
+	Cmdline []string      // command line arguments which are empty for kernel threads
+	Threads int           // number of threads
+	RSS     int64         // resident set size in bytes
+	VSize   int64         // virtual memory size in bytes
+	UTime   time.Duration // CPU time spent in user mode
+	STime   time.Duration // CPU time spent in kernel mode
+	Start   time.Time     // time the process started
+}
+
+// CPUTime returns the total CPU time spent by the process in user and kernel mode
+func (p *Process) CPUTime() time.Duration {
+	return p.UTime + p.STime
+}
+
+// Ancestors returns the chain of parent processes starting with the direct parent
+func (p *Process) Ancestors() (result []*Process, err error) {
+	result = []*Process{}
+	for ppid := p.PPID; ppid > 0; {
+		var parent *Process
+		if parent, err = GetProcess(ppid); err != nil {
+			return
+		}
+		result = append(result, parent)
+		ppid = parent.PPID
+	}
+	return
+}
+
+// Children returns the processes whose parent is this process sorted by pid
+func (p *Process) Children() (result []*Process, err error) {
+	var procs []*Process
+	if procs, err = Processes(); err != nil {
+		return
+	}
+	result = []*Process{}
+	for _, proc := range procs {
+		if proc.PPID == p.PID {
+			result = append(result, proc)
+		}
+	}
+	return
+}
+
+// Parent returns the parent process
+func (p *Process) Parent() (*Process, error) {
+	return GetProcess(p.PPID)
+}
+
+// ToMap exports the process as a map e.g. for use with n.ToStringMap
+func (p *Process) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"pid": p.PID, "ppid": p.PPID, "uid": p.UID, "name": p.Name, "state": p.State,
+		"cmdline": p.Cmdline, "threads": p.Threads, "rss": p.RSS, "vsize": p.VSize,
+		"utime": p.UTime, "stime": p.STime, "start": p.Start,
+	}
+}
+
+// GetProcess returns the process with the given pid
+func GetProcess(pid int) (proc *Process, err error) {
+	boot, _ := BootTime()
+	return getProcess(pid, boot)
+}
+
+// getProcess returns the process with the given pid calculating its start time from the given
+// boot time which is read once by the caller as it requires parsing /proc/stat
+func getProcess(pid int, boot time.Time) (proc *Process, err error) {
+	dir := path.Join(gProcRoot, strconv.Itoa(pid))
+	proc = &Process{PID: pid, Cmdline: []string{}}
+
+	var data []byte
+	if data, err = os.ReadFile(path.Join(dir, "stat")); err != nil {
+		proc = nil
+		err = errors.Wrapf(err, "failed to read process %d", pid)
+		return
+	}
+	if err = proc.parseStat(string(data), boot); err != nil {
+		proc = nil
+		return
+	}
+
+	// The cmdline and status may be unreadable or gone if the process exits in the meantime
+	if data, err = os.ReadFile(path.Join(dir, "cmdline")); err == nil && len(data) > 0 {
+		proc.Cmdline = strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	}
+	var status map[string]string
+	if status, err = readProcFields(path.Join(dir, "status")); err == nil {
+		if fields := strings.Fields(status["Uid"]); len(fields) > 0 {
+			proc.UID, _ = strconv.Atoi(fields[0])
+		}
+	}
+	err = nil
+	return
+}
+
+// parseStat parses the content of /proc/<pid>/stat into the process. The name is found by the
+// last closing parenthesis as it may contain spaces and parentheses itself. The start time is
+// left unset when the given boot time is zero.
+func (p *Process) parseStat(stat string, boot time.Time) (err error) {
+	start, end := strings.Index(stat, "("), strings.LastIndex(stat, ")")
+	if start == -1 || end < start {
+		return errors.Errorf("invalid stat for process %d", p.PID)
+	}
+	p.Name = stat[start+1 : end]
+
+	// Fields are numbered from 1 in proc(5) with the name being field 2 and state field 3
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return errors.Errorf("invalid stat for process %d", p.PID)
+	}
+	field := func(n int) int64 {
+		val, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return val
+	}
+	p.State = fields[0]
+	p.PPID = int(field(4))
+	p.UTime = ticksToDuration(field(14))
+	p.STime = ticksToDuration(field(15))
+	p.Threads = int(field(20))
+	p.VSize = field(23)
+	p.RSS = field(24) * int64(os.Getpagesize())
+	if !boot.IsZero() {
+		p.Start = boot.Add(ticksToDuration(field(22)))
+	}
+	return
+}
+
+// ticksToDuration converts the given clock ticks to a duration
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / gClockTicks
+}
+
+// Processes returns all running processes sorted by pid. Processes that exit while being read
+// are skipped.
+func Processes() (result []*Process, err error) {
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(gProcRoot); err != nil {
+		err = errors.Wrapf(err, "failed to list processes")
+		return
+	}
+	result = []*Process{}
+	boot, _ := BootTime()
+	for _, entry := range entries {
+		pid, e := strconv.Atoi(entry.Name())
+		if e != nil || !entry.IsDir() {
+			continue
+		}
+		if proc, e := getProcess(pid, boot); e == nil {
+			result = append(result, proc)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PID < result[j].PID })
+	return
+}
+
+// ProcessesByName returns the processes with the given name or whose first command line
+// argument has the given base name sorted by pid
+func ProcessesByName(name string) (result []*Process, err error) {
+	return filterProcesses(func(p *Process) bool {
+		return p.Name == name || (len(p.Cmdline) > 0 && path.Base(p.Cmdline[0]) == name)
+	})
+}
+
+// ProcessesByCmdline returns the processes whose space joined command line matches the given
+// regular expression sorted by pid
+func ProcessesByCmdline(expr string) (result []*Process, err error) {
+	var rx *regexp.Regexp
+	if rx, err = regexp.Compile(expr); err != nil {
+		err = errors.Wrapf(err, "failed to compile command line expression %s", expr)
+		return
+	}
+	return filterProcesses(func(p *Process) bool {
+		return len(p.Cmdline) > 0 && rx.MatchString(strings.Join(p.Cmdline, " "))
+	})
+}
+
+// filterProcesses returns the running processes the given function returns true for
+func filterProcesses(fn func(p *Process) bool) (result []*Process, err error) {
+	var procs []*Process
+	if procs, err = Processes(); err != nil {
+		return
+	}
+	result = []*Process{}
+	for _, proc := range procs {
+		if fn(proc) {
+			result = append(result, proc)
+		}
+	}
+	return
+}
+
+// MemInfo provides the system memory usage parsed from /proc/meminfo in bytes
+type MemInfo struct {
+	Total     int64 // total usable memory
+	Free      int64 // completely unused memory
+	Available int64 // estimate of memory available for new applications without swapping
+	Buffers   int64 // memory used by kernel buffers
+	Cached    int64 // memory used by the page cache
+	SwapTotal int64 // total swap space
+	SwapFree  int64 // unused swap space
+}
+
+// Used returns the memory in use i.e. not available for new applications
+func (m *MemInfo) Used() int64 {
+	return m.Total - m.Available
+}
+
+// ToMap exports the memory info as a map e.g. for use with n.ToStringMap
+func (m *MemInfo) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"total": m.Total, "free": m.Free, "available": m.Available, "used": m.Used(),
+		"buffers": m.Buffers, "cached": m.Cached, "swap_total": m.SwapTotal, "swap_free": m.SwapFree,
+	}
+}
+
+// Memory returns the system memory usage
+func Memory() (mem *MemInfo, err error) {
+	var fields map[string]string
+	if fields, err = readProcFields(path.Join(gProcRoot, "meminfo")); err != nil {
+		err = errors.Wrap(err, "failed to read memory info")
+		return
+	}
+	kb := func(key string) int64 {
+		val, _ := strconv.ParseInt(strings.TrimSuffix(fields[key], " kB"), 10, 64)
+		return val * 1024
+	}
+	mem = &MemInfo{
+		Total: kb("MemTotal"), Free: kb("MemFree"), Available: kb("MemAvailable"),
+		Buffers: kb("Buffers"), Cached: kb("Cached"), SwapTotal: kb("SwapTotal"), SwapFree: kb("SwapFree"),
+	}
+	return
+}
+
+// LoadAvg provides the system load averages parsed from /proc/loadavg
+type LoadAvg struct {
+	One      float64 // load average over the last minute
+	Five     float64 // load average over the last 5 minutes
+	Fifteen  float64 // load average over the last 15 minutes
+	Running  int     // currently runnable scheduling entities
+	Entities int     // total scheduling entities
+}
+
+// ToMap exports the load averages as a map e.g. for use with n.ToStringMap
+func (l *LoadAvg) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"one": l.One, "five": l.Five, "fifteen": l.Fifteen, "running": l.Running, "entities": l.Entities,
+	}
+}
+
+// Load returns the system load averages
+func Load() (load *LoadAvg, err error) {
+	var data []byte
+	if data, err = os.ReadFile(path.Join(gProcRoot, "loadavg")); err != nil {
+		err = errors.Wrap(err, "failed to read load averages")
+		return
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 || !strings.Contains(fields[3], "/") {
+		err = errors.Errorf("invalid load averages %q", strings.TrimSpace(string(data)))
+		return
+	}
+	load = &LoadAvg{}
+	load.One, _ = strconv.ParseFloat(fields[0], 64)
+	load.Five, _ = strconv.ParseFloat(fields[1], 64)
+	load.Fifteen, _ = strconv.ParseFloat(fields[2], 64)
+	entities := strings.SplitN(fields[3], "/", 2)
+	load.Running, _ = strconv.Atoi(entities[0])
+	load.Entities, _ = strconv.Atoi(entities[1])
+	return
+}
+
+// Uptime returns the time elapsed since the system booted
+func Uptime() (uptime time.Duration, err error) {
+	var data []byte
+	if data, err = os.ReadFile(path.Join(gProcRoot, "uptime")); err != nil {
+		err = errors.Wrap(err, "failed to read uptime")
+		return
+	}
+	fields := strings.Fields(string(data))
+	var secs float64
+	if len(fields) == 0 {
+		err = errors.Errorf("invalid uptime %q", string(data))
+	} else if secs, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		err = errors.Wrapf(err, "invalid uptime %q", fields[0])
+	}
+	uptime = time.Duration(secs * float64(time.Second))
+	return
+}
+
+// BootTime returns the time the system booted
+func BootTime() (boot time.Time, err error) {
+	var fields map[string]string
+	if fields, err = readProcFields(path.Join(gProcRoot, "stat")); err != nil {
+		err = errors.Wrap(err, "failed to read boot time")
+		return
+	}
+	var secs int64
+	if secs, err = strconv.ParseInt(fields["btime"], 10, 64); err != nil {
+		err = errors.Wrapf(err, "invalid boot time %q", fields["btime"])
+		return
+	}
+	boot = time.Unix(secs, 0)
+	return
+}
+
+// Mount provides a single mount parsed from /proc/self/mountinfo
+type Mount struct {
+	ID           int    // unique id of the mount
+	ParentID     int    // id of the parent mount
+	Device       string // major:minor device numbers
+	Root         string // path within the filesystem forming the root of the mount
+	Path         string // mount point
+	Options      string // per mount options e.g. rw,relatime
+	Type         string // filesystem type e.g. ext4
+	Source       string // filesystem specific source e.g. /dev/sda1
+	SuperOptions string // per filesystem options
+}
+
+// Statfs returns the usage of the filesystem mounted
+func (m *Mount) Statfs() (*FSStat, error) {
+	return Statfs(m.Path)
+}
+
+// ToMap exports the mount as a map e.g. for use with n.ToStringMap
+func (m *Mount) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id": m.ID, "parent_id": m.ParentID, "device": m.Device, "root": m.Root, "path": m.Path,
+		"options": m.Options, "type": m.Type, "source": m.Source, "super_options": m.SuperOptions,
+	}
+}
+
+// Mounts returns the mounts visible to the current process in mount order
+func Mounts() (mounts []*Mount, err error) {
+	var file *os.File
+	target := path.Join(gProcRoot, "self", "mountinfo")
+	if file, err = os.Open(target); err != nil {
+		err = errors.Wrap(err, "failed to read mounts")
+		return
+	}
+	defer file.Close()
+	return parseMountInfo(file)
+}
+
+// parseMountInfo parses the mountinfo format described in proc(5) i.e. space separated fields
+// with a variable number of optional fields terminated by a single hyphen
+func parseMountInfo(reader io.Reader) (mounts []*Mount, err error) {
+	mounts = []*Mount{}
+	scanner := bufio.NewScanner(reader)
+	for i := 1; scanner.Scan(); i++ {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for j := 6; j < len(fields); j++ {
+			if fields[j] == "-" {
+				sep = j
+				break
+			}
+		}
+		if len(fields) < 6 || sep == -1 || len(fields) < sep+3 {
+			err = errors.Errorf("invalid mountinfo at line %d", i)
+			return
+		}
+		mount := &Mount{
+			Device: fields[2], Root: unescapeMount(fields[3]), Path: unescapeMount(fields[4]), Options: fields[5],
+			Type: fields[sep+1], Source: unescapeMount(fields[sep+2]),
+		}
+		mount.ID, _ = strconv.Atoi(fields[0])
+		mount.ParentID, _ = strconv.Atoi(fields[1])
+		if len(fields) > sep+3 {
+			mount.SuperOptions = fields[sep+3]
+		}
+		mounts = append(mounts, mount)
+	}
+	if err = scanner.Err(); err != nil {
+		err = errors.Wrap(err, "failed to read mounts")
+	}
+	return
+}
+
+// unescapeMount replaces the octal escapes the kernel uses for spaces, tabs, newlines and
+// backslashes in mount paths
+func unescapeMount(val string) string {
+	if !strings.Contains(val, `\`) {
+		return val
+	}
+	var b strings.Builder
+	for i := 0; i < len(val); i++ {
+		if val[i] == '\\' && i+3 < len(val) {
+			if n, err := strconv.ParseUint(val[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(val[i])
+	}
+	return b.String()
+}
+
+// FSStat provides the usage of a filesystem in bytes
+type FSStat struct {
+	Path      string // path the filesystem was queried with
+	Total     int64  // total size of the filesystem
+	Free      int64  // free space including space reserved for root
+	Available int64  // free space available to unprivileged users
+	Files     int64  // total inodes
+	FilesFree int64  // free inodes
+	BlockSize int64  // filesystem block size
+}
+
+// Statfs returns the usage of the filesystem the given path resides on
+func Statfs(target string) (stat *FSStat, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	var st unix.Statfs_t
+	if err = unix.Statfs(target, &st); err != nil {
+		err = errors.Wrapf(err, "failed to query filesystem usage for %s", target)
+		return
+	}
+	bsize := int64(st.Bsize)
+	stat = &FSStat{
+		Path: target, Total: int64(st.Blocks) * bsize, Free: int64(st.Bfree) * bsize,
+		Available: int64(st.Bavail) * bsize, Files: int64(st.Files), FilesFree: int64(st.Ffree), BlockSize: bsize,
+	}
+	return
+}
+
+// Used returns the space used
+func (s *FSStat) Used() int64 {
+	return s.Total - s.Free
+}
+
+// ToMap exports the filesystem usage as a map e.g. for use with n.ToStringMap
+func (s *FSStat) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"path": s.Path, "total": s.Total, "free": s.Free, "available": s.Available, "used": s.Used(),
+		"files": s.Files, "files_free": s.FilesFree, "block_size": s.BlockSize,
+	}
+}
+
+// CPU provides the topology of a single logical CPU
+type CPU struct {
+	ID     int     // logical processor id
+	Socket int     // physical package id
+	Core   int     // core id within the package
+	Model  string  // model name
+	MHz    float64 // current clock speed if reported
+}
+
+// CPUInfo provides the CPU count and topology parsed from /proc/cpuinfo
+type CPUInfo struct {
+	CPUs []*CPU // logical CPUs ordered by id
+}
+
+// Count returns the number of logical CPUs
+func (c *CPUInfo) Count() int {
+	return len(c.CPUs)
+}
+
+// Cores returns the number of physical cores across all sockets
+func (c *CPUInfo) Cores() int {
+	cores := map[[2]int]bool{}
+	for _, cpu := range c.CPUs {
+		cores[[2]int{cpu.Socket, cpu.Core}] = true
+	}
+	return len(cores)
+}
+
+// Sockets returns the number of physical packages
+func (c *CPUInfo) Sockets() int {
+	sockets := map[int]bool{}
+	for _, cpu := range c.CPUs {
+		sockets[cpu.Socket] = true
+	}
+	return len(sockets)
+}
+
+// ToMap exports the CPU info as a map e.g. for use with n.ToStringMap
+func (c *CPUInfo) ToMap() map[string]interface{} {
+	cpus := []map[string]interface{}{}
+	for _, cpu := range c.CPUs {
+		cpus = append(cpus, map[string]interface{}{
+			"id": cpu.ID, "socket": cpu.Socket, "core": cpu.Core, "model": cpu.Model, "mhz": cpu.MHz,
+		})
+	}
+	return map[string]interface{}{"count": c.Count(), "cores": c.Cores(), "sockets": c.Sockets(), "cpus": cpus}
+}
+
+// CPUs returns the CPU count and topology. Architectures that don't report the topology in
+// /proc/cpuinfo fall back on sysfs and then on a core per logical CPU.
+func CPUs() (info *CPUInfo, err error) {
+	var file *os.File
+	if file, err = os.Open(path.Join(gProcRoot, "cpuinfo")); err != nil {
+		err = errors.Wrap(err, "failed to read cpu info")
+		return
+	}
+	defer file.Close()
+
+	info = &CPUInfo{CPUs: []*CPU{}}
+	var cpu *CPU
+	var model string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, val := splitProcField(scanner.Text())
+		switch key {
+		case "processor":
+			id, e := strconv.Atoi(val)
+			if e != nil {
+				continue
+			}
+			cpu = &CPU{ID: id, Socket: -1, Core: -1}
+			info.CPUs = append(info.CPUs, cpu)
+		case "model name", "Processor", "cpu model":
+			model = val
+		}
+		if cpu == nil {
+			continue
+		}
+		switch key {
+		case "physical id":
+			cpu.Socket, _ = strconv.Atoi(val)
+		case "core id":
+			cpu.Core, _ = strconv.Atoi(val)
+		case "model name", "cpu model":
+			cpu.Model = val
+		case "cpu MHz":
+			cpu.MHz, _ = strconv.ParseFloat(val, 64)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		err = errors.Wrap(err, "failed to read cpu info")
+		return
+	}
+
+	// Fill in topology missing from cpuinfo
+	for _, cpu := range info.CPUs {
+		dir := path.Join(gSysRoot, "devices", "system", "cpu", "cpu"+strconv.Itoa(cpu.ID), "topology")
+		if cpu.Socket == -1 {
+			if cpu.Socket = readSysInt(path.Join(dir, "physical_package_id")); cpu.Socket < 0 {
+				cpu.Socket = 0
+			}
+		}
+		if cpu.Core == -1 {
+			if cpu.Core = readSysInt(path.Join(dir, "core_id")); cpu.Core < 0 {
+				cpu.Core = cpu.ID
+			}
+		}
+		if cpu.Model == "" {
+			cpu.Model = model
+		}
+	}
+	sort.Slice(info.CPUs, func(i, j int) bool { return info.CPUs[i].ID < info.CPUs[j].ID })
+	return
+}
+
+// readSysInt reads a single integer from the given sysfs file returning -1 on failure
+func readSysInt(target string) int {
+	if data, err := os.ReadFile(target); err == nil {
+		if val, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return val
+		}
+	}
+	return -1
+}
+
+// readProcFields reads the given proc file of key: value or key value lines into a map
+func readProcFields(target string) (fields map[string]string, err error) {
+	var file *os.File
+	if file, err = os.Open(target); err != nil {
+		return
+	}
+	defer file.Close()
+
+	fields = map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key, val := splitProcField(scanner.Text()); key != "" {
+			fields[key] = val
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// splitProcField splits the given line into a trimmed key and value on the first colon or
+// failing that the first space
+func splitProcField(line string) (key, val string) {
+	i := strings.Index(line, ":")
+	if i == -1 {
+		i = strings.Index(line, " ")
+	}
+	if i == -1 {
+		return strings.TrimSpace(line), ""
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+}
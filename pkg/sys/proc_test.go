@@ -0,0 +1,248 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// procTest creates a fake proc and sys tree under the temp directory and points the package at
+// it until the test completes
+func procTest(t *testing.T, files map[string]string) {
+	resetTest()
+	for name, data := range files {
+		target := path.Join(tmpDir, name)
+		assert.Nil(t, os.MkdirAll(path.Dir(target), 0755))
+		assert.Nil(t, os.WriteFile(target, []byte(data), 0644))
+	}
+	procRoot, sysRoot := gProcRoot, gSysRoot
+	gProcRoot, gSysRoot = path.Join(tmpDir, "proc"), path.Join(tmpDir, "sys")
+	t.Cleanup(func() { gProcRoot, gSysRoot = procRoot, sysRoot })
+}
+
+// procStat returns a /proc/<pid>/stat line for the given values
+func procStat(pid, name, state string, ppid string) string {
+	// pid (name) state ppid pgrp session tty tpgid flags minflt cminflt majflt cmajflt utime
+	// stime cutime cstime priority nice threads itrealvalue starttime vsize rss
+	return pid + " (" + name + ") " + state + " " + ppid + " 1 1 0 -1 0 0 0 0 0 250 50 0 0 20 0 3 0 500 4096000 10"
+}
+
+func TestProcesses(t *testing.T) {
+	procTest(t, map[string]string{
+		"proc/stat":        "cpu  1 2 3\nbtime 1000\n",
+		"proc/1/stat":      procStat("1", "init", "S", "0"),
+		"proc/1/cmdline":   "/sbin/init\x00",
+		"proc/1/status":    "Name:\tinit\nUid:\t0\t0\t0\t0\n",
+		"proc/20/stat":     procStat("20", "my (app) 2", "R", "1"),
+		"proc/20/cmdline":  "/usr/bin/app\x00--flag\x00value\x00",
+		"proc/20/status":   "Name:\tapp\nUid:\t1000\t1000\t1000\t1000\n",
+		"proc/300/stat":    procStat("300", "kworker/0:1", "I", "20"),
+		"proc/300/cmdline": "",
+		"proc/bogus/stat":  "",
+	})
+
+	// list
+	{
+		procs, err := Processes()
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(procs))
+		assert.Equal(t, []int{1, 20, 300}, []int{procs[0].PID, procs[1].PID, procs[2].PID})
+
+		app := procs[1]
+		assert.Equal(t, "my (app) 2", app.Name)
+		assert.Equal(t, "R", app.State)
+		assert.Equal(t, 1, app.PPID)
+		assert.Equal(t, 1000, app.UID)
+		assert.Equal(t, []string{"/usr/bin/app", "--flag", "value"}, app.Cmdline)
+		assert.Equal(t, 3, app.Threads)
+		assert.Equal(t, int64(4096000), app.VSize)
+		assert.Equal(t, int64(10*os.Getpagesize()), app.RSS)
+		assert.Equal(t, 2500*time.Millisecond, app.UTime)
+		assert.Equal(t, 500*time.Millisecond, app.STime)
+		assert.Equal(t, 3*time.Second, app.CPUTime())
+		assert.Equal(t, time.Unix(1005, 0), app.Start)
+		assert.Equal(t, []string{}, procs[2].Cmdline)
+	}
+
+	// find
+	{
+		procs, err := ProcessesByName("app")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(procs))
+		assert.Equal(t, 20, procs[0].PID)
+
+		procs, err = ProcessesByName("kworker/0:1")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(procs))
+
+		procs, err = ProcessesByCmdline(`--flag\s+value`)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(procs))
+
+		_, err = ProcessesByCmdline(`(`)
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to compile command line expression ("))
+	}
+
+	// tree
+	{
+		proc, err := GetProcess(300)
+		assert.Nil(t, err)
+		parent, err := proc.Parent()
+		assert.Nil(t, err)
+		assert.Equal(t, 20, parent.PID)
+		ancestors, err := proc.Ancestors()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(ancestors))
+		assert.Equal(t, 1, ancestors[1].PID)
+		children, err := ancestors[1].Children()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(children))
+		assert.Equal(t, 20, children[0].PID)
+	}
+
+	// errors and map export
+	{
+		_, err := GetProcess(2)
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to read process 2"))
+
+		proc, _ := GetProcess(1)
+		m := proc.ToMap()
+		assert.Equal(t, "init", m["name"])
+		assert.Equal(t, 0, m["ppid"])
+	}
+}
+
+func TestSystemInfo(t *testing.T) {
+	procTest(t, map[string]string{
+		"proc/stat":    "cpu  1 2 3\nbtime 1000\n",
+		"proc/uptime":  "350735.47 234388.90\n",
+		"proc/loadavg": "0.75 0.35 0.25 1/25 1747\n",
+		"proc/meminfo": "MemTotal:        8000 kB\nMemFree:         1000 kB\nMemAvailable:    3000 kB\n" +
+			"Buffers:          100 kB\nCached:          1500 kB\nSwapTotal:       2000 kB\nSwapFree:        2000 kB\n",
+	})
+
+	mem, err := Memory()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8000*1024), mem.Total)
+	assert.Equal(t, int64(3000*1024), mem.Available)
+	assert.Equal(t, int64(5000*1024), mem.Used())
+	assert.Equal(t, int64(5000*1024), mem.ToMap()["used"])
+
+	load, err := Load()
+	assert.Nil(t, err)
+	assert.Equal(t, &LoadAvg{One: 0.75, Five: 0.35, Fifteen: 0.25, Running: 1, Entities: 25}, load)
+
+	uptime, err := Uptime()
+	assert.Nil(t, err)
+	assert.Equal(t, 350735*time.Second+470*time.Millisecond, uptime.Round(time.Millisecond))
+
+	boot, err := BootTime()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(1000, 0), boot)
+
+	// missing files
+	os.Remove(path.Join(gProcRoot, "loadavg"))
+	_, err = Load()
+	assert.True(t, strings.HasPrefix(err.Error(), "failed to read load averages"))
+}
+
+func TestMounts(t *testing.T) {
+	procTest(t, map[string]string{
+		"proc/self/mountinfo": "" +
+			"22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n" +
+			"35 22 0:30 /data /mnt/my\\040disk rw,nosuid - btrfs /dev/sdb\\0401 rw,subvol=/data\n" +
+			"40 22 0:5 / /dev rw master:2 shared:3 - devtmpfs udev rw,size=10k\n",
+	})
+
+	mounts, err := Mounts()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(mounts))
+	assert.Equal(t, &Mount{ID: 22, ParentID: 1, Device: "8:1", Root: "/", Path: "/", Options: "rw,relatime",
+		Type: "ext4", Source: "/dev/sda1", SuperOptions: "rw"}, mounts[0])
+	assert.Equal(t, "/mnt/my disk", mounts[1].Path)
+	assert.Equal(t, "/dev/sdb 1", mounts[1].Source)
+	assert.Equal(t, "devtmpfs", mounts[2].Type)
+	assert.Equal(t, "/dev", mounts[2].ToMap()["path"])
+
+	_, err = parseMountInfo(strings.NewReader("22 1 8:1 / / rw shared:1 ext4\n"))
+	assert.Equal(t, "invalid mountinfo at line 1", err.Error())
+
+	// usage of the filesystem the tests run on
+	stat, err := mounts[0].Statfs()
+	assert.Nil(t, err)
+	assert.Equal(t, "/", stat.Path)
+	assert.True(t, stat.Total > 0)
+	assert.True(t, stat.Available <= stat.Free)
+	assert.Equal(t, stat.Total-stat.Free, stat.Used())
+
+	_, err = Statfs(path.Join(tmpDir, "bogus"))
+	assert.True(t, strings.HasPrefix(err.Error(), "failed to query filesystem usage for"))
+}
+
+func TestCPUs(t *testing.T) {
+
+	// topology from cpuinfo
+	{
+		cpu := func(id, core string) string {
+			return "processor\t: " + id + "\nmodel name\t: Test CPU\ncpu MHz\t\t: 2400.000\nphysical id\t: 0\ncore id\t\t: " + core + "\n\n"
+		}
+		procTest(t, map[string]string{"proc/cpuinfo": cpu("0", "0") + cpu("1", "1") + cpu("2", "0") + cpu("3", "1")})
+
+		info, err := CPUs()
+		assert.Nil(t, err)
+		assert.Equal(t, 4, info.Count())
+		assert.Equal(t, 2, info.Cores())
+		assert.Equal(t, 1, info.Sockets())
+		assert.Equal(t, &CPU{ID: 2, Socket: 0, Core: 0, Model: "Test CPU", MHz: 2400}, info.CPUs[2])
+		assert.Equal(t, 4, info.ToMap()["count"])
+	}
+
+	// topology from sysfs
+	{
+		procTest(t, map[string]string{
+			"proc/cpuinfo": "processor\t: 0\nBogoMIPS\t: 50.00\n\nprocessor\t: 1\nBogoMIPS\t: 50.00\n\nProcessor\t: ARMv7\n",
+			"sys/devices/system/cpu/cpu0/topology/physical_package_id": "0\n",
+			"sys/devices/system/cpu/cpu0/topology/core_id":             "0\n",
+			"sys/devices/system/cpu/cpu1/topology/physical_package_id": "1\n",
+		})
+
+		info, err := CPUs()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, info.Count())
+		assert.Equal(t, 2, info.Sockets())
+		assert.Equal(t, &CPU{ID: 1, Socket: 1, Core: 1, Model: "ARMv7"}, info.CPUs[1])
+	}
+}
+
+func TestProcLive(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		return
+	}
+
+	proc, err := GetProcess(os.Getpid())
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getppid(), proc.PPID)
+	assert.Equal(t, os.Getuid(), proc.UID)
+	assert.True(t, proc.RSS > 0)
+	assert.True(t, proc.Start.Before(time.Now()))
+
+	procs, err := Processes()
+	assert.Nil(t, err)
+	assert.True(t, len(procs) > 0)
+
+	mem, err := Memory()
+	assert.Nil(t, err)
+	assert.True(t, mem.Total > 0)
+
+	mounts, err := Mounts()
+	assert.Nil(t, err)
+	assert.True(t, len(mounts) > 0)
+
+	info, err := CPUs()
+	assert.Nil(t, err)
+	assert.True(t, info.Count() > 0)
+}
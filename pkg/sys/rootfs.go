@@ -0,0 +1,285 @@
+package sys
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gMaxSymlinks is the maximum number of links followed while resolving a path as with Linux
+const gMaxSymlinks = 40
+
+// RootFS implements FS over an OS directory treated as the root of the filesystem e.g. a rootfs
+// being built for a disk image. Names are resolved within the root like openat2 with
+// RESOLVE_IN_ROOT i.e. absolute link targets are relative to the root and .. never leaves it,
+// without the need for a chroot or mount namespace. Resolution is done in userspace so it
+// isn't safe against the root being modified concurrently by an untrusted process.
+type RootFS struct {
+	root string // OS directory acting as the root
+}
+
+// NewRootFS creates a new RootFS for the given OS directory
+func NewRootFS(root string) *RootFS {
+	if abs, err := Abs(root); err == nil {
+		root = abs
+	}
+	return &RootFS{root: root}
+}
+
+// Root returns the OS directory acting as the root
+func (r *RootFS) Root() string {
+	return r.root
+}
+
+// Resolve returns the OS path for the given name resolving all links within the root. The
+// last element is only resolved when follow is true. Missing elements are joined as is.
+func (r *RootFS) Resolve(name string, follow bool) (result string, err error) {
+	resolved := "/"
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		if !follow && len(parts) == 0 {
+			resolved = next
+			break
+		}
+
+		// Missing elements and elements below files are taken as is for the OS to fail on
+		info, e := os.Lstat(r.path(next))
+		if e != nil || info.Mode()&os.ModeSymlink == 0 {
+			if e != nil && !os.IsNotExist(e) && !errors.Is(e, syscall.ENOTDIR) {
+				return "", e
+			}
+			resolved = next
+			continue
+		}
+		if hops++; hops > gMaxSymlinks {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+		}
+		var target string
+		if target, err = os.Readlink(r.path(next)); err != nil {
+			return
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return r.path(resolved), nil
+}
+
+// path returns the OS path for the given already resolved name
+func (r *RootFS) path(name string) string {
+	return filepath.Join(r.root, filepath.FromSlash(name))
+}
+
+// resolve returns the OS path for the given name following the last element as directed
+func (r *RootFS) resolve(op, name string, follow bool) (string, error) {
+	result, err := r.Resolve(name, follow)
+	if err != nil {
+		if _, ok := err.(*fs.PathError); !ok {
+			err = &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return result, err
+}
+
+// Chmod implements FS.Chmod
+func (r *RootFS) Chmod(name string, mode fs.FileMode) error {
+	target, err := r.resolve("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
+
+// Chtimes implements FS.Chtimes
+func (r *RootFS) Chtimes(name string, atime, mtime time.Time) error {
+	target, err := r.resolve("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(target, atime, mtime)
+}
+
+// Lstat implements FS.Lstat
+func (r *RootFS) Lstat(name string) (fs.FileInfo, error) {
+	target, err := r.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(target)
+}
+
+// Mkdir implements FS.Mkdir
+func (r *RootFS) Mkdir(name string, perm fs.FileMode) error {
+	target, err := r.resolve("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(target, perm)
+}
+
+// MkdirAll implements FS.MkdirAll
+func (r *RootFS) MkdirAll(name string, perm fs.FileMode) error {
+	target, err := r.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, perm)
+}
+
+// Open implements fs.FS
+func (r *RootFS) Open(name string) (fs.File, error) {
+	if err := fsInvalid("open", name); err != nil {
+		return nil, err
+	}
+	target, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
+
+// OpenFile implements FS.OpenFile
+func (r *RootFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	target, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(target, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (r *RootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := fsInvalid("open", name); err != nil {
+		return nil, err
+	}
+	target, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(target)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (r *RootFS) ReadFile(name string) ([]byte, error) {
+	if err := fsInvalid("open", name); err != nil {
+		return nil, err
+	}
+	target, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(target)
+}
+
+// Readlink implements FS.Readlink returning the target as is i.e. absolute targets are
+// relative to the root
+func (r *RootFS) Readlink(name string) (string, error) {
+	target, err := r.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(target)
+}
+
+// Remove implements FS.Remove
+func (r *RootFS) Remove(name string) error {
+	target, err := r.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return os.Remove(target)
+}
+
+// RemoveAll implements FS.RemoveAll
+func (r *RootFS) RemoveAll(name string) error {
+	target, err := r.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(target)
+}
+
+// Rename implements FS.Rename
+func (r *RootFS) Rename(oldname, newname string) error {
+	oldpath, err := r.resolve("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	newpath, err := r.resolve("rename", newname, false)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// Stat implements fs.StatFS
+func (r *RootFS) Stat(name string) (fs.FileInfo, error) {
+	if err := fsInvalid("stat", name); err != nil {
+		return nil, err
+	}
+	target, err := r.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(target)
+}
+
+// Symlink implements FS.Symlink creating the link with the target as is
+func (r *RootFS) Symlink(oldname, newname string) error {
+	target, err := r.resolve("symlink", newname, false)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(oldname, target)
+}
+
+// WriteFile implements FS.WriteFile
+func (r *RootFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	target, err := r.resolve("open", name, true)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(target, data, perm)
+}
+
+// fsOSPath returns the OS path for the given name in the given filesystem if it is backed by
+// the OS i.e. nil, OsFS or RootFS. The last element is only resolved when follow is true.
+func fsOSPath(fsys FS, name string, follow bool) (result string, ok bool) {
+	switch x := fsys.(type) {
+	case nil:
+		result, ok = name, true
+	case *OsFS:
+		result, ok = x.path(name), true
+	case *RootFS:
+		var err error
+		result, err = x.Resolve(name, follow)
+		return result, err == nil
+	}
+	if ok && follow {
+		var err error
+		result, err = filepath.EvalSymlinks(result)
+		ok = err == nil
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package sys
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rootFSTest creates a rootfs under the temp directory with absolute, escaping and looping links
+func rootFSTest(t *testing.T) (root string) {
+	resetTest()
+	root, _ = Abs(path.Join(tmpDir, "rootfs"))
+	assert.Nil(t, os.MkdirAll(path.Join(root, "usr/lib/modules"), 0755))
+	assert.Nil(t, os.MkdirAll(path.Join(root, "etc"), 0755))
+	assert.Nil(t, os.MkdirAll(path.Join(root, "loop"), 0755))
+	assert.Nil(t, WriteString(path.Join(root, "usr/lib/os-release"), "NAME=test"))
+	assert.Nil(t, os.Symlink("/usr/lib", path.Join(root, "lib")))
+	assert.Nil(t, os.Symlink("../usr/lib/os-release", path.Join(root, "etc/os-release")))
+	assert.Nil(t, os.Symlink("../../../../usr/lib", path.Join(root, "etc/escape")))
+	assert.Nil(t, os.Symlink("loop2", path.Join(root, "loop/loop1")))
+	assert.Nil(t, os.Symlink("/loop/loop1", path.Join(root, "loop/loop2")))
+	return
+}
+
+func TestRootFSResolve(t *testing.T) {
+	root := rootFSTest(t)
+	fsys := NewRootFS(path.Join(tmpDir, "rootfs"))
+	assert.Equal(t, root, fsys.Root())
+
+	// links resolve within the root
+	{
+		result, err := fsys.Resolve("/lib/os-release", true)
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "usr/lib/os-release"), result)
+		result, err = fsys.Resolve("etc/escape/modules", true)
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "usr/lib/modules"), result)
+		result, err = fsys.Resolve("/../../lib", true)
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "usr/lib"), result)
+		result, err = fsys.Resolve("/lib", false)
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "lib"), result)
+		result, err = fsys.Resolve("/lib/bogus/file", true)
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "usr/lib/bogus/file"), result)
+	}
+
+	// conforms to io/fs within a root free of loops
+	{
+		assert.Nil(t, fstest.TestFS(NewRootFS(path.Join(root, "usr")), "lib/os-release", "lib/modules"))
+		_, err := fsys.ReadFile("/etc/os-release")
+		assert.ErrorIs(t, err, fs.ErrInvalid)
+	}
+
+	// loops
+	{
+		_, err := fsys.Resolve("/loop/loop1", true)
+		assert.ErrorIs(t, err, syscall.ELOOP)
+		_, err = fsys.Stat("loop/loop2")
+		assert.ErrorIs(t, err, syscall.ELOOP)
+	}
+
+	// filesystem operations
+	{
+		data, err := fsys.ReadFile("etc/os-release")
+		assert.Nil(t, err)
+		assert.Equal(t, "NAME=test", string(data))
+		assert.Nil(t, fsys.WriteFile("/lib/file", []byte("data"), 0644))
+		assert.True(t, Exists(path.Join(root, "usr/lib/file")))
+		target, err := fsys.Readlink("/lib")
+		assert.Nil(t, err)
+		assert.Equal(t, "/usr/lib", target)
+		assert.Nil(t, fsys.Remove("/lib"))
+		assert.True(t, Exists(path.Join(root, "usr/lib")))
+	}
+}
+
+func TestChrootOpt(t *testing.T) {
+	root := rootFSTest(t)
+
+	// abs
+	{
+		result, err := Abs("/etc/escape/os-release", ChrootOpt(root))
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "usr/lib/os-release"), result)
+		result, err = Abs("/etc/escape", ChrootOpt(root))
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(root, "etc/escape"), result)
+		_, err = Abs("/loop/loop1/file", ChrootOpt(root))
+		assert.Equal(t, "failed to resolve /loop/loop1/file within "+root+": resolve /loop/loop1/file: too many levels of symbolic links", err.Error())
+	}
+
+	// lstat and link helpers
+	{
+		info, err := Lstat("/lib", ChrootOpt(root))
+		assert.Nil(t, err)
+		assert.Equal(t, "/lib", info.Path)
+		assert.True(t, info.IsSymlinkDir())
+		assert.True(t, info.SymlinkTargetExists())
+		target, err := info.SymlinkTarget()
+		assert.Nil(t, err)
+		assert.Equal(t, "/usr/lib", target)
+		info, err = Lstat("/etc/escape", ChrootOpt(root))
+		assert.Nil(t, err)
+		assert.True(t, info.IsSymlinkDir())
+		assert.False(t, IsSymlinkDir(path.Join(root, "etc/escape")))
+	}
+
+	// walk following links within the root
+	{
+		paths, err := AllPaths("/etc", ChrootOpt(root), FollowOpt(true))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"/etc", "/etc/escape", "/usr/lib", "/usr/lib/modules", "/usr/lib/os-release",
+			"/etc/os-release"}, paths)
+		_, err = AllPaths("/loop", ChrootOpt(root), FollowOpt(true))
+		assert.ErrorIs(t, err, syscall.ELOOP)
+	}
+
+	// copy following links within the root preserving attributes
+	{
+		assert.Nil(t, SetXattr(path.Join(root, "usr/lib/os-release"), "user.test", []byte("value")))
+		assert.Nil(t, Copy("/etc/os-release", "/etc/copy", ChrootOpt(root), FollowOpt(true), PreserveOpt(PreserveXattrs)))
+		data, err := ReadString(path.Join(root, "etc/copy"))
+		assert.Nil(t, err)
+		assert.Equal(t, "NAME=test", data)
+		val, err := GetXattr(path.Join(root, "etc/copy"), "user.test")
+		assert.Nil(t, err)
+		assert.Equal(t, "value", string(val))
+
+		assert.Nil(t, Copy("/lib", "/opt", ChrootOpt(root)))
+		target, err := SymlinkTarget(path.Join(root, "opt"))
+		assert.Nil(t, err)
+		assert.Equal(t, "/usr/lib", target)
+	}
+}
@@ -0,0 +1,465 @@
+package sys
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// shellLexer provides POSIX sh compatible word splitting, quote removal and parameter
+// expansion for a single string. Expansion is only performed when an env map is given.
+type shellLexer struct {
+	runes []rune            // runes to process
+	pos   int               // current position in the runes
+	env   map[string]string // variables to expand against, nil disables expansion
+	split bool              // split into words on whitespace when true
+	ops   bool              // treat unquoted | < > & as operator tokens when true
+}
+
+// shellToken provides a single word or operator produced by the shellLexer
+type shellToken struct {
+	val string // word after quote removal and expansion or the operator e.g. | > >> 2>&1
+	op  bool   // true if the token is an unquoted operator
+}
+
+// ExpandVars expands the $VAR, ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:+alt},
+// ${VAR+alt}, ${VAR:?msg} and ${VAR?msg} forms in the given string against the given env
+// map. Unlike SplitWords no quote removal or word splitting is done. Unset variables
+// expand to the empty string.
+func ExpandVars(str string, env map[string]string) (result string, err error) {
+	if env == nil {
+		env = map[string]string{}
+	}
+	l := &shellLexer{runes: []rune(str), env: env}
+
+	var val string
+	var out []rune
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		if r == '$' {
+			if val, err = l.expand(); err != nil {
+				return
+			}
+			out = append(out, []rune(val)...)
+			continue
+		}
+		out = append(out, r)
+		l.pos++
+	}
+	result = string(out)
+	return
+}
+
+// JoinWords quotes each of the given words as needed and joins them with a space such that
+// SplitWords would return the original words.
+func JoinWords(words []string) string {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, Quote(word))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quote returns a version of the given string that is safe to use as a single word in a
+// POSIX shell. Strings made up entirely of safe characters are returned as is, all others
+// are wrapped in single quotes with embedded single quotes escaped.
+func Quote(str string) string {
+	if str == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range str {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return str
+	}
+	return "'" + strings.ReplaceAll(str, "'", `'"'"'`) + "'"
+}
+
+// SplitWords splits the given string into words following POSIX sh rules.
+// * Unquoted whitespace separates words
+// * Backslash escapes the following character and backslash newline is a line continuation
+// * Single quotes preserve everything literally up to the next single quote
+// * Double quotes preserve everything except $ expansion and the \$ \` \" \\ escapes
+// * Adjacent quoted and unquoted segments form a single word e.g. a"b c"d => ab cd
+// * Empty quotes produce an empty word
+// * Unquoted expansions are split into separate words on whitespace
+// Expansion of the forms supported by ExpandVars is only done when an env map is given
+// otherwise $ is taken literally. Unterminated quotes, escapes and expansions return an error.
+func SplitWords(str string, env ...map[string]string) (words []string, err error) {
+	l := &shellLexer{runes: []rune(str), split: true}
+	if len(env) > 0 {
+		l.env = env[0]
+		if l.env == nil {
+			l.env = map[string]string{}
+		}
+	}
+	return l.words()
+}
+
+// words processes the runes into a list of words
+func (l *shellLexer) words() (words []string, err error) {
+	var tokens []shellToken
+	if tokens, err = l.tokens(); err != nil {
+		return
+	}
+	words = make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		words = append(words, token.val)
+	}
+	return
+}
+
+// tokens processes the runes into a list of words and if enabled operators
+func (l *shellLexer) tokens() (tokens []shellToken, err error) {
+	word := []rune{}
+	inWord := false
+	tokens = []shellToken{}
+
+	// Complete the current word if one is in progress
+	complete := func() {
+		if inWord {
+			tokens = append(tokens, shellToken{val: string(word)})
+			word = word[:0]
+			inWord = false
+		}
+	}
+
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		switch {
+
+		// Unquoted operators and redirects with a file descriptor prefix e.g. 2>
+		case l.ops && (strings.ContainsRune("|<>&", r) || (!inWord && isShellDigit(r) &&
+			l.pos+1 < len(l.runes) && strings.ContainsRune("<>", l.runes[l.pos+1]))):
+			complete()
+			var op string
+			if op, err = l.operator(); err != nil {
+				return
+			}
+			tokens = append(tokens, shellToken{val: op, op: true})
+
+		// Unquoted whitespace
+		case l.split && isShellSpace(r):
+			complete()
+			l.pos++
+
+		// Escape the next rune
+		case r == '\\':
+			l.pos++
+			if l.pos >= len(l.runes) {
+				err = errors.Errorf("unterminated escape at end of input")
+				return
+			}
+			if l.runes[l.pos] != '\n' {
+				word = append(word, l.runes[l.pos])
+				inWord = true
+			}
+			l.pos++
+
+		// Single quotes preserve everything literally
+		case r == '\'':
+			start := l.pos
+			l.pos++
+			end := l.index('\'')
+			if end < 0 {
+				err = errors.Errorf("unterminated single quote at offset %d", start)
+				return
+			}
+			word = append(word, l.runes[l.pos:end]...)
+			inWord = true
+			l.pos = end + 1
+
+		// Double quotes preserve everything but expansions and a few escapes
+		case r == '"':
+			var quoted []rune
+			if quoted, err = l.doubleQuoted(); err != nil {
+				return
+			}
+			word = append(word, quoted...)
+			inWord = true
+
+		// Unquoted expansion is subject to word splitting
+		case r == '$' && l.env != nil:
+			var val string
+			if val, err = l.expand(); err != nil {
+				return
+			}
+			for _, x := range val {
+				if l.split && isShellSpace(x) {
+					complete()
+					continue
+				}
+				word = append(word, x)
+				inWord = true
+			}
+
+		default:
+			word = append(word, r)
+			inWord = true
+			l.pos++
+		}
+	}
+	complete()
+
+	return
+}
+
+// operator reads a pipe or redirect operator from the current position. Supported forms are
+// |, <, >, >> and >&N each optionally prefixed with a single digit file descriptor.
+func (l *shellLexer) operator() (op string, err error) {
+	start := l.pos
+	if isShellDigit(l.runes[l.pos]) {
+		l.pos++
+	}
+	r := l.runes[l.pos]
+	l.pos++
+	next := rune(0)
+	if l.pos < len(l.runes) {
+		next = l.runes[l.pos]
+	}
+
+	switch {
+	case r == '|' && next == '|', r == '&' && next == '&':
+		l.pos++
+		err = errors.Errorf("unsupported operator %q at offset %d", string(l.runes[start:l.pos]), start)
+	case r == '&':
+		err = errors.Errorf("unsupported operator %q at offset %d", string(l.runes[start:l.pos]), start)
+	case r == '>' && next == '>':
+		l.pos++
+	case r == '>' && next == '&':
+		l.pos++
+		if l.pos >= len(l.runes) || !isShellDigit(l.runes[l.pos]) {
+			err = errors.Errorf("invalid file descriptor for operator %q at offset %d", string(l.runes[start:l.pos]), start)
+			return
+		}
+		l.pos++
+	}
+	op = string(l.runes[start:l.pos])
+	return
+}
+
+// doubleQuoted processes a double quoted segment starting at the current opening quote
+func (l *shellLexer) doubleQuoted() (result []rune, err error) {
+	start := l.pos
+	l.pos++
+	result = []rune{}
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		switch {
+		case r == '"':
+			l.pos++
+			return
+
+		// Within double quotes backslash only escapes $ ` " \ and newline
+		case r == '\\' && l.pos+1 < len(l.runes):
+			next := l.runes[l.pos+1]
+			switch next {
+			case '$', '`', '"', '\\':
+				result = append(result, next)
+			case '\n':
+			default:
+				result = append(result, r, next)
+			}
+			l.pos += 2
+
+		case r == '$' && l.env != nil:
+			var val string
+			if val, err = l.expand(); err != nil {
+				return
+			}
+			result = append(result, []rune(val)...)
+
+		default:
+			result = append(result, r)
+			l.pos++
+		}
+	}
+	err = errors.Errorf("unterminated double quote at offset %d", start)
+	return
+}
+
+// expand processes a parameter expansion starting at the current $
+func (l *shellLexer) expand() (result string, err error) {
+	start := l.pos
+	l.pos++
+
+	// A lone $ is taken literally
+	if l.pos >= len(l.runes) {
+		result = "$"
+		return
+	}
+
+	// Simple $VAR or $1 form
+	r := l.runes[l.pos]
+	if r != '{' {
+		if isShellDigit(r) {
+			l.pos++
+			result = l.env[string(r)]
+			return
+		}
+		name := l.name()
+		if name == "" {
+			result = "$"
+			return
+		}
+		result = l.env[name]
+		return
+	}
+
+	// Braced ${VAR} form with optional operator and word
+	l.pos++
+	name := l.name()
+	if name == "" {
+		err = errors.Errorf("invalid parameter name in expansion at offset %d", start)
+		return
+	}
+	if l.pos >= len(l.runes) {
+		err = errors.Errorf("unterminated parameter expansion at offset %d", start)
+		return
+	}
+	if l.runes[l.pos] == '}' {
+		l.pos++
+		result = l.env[name]
+		return
+	}
+
+	// Parse the operator
+	colon := false
+	if l.runes[l.pos] == ':' {
+		colon = true
+		l.pos++
+	}
+	if l.pos >= len(l.runes) {
+		err = errors.Errorf("unterminated parameter expansion at offset %d", start)
+		return
+	}
+	op := l.runes[l.pos]
+	if op != '-' && op != '+' && op != '?' {
+		err = errors.Errorf("unsupported parameter expansion operator %q at offset %d", op, l.pos)
+		return
+	}
+	l.pos++
+
+	// Find the matching closing brace taking nesting and quotes into account
+	end := l.closingBrace()
+	if end < 0 {
+		err = errors.Errorf("unterminated parameter expansion at offset %d", start)
+		return
+	}
+	raw := l.runes[l.pos:end]
+	l.pos = end + 1
+
+	// Determine if the variable is considered set
+	val, ok := l.env[name]
+	set := ok && (!colon || val != "")
+
+	// Process the word only when it will be used as the word itself may contain expansions
+	word := func() (string, error) {
+		sub := &shellLexer{runes: raw, env: l.env}
+		words, e := sub.words()
+		return strings.Join(words, ""), e
+	}
+	switch op {
+	case '-':
+		if set {
+			result = val
+		} else {
+			result, err = word()
+		}
+	case '+':
+		if set {
+			result, err = word()
+		}
+	case '?':
+		if set {
+			result = val
+		} else {
+			var msg string
+			if msg, err = word(); err != nil {
+				return
+			}
+			if msg == "" {
+				msg = "parameter null or not set"
+			}
+			err = errors.Errorf("%s: %s", name, msg)
+		}
+	}
+	return
+}
+
+// closingBrace returns the index of the brace closing the current expansion or -1 skipping
+// nested braces, escaped runes and braces in single or double quotes
+func (l *shellLexer) closingBrace() int {
+	depth := 0
+	for i := l.pos; i < len(l.runes); i++ {
+		switch l.runes[i] {
+		case '\\':
+			i++
+		case '\'':
+			for i++; i < len(l.runes) && l.runes[i] != '\''; i++ {
+			}
+		case '"':
+			for i++; i < len(l.runes) && l.runes[i] != '"'; i++ {
+				if l.runes[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// index returns the index of the next occurrence of the given rune or -1
+func (l *shellLexer) index(r rune) int {
+	for i := l.pos; i < len(l.runes); i++ {
+		if l.runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// name reads a variable name from the current position
+func (l *shellLexer) name() string {
+	start := l.pos
+	for l.pos < len(l.runes) {
+		r := l.runes[l.pos]
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (l.pos > start && isShellDigit(r)) {
+			l.pos++
+			continue
+		}
+		break
+	}
+	return string(l.runes[start:l.pos])
+}
+
+// isShellDigit returns true if the given rune is an ascii digit
+func isShellDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// isShellSafe returns true if the given rune doesn't require quoting in a shell
+func isShellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', isShellDigit(r):
+		return true
+	}
+	return strings.ContainsRune("@%+=:,./-_", r)
+}
+
+// isShellSpace returns true if the given rune is a default IFS whitespace rune
+func isShellSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n'
+}
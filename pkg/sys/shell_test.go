@@ -0,0 +1,175 @@
+package sys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandVars(t *testing.T) {
+	env := map[string]string{"FOO": "foo", "EMPTY": "", "SPACES": "a b"}
+
+	// simple forms
+	{
+		result, err := ExpandVars("$FOO/${FOO}bar/$BOGUS/$", env)
+		assert.Nil(t, err)
+		assert.Equal(t, "foo/foobar//$", result)
+	}
+
+	// defaults and alternates
+	{
+		result, err := ExpandVars("${BOGUS:-def} ${EMPTY:-def} ${EMPTY-def} ${FOO:+alt} ${BOGUS+alt}", env)
+		assert.Nil(t, err)
+		assert.Equal(t, "def def  alt ", result)
+	}
+
+	// nested default
+	{
+		result, err := ExpandVars("${BOGUS:-${FOO}/bar}", env)
+		assert.Nil(t, err)
+		assert.Equal(t, "foo/bar", result)
+		result, err = ExpandVars(`${BOGUS:-"a}b"}`, env)
+		assert.Nil(t, err)
+		assert.Equal(t, "a}b", result)
+		result, err = ExpandVars(`${BOGUS:-"a\"}b"}`, env)
+		assert.Nil(t, err)
+		assert.Equal(t, `a"}b`, result)
+	}
+
+	// quotes and whitespace are retained
+	{
+		result, err := ExpandVars(`"$SPACES" '$FOO'`, env)
+		assert.Nil(t, err)
+		assert.Equal(t, `"a b" 'foo'`, result)
+	}
+
+	// required variables
+	{
+		_, err := ExpandVars("${BOGUS:?must be set}", env)
+		assert.Equal(t, "BOGUS: must be set", err.Error())
+		_, err = ExpandVars("${EMPTY?}", env)
+		assert.Nil(t, err)
+		_, err = ExpandVars("${EMPTY:?}", env)
+		assert.Equal(t, "EMPTY: parameter null or not set", err.Error())
+	}
+
+	// invalid expansions
+	{
+		_, err := ExpandVars("${FOO", env)
+		assert.Equal(t, "unterminated parameter expansion at offset 0", err.Error())
+		_, err = ExpandVars("${FOO:-bar", env)
+		assert.Equal(t, "unterminated parameter expansion at offset 0", err.Error())
+		_, err = ExpandVars("${}", env)
+		assert.Equal(t, "invalid parameter name in expansion at offset 0", err.Error())
+		_, err = ExpandVars("${FOO#bar}", env)
+		assert.Equal(t, "unsupported parameter expansion operator '#' at offset 5", err.Error())
+	}
+}
+
+func TestJoinWords(t *testing.T) {
+	words := []string{"echo", "hello world", "it's", "", "$HOME"}
+	assert.Equal(t, `echo 'hello world' 'it'"'"'s' '' '$HOME'`, JoinWords(words))
+
+	// round trip
+	result, err := SplitWords(JoinWords(words))
+	assert.Nil(t, err)
+	assert.Equal(t, words, result)
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "''", Quote(""))
+	assert.Equal(t, "foo", Quote("foo"))
+	assert.Equal(t, "/usr/bin/foo-bar_1.2,a=b:c@d%e+f", Quote("/usr/bin/foo-bar_1.2,a=b:c@d%e+f"))
+	assert.Equal(t, "'foo bar'", Quote("foo bar"))
+	assert.Equal(t, `'it'"'"'s'`, Quote("it's"))
+	assert.Equal(t, `'a;b|c&d'`, Quote("a;b|c&d"))
+	assert.Equal(t, `'*.go'`, Quote("*.go"))
+}
+
+func TestSplitWords(t *testing.T) {
+
+	// whitespace
+	{
+		result, err := SplitWords("")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, result)
+
+		result, err = SplitWords(" \t\n ")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, result)
+
+		result, err = SplitWords("  foo \t bar\nblah ")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"foo", "bar", "blah"}, result)
+	}
+
+	// quotes are not greedy across multiple args
+	{
+		result, err := SplitWords(` arg1 arg2 '   hello    world' "  another hello world   " 'a' 'b' `)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"arg1", "arg2", "   hello    world", "  another hello world   ", "a", "b"}, result)
+	}
+
+	// adjacent quoted segments
+	{
+		result, err := SplitWords(`a"b c"d 'e'"f"g`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"ab cd", "efg"}, result)
+	}
+
+	// empty quotes produce empty words
+	{
+		result, err := SplitWords(`foo "" '' bar`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"foo", "", "", "bar"}, result)
+	}
+
+	// escapes
+	{
+		result, err := SplitWords(`foo\ bar \'a\" \\ line\` + "\n" + `cont`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"foo bar", `'a"`, `\`, "linecont"}, result)
+
+		result, err = SplitWords(`"a\"b\\c\$d\e" 'a\b'`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{`a"b\c$d\e`, `a\b`}, result)
+	}
+
+	// no env means no expansion
+	{
+		result, err := SplitWords(`bash -c 'ls -la ${DIR}' | exec "$FOO"`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"bash", "-c", "ls -la ${DIR}", "|", "exec", "$FOO"}, result)
+	}
+
+	// expansion with env
+	{
+		env := map[string]string{"FOO": "foo", "SPACES": " a  b ", "DIR": "/tmp"}
+		result, err := SplitWords(`ls $DIR '$DIR' "$DIR/x" ${BOGUS:-"def val"} pre$SPACES "$SPACES" $BOGUS`, env)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"ls", "/tmp", "$DIR", "/tmp/x", "def", "val", "pre", "a", "b", " a  b "}, result)
+
+		result, err = SplitWords(`"${BOGUS:-"def val"}" \$FOO`, env)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"def val", "$FOO"}, result)
+	}
+
+	// nil env still enables expansion
+	{
+		result, err := SplitWords(`a$FOO b`, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, result)
+	}
+
+	// errors
+	{
+		_, err := SplitWords(`foo 'bar`)
+		assert.Equal(t, "unterminated single quote at offset 4", err.Error())
+		_, err = SplitWords(`foo "bar`)
+		assert.Equal(t, "unterminated double quote at offset 4", err.Error())
+		_, err = SplitWords(`foo\`)
+		assert.Equal(t, "unterminated escape at end of input", err.Error())
+		_, err = SplitWords(`${FOO`, map[string]string{})
+		assert.Equal(t, "unterminated parameter expansion at offset 0", err.Error())
+	}
+}
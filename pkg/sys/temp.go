@@ -0,0 +1,290 @@
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phR0ze/n/pkg/opt"
+	yaml "github.com/phR0ze/yaml/v2"
+	"github.com/pkg/errors"
+)
+
+// TB provides the subset of testing.TB used to register cleanup and fail a test or benchmark
+// without the testing package being imported outside of tests
+type TB interface {
+	Cleanup(func())
+	Fatal(args ...interface{})
+	Helper()
+}
+
+// TmpDir is a temporary directory removed along with its content by Close or Cleanup
+type TmpDir struct {
+	Path string // absolute path of the directory
+}
+
+// TempDir creates a new temporary directory returning a handle for cleaning it up.
+// * The last element of the pattern is used as in os.MkdirTemp e.g. foo-* for foo-123456
+// * The directory is created in the directory of the pattern if given else in os.TempDir()
+// * Registers Cleanup with the test and fails it on error by passing in TBOpt(t)
+func TempDir(pattern string, opts ...*opt.Opt) (dir *TmpDir, err error) {
+	tb := getTBOpt(opts)
+	if tb != nil {
+		tb.Helper()
+	}
+	var parent string
+	if parent, pattern, err = tempPattern(pattern); err == nil {
+		var target string
+		if target, err = os.MkdirTemp(parent, pattern); err != nil {
+			err = errors.Wrapf(err, "failed to create temp directory in %s", parent)
+		} else {
+			dir = &TmpDir{Path: target}
+		}
+	}
+	if tb != nil {
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(dir.Cleanup)
+	}
+	return
+}
+
+// Cleanup removes the directory and its content ignoring errors for use with defer or
+// testing.TB.Cleanup
+func (d *TmpDir) Cleanup() {
+	d.Close()
+}
+
+// Close removes the directory and its content
+func (d *TmpDir) Close() (err error) {
+	if err = RemoveAll(d.Path); err != nil {
+		err = errors.Wrapf(err, "failed to remove temp directory %s", d.Path)
+	}
+	return
+}
+
+// Fixture creates the tree described by the spec within the directory see Fixture
+func (d *TmpDir) Fixture(spec interface{}) error {
+	return Fixture(d.Path, spec)
+}
+
+// Join returns the path of the given elements within the directory
+func (d *TmpDir) Join(elems ...string) string {
+	return path.Join(append([]string{d.Path}, elems...)...)
+}
+
+// TmpFile is an open temporary file closed and removed by Close or Cleanup
+type TmpFile struct {
+	*os.File
+	Path string // absolute path of the file
+}
+
+// TempFile creates and opens a new temporary file for reading and writing returning a handle
+// for cleaning it up.
+// * The last element of the pattern is used as in os.CreateTemp e.g. foo-*.txt for foo-123456.txt
+// * The file is created in the directory of the pattern if given else in os.TempDir()
+// * Registers Cleanup with the test and fails it on error by passing in TBOpt(t)
+func TempFile(pattern string, opts ...*opt.Opt) (file *TmpFile, err error) {
+	tb := getTBOpt(opts)
+	if tb != nil {
+		tb.Helper()
+	}
+	var parent string
+	if parent, pattern, err = tempPattern(pattern); err == nil {
+		var f *os.File
+		if f, err = os.CreateTemp(parent, pattern); err != nil {
+			err = errors.Wrapf(err, "failed to create temp file in %s", parent)
+		} else {
+			file = &TmpFile{File: f, Path: f.Name()}
+		}
+	}
+	if tb != nil {
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(file.Cleanup)
+	}
+	return
+}
+
+// Cleanup closes and removes the file ignoring errors for use with defer or testing.TB.Cleanup
+func (f *TmpFile) Cleanup() {
+	f.Close()
+}
+
+// Close closes and removes the file. Closing a file already closed is not an error.
+func (f *TmpFile) Close() (err error) {
+	if err = f.File.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return errors.Wrapf(err, "failed to close temp file %s", f.Path)
+	}
+	if err = os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove temp file %s", f.Path)
+	}
+	return nil
+}
+
+// tempPattern splits the given pattern into the absolute parent directory and name pattern
+func tempPattern(pattern string) (parent, name string, err error) {
+	parent, name = path.Split(pattern)
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	parent, err = Abs(parent)
+	return
+}
+
+// Fixture creates the tree described by the spec within the given directory e.g. for tests. The
+// spec is a map of slash separated paths relative to the directory to the entry to create, as
+// a yaml.MapSlice e.g. a StringMap, a map[string]interface{} created in sorted order or a YAML
+// document as a string or []byte.
+// * A string value creates a file with the string as its content
+// * A nil value or a path ending in a slash creates a directory
+// * A map value describes the entry with the keys data, dir, link and mode e.g. {link: ../lib}
+// * Parent directories are created as needed with 0755 and files with 0644 unless given a mode
+// * Modes are applied once the tree is created so read only directories can be described
+func Fixture(dir string, spec interface{}) (err error) {
+	if dir, err = Abs(dir); err != nil {
+		return
+	}
+	var entries yaml.MapSlice
+	if entries, err = fixtureEntries(spec); err != nil {
+		return
+	}
+
+	modes := map[string]os.FileMode{}
+	for _, entry := range entries {
+		name := fmt.Sprint(entry.Key)
+		target := path.Join(dir, path.Clean("/"+name))
+		isDir := strings.HasSuffix(name, "/")
+		var data, link string
+		var mode os.FileMode
+
+		// Decode the entry
+		switch x := entry.Value.(type) {
+		case nil:
+			isDir = true
+		case string:
+			data = x
+		default:
+			var attrs yaml.MapSlice
+			if attrs, err = fixtureEntries(x); err != nil {
+				return errors.Wrapf(err, "invalid fixture entry %s", name)
+			}
+			for _, attr := range attrs {
+				switch key := fmt.Sprint(attr.Key); key {
+				case "data":
+					if attr.Value != nil {
+						data = fmt.Sprint(attr.Value)
+					}
+				case "dir":
+					isDir, _ = attr.Value.(bool)
+				case "link":
+					link = fmt.Sprint(attr.Value)
+				case "mode":
+					if mode, err = fixtureMode(attr.Value); err != nil {
+						return errors.Wrapf(err, "invalid fixture entry %s", name)
+					}
+				default:
+					return errors.Errorf("invalid fixture entry %s: unknown key %s", name, key)
+				}
+			}
+		}
+
+		// Create the entry
+		if isDir {
+			err = os.MkdirAll(target, 0755)
+		} else if err = os.MkdirAll(path.Dir(target), 0755); err == nil {
+			if link != "" {
+				err = os.Symlink(link, target)
+			} else {
+				err = os.WriteFile(target, []byte(data), 0644)
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to create fixture entry %s", name)
+		}
+		if mode != 0 && link == "" {
+			modes[target] = mode
+		}
+	}
+
+	// Apply modes deepest first so restrictive directories don't block their content
+	targets := []string{}
+	for target := range modes {
+		targets = append(targets, target)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(targets)))
+	for _, target := range targets {
+		if err = os.Chmod(target, modes[target]); err != nil {
+			return errors.Wrapf(err, "failed to set mode of fixture entry %s", target)
+		}
+	}
+	return
+}
+
+// fixtureEntries converts the given spec into an ordered list of entries
+func fixtureEntries(spec interface{}) (entries yaml.MapSlice, err error) {
+	switch x := spec.(type) {
+	case nil:
+		return yaml.MapSlice{}, nil
+	case string:
+		err = yaml.Unmarshal([]byte(x), &entries)
+	case []byte:
+		err = yaml.Unmarshal(x, &entries)
+	case map[string]interface{}:
+		for key, val := range x {
+			entries = append(entries, yaml.MapItem{Key: key, Value: val})
+		}
+	case map[string]string:
+		for key, val := range x {
+			entries = append(entries, yaml.MapItem{Key: key, Value: val})
+		}
+	case map[interface{}]interface{}:
+		for key, val := range x {
+			entries = append(entries, yaml.MapItem{Key: fmt.Sprint(key), Value: val})
+		}
+	default:
+		// Handle yaml.MapSlice and types based on it like StringMap
+		v := reflect.Indirect(reflect.ValueOf(spec))
+		if t := reflect.TypeOf(entries); v.IsValid() && v.Type().ConvertibleTo(t) {
+			entries = v.Convert(t).Interface().(yaml.MapSlice)
+		} else {
+			err = errors.Errorf("unsupported fixture spec type %T", spec)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse fixture spec")
+	}
+
+	// Go maps are unordered so sort them
+	if reflect.TypeOf(spec).Kind() == reflect.Map {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Key.(string) < entries[j].Key.(string) })
+	}
+	return
+}
+
+// fixtureMode converts the given YAML or Go mode value into a file mode. Strings are octal.
+func fixtureMode(val interface{}) (mode os.FileMode, err error) {
+	switch x := val.(type) {
+	case os.FileMode:
+		mode = x
+	case int:
+		mode = os.FileMode(x)
+	case uint32:
+		mode = os.FileMode(x)
+	case string:
+		var m uint64
+		if m, err = strconv.ParseUint(x, 8, 32); err != nil {
+			return 0, errors.Errorf("invalid mode %s", x)
+		}
+		mode = os.FileMode(m)
+	default:
+		err = errors.Errorf("invalid mode %v", val)
+	}
+	return
+}
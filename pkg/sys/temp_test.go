@@ -0,0 +1,136 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	yaml "github.com/phR0ze/yaml/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTempDir(t *testing.T) {
+	resetTest()
+
+	// create and close
+	{
+		dir, err := TempDir(path.Join(tmpDir, "foo-*"))
+		assert.Nil(t, err)
+		assert.True(t, path.IsAbs(dir.Path))
+		assert.True(t, strings.HasPrefix(path.Base(dir.Path), "foo-"))
+		assert.True(t, IsDir(dir.Path))
+		assert.Nil(t, WriteString(dir.Join("file"), "data"))
+		assert.Nil(t, dir.Close())
+		assert.False(t, Exists(dir.Path))
+		assert.Nil(t, dir.Close())
+	}
+
+	// default to the os temp directory
+	{
+		dir, err := TempDir("")
+		assert.Nil(t, err)
+		defer dir.Cleanup()
+		assert.Equal(t, os.TempDir(), path.Dir(dir.Path))
+	}
+
+	// registered with the test
+	{
+		var target string
+		t.Run("sub", func(t *testing.T) {
+			dir, _ := TempDir(path.Join(tmpDir, "*"), TBOpt(t))
+			target = dir.Path
+			assert.True(t, IsDir(target))
+		})
+		assert.False(t, Exists(target))
+	}
+
+	// failures
+	{
+		_, err := TempDir(path.Join(tmpDir, "bogus", "*"))
+		abs, _ := Abs(path.Join(tmpDir, "bogus"))
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to create temp directory in "+abs+": "))
+	}
+}
+
+func TestTempFile(t *testing.T) {
+	resetTest()
+
+	// create and close
+	{
+		file, err := TempFile(path.Join(tmpDir, "foo-*.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, ".txt", path.Ext(file.Path))
+		_, err = file.WriteString("data")
+		assert.Nil(t, err)
+		assert.Nil(t, file.Sync())
+		data, err := ReadString(file.Path)
+		assert.Nil(t, err)
+		assert.Equal(t, "data", data)
+		assert.Nil(t, file.Close())
+		assert.False(t, Exists(file.Path))
+		assert.Nil(t, file.Close())
+	}
+
+	// registered with the test
+	{
+		var target string
+		t.Run("sub", func(t *testing.T) {
+			file, _ := TempFile(path.Join(tmpDir, "*"), TBOpt(t))
+			target = file.Path
+			assert.True(t, IsFile(target))
+		})
+		assert.False(t, Exists(target))
+	}
+}
+
+func TestFixture(t *testing.T) {
+	resetTest()
+
+	// yaml
+	{
+		dir, _ := TempDir(path.Join(tmpDir, "*"), TBOpt(t))
+		err := dir.Fixture(`
+bin/run: {data: "#!/bin/sh", mode: 0755}
+etc/hosts: 127.0.0.1 localhost
+lib: {link: usr/lib}
+usr/lib/:
+var/empty: {dir: true, mode: "0700"}
+`)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0755), Mode(dir.Join("bin/run")).Perm())
+		data, _ := ReadString(dir.Join("etc/hosts"))
+		assert.Equal(t, "127.0.0.1 localhost", data)
+		assert.True(t, IsSymlinkDir(dir.Join("lib")))
+		assert.Equal(t, os.ModeDir|0700, Mode(dir.Join("var/empty")))
+		paths, _ := AllPaths(dir.Path)
+		assert.Equal(t, 10, len(paths))
+	}
+
+	// maps
+	{
+		dir, _ := TempDir(path.Join(tmpDir, "*"), TBOpt(t))
+		assert.Nil(t, Fixture(dir.Path, map[string]interface{}{"a/b": "b", "a": nil, "../../c": "c"}))
+		assert.Nil(t, Fixture(dir.Path, map[string]string{"d": "d"}))
+		files, _ := AllFiles(dir.Path)
+		assert.Equal(t, []string{dir.Join("a/b"), dir.Join("c"), dir.Join("d")}, files)
+
+		// types based on yaml.MapSlice e.g. StringMap
+		type stringMap yaml.MapSlice
+		spec := &stringMap{{Key: "ro", Value: map[string]interface{}{"dir": true, "mode": 0555}}, {Key: "ro/file", Value: "data"}}
+		assert.Nil(t, Fixture(dir.Path, spec))
+		assert.Equal(t, os.FileMode(0555), Mode(dir.Join("ro")).Perm())
+		assert.True(t, IsFile(dir.Join("ro/file")))
+		assert.Nil(t, os.Chmod(dir.Join("ro"), 0755))
+	}
+
+	// failures
+	{
+		err := Fixture(tmpDir, 5)
+		assert.Equal(t, "failed to parse fixture spec: unsupported fixture spec type int", err.Error())
+		err = Fixture(tmpDir, map[string]interface{}{"a": map[string]interface{}{"bogus": 1}})
+		assert.Equal(t, "invalid fixture entry a: unknown key bogus", err.Error())
+		err = Fixture(tmpDir, map[string]interface{}{"a": map[string]interface{}{"mode": "rwx"}})
+		assert.Equal(t, "invalid fixture entry a: invalid mode rwx", err.Error())
+	}
+}
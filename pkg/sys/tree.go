@@ -0,0 +1,300 @@
+package sys
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+)
+
+// gPartialHashSize is the number of bytes read from the start and end of a file for its
+// partial hash when finding duplicates
+const gPartialHashSize = 4096
+
+const (
+	// TreeOnlyA indicates a path compared only exists in the first tree
+	TreeOnlyA = "only_a"
+
+	// TreeOnlyB indicates a path compared only exists in the second tree
+	TreeOnlyB = "only_b"
+
+	// TreeDiffer indicates a path compared exists in both trees but differs
+	TreeDiffer = "differ"
+)
+
+// FindDuplicates finds the regular files under the given roots with identical content
+// returning a map per group of duplicates with the keys size, hash and paths. Candidates are
+// grouped by size first then by a partial hash of their first and last 4KiB and finally by a
+// full sha256 hash. Groups are ordered by size descending then by first path. Empty files
+// are ignored, links are not followed and hard links to the same file are only counted once.
+func FindDuplicates(roots ...string) (result []map[string]interface{}, err error) {
+	result = []map[string]interface{}{}
+
+	// Group by size skipping hard links to files already seen
+	bySize := map[int64][]string{}
+	inodes := map[[2]uint64]bool{}
+	for _, root := range roots {
+		if root, err = Abs(root); err != nil {
+			return
+		}
+		err = Walk(root, func(p string, info *FileInfo, e error) error {
+			if e != nil {
+				return e
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				key := [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}
+				if inodes[key] {
+					return nil
+				}
+				inodes[key] = true
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], p)
+			return nil
+		}, FollowOpt(false))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to find duplicates in %s", root)
+			return
+		}
+	}
+
+	for size, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+
+		// Narrow by partial then full hash
+		for _, partials := range groupBy(paths, func(p string) (string, error) { return partialHash(p, size) }) {
+			if len(partials) < 2 {
+				continue
+			}
+			var groups map[string][]string
+			if groups, err = groupByHash(partials); err != nil {
+				return
+			}
+			for hash, dupes := range groups {
+				if len(dupes) > 1 {
+					sort.Strings(dupes)
+					result = append(result, map[string]interface{}{"size": size, "hash": hash, "paths": dupes})
+				}
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i]["size"].(int64) != result[j]["size"].(int64) {
+			return result[i]["size"].(int64) > result[j]["size"].(int64)
+		}
+		return result[i]["paths"].([]string)[0] < result[j]["paths"].([]string)[0]
+	})
+	return
+}
+
+// groupBy groups the given paths by the key computed for each skipping paths that fail
+func groupBy(paths []string, key func(p string) (string, error)) (groups map[string][]string) {
+	groups = map[string][]string{}
+	for _, p := range paths {
+		if k, err := key(p); err == nil {
+			groups[k] = append(groups[k], p)
+		}
+	}
+	return
+}
+
+// groupByHash groups the given paths by their full sha256 hash
+func groupByHash(paths []string) (groups map[string][]string, err error) {
+	groups = map[string][]string{}
+	for _, p := range paths {
+		var sum string
+		if sum, err = SHA256(p); err != nil {
+			err = errors.Wrapf(err, "failed to hash %s", p)
+			return
+		}
+		groups[sum] = append(groups[sum], p)
+	}
+	return
+}
+
+// partialHash returns the sha256 hash of the first and last 4KiB of the given file
+func partialHash(target string, size int64) (result string, err error) {
+	var fr *os.File
+	if fr, err = os.Open(target); err != nil {
+		err = errors.Wrapf(err, "failed opening target file %s", target)
+		return
+	}
+	defer fr.Close()
+
+	readers := []io.Reader{io.LimitReader(fr, gPartialHashSize)}
+	if size > 2*gPartialHashSize {
+		readers = append(readers, io.NewSectionReader(fr, size-gPartialHashSize, gPartialHashSize))
+	}
+	return HashReader(io.MultiReader(readers...), HashSHA256)
+}
+
+// CompareTrees compares the trees rooted at a and b returning a map per path that differs with
+// the keys path, status and reason. The path is relative to the roots, the status is one of
+// TreeOnlyA, TreeOnlyB or TreeDiffer and the reason is one of type, target, size, mtime, content
+// or mode for differing paths and empty otherwise. Results are ordered by path.
+// * Doesn't follow links by default but can be turned on by passing in FollowOpt(true)
+// * Following links compares the content of the target using the link name
+// * Compares file content with a sha256 hash by default or size and modification time with CompareOpt(CompareMtime)
+// * Filters paths relative to the roots with the IncludeOpt and ExcludeOpt glob patterns
+func CompareTrees(a, b string, opts ...*opt.Opt) (result []map[string]interface{}, err error) {
+	result = []map[string]interface{}{}
+	defaultFollowOpt(&opts, false)
+
+	var entriesA, entriesB map[string]*FileInfo
+	if entriesA, err = treeEntries(a, opts); err != nil {
+		return
+	}
+	if entriesB, err = treeEntries(b, opts); err != nil {
+		return
+	}
+
+	paths := []string{}
+	for rel := range entriesA {
+		paths = append(paths, rel)
+	}
+	for rel := range entriesB {
+		if _, ok := entriesA[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		infoA, okA := entriesA[rel]
+		infoB, okB := entriesB[rel]
+		switch {
+		case !okB:
+			result = append(result, map[string]interface{}{"path": rel, "status": TreeOnlyA, "reason": ""})
+		case !okA:
+			result = append(result, map[string]interface{}{"path": rel, "status": TreeOnlyB, "reason": ""})
+		default:
+			var reason string
+			if reason, err = compareEntries(infoA, infoB, opts); err != nil {
+				return
+			}
+			if reason != "" {
+				result = append(result, map[string]interface{}{"path": rel, "status": TreeDiffer, "reason": reason})
+			}
+		}
+	}
+	return
+}
+
+// compareEntries returns the reason the two entries differ or empty if they are the same
+func compareEntries(a, b *FileInfo, opts []*opt.Opt) (reason string, err error) {
+	switch {
+	case a.Mode().Type() != b.Mode().Type():
+		return "type", nil
+
+	case a.IsSymlink():
+		var targetA, targetB string
+		if targetA, err = os.Readlink(a.Path); err != nil {
+			err = errors.Wrapf(err, "failed reading link %s", a.Path)
+			return
+		}
+		if targetB, err = os.Readlink(b.Path); err != nil {
+			err = errors.Wrapf(err, "failed reading link %s", b.Path)
+			return
+		}
+		if targetA != targetB {
+			return "target", nil
+		}
+		return
+
+	case a.Mode().IsRegular():
+		if a.Size() != b.Size() {
+			return "size", nil
+		}
+		if getCompareOpt(opts) == CompareMtime {
+			if !a.ModTime().Equal(b.ModTime()) {
+				return "mtime", nil
+			}
+		} else {
+			var sumA, sumB string
+			if sumA, err = SHA256(a.Path); err != nil {
+				return
+			}
+			if sumB, err = SHA256(b.Path); err != nil {
+				return
+			}
+			if sumA != sumB {
+				return "content", nil
+			}
+		}
+	}
+	if a.Mode().Perm() != b.Mode().Perm() {
+		return "mode", nil
+	}
+	return
+}
+
+// treeEntries returns the paths under the given root keyed by their path relative to it.
+// Following links replaces the link's information with its target's and walks linked
+// directories under the link name skipping those that loop back to a parent.
+func treeEntries(root string, opts []*opt.Opt) (entries map[string]*FileInfo, err error) {
+	if root, err = Abs(root); err != nil {
+		return
+	}
+	entries = map[string]*FileInfo{}
+	follow := getFollowOpt(opts)
+
+	var visit func(p, rel string, parents map[string]bool) error
+	visit = func(p, rel string, parents map[string]bool) (e error) {
+		var info *FileInfo
+		if info, e = Lstat(p); e != nil {
+			return
+		}
+		if follow && info.IsSymlink() {
+			var obj os.FileInfo
+			if obj, e = os.Stat(p); e != nil {
+				return errors.Wrapf(e, "failed to follow link %s", p)
+			}
+			info = &FileInfo{Path: p, Obj: obj}
+		}
+		if rel != "" {
+			if copyFilter(rel, info.IsDir(), opts) {
+				return
+			}
+			entries[rel] = info
+		}
+		if !info.IsDir() {
+			return
+		}
+
+		// Skip directories looping back to a parent
+		var resolved string
+		if resolved, e = filepath.EvalSymlinks(p); e != nil {
+			return errors.Wrapf(e, "failed to resolve %s", p)
+		}
+		if parents[resolved] {
+			return
+		}
+		parents[resolved] = true
+		defer delete(parents, resolved)
+
+		var names []string
+		if names, e = ReadDirnames(p); e != nil {
+			return
+		}
+		for _, name := range names {
+			if e = visit(path.Join(p, name), path.Join(rel, name), parents); e != nil {
+				return
+			}
+		}
+		return
+	}
+	if err = visit(root, "", map[string]bool{}); err != nil {
+		err = errors.Wrapf(err, "failed to compare tree %s", root)
+	}
+	return
+}
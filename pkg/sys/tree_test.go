@@ -0,0 +1,111 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	resetTest()
+	first := path.Join(tmpDir, "first")
+	second := path.Join(tmpDir, "second")
+	big := strings.Repeat("a", 3*gPartialHashSize)
+	writeTestFile(t, path.Join(first, "a"), "dupe")
+	writeTestFile(t, path.Join(first, "sub", "b"), "dupe")
+	writeTestFile(t, path.Join(second, "c"), "dupe")
+	writeTestFile(t, path.Join(second, "d"), "same")
+	writeTestFile(t, path.Join(first, "big1"), big)
+	writeTestFile(t, path.Join(second, "big2"), big)
+	writeTestFile(t, path.Join(second, "big3"), big[:gPartialHashSize+1]+"b"+big[gPartialHashSize+2:])
+	writeTestFile(t, path.Join(second, "empty1"), "")
+	writeTestFile(t, path.Join(second, "empty2"), "")
+	assert.Nil(t, os.Link(path.Join(first, "a"), path.Join(second, "hardlink")))
+	assert.Nil(t, os.Symlink("a", path.Join(first, "link")))
+
+	result, err := FindDuplicates(first, second, first)
+	assert.Nil(t, err)
+	assert.Len(t, result, 2)
+
+	abs := func(p string) string { p, _ = Abs(p); return p }
+	assert.Equal(t, int64(len(big)), result[0]["size"])
+	assert.Equal(t, []string{abs(path.Join(first, "big1")), abs(path.Join(second, "big2"))}, result[0]["paths"])
+	assert.Equal(t, int64(4), result[1]["size"])
+	sum, _ := SHA256(path.Join(first, "a"))
+	assert.Equal(t, sum, result[1]["hash"])
+	assert.Equal(t, []string{abs(path.Join(first, "a")), abs(path.Join(first, "sub", "b")), abs(path.Join(second, "c"))}, result[1]["paths"])
+
+	_, err = FindDuplicates(path.Join(tmpDir, "bogus"))
+	assert.True(t, strings.HasPrefix(err.Error(), "failed to find duplicates in "))
+}
+
+func TestCompareTrees(t *testing.T) {
+	resetTest()
+	a := path.Join(tmpDir, "a")
+	b := path.Join(tmpDir, "b")
+	for _, dir := range []string{a, b} {
+		writeTestFile(t, path.Join(dir, "same"), "same")
+		writeTestFile(t, path.Join(dir, "content"), "content "+path.Base(dir))
+		writeTestFile(t, path.Join(dir, "sub", "file"), "file")
+		writeTestFile(t, path.Join(dir, "target", "file"), "target "+path.Base(dir))
+	}
+	writeTestFile(t, path.Join(a, "size"), "size")
+	writeTestFile(t, path.Join(b, "size"), "sizes")
+	writeTestFile(t, path.Join(a, "onlya"), "a")
+	writeTestFile(t, path.Join(b, "sub", "onlyb"), "b")
+	writeTestFile(t, path.Join(a, "type"), "file")
+	_, err := MkdirP(path.Join(b, "type"))
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chmod(path.Join(b, "same"), 0600))
+	assert.Nil(t, os.Symlink("target", path.Join(a, "link")))
+	assert.Nil(t, os.Symlink("sub", path.Join(b, "link")))
+
+	// links not followed
+	{
+		result, err := CompareTrees(a, b)
+		assert.Nil(t, err)
+		assert.Equal(t, []map[string]interface{}{
+			{"path": "content", "status": TreeDiffer, "reason": "content"},
+			{"path": "link", "status": TreeDiffer, "reason": "target"},
+			{"path": "onlya", "status": TreeOnlyA, "reason": ""},
+			{"path": "same", "status": TreeDiffer, "reason": "mode"},
+			{"path": "size", "status": TreeDiffer, "reason": "size"},
+			{"path": "sub/onlyb", "status": TreeOnlyB, "reason": ""},
+			{"path": "target/file", "status": TreeDiffer, "reason": "content"},
+			{"path": "type", "status": TreeDiffer, "reason": "type"},
+		}, result)
+	}
+
+	// links followed compare their targets using the link name
+	{
+		result, err := CompareTrees(a, b, FollowOpt(true), ExcludeOpt("target", "same", "content", "size", "type"))
+		assert.Nil(t, err)
+		assert.Equal(t, []map[string]interface{}{
+			{"path": "link/file", "status": TreeDiffer, "reason": "size"},
+			{"path": "link/onlyb", "status": TreeOnlyB, "reason": ""},
+			{"path": "onlya", "status": TreeOnlyA, "reason": ""},
+			{"path": "sub/onlyb", "status": TreeOnlyB, "reason": ""},
+		}, result)
+	}
+
+	// mtime comparison
+	{
+		assert.Nil(t, os.Chtimes(path.Join(b, "sub", "file"), time.Now(), time.Now().Add(time.Hour)))
+		result, err := CompareTrees(path.Join(a, "sub"), path.Join(b, "sub"), CompareOpt(CompareMtime))
+		assert.Nil(t, err)
+		assert.Equal(t, []map[string]interface{}{
+			{"path": "file", "status": TreeDiffer, "reason": "mtime"},
+			{"path": "onlyb", "status": TreeOnlyB, "reason": ""},
+		}, result)
+	}
+
+	// failure
+	{
+		_, err := CompareTrees(path.Join(tmpDir, "bogus"), b)
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to compare tree "))
+	}
+}
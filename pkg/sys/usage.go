@@ -0,0 +1,149 @@
+package sys
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/phR0ze/n/pkg/enc/unit"
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+)
+
+// Usage provides the disk usage of a directory including all paths under it
+type Usage struct {
+	Path     string   // Absolute path of the directory
+	Size     int64    // Apparent size in bytes of all files under the directory
+	Disk     int64    // Bytes allocated on disk for the directory and all paths under it
+	Files    int      // Number of non directory paths under the directory
+	Dirs     int      // Number of directories under the directory
+	Children []*Usage // Usage of the directories directly under the directory in sorted order
+}
+
+// DiskUsage calculates the disk usage of the given root returning a tree of per directory
+// usage. Hard links are only counted once against the first path walked and links are not
+// followed. Walks the tree in parallel and accepts the WalkParallel options e.g. WorkersOpt
+// and ExcludeOpt.
+func DiskUsage(root string, opts ...*opt.Opt) (usage *Usage, err error) {
+	if root, err = Abs(root); err != nil {
+		return
+	}
+	opts = opt.Copy(opts)
+	opt.Overwrite(&opts, FollowOpt(false))
+	opt.Overwrite(&opts, OrderedOpt(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dirs := map[string]*Usage{}
+	linked := map[[2]uint64]bool{}
+	for result := range WalkParallel(ctx, root, opts...) {
+		if result.Err != nil {
+			err = errors.Wrapf(result.Err, "failed to calculate disk usage for %s", root)
+			return
+		}
+
+		// Count hard linked files only once
+		var disk int64
+		if stat, ok := result.Info.Sys().(*syscall.Stat_t); ok {
+			if !result.Info.IsDir() && stat.Nlink > 1 {
+				key := [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}
+				if linked[key] {
+					continue
+				}
+				linked[key] = true
+			}
+			disk = int64(stat.Blocks) * 512
+		}
+
+		if result.Info.IsDir() {
+			dir := usageDir(dirs, result.Path)
+			dir.Disk += disk
+			if result.Path != root {
+				parent := usageDir(dirs, filepath.Dir(result.Path))
+				parent.Children = append(parent.Children, dir)
+			}
+			continue
+		}
+
+		// Root is a file
+		if result.Path == root {
+			usage = &Usage{Path: root, Size: result.Info.Size(), Disk: disk, Children: []*Usage{}}
+			return
+		}
+		dir := usageDir(dirs, filepath.Dir(result.Path))
+		dir.Size += result.Info.Size()
+		dir.Disk += disk
+		dir.Files++
+	}
+
+	usage = dirs[root]
+	usage.total()
+	return
+}
+
+// usageDir returns the usage for the given directory creating it if needed
+func usageDir(dirs map[string]*Usage, target string) (usage *Usage) {
+	if usage = dirs[target]; usage == nil {
+		usage = &Usage{Path: target, Children: []*Usage{}}
+		dirs[target] = usage
+	}
+	return
+}
+
+// total sorts the children and adds their totals to the usage
+func (u *Usage) total() {
+	sort.Slice(u.Children, func(i, j int) bool { return u.Children[i].Path < u.Children[j].Path })
+	for _, child := range u.Children {
+		child.total()
+		u.Size += child.Size
+		u.Disk += child.Disk
+		u.Files += child.Files
+		u.Dirs += child.Dirs + 1
+	}
+}
+
+// Human returns the apparent size in human readable form e.g. 3.05 MiB
+func (u *Usage) Human() string {
+	return unit.HumanBase2(u.Size)
+}
+
+// Report returns the n largest directories under and including the usage one per line in the
+// form of human readable size followed by the path
+func (u *Usage) Report(n int) string {
+	lines := []string{}
+	for _, usage := range u.Top(n) {
+		lines = append(lines, fmt.Sprintf("%-12s %s", usage.Human(), usage.Path))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Top returns the n largest directories under and including the usage ordered by size
+// descending then by path. Returns all directories if n is less than 1.
+func (u *Usage) Top(n int) (result []*Usage) {
+	result = []*Usage{}
+	u.Walk(func(usage *Usage) {
+		result = append(result, usage)
+	})
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Size != result[j].Size {
+			return result[i].Size > result[j].Size
+		}
+		return result[i].Path < result[j].Path
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return
+}
+
+// Walk calls the given function for the usage and all directories under it in order
+func (u *Usage) Walk(fn func(usage *Usage)) {
+	fn(u)
+	for _, child := range u.Children {
+		child.Walk(fn)
+	}
+}
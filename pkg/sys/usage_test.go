@@ -0,0 +1,59 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskUsage(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "usage")
+	writeTestFile(t, path.Join(dir, "a"), strings.Repeat("a", 100))
+	writeTestFile(t, path.Join(dir, "big", "b"), strings.Repeat("b", 3000))
+	writeTestFile(t, path.Join(dir, "big", "nested", "c"), strings.Repeat("c", 2000))
+	writeTestFile(t, path.Join(dir, "small", "d"), strings.Repeat("d", 10))
+	assert.Nil(t, os.Link(path.Join(dir, "big", "b"), path.Join(dir, "small", "hardlink")))
+	assert.Nil(t, os.Symlink("big", path.Join(dir, "link")))
+
+	// tree
+	usage, err := DiskUsage(dir)
+	assert.Nil(t, err)
+	abs, _ := Abs(dir)
+	assert.Equal(t, abs, usage.Path)
+	assert.Equal(t, int64(5110+len("big")), usage.Size)
+	assert.Equal(t, 5, usage.Files)
+	assert.Equal(t, 3, usage.Dirs)
+	assert.True(t, usage.Disk > 0)
+	assert.Len(t, usage.Children, 2)
+	assert.Equal(t, "big", path.Base(usage.Children[0].Path))
+	assert.Equal(t, int64(5000), usage.Children[0].Size)
+	assert.Equal(t, 1, usage.Children[0].Dirs)
+	assert.Equal(t, int64(10), usage.Children[1].Size)
+	assert.Equal(t, "4.99 KiB", usage.Human())
+
+	// top
+	top := usage.Top(2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, abs, top[0].Path)
+	assert.Equal(t, path.Join(abs, "big"), top[1].Path)
+	assert.Len(t, usage.Top(0), 4)
+	assert.Equal(t, "4.99 KiB     "+abs+"\n4.88 KiB     "+path.Join(abs, "big"), usage.Report(2))
+
+	// exclude
+	usage, err = DiskUsage(dir, ExcludeOpt("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3113), usage.Size)
+
+	// file
+	usage, err = DiskUsage(path.Join(dir, "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), usage.Size)
+
+	// failure
+	_, err = DiskUsage(path.Join(dir, "bogus"))
+	assert.True(t, strings.HasPrefix(err.Error(), "failed to calculate disk usage for "))
+}
@@ -4,12 +4,27 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"strconv"
+	"sync"
+	"syscall"
 
+	"github.com/phR0ze/n/pkg/opt"
 	"github.com/pkg/errors"
 )
 
+// gAsUserEnv names the registered function a child process started by AsUser is to run
+const gAsUserEnv = "N_AS_USER_FUNC"
+
+var (
+	// gAsUserFuncs tracks the functions registered with RegisterAsUser by name
+	gAsUserFuncs     = map[string]func() error{}
+	gAsUserFuncsLock sync.Mutex
+)
+
 // User wraps the os.User interface and provide additional helper functions
 type User struct {
 	obj      *user.User // handle on the actual OS object to use where needed
@@ -133,6 +148,162 @@ func LookupUserById(uid int) (u *User, err error) {
 	return
 }
 
+// LookupUser gets the user for the given login name
+func LookupUser(name string) (u *User, err error) {
+	var obj *user.User
+	if obj, err = user.Lookup(name); err != nil {
+		err = errors.Wrapf(err, "failed to get user for name %s", name)
+		return
+	}
+	return newUserStruct(obj)
+}
+
+// LookupGroup gets the group for the given name. Members are read from /etc/group falling back
+// on the system lookup without members for groups defined elsewhere e.g. LDAP.
+func LookupGroup(name string) (g *Group, err error) {
+	return lookupGroup(func(x *Group) bool { return x.Name == name }, func() (*user.Group, error) {
+		return user.LookupGroup(name)
+	}, "name "+name)
+}
+
+// LookupGroupById gets the group for the given gid. Members are read from /etc/group falling
+// back on the system lookup without members for groups defined elsewhere e.g. LDAP.
+func LookupGroupById(gid int) (g *Group, err error) {
+	return lookupGroup(func(x *Group) bool { return x.GID == gid }, func() (*user.Group, error) {
+		return user.LookupGroupId(strconv.Itoa(gid))
+	}, fmt.Sprintf("id %d", gid))
+}
+
+// lookupGroup finds the group matching the given function in /etc/group falling back on the
+// given system lookup
+func lookupGroup(match func(*Group) bool, lookup func() (*user.Group, error), desc string) (g *Group, err error) {
+	if groups, e := Groups(); e == nil {
+		for _, x := range groups {
+			if match(x) {
+				return x, nil
+			}
+		}
+	}
+	var obj *user.Group
+	if obj, err = lookup(); err != nil {
+		err = errors.Wrapf(err, "failed to get group for %s", desc)
+		return
+	}
+	g = &Group{Name: obj.Name, Password: "x", Members: []string{}}
+	if g.GID, err = strconv.Atoi(obj.Gid); err != nil {
+		err = errors.Wrap(err, "failed to convert group's gid into an int. Not a POSIX system?")
+		g = nil
+	}
+	return
+}
+
+// GroupIds returns the user's primary and supplementary group ids
+func (u *User) GroupIds() (gids []int, err error) {
+	gids = []int{u.GID}
+	if u.obj == nil {
+		return
+	}
+	var ids []string
+	if ids, err = u.obj.GroupIds(); err != nil {
+		err = errors.Wrapf(err, "failed to get groups for user %s", u.Name)
+		return
+	}
+	for _, id := range ids {
+		if gid, e := strconv.Atoi(id); e == nil && gid != u.GID {
+			gids = append(gids, gid)
+		}
+	}
+	return
+}
+
+// Groups returns the user's primary and supplementary groups
+func (u *User) Groups() (groups []*Group, err error) {
+	var gids []int
+	if gids, err = u.GroupIds(); err != nil {
+		return
+	}
+	groups = []*Group{}
+	for _, gid := range gids {
+		var g *Group
+		if g, err = LookupGroupById(gid); err != nil {
+			return
+		}
+		groups = append(groups, g)
+	}
+	return
+}
+
+// CanExec returns true if the user is allowed to execute the given file or search the given
+// directory based on the mode bits and ownership of it and its parent directories
+func (u *User) CanExec(target string) bool {
+	return u.access(target, 01)
+}
+
+// CanRead returns true if the user is allowed to read the given path based on the mode bits and
+// ownership of it and search permission on its parent directories
+func (u *User) CanRead(target string) bool {
+	return u.access(target, 04)
+}
+
+// CanWrite returns true if the user is allowed to write the given path based on the mode bits
+// and ownership of it and search permission on its parent directories
+func (u *User) CanWrite(target string) bool {
+	return u.access(target, 02)
+}
+
+// access checks the given permission bits i.e. 4 read, 2 write and 1 execute against the owner,
+// group or other bits of the target as the kernel would ignoring ACLs
+func (u *User) access(target string, perm os.FileMode) bool {
+	if u == nil {
+		return false
+	}
+	target, err := Abs(target)
+	if err != nil {
+		return false
+	}
+	gids, _ := u.GroupIds()
+	check := func(target string, perm os.FileMode) bool {
+		info, err := os.Stat(target)
+		if err != nil {
+			return false
+		}
+		mode := info.Mode().Perm()
+
+		// Root may read and write anything but only execute if any execute bit is set
+		if u.UID == 0 {
+			return perm != 01 || info.IsDir() || mode&0111 != 0
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false
+		}
+		switch {
+		case int(stat.Uid) == u.UID:
+			return mode&(perm<<6) != 0
+		case containsInt(gids, int(stat.Gid)):
+			return mode&(perm<<3) != 0
+		default:
+			return mode&perm != 0
+		}
+	}
+	for dir := path.Dir(target); dir != target && dir != "/"; dir = path.Dir(dir) {
+		if !check(dir, 01) {
+			return false
+		}
+	}
+	return check("/", 01) && check(target, perm)
+}
+
+// containsInt returns true if the given slice contains the given value
+func containsInt(vals []int, val int) bool {
+	for _, x := range vals {
+		if x == val {
+			return true
+		}
+	}
+	return false
+}
+
 // IsRoot detects if the current user has root permissions based on the user uid
 func (u *User) IsRoot() bool {
 	if u == nil {
@@ -141,6 +312,100 @@ func (u *User) IsRoot() bool {
 	return u.UID == 0
 }
 
+// RegisterAsUser registers the given functions so that AsUser can run them in a child process.
+// Functions are identified by their fully qualified name and must be registered during init so
+// they are known to the child before AsUserInit is called.
+func RegisterAsUser(funcs ...func() error) {
+	gAsUserFuncsLock.Lock()
+	defer gAsUserFuncsLock.Unlock()
+	for _, f := range funcs {
+		gAsUserFuncs[funcName(f)] = f
+	}
+}
+
+// AsUserInit runs the registered function the current process was started to run by AsUser and
+// exits with its result writing any error to stderr. Returns without doing anything in any other
+// process. Call at the start of main or TestMain before anything else is done.
+func AsUserInit() {
+	name := os.Getenv(gAsUserEnv)
+	if name == "" {
+		return
+	}
+	os.Unsetenv(gAsUserEnv)
+	gAsUserFuncsLock.Lock()
+	f, ok := gAsUserFuncs[name]
+	gAsUserFuncsLock.Unlock()
+	if !ok {
+		fmt.Fprintf(os.Stderr, "function %s isn't registered with RegisterAsUser\n", name)
+		os.Exit(2)
+	}
+	if err := f(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// AsUser runs the given function in a child process started as the given user via
+// SysProcAttr.Credential with the user's uid, gid and supplementary groups and HOME, USER and
+// LOGNAME set accordingly, leaving the credentials of the current process untouched. The child
+// re-runs the current executable with the same arguments and environment, so the function must
+// be registered with RegisterAsUser and the program must call AsUserInit at the start of main.
+// The function's state is not shared with the child i.e. anything it needs must come from
+// package state set up during init, the arguments or the environment. The child's stdin, stdout
+// and stderr are connected to the current process's and a failure is returned as an *ExitError
+// carrying the function's error message. The function is simply called when the user is the
+// current user. Running as another user requires root.
+func AsUser(u *User, f func() error) (err error) {
+	if u == nil {
+		return errors.New("failed to run as user: user is nil")
+	}
+	if u.UID == os.Geteuid() && u.GID == os.Getegid() {
+		return f()
+	}
+
+	name := funcName(f)
+	gAsUserFuncsLock.Lock()
+	_, ok := gAsUserFuncs[name]
+	gAsUserFuncsLock.Unlock()
+	if !ok {
+		return errors.Errorf("failed to run as user %s: function %s isn't registered with RegisterAsUser", u.Name, name)
+	}
+	var exe string
+	if exe, err = os.Executable(); err != nil {
+		return errors.Wrapf(err, "failed to run as user %s", u.Name)
+	}
+	err = NewCmd(exe, os.Args[1:]...).User(u).Env(gAsUserEnv+"="+name).
+		Run(opt.InOpt(os.Stdin), opt.OutOpt(os.Stdout), opt.ErrOpt(os.Stderr))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to run %s as user %s", name, u.Name)
+	}
+	return
+}
+
+// funcName returns the fully qualified name of the given function
+func funcName(f func() error) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
+
+// WithRealUser runs the given function as the real user behind the sudo mask via AsUser so that
+// files created end up owned by the sudo invoker rather than root. The function must be
+// registered with RegisterAsUser as with AsUser. The function is run as is when not using sudo.
+func WithRealUser(f func() error) (err error) {
+	var u *User
+	if u, err = CurrentUser(); err != nil {
+		return
+	}
+	if !u.IsRoot() || u.RealUID == u.UID {
+		return f()
+	}
+	var real *User
+	if real, err = LookupUserById(u.RealUID); err != nil {
+		return errors.Wrap(err, "failed to get real user behind sudo mask")
+	}
+	return AsUser(real, f)
+}
+
 // DropSudo switches back to the original user under the sudo mask.
 // Preserves the ability to raise Sudo again.
 func DropSudo() (err error) {
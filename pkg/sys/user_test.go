@@ -1,11 +1,26 @@
 package sys
 
 import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	RegisterAsUser(asUserTestWrite, asUserTestFail)
+}
+
+func TestMain(m *testing.M) {
+	AsUserInit()
+	os.Exit(m.Run())
+}
+
 func TestUser_Home(t *testing.T) {
 	user, err := CurrentUser()
 	assert.Nil(t, err)
@@ -14,3 +29,196 @@ func TestUser_Home(t *testing.T) {
 
 	assert.Equal(t, home, user.Home)
 }
+
+func TestLookupGroup(t *testing.T) {
+	g, err := LookupGroupById(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "root", g.Name)
+	g, err = LookupGroup("root")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, g.GID)
+
+	_, err = LookupGroup("bogus-group")
+	assert.Equal(t, "failed to get group for name bogus-group: group: unknown group bogus-group", err.Error())
+
+	u, err := LookupUser("root")
+	assert.Nil(t, err)
+	groups, err := u.Groups()
+	assert.Nil(t, err)
+	assert.Equal(t, "root", groups[0].Name)
+}
+
+func TestUserAccess(t *testing.T) {
+	if UserIsRoot() {
+		t.Skip("root bypasses the permission bits see TestUserAccess_Chown")
+	}
+	dir, err := os.MkdirTemp("", "access")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "file")
+	assert.Nil(t, os.Chmod(dir, 0755))
+	assert.Nil(t, os.WriteFile(file, []byte("file"), 0600))
+	info, err := os.Stat(file)
+	assert.Nil(t, err)
+	gid := int(info.Sys().(*syscall.Stat_t).Gid)
+
+	// users are built around the owner of the files created by the test
+	owner := &User{UID: os.Getuid(), GID: gid}
+	member := &User{UID: os.Getuid() + 1, GID: gid}
+	other := &User{UID: os.Getuid() + 1, GID: gid + 1}
+
+	// owner bits
+	assert.Nil(t, os.Chmod(file, 0640))
+	assert.True(t, owner.CanRead(file))
+	assert.True(t, owner.CanWrite(file))
+	assert.False(t, owner.CanExec(file))
+	assert.Nil(t, os.Chmod(file, 0700))
+	assert.True(t, owner.CanExec(file))
+
+	// group bits take precedence over other bits for members
+	assert.Nil(t, os.Chmod(file, 0640))
+	assert.True(t, member.CanRead(file))
+	assert.False(t, member.CanWrite(file))
+	assert.False(t, other.CanRead(file))
+	assert.Nil(t, os.Chmod(file, 0604))
+	assert.False(t, member.CanRead(file))
+	assert.True(t, other.CanRead(file))
+	assert.False(t, other.CanWrite(file))
+
+	// search permission on parents
+	assert.Nil(t, os.Chmod(dir, 0600))
+	defer os.Chmod(dir, 0700)
+	assert.True(t, owner.CanRead(dir))
+	assert.False(t, owner.CanRead(file))
+	assert.False(t, owner.CanRead(path.Join(dir, "bogus")))
+	assert.False(t, (*User)(nil).CanRead(file))
+}
+
+func TestUserAccess_Chown(t *testing.T) {
+	if !UserIsRoot() {
+		t.Skip("changing file ownership requires root")
+	}
+
+	// the temp directory lives under os.TempDir which others may search
+	dir, err := os.MkdirTemp("", "access")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "file")
+	assert.Nil(t, os.Chmod(dir, 0755))
+	assert.Nil(t, os.WriteFile(file, []byte("file"), 0640))
+	assert.Nil(t, os.Chown(file, 0, 65534))
+
+	// root may read and write but not execute without an execute bit
+	root := &User{UID: 0, GID: 0}
+	assert.True(t, root.CanRead(file))
+	assert.True(t, root.CanWrite(file))
+	assert.False(t, root.CanExec(file))
+	assert.True(t, root.CanExec(dir))
+
+	// group and other bits
+	member := &User{UID: 1234, GID: 65534}
+	other := &User{UID: 1234, GID: 1234}
+	assert.True(t, member.CanRead(file))
+	assert.False(t, member.CanWrite(file))
+	assert.False(t, other.CanRead(file))
+
+	// owner bits and search permission on parents
+	owner := &User{UID: 1234, GID: 1234}
+	assert.Nil(t, os.Chown(file, 1234, 1234))
+	assert.Nil(t, os.Chmod(file, 0700))
+	assert.True(t, owner.CanExec(file))
+	assert.Nil(t, os.Chmod(dir, 0700))
+	assert.False(t, owner.CanRead(file))
+	assert.False(t, owner.CanRead(path.Join(dir, "bogus")))
+	assert.False(t, (*User)(nil).CanRead(file))
+}
+
+func TestAsUser(t *testing.T) {
+
+	// the current user simply calls the function
+	{
+		u, err := CurrentUser()
+		assert.Nil(t, err)
+		called := false
+		assert.Nil(t, AsUser(u, func() error { called = true; return nil }))
+		assert.True(t, called)
+		assert.Equal(t, os.ErrInvalid, AsUser(u, func() error { return os.ErrInvalid }))
+		assert.Equal(t, "failed to run as user: user is nil", AsUser(nil, func() error { return nil }).Error())
+	}
+
+	if !UserIsRoot() {
+		t.Skip("switching users requires root")
+	}
+	nobody, err := LookupUserById(65534)
+	if err != nil {
+		t.Skip("requires the nobody user with uid 65534")
+	}
+	if exe, err := os.Executable(); err != nil || !nobody.CanExec(exe) {
+		t.Skip("requires the test binary to be executable by the nobody user")
+	}
+	dir, err := os.MkdirTemp("", "asuser")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.Chmod(dir, 0777))
+	owner := func(target string) int {
+		info, err := os.Stat(target)
+		assert.Nil(t, err)
+		return int(info.Sys().(*syscall.Stat_t).Uid)
+	}
+
+	// registered functions run in a child process as the user leaving the process untouched
+	{
+		target := path.Join(dir, "nobody")
+		setenvTest(t, "N_AS_USER_TEST", target)
+		assert.Nil(t, AsUser(nobody, asUserTestWrite))
+		data, err := ReadString(target)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("65534 %s %s [%d]", nobody.Home, nobody.Name, nobody.GID), data)
+		assert.Equal(t, 65534, owner(target))
+		assert.Nil(t, WriteString(path.Join(dir, "root"), "data"))
+		assert.Equal(t, 0, owner(path.Join(dir, "root")))
+		assert.Equal(t, 0, os.Geteuid())
+	}
+
+	// commands given a user explicitly
+	{
+		out, err := NewCmd("id", "-u").User(nobody).Output()
+		assert.Nil(t, err)
+		assert.Equal(t, "65534\n", out)
+	}
+
+	// errors are passed back to the caller
+	{
+		err := AsUser(nobody, asUserTestFail)
+		var exitErr *ExitError
+		assert.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 1, exitErr.Code)
+		assert.Equal(t, "as user failure\n", exitErr.Stderr)
+		err = AsUser(nobody, func() error { return nil })
+		assert.True(t, strings.HasSuffix(err.Error(), "isn't registered with RegisterAsUser"))
+	}
+
+	// real user behind sudo
+	{
+		target := path.Join(dir, "real")
+		setenvTest(t, "N_AS_USER_TEST", target)
+		setenvTest(t, "SUDO_UID", "65534")
+		assert.Nil(t, WithRealUser(asUserTestWrite))
+		assert.Equal(t, 65534, owner(target))
+	}
+}
+
+// asUserTestWrite writes the user's uid, HOME, USER and groups to the file named by N_AS_USER_TEST
+func asUserTestWrite() error {
+	groups, err := os.Getgroups()
+	if err != nil {
+		return err
+	}
+	return WriteString(os.Getenv("N_AS_USER_TEST"), fmt.Sprintf("%d %s %s %v", os.Geteuid(),
+		os.Getenv("HOME"), os.Getenv("USER"), groups))
+}
+
+// asUserTestFail always fails
+func asUserTestFail() error {
+	return errors.New("as user failure")
+}
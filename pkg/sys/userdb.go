@@ -0,0 +1,500 @@
+package sys
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// gFirstID is the first uid or gid handed out to regular users and groups
+	gFirstID = 1000
+
+	// gLastID is the last uid or gid handed out to regular users and groups
+	gLastID = 60000
+)
+
+// Passwd is an entry of a passwd(5) format file e.g. /etc/passwd
+type Passwd struct {
+	Name     string // login name
+	Password string // password placeholder usually x meaning it is stored in the shadow file
+	UID      int    // user id
+	GID      int    // primary group id
+	Comment  string // GECOS field usually the full name
+	Home     string // home directory
+	Shell    string // login shell
+}
+
+// String returns the entry as a passwd(5) line
+func (p *Passwd) String() string {
+	return fmt.Sprintf("%s:%s:%d:%d:%s:%s:%s", p.Name, p.Password, p.UID, p.GID, p.Comment, p.Home, p.Shell)
+}
+
+// Group is an entry of a group(5) format file e.g. /etc/group
+type Group struct {
+	Name     string   // group name
+	Password string   // password placeholder usually x
+	GID      int      // group id
+	Members  []string // names of the users for which this is a supplementary group
+}
+
+// String returns the entry as a group(5) line
+func (g *Group) String() string {
+	return fmt.Sprintf("%s:%s:%d:%s", g.Name, g.Password, g.GID, strings.Join(g.Members, ","))
+}
+
+// Shadow is an entry of a shadow(5) format file e.g. /etc/shadow. Empty numeric fields are -1.
+type Shadow struct {
+	Name       string // login name
+	Password   string // encrypted password, ! or * lock the account
+	LastChange int    // days since the epoch the password was last changed
+	MinAge     int    // days before the password may be changed again
+	MaxAge     int    // days after which the password must be changed
+	Warn       int    // days before the maximum age to warn the user
+	Inactive   int    // days after the maximum age the password is still accepted
+	Expire     int    // days since the epoch the account expires
+	Reserved   string // reserved field
+}
+
+// String returns the entry as a shadow(5) line
+func (s *Shadow) String() string {
+	days := func(val int) string {
+		if val < 0 {
+			return ""
+		}
+		return strconv.Itoa(val)
+	}
+	return strings.Join([]string{s.Name, s.Password, days(s.LastChange), days(s.MinAge), days(s.MaxAge),
+		days(s.Warn), days(s.Inactive), days(s.Expire), s.Reserved}, ":")
+}
+
+// Groups returns the groups defined in /etc/group
+func Groups() ([]*Group, error) {
+	return ReadGroup("/etc/group")
+}
+
+// Users returns the users defined in /etc/passwd
+func Users() ([]*Passwd, error) {
+	return ReadPasswd("/etc/passwd")
+}
+
+// ReadGroup reads the given group(5) format file
+func ReadGroup(target string) (groups []*Group, err error) {
+	groups = []*Group{}
+	err = readUserDBFile(target, "group", 4, func(fields []string) (e error) {
+		g := &Group{Name: fields[0], Password: fields[1], Members: []string{}}
+		if g.GID, e = strconv.Atoi(fields[2]); e != nil {
+			return
+		}
+		if fields[3] != "" {
+			g.Members = strings.Split(fields[3], ",")
+		}
+		groups = append(groups, g)
+		return
+	})
+	return
+}
+
+// ReadPasswd reads the given passwd(5) format file
+func ReadPasswd(target string) (users []*Passwd, err error) {
+	users = []*Passwd{}
+	err = readUserDBFile(target, "passwd", 7, func(fields []string) (e error) {
+		p := &Passwd{Name: fields[0], Password: fields[1], Comment: fields[4], Home: fields[5], Shell: fields[6]}
+		if p.UID, e = strconv.Atoi(fields[2]); e != nil {
+			return
+		}
+		if p.GID, e = strconv.Atoi(fields[3]); e != nil {
+			return
+		}
+		users = append(users, p)
+		return
+	})
+	return
+}
+
+// ReadShadow reads the given shadow(5) format file
+func ReadShadow(target string) (shadows []*Shadow, err error) {
+	shadows = []*Shadow{}
+	err = readUserDBFile(target, "shadow", 9, func(fields []string) (e error) {
+		s := &Shadow{Name: fields[0], Password: fields[1], Reserved: fields[8]}
+		for i, val := range []*int{&s.LastChange, &s.MinAge, &s.MaxAge, &s.Warn, &s.Inactive, &s.Expire} {
+			if *val = -1; fields[i+2] != "" {
+				if *val, e = strconv.Atoi(fields[i+2]); e != nil {
+					return
+				}
+			}
+		}
+		shadows = append(shadows, s)
+		return
+	})
+	return
+}
+
+// readUserDBFile reads the given colon separated file calling the parse function for each
+// non empty line with exactly the given number of fields
+func readUserDBFile(target, kind string, count int, parse func(fields []string) error) (err error) {
+	var file *os.File
+	if file, err = os.Open(target); err != nil {
+		err = errors.Wrapf(err, "failed to read %s file %s", kind, target)
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != count {
+			return errors.Errorf("invalid %s entry at line %d of %s", kind, i, target)
+		}
+		if err = parse(fields); err != nil {
+			return errors.Wrapf(err, "invalid %s entry at line %d of %s", kind, i, target)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		err = errors.Wrapf(err, "failed to read %s file %s", kind, target)
+	}
+	return
+}
+
+// UserDB provides editing of the passwd, group and shadow files under a root directory e.g. a
+// chroot image being built. Changes are only written to disk by Save.
+type UserDB struct {
+	root    string    // root directory the etc files are found under
+	Users   []*Passwd // entries of etc/passwd
+	Groups  []*Group  // entries of etc/group
+	Shadows []*Shadow // entries of etc/shadow
+}
+
+// OpenUserDB reads the passwd, group and shadow files under the given root directory. Missing
+// files are treated as empty so that a database can be created from scratch.
+func OpenUserDB(root string) (db *UserDB, err error) {
+	if root, err = Abs(root); err != nil {
+		return
+	}
+	db = &UserDB{root: root, Users: []*Passwd{}, Groups: []*Group{}, Shadows: []*Shadow{}}
+	if target := db.path("passwd"); Exists(target) {
+		if db.Users, err = ReadPasswd(target); err != nil {
+			return nil, err
+		}
+	}
+	if target := db.path("group"); Exists(target) {
+		if db.Groups, err = ReadGroup(target); err != nil {
+			return nil, err
+		}
+	}
+	if target := db.path("shadow"); Exists(target) {
+		if db.Shadows, err = ReadShadow(target); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Root returns the root directory of the database
+func (db *UserDB) Root() string {
+	return db.root
+}
+
+// Save writes the passwd, group and shadow files atomically under the root directory. New
+// shadow files are only readable by root.
+func (db *UserDB) Save() (err error) {
+	if _, err = MkdirP(path.Join(db.root, "etc")); err != nil {
+		return
+	}
+	users := []string{}
+	for _, u := range db.Users {
+		users = append(users, u.String()+"\n")
+	}
+	if err = WriteStringAtomic(db.path("passwd"), strings.Join(users, "")); err != nil {
+		return
+	}
+	groups := []string{}
+	for _, g := range db.Groups {
+		groups = append(groups, g.String()+"\n")
+	}
+	if err = WriteStringAtomic(db.path("group"), strings.Join(groups, "")); err != nil {
+		return
+	}
+	shadows := []string{}
+	for _, s := range db.Shadows {
+		shadows = append(shadows, s.String()+"\n")
+	}
+	target := db.path("shadow")
+	if Exists(target) {
+		err = WriteStringAtomic(target, strings.Join(shadows, ""))
+	} else {
+		err = WriteStringAtomic(target, strings.Join(shadows, ""), ModeOpt(0600))
+	}
+	return
+}
+
+// path returns the path of the given etc file under the root directory
+func (db *UserDB) path(name string) string {
+	return path.Join(db.root, "etc", name)
+}
+
+// Group returns the group with the given name or nil if it doesn't exist
+func (db *UserDB) Group(name string) *Group {
+	for _, g := range db.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// GroupById returns the group with the given gid or nil if it doesn't exist
+func (db *UserDB) GroupById(gid int) *Group {
+	for _, g := range db.Groups {
+		if g.GID == gid {
+			return g
+		}
+	}
+	return nil
+}
+
+// Shadow returns the shadow entry for the given user name or nil if it doesn't exist
+func (db *UserDB) Shadow(name string) *Shadow {
+	for _, s := range db.Shadows {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// User returns the user with the given name or nil if it doesn't exist
+func (db *UserDB) User(name string) *Passwd {
+	for _, u := range db.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// UserById returns the user with the given uid or nil if it doesn't exist
+func (db *UserDB) UserById(uid int) *Passwd {
+	for _, u := range db.Users {
+		if u.UID == uid {
+			return u
+		}
+	}
+	return nil
+}
+
+// UserGroups returns the primary and supplementary groups of the given user sorted by gid
+func (db *UserDB) UserGroups(name string) (groups []*Group, err error) {
+	u := db.User(name)
+	if u == nil {
+		err = errors.Errorf("user %s doesn't exist", name)
+		return
+	}
+	groups = []*Group{}
+	for _, g := range db.Groups {
+		if g.GID == u.GID || hasMember(g, name) {
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GID < groups[j].GID })
+	return
+}
+
+// AddGroup adds the given group. A GID of -1 allocates the next free gid for regular groups
+// and an empty password defaults to x.
+func (db *UserDB) AddGroup(group *Group) (err error) {
+	if db.Group(group.Name) != nil {
+		return errors.Errorf("group %s already exists", group.Name)
+	}
+	if group.GID == -1 {
+		if group.GID, err = db.nextID(func(id int) bool { return db.GroupById(id) == nil }); err != nil {
+			return errors.Wrapf(err, "failed to allocate gid for group %s", group.Name)
+		}
+	} else if db.GroupById(group.GID) != nil {
+		return errors.Errorf("gid %d already exists", group.GID)
+	}
+	if group.Password == "" {
+		group.Password = "x"
+	}
+	if group.Members == nil {
+		group.Members = []string{}
+	}
+	db.Groups = append(db.Groups, group)
+	return
+}
+
+// AddGroupMember adds the given user to the given group's supplementary members
+func (db *UserDB) AddGroupMember(group, user string) (err error) {
+	g := db.Group(group)
+	if g == nil {
+		return errors.Errorf("group %s doesn't exist", group)
+	}
+	if db.User(user) == nil {
+		return errors.Errorf("user %s doesn't exist", user)
+	}
+	if !hasMember(g, user) {
+		g.Members = append(g.Members, user)
+	}
+	return
+}
+
+// AddUser adds the given user along with a locked shadow entry. A UID of -1 allocates the next
+// free uid for regular users. A GID of -1 creates a group named after the user with the same id
+// as the uid if free. An empty password defaults to x.
+func (db *UserDB) AddUser(user *Passwd) (err error) {
+	if db.User(user.Name) != nil {
+		return errors.Errorf("user %s already exists", user.Name)
+	}
+	if user.UID == -1 {
+		free := func(id int) bool { return db.UserById(id) == nil && (user.GID != -1 || db.GroupById(id) == nil) }
+		if user.UID, err = db.nextID(free); err != nil {
+			return errors.Wrapf(err, "failed to allocate uid for user %s", user.Name)
+		}
+	} else if db.UserById(user.UID) != nil {
+		return errors.Errorf("uid %d already exists", user.UID)
+	}
+	if user.GID == -1 {
+		group := &Group{Name: user.Name, GID: user.UID}
+		if db.GroupById(group.GID) != nil {
+			group.GID = -1
+		}
+		if err = db.AddGroup(group); err != nil {
+			return errors.Wrapf(err, "failed to create group for user %s", user.Name)
+		}
+		user.GID = group.GID
+	} else if db.GroupById(user.GID) == nil {
+		return errors.Errorf("gid %d doesn't exist", user.GID)
+	}
+	if user.Password == "" {
+		user.Password = "x"
+	}
+	db.Users = append(db.Users, user)
+	if db.Shadow(user.Name) == nil {
+		db.Shadows = append(db.Shadows, &Shadow{Name: user.Name, Password: "!", LastChange: shadowDays(time.Now()),
+			MinAge: -1, MaxAge: -1, Warn: -1, Inactive: -1, Expire: -1})
+	}
+	return
+}
+
+// RemoveGroup removes the given group. Groups that are the primary group of a user can't be
+// removed.
+func (db *UserDB) RemoveGroup(name string) (err error) {
+	g := db.Group(name)
+	if g == nil {
+		return errors.Errorf("group %s doesn't exist", name)
+	}
+	for _, u := range db.Users {
+		if u.GID == g.GID {
+			return errors.Errorf("group %s is the primary group of user %s", name, u.Name)
+		}
+	}
+	groups := []*Group{}
+	for _, x := range db.Groups {
+		if x != g {
+			groups = append(groups, x)
+		}
+	}
+	db.Groups = groups
+	return
+}
+
+// RemoveGroupMember removes the given user from the given group's supplementary members
+func (db *UserDB) RemoveGroupMember(group, user string) (err error) {
+	g := db.Group(group)
+	if g == nil {
+		return errors.Errorf("group %s doesn't exist", group)
+	}
+	members := []string{}
+	for _, member := range g.Members {
+		if member != user {
+			members = append(members, member)
+		}
+	}
+	g.Members = members
+	return
+}
+
+// RemoveUser removes the given user, their shadow entry and group memberships. The group named
+// after the user is removed as well if it is the user's primary group and has no other members.
+func (db *UserDB) RemoveUser(name string) (err error) {
+	u := db.User(name)
+	if u == nil {
+		return errors.Errorf("user %s doesn't exist", name)
+	}
+	users := []*Passwd{}
+	for _, x := range db.Users {
+		if x != u {
+			users = append(users, x)
+		}
+	}
+	db.Users = users
+	shadows := []*Shadow{}
+	for _, x := range db.Shadows {
+		if x.Name != name {
+			shadows = append(shadows, x)
+		}
+	}
+	db.Shadows = shadows
+	for _, g := range db.Groups {
+		db.RemoveGroupMember(g.Name, name)
+	}
+	if g := db.Group(name); g != nil && g.GID == u.GID && len(g.Members) == 0 {
+		err = db.RemoveGroup(name)
+	}
+	return
+}
+
+// SetPassword sets the given user's encrypted password hash e.g. as created by mkpasswd and
+// updates the last changed date. Use ! to lock the account.
+func (db *UserDB) SetPassword(name, hash string) (err error) {
+	if db.User(name) == nil {
+		return errors.Errorf("user %s doesn't exist", name)
+	}
+	if strings.ContainsAny(hash, ":\n") {
+		return errors.Errorf("invalid password hash for user %s", name)
+	}
+	s := db.Shadow(name)
+	if s == nil {
+		s = &Shadow{Name: name, MinAge: -1, MaxAge: -1, Warn: -1, Inactive: -1, Expire: -1}
+		db.Shadows = append(db.Shadows, s)
+	}
+	s.Password = hash
+	s.LastChange = shadowDays(time.Now())
+	return
+}
+
+// nextID returns the first regular id the given function reports as free
+func (db *UserDB) nextID(free func(id int) bool) (int, error) {
+	for id := gFirstID; id <= gLastID; id++ {
+		if free(id) {
+			return id, nil
+		}
+	}
+	return -1, errors.Errorf("no free ids between %d and %d", gFirstID, gLastID)
+}
+
+// hasMember returns true if the given user is a supplementary member of the given group
+func hasMember(g *Group, user string) bool {
+	for _, member := range g.Members {
+		if member == user {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowDays converts the given time into days since the epoch
+func shadowDays(t time.Time) int {
+	return int(t.Unix() / 86400)
+}
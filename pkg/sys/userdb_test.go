@@ -0,0 +1,138 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// userDBTest creates an etc directory under the temp directory with a few users and groups
+func userDBTest(t *testing.T) (db *UserDB) {
+	resetTest()
+	etc := path.Join(tmpDir, "etc")
+	assert.Nil(t, os.MkdirAll(etc, 0755))
+	assert.Nil(t, os.WriteFile(path.Join(etc, "passwd"), []byte(""+
+		"root:x:0:0:root:/root:/bin/bash\n"+
+		"\n"+
+		"alice:x:1000:1000:Alice Smith,,,:/home/alice:/bin/zsh\n"), 0644))
+	assert.Nil(t, os.WriteFile(path.Join(etc, "group"), []byte(""+
+		"root:x:0:\n"+
+		"wheel:x:10:alice\n"+
+		"alice:x:1000:\n"), 0644))
+	assert.Nil(t, os.WriteFile(path.Join(etc, "shadow"), []byte(""+
+		"root:*:19000:0:99999:7:::\n"+
+		"alice:$6$salt$hash:19000:0:99999:7:::\n"), 0640))
+	db, err := OpenUserDB(tmpDir)
+	assert.Nil(t, err)
+	return
+}
+
+func TestReadUserDBFiles(t *testing.T) {
+	db := userDBTest(t)
+
+	assert.Equal(t, 2, len(db.Users))
+	assert.Equal(t, &Passwd{Name: "alice", Password: "x", UID: 1000, GID: 1000, Comment: "Alice Smith,,,",
+		Home: "/home/alice", Shell: "/bin/zsh"}, db.User("alice"))
+	assert.Equal(t, &Group{Name: "wheel", Password: "x", GID: 10, Members: []string{"alice"}}, db.GroupById(10))
+	assert.Equal(t, []string{}, db.Group("root").Members)
+	assert.Equal(t, &Shadow{Name: "root", Password: "*", LastChange: 19000, MinAge: 0, MaxAge: 99999, Warn: 7,
+		Inactive: -1, Expire: -1}, db.Shadow("root"))
+	assert.Equal(t, "root:*:19000:0:99999:7:::", db.Shadow("root").String())
+
+	groups, err := db.UserGroups("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"wheel", "alice"}, []string{groups[0].Name, groups[1].Name})
+
+	// invalid entries
+	{
+		target := path.Join(tmpDir, "etc", "passwd")
+		assert.Nil(t, os.WriteFile(target, []byte("root:x:0:0:root:/root:/bin/bash\nbob:x:1001\n"), 0644))
+		_, err := ReadPasswd(target)
+		assert.Equal(t, "invalid passwd entry at line 2 of "+target, err.Error())
+
+		assert.Nil(t, os.WriteFile(target, []byte("bob:x:bob:1001:::\n"), 0644))
+		target, _ = Abs(target)
+		_, err = OpenUserDB(tmpDir)
+		assert.Equal(t, "invalid passwd entry at line 1 of "+target+`: strconv.Atoi: parsing "bob": invalid syntax`, err.Error())
+	}
+
+	// system files
+	{
+		users, err := Users()
+		assert.Nil(t, err)
+		assert.Equal(t, "root", users[0].Name)
+		groups, err := Groups()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, groups[0].GID)
+	}
+}
+
+func TestUserDB(t *testing.T) {
+
+	// add users and groups
+	{
+		db := userDBTest(t)
+		assert.Nil(t, db.AddUser(&Passwd{Name: "bob", UID: -1, GID: -1, Home: "/home/bob", Shell: "/bin/sh"}))
+		assert.Equal(t, &Passwd{Name: "bob", Password: "x", UID: 1001, GID: 1001, Home: "/home/bob", Shell: "/bin/sh"}, db.User("bob"))
+		assert.Equal(t, "bob:x:1001:", db.Group("bob").String())
+		assert.Equal(t, "!", db.Shadow("bob").Password)
+		assert.Equal(t, shadowDays(time.Now()), db.Shadow("bob").LastChange)
+
+		assert.Nil(t, db.AddGroup(&Group{Name: "docker", GID: -1}))
+		assert.Equal(t, 1002, db.Group("docker").GID)
+		assert.Nil(t, db.AddUser(&Passwd{Name: "carol", UID: -1, GID: 10}))
+		assert.Equal(t, 1002, db.User("carol").UID)
+		assert.Nil(t, db.AddGroupMember("docker", "bob"))
+		assert.Nil(t, db.AddGroupMember("docker", "bob"))
+		assert.Equal(t, []string{"bob"}, db.Group("docker").Members)
+		assert.Nil(t, db.SetPassword("bob", "$6$new$hash"))
+		assert.Equal(t, "$6$new$hash", db.Shadow("bob").Password)
+
+		assert.Equal(t, "user alice already exists", db.AddUser(&Passwd{Name: "alice", UID: -1, GID: -1}).Error())
+		assert.Equal(t, "uid 1000 already exists", db.AddUser(&Passwd{Name: "dave", UID: 1000, GID: -1}).Error())
+		assert.Equal(t, "gid 5 doesn't exist", db.AddUser(&Passwd{Name: "dave", UID: -1, GID: 5}).Error())
+		assert.Equal(t, "gid 10 already exists", db.AddGroup(&Group{Name: "staff", GID: 10}).Error())
+		assert.Equal(t, "user dave doesn't exist", db.AddGroupMember("wheel", "dave").Error())
+		assert.Equal(t, "invalid password hash for user bob", db.SetPassword("bob", "a:b").Error())
+	}
+
+	// remove users and groups
+	{
+		db := userDBTest(t)
+		assert.Equal(t, "group alice is the primary group of user alice", db.RemoveGroup("alice").Error())
+		assert.Nil(t, db.RemoveUser("alice"))
+		assert.Nil(t, db.User("alice"))
+		assert.Nil(t, db.Shadow("alice"))
+		assert.Nil(t, db.Group("alice"))
+		assert.Equal(t, []string{}, db.Group("wheel").Members)
+		assert.Nil(t, db.RemoveGroup("wheel"))
+		assert.Equal(t, 1, len(db.Groups))
+		assert.Equal(t, "user alice doesn't exist", db.RemoveUser("alice").Error())
+	}
+
+	// save preserving modes and creating new databases
+	{
+		db := userDBTest(t)
+		assert.Nil(t, db.AddUser(&Passwd{Name: "bob", UID: -1, GID: 10}))
+		assert.Nil(t, db.Save())
+		data, err := ReadString(path.Join(tmpDir, "etc", "passwd"))
+		assert.Nil(t, err)
+		assert.Equal(t, "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000:Alice Smith,,,:/home/alice:/bin/zsh\nbob:x:1001:10:::\n", data)
+		assert.Equal(t, os.FileMode(0640), Mode(path.Join(tmpDir, "etc", "shadow")).Perm())
+
+		root := path.Join(tmpDir, "image")
+		db, err = OpenUserDB(root)
+		assert.Nil(t, err)
+		assert.Nil(t, db.AddGroup(&Group{Name: "root", GID: 0}))
+		assert.Nil(t, db.AddUser(&Passwd{Name: "root", UID: 0, GID: 0, Home: "/root", Shell: "/bin/sh"}))
+		assert.Nil(t, db.Save())
+		assert.Equal(t, os.FileMode(0600), Mode(path.Join(root, "etc", "shadow")).Perm())
+		db, err = OpenUserDB(root)
+		assert.Nil(t, err)
+		assert.Equal(t, "root:x:0:0::/root:/bin/sh", db.User("root").String())
+		assert.Equal(t, "root:x:0:", db.Group("root").String())
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"regexp"
 )
 
-var gRXSplitCmd = regexp.MustCompile(`'.+'|".+"|\S+`)
+var gRXSplitCmd = regexp.MustCompile(`'[^']*'|"[^"]*"|\S+`)
 
 // SplitCmd splits this cmd into substrings around spaces taking into account bash like
-// double and single quotes. Unmatched quotes throw and error and empty quotes are removed.
+// double and single quotes. Quotes are retained in the substrings and unmatched quotes
+// are not detected. Use SplitWords for POSIX sh compatible splitting.
 func SplitCmd(cmd string) (slice []string) {
 	return gRXSplitCmd.FindAllString(cmd, -1)
 }
@@ -20,6 +20,13 @@ func TestSplitCmd(t *testing.T) {
 		assert.Equal(t, expected, SplitCmd(cmd))
 	}
 
+	// Multiple quoted values are not greedy
+	{
+		cmd := `'a' 'b' "c" "d"`
+		expected := []string{"'a'", "'b'", `"c"`, `"d"`}
+		assert.Equal(t, expected, SplitCmd(cmd))
+	}
+
 	// single value with spaces with quotes
 	{
 		cmd := " '  foo' "
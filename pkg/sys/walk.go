@@ -0,0 +1,236 @@
+package sys
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/phR0ze/n/pkg/opt"
+)
+
+// WalkResult provides a single path found by WalkParallel or an error encountered
+type WalkResult struct {
+	Path string    // Absolute path found, links followed are reported by their link path
+	Info *FileInfo // Lstat information for the path or nil if it couldn't be read
+	Err  error     // Error encountered reading the path or directory
+}
+
+// WalkParallel walks the tree rooted at root with a bounded pool of workers streaming results
+// over the returned channel. The channel is closed once the walk completes or the context is
+// done. Directories that can't be read are reported a second time with the error set.
+// * Change the number of workers from the default of one per CPU by passing in WorkersOpt(n)
+// * Deliver results in the same deterministic order as Walk by passing in OrderedOpt(true)
+// * Follow links to directories by passing in FollowOpt(true), defaults to false
+// * Prune paths relative to root with the IncludeOpt and ExcludeOpt glob patterns
+func WalkParallel(ctx context.Context, root string, opts ...*opt.Opt) <-chan WalkResult {
+	w := &parallelWalker{
+		ctx:     ctx,
+		opts:    opts,
+		follow:  getFollowOpt(opts),
+		ordered: getOrderedOpt(opts),
+		visited: map[string]bool{},
+	}
+	workers := getWorkersOpt(opts)
+	w.results = make(chan WalkResult, workers)
+	w.cond = sync.NewCond(&w.mutex)
+	go w.start(root, workers)
+	return w.results
+}
+
+// parallelWalker provides the state shared between the WalkParallel workers
+type parallelWalker struct {
+	ctx     context.Context
+	opts    []*opt.Opt
+	root    string
+	follow  bool            // follow links to directories
+	ordered bool            // deliver results in deterministic order
+	results chan WalkResult // results delivered to the caller
+	mutex   sync.Mutex      // protects queue, pending and visited
+	cond    *sync.Cond      // signals changes to queue and pending
+	queue   []*walkNode     // directories waiting to be read
+	pending int             // directories queued or being read
+	visited map[string]bool // resolved directories reached through links
+	wg      sync.WaitGroup  // tracks running workers
+}
+
+// walkNode provides a path found and, for directories, the paths found under it
+type walkNode struct {
+	path     string        // path found
+	info     *FileInfo     // Lstat information for the path
+	dir      bool          // path is a directory or a followed link to one
+	err      error         // error reading the directory
+	children []*walkNode   // paths under the directory in sorted order
+	ready    chan struct{} // closed once the directory has been read
+}
+
+// start queues the root then runs the workers and the ordered emitter until done
+func (w *parallelWalker) start(root string, workers int) {
+	defer close(w.results)
+
+	info, err := Lstat(root)
+	if err != nil {
+		w.send(WalkResult{Path: root, Err: err})
+		return
+	}
+	w.root = info.Path
+	if resolved, err := filepath.EvalSymlinks(w.root); err == nil {
+		w.visited[resolved] = true
+	}
+	node := w.node(info.Path, info)
+	if !w.ordered && !w.send(WalkResult{Path: node.path, Info: node.info}) {
+		return
+	}
+
+	// Wake waiting workers when cancelled
+	stop := context.AfterFunc(w.ctx, func() {
+		w.mutex.Lock()
+		w.cond.Broadcast()
+		w.mutex.Unlock()
+	})
+	defer stop()
+
+	if node.dir {
+		w.push([]*walkNode{node})
+		w.wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go w.work()
+		}
+	}
+	if w.ordered {
+		w.emit(node)
+	}
+	w.wg.Wait()
+}
+
+// node creates a new node for the given path resolving links to directories when following
+func (w *parallelWalker) node(target string, info *FileInfo) (node *walkNode) {
+	node = &walkNode{path: target, info: info, dir: info.IsDir(), ready: make(chan struct{})}
+	if w.follow && info.IsSymlink() {
+		if resolved, err := filepath.EvalSymlinks(target); err == nil && IsDir(resolved) {
+			w.mutex.Lock()
+			node.dir = !w.visited[resolved]
+			w.visited[resolved] = true
+			w.mutex.Unlock()
+		}
+	}
+	return
+}
+
+// push queues the given directories in reverse order so that the first is read next
+func (w *parallelWalker) push(nodes []*walkNode) {
+	w.mutex.Lock()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		w.queue = append(w.queue, nodes[i])
+	}
+	w.pending += len(nodes)
+	w.cond.Broadcast()
+	w.mutex.Unlock()
+}
+
+// pop returns the next directory to read blocking until one is available or returns nil
+// once all directories have been read or the context is done
+func (w *parallelWalker) pop() (node *walkNode) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for len(w.queue) == 0 && w.pending > 0 && w.ctx.Err() == nil {
+		w.cond.Wait()
+	}
+	if len(w.queue) == 0 || w.ctx.Err() != nil {
+		return nil
+	}
+	node = w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return
+}
+
+// work reads queued directories until there are none left
+func (w *parallelWalker) work() {
+	defer w.wg.Done()
+	for node := w.pop(); node != nil; node = w.pop() {
+		w.read(node)
+		w.mutex.Lock()
+		w.pending--
+		w.cond.Broadcast()
+		w.mutex.Unlock()
+	}
+}
+
+// read reads the given directory's entries queuing any directories found
+func (w *parallelWalker) read(node *walkNode) {
+	defer close(node.ready)
+
+	var names []string
+	if names, node.err = ReadDirnames(node.path); node.err != nil {
+		if !w.ordered {
+			w.send(WalkResult{Path: node.path, Info: node.info, Err: node.err})
+		}
+		return
+	}
+
+	dirs := []*walkNode{}
+	for _, name := range names {
+		target := filepath.Join(node.path, name)
+		info, err := Lstat(target)
+		if err != nil {
+			child := &walkNode{path: target, err: err, ready: make(chan struct{})}
+			close(child.ready)
+			node.children = append(node.children, child)
+			if !w.ordered && !w.send(WalkResult{Path: target, Err: err}) {
+				return
+			}
+			continue
+		}
+		if copyFilter(TrimShared(target, w.root), info.IsDir(), w.opts) {
+			continue
+		}
+		child := w.node(target, info)
+		if child.dir {
+			dirs = append(dirs, child)
+		}
+		if w.ordered {
+			node.children = append(node.children, child)
+		} else if !w.send(WalkResult{Path: target, Info: info}) {
+			return
+		}
+	}
+	w.push(dirs)
+}
+
+// emit delivers the given node and all nodes under it in order waiting on each directory
+// to be read. Returns false if the context is done.
+func (w *parallelWalker) emit(node *walkNode) bool {
+	if node.info == nil {
+		return w.send(WalkResult{Path: node.path, Err: node.err})
+	}
+	if !w.send(WalkResult{Path: node.path, Info: node.info}) {
+		return false
+	}
+	if !node.dir {
+		return true
+	}
+	select {
+	case <-node.ready:
+	case <-w.ctx.Done():
+		return false
+	}
+	if node.err != nil {
+		return w.send(WalkResult{Path: node.path, Info: node.info, Err: node.err})
+	}
+	for _, child := range node.children {
+		if !w.emit(child) {
+			return false
+		}
+	}
+	node.children = nil
+	return true
+}
+
+// send delivers the given result returning false if the context is done
+func (w *parallelWalker) send(result WalkResult) bool {
+	select {
+	case w.results <- result:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,116 @@
+package sys
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// walkTree creates a tree of the given depth and breadth with a file in every directory
+func walkTree(t *testing.T, dir string, depth, breadth int) {
+	writeTestFile(t, path.Join(dir, "file"), "data")
+	if depth == 0 {
+		return
+	}
+	for i := 0; i < breadth; i++ {
+		walkTree(t, path.Join(dir, string(rune('a'+i))), depth-1, breadth)
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "walk")
+	walkTree(t, dir, 3, 3)
+	expected, err := AllPaths(dir)
+	assert.Nil(t, err)
+	assert.Len(t, expected, 80)
+
+	// unordered
+	{
+		paths := []string{}
+		for result := range WalkParallel(context.Background(), dir, WorkersOpt(4)) {
+			assert.Nil(t, result.Err)
+			assert.Equal(t, result.Path, result.Info.Path)
+			paths = append(paths, result.Path)
+		}
+		sort.Strings(paths)
+		sorted := append([]string{}, expected...)
+		sort.Strings(sorted)
+		assert.Equal(t, sorted, paths)
+	}
+
+	// ordered matches Walk
+	{
+		paths := []string{}
+		for result := range WalkParallel(context.Background(), dir, OrderedOpt(true)) {
+			paths = append(paths, result.Path)
+		}
+		assert.Equal(t, expected, paths)
+	}
+
+	// exclude prunes directories
+	{
+		paths := []string{}
+		for result := range WalkParallel(context.Background(), dir, ExcludeOpt("a", "file"), OrderedOpt(true)) {
+			paths = append(paths, TrimShared(result.Path, expected[0]))
+		}
+		assert.Equal(t, []string{"", "b", "b/b", "b/b/b", "b/b/c", "b/c", "b/c/b", "b/c/c",
+			"c", "c/b", "c/b/b", "c/b/c", "c/c", "c/c/b", "c/c/c"}, paths)
+	}
+
+	// cancellation closes the channel
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		results := WalkParallel(ctx, dir, WorkersOpt(2))
+		<-results
+		cancel()
+		count := 1
+		for range results {
+			count++
+		}
+		assert.True(t, count < len(expected))
+		assert.Equal(t, context.Canceled, ctx.Err())
+	}
+}
+
+func TestWalkParallel_Links(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "walk")
+	writeTestFile(t, path.Join(dir, "sub", "file"), "data")
+	assert.Nil(t, os.Symlink("sub", path.Join(dir, "link")))
+	assert.Nil(t, os.Symlink("..", path.Join(dir, "sub", "loop")))
+
+	// not followed by default
+	{
+		paths := []string{}
+		for result := range WalkParallel(context.Background(), dir, OrderedOpt(true)) {
+			paths = append(paths, path.Base(result.Path))
+		}
+		assert.Equal(t, []string{"walk", "link", "sub", "file", "loop"}, paths)
+	}
+
+	// followed once
+	{
+		abs, _ := Abs(dir)
+		paths := []string{}
+		for result := range WalkParallel(context.Background(), dir, OrderedOpt(true), FollowOpt(true)) {
+			paths = append(paths, TrimShared(result.Path, abs))
+		}
+		assert.Equal(t, []string{"", "link", "link/file", "link/loop", "sub", "sub/file", "sub/loop"}, paths)
+	}
+}
+
+func TestWalkParallel_Errors(t *testing.T) {
+	results := []WalkResult{}
+	for result := range WalkParallel(context.Background(), path.Join(tmpDir, "bogus")) {
+		results = append(results, result)
+	}
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].Info)
+	assert.True(t, os.IsNotExist(errors.Cause(results[0].Err)))
+}
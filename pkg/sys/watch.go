@@ -0,0 +1,402 @@
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+)
+
+var (
+	// gWatchDebounce is the default quiet period to coalesce events over
+	gWatchDebounce = 100 * time.Millisecond
+
+	// gWatchPoll is the default polling interval when native watching is unavailable
+	gWatchPoll = time.Second
+)
+
+// ErrWatchOverflow indicates that the kernel's event queue overflowed and events were lost
+var ErrWatchOverflow = errors.New("watch event queue overflowed")
+
+// Op describes the set of changes in a watch event
+type Op uint32
+
+const (
+	// OpCreate indicates a path was created or moved into a watched directory
+	OpCreate Op = 1 << iota
+
+	// OpWrite indicates a file's content was written to
+	OpWrite
+
+	// OpRemove indicates a path was removed
+	OpRemove
+
+	// OpRename indicates a path was renamed or moved out of a watched directory
+	OpRename
+
+	// OpChmod indicates a path's attributes e.g. mode, owner or times were changed
+	OpChmod
+)
+
+// String returns the op's names joined with | e.g. create|write
+func (op Op) String() string {
+	names := []string{}
+	for i, name := range []string{"create", "write", "remove", "rename", "chmod"} {
+		if op&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchEvent provides the changes to a path over a debounce period
+type WatchEvent struct {
+	Path string // Absolute path that changed
+	Op   Op     // All changes that occurred to the path
+}
+
+// String returns the event in the form op path
+func (e WatchEvent) String() string {
+	return fmt.Sprintf("%s %s", e.Op, e.Path)
+}
+
+// watchBackend provides platform specific native watching
+type watchBackend interface {
+	add(root string) error
+	remove(root string) error
+	close() error
+}
+
+// Watcher provides filesystem change notifications using inotify where available falling back
+// on polling for platforms or filesystems that lack it. Events are coalesced per path until no
+// new events have arrived for the debounce period then delivered in the order first seen.
+type Watcher struct {
+	opts     []*opt.Opt                       // options including the include and exclude filters
+	recurse  bool                             // watch directories recursively
+	debounce time.Duration                    // quiet period to coalesce events over
+	interval time.Duration                    // polling interval
+	native   watchBackend                     // native backend or nil if polling only
+	mutex    sync.Mutex                       // protects roots and polled
+	roots    map[string]bool                  // watched root paths
+	polled   map[string]map[string]*pollState // polled root paths with their last snapshot
+	raw      chan WatchEvent                  // events from the backends to debounce
+	events   chan WatchEvent                  // debounced events
+	errors   chan error                       // errors from the backends
+	done     chan struct{}                    // closed to stop all goroutines
+	wg       sync.WaitGroup                   // tracks running goroutines
+	once     sync.Once                        // ensures closing only occurs once
+}
+
+// NewWatcher creates a new watcher. Add paths to watch with Add and receive events with Events.
+// * Watch directories recursively by passing in RecurseOpt(true)
+// * Filter paths relative to the watched path with the IncludeOpt and ExcludeOpt glob patterns
+// * Change the debounce period from the default of 100ms by passing in DebounceOpt(duration)
+// * Force polling at the given interval rather than native watching by passing in PollOpt(duration)
+func NewWatcher(opts ...*opt.Opt) (w *Watcher, err error) {
+	w = &Watcher{
+		opts:     opts,
+		recurse:  getRecurseOpt(opts),
+		debounce: gWatchDebounce,
+		interval: getPollOpt(opts),
+		roots:    map[string]bool{},
+		polled:   map[string]map[string]*pollState{},
+		raw:      make(chan WatchEvent, 64),
+		events:   make(chan WatchEvent, 64),
+		errors:   make(chan error, 16),
+		done:     make(chan struct{}),
+	}
+	if opt.Exists(opts, "debounce") {
+		w.debounce = getDebounceOpt(opts)
+	}
+
+	// Use native watching unless polling was requested
+	if w.interval <= 0 {
+		w.interval = gWatchPoll
+		w.native, _ = newWatchBackend(w)
+	}
+
+	w.wg.Add(2)
+	go w.run()
+	go w.poll()
+	return
+}
+
+// Add starts watching the given path. Directories are watched for changes to their entries
+// and recursively if RecurseOpt(true) was given. Paths that can't be watched natively are polled.
+func (w *Watcher) Add(target string) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	if _, err = os.Lstat(target); err != nil {
+		err = errors.Wrapf(err, "failed to watch %s", target)
+		return
+	}
+
+	w.mutex.Lock()
+	if w.roots[target] {
+		w.mutex.Unlock()
+		return
+	}
+	w.roots[target] = true
+	w.mutex.Unlock()
+
+	// Fall back on polling if native watching fails
+	if w.native != nil {
+		if err = w.native.add(target); err == nil {
+			return
+		}
+		w.native.remove(target)
+		err = nil
+	}
+	snapshot := w.snapshot(target)
+	w.mutex.Lock()
+	w.polled[target] = snapshot
+	w.mutex.Unlock()
+	return
+}
+
+// Close stops watching all paths and closes the events and errors channels
+func (w *Watcher) Close() (err error) {
+	w.once.Do(func() {
+		close(w.done)
+		if w.native != nil {
+			err = w.native.close()
+		}
+		w.wg.Wait()
+		close(w.events)
+		close(w.errors)
+	})
+	return
+}
+
+// Errors returns the channel errors are delivered on e.g. ErrWatchOverflow. Errors are
+// dropped if not received.
+func (w *Watcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Events returns the channel debounced events are delivered on
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Remove stops watching the given path
+func (w *Watcher) Remove(target string) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	w.mutex.Lock()
+	if !w.roots[target] {
+		w.mutex.Unlock()
+		err = errors.Errorf("failed to remove %s as it isn't being watched", target)
+		return
+	}
+	delete(w.roots, target)
+	_, polled := w.polled[target]
+	delete(w.polled, target)
+	w.mutex.Unlock()
+
+	if !polled && w.native != nil {
+		err = w.native.remove(target)
+	}
+	return
+}
+
+// error delivers the given error dropping it if the errors channel is full
+func (w *Watcher) error(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
+
+// filter returns true if the given path should be ignored according to the include and exclude
+// options relative to the watched root it falls under
+func (w *Watcher) filter(target string, isDir bool) bool {
+	root := ""
+	w.mutex.Lock()
+	for r := range w.roots {
+		if (target == r || strings.HasPrefix(target, r+"/")) && len(r) > len(root) {
+			root = r
+		}
+	}
+	w.mutex.Unlock()
+
+	rel := TrimShared(target, root)
+	if root == "" || rel == "" {
+		rel = path.Base(target)
+	}
+	return copyFilter(rel, isDir, w.opts)
+}
+
+// send delivers the given raw event to be debounced unless filtered out
+func (w *Watcher) send(target string, op Op, isDir bool) {
+	if op == 0 || w.filter(target, isDir) {
+		return
+	}
+	select {
+	case w.raw <- WatchEvent{Path: target, Op: op}:
+	case <-w.done:
+	}
+}
+
+// run debounces raw events coalescing them per path until the debounce period passes
+// without any new events then delivers them in the order first seen
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	pending := map[string]Op{}
+	order := []string{}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		select {
+		case e := <-w.raw:
+			if _, ok := pending[e.Path]; !ok {
+				order = append(order, e.Path)
+			}
+			pending[e.Path] |= e.Op
+			timer.Reset(w.debounce)
+
+		case <-timer.C:
+			for _, p := range order {
+				select {
+				case w.events <- WatchEvent{Path: p, Op: pending[p]}:
+				case <-w.done:
+					return
+				}
+			}
+			pending, order = map[string]Op{}, []string{}
+
+		case <-w.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// pollState provides the attributes of a path used to detect changes when polling
+type pollState struct {
+	mode  os.FileMode
+	size  int64
+	mtime time.Time
+	ino   uint64
+}
+
+// poll periodically snapshots the polled roots and sends events for the differences
+func (w *Watcher) poll() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mutex.Lock()
+		roots := make([]string, 0, len(w.polled))
+		for root := range w.polled {
+			roots = append(roots, root)
+		}
+		w.mutex.Unlock()
+		sort.Strings(roots)
+
+		for _, root := range roots {
+			cur := w.snapshot(root)
+			w.mutex.Lock()
+			prev, ok := w.polled[root]
+			if ok {
+				w.polled[root] = cur
+			}
+			w.mutex.Unlock()
+			if ok {
+				w.diff(prev, cur)
+			}
+		}
+	}
+}
+
+// diff sends events for the differences between the two snapshots. Removed paths with the same
+// inode as a created path are reported as a rename of the old path and create of the new path.
+func (w *Watcher) diff(prev, cur map[string]*pollState) {
+	created := map[uint64]string{}
+	for _, p := range sortedStates(cur) {
+		if _, ok := prev[p]; !ok {
+			created[cur[p].ino] = p
+		}
+	}
+
+	for _, p := range sortedStates(prev) {
+		old := prev[p]
+		state, ok := cur[p]
+		switch {
+		case !ok:
+			if _, renamed := created[old.ino]; renamed {
+				w.send(p, OpRename, old.mode.IsDir())
+			} else {
+				w.send(p, OpRemove, old.mode.IsDir())
+			}
+		default:
+			var op Op
+			if !state.mode.IsDir() && (state.size != old.size || !state.mtime.Equal(old.mtime)) {
+				op |= OpWrite
+			}
+			if state.mode != old.mode {
+				op |= OpChmod
+			}
+			w.send(p, op, state.mode.IsDir())
+		}
+	}
+	for _, p := range sortedStates(cur) {
+		if _, ok := prev[p]; !ok {
+			w.send(p, OpCreate, cur[p].mode.IsDir())
+		}
+	}
+}
+
+// snapshot returns the state of the root and the paths under it that aren't filtered out
+func (w *Watcher) snapshot(root string) (result map[string]*pollState) {
+	result = map[string]*pollState{}
+	Walk(root, func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != root && w.filter(p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		state := &pollState{mode: info.Mode(), size: info.Size(), mtime: info.ModTime()}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			state.ino = uint64(stat.Ino)
+		}
+		result[p] = state
+		if p != root && info.IsDir() && !w.recurse {
+			return filepath.SkipDir
+		}
+		return nil
+	}, FollowOpt(false))
+	return
+}
+
+// sortedStates returns the paths of the given snapshot in sorted order
+func sortedStates(states map[string]*pollState) (paths []string) {
+	paths = make([]string, 0, len(states))
+	for p := range states {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return
+}
@@ -0,0 +1,14 @@
+//go:build darwin
+// +build darwin
+
+package sys
+
+import (
+	"github.com/pkg/errors"
+)
+
+// newWatchBackend isn't implemented for darwin so watching falls back on polling
+func newWatchBackend(w *Watcher) (backend watchBackend, err error) {
+	err = errors.New("native watching not implemented for darwin")
+	return
+}
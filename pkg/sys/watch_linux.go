@@ -0,0 +1,193 @@
+//go:build linux
+// +build linux
+
+package sys
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// inotify events watched for
+const gInotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_DELETE |
+	unix.IN_DELETE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+
+// inotify provides the native linux watch backend
+type inotify struct {
+	w     *Watcher
+	fd    int            // inotify instance used directly as calling Fd on file makes it blocking
+	file  *os.File       // non blocking inotify instance so that closing unblocks reads
+	mutex sync.Mutex     // protects wds and paths
+	wds   map[int]string // watch descriptors to paths
+	paths map[string]int // paths to watch descriptors
+}
+
+// newWatchBackend creates a new inotify instance and starts reading events from it
+func newWatchBackend(w *Watcher) (backend watchBackend, err error) {
+	var fd int
+	if fd, err = unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK); err != nil {
+		err = errors.Wrap(err, "failed to initialize inotify")
+		return
+	}
+	n := &inotify{w: w, fd: fd, file: os.NewFile(uintptr(fd), "inotify"), wds: map[int]string{}, paths: map[string]int{}}
+	w.wg.Add(1)
+	go n.read()
+	backend = n
+	return
+}
+
+// add watches the given root and all directories under it if recursive
+func (n *inotify) add(root string) (err error) {
+	if !n.w.recurse || !IsDir(root) {
+		return n.watch(root)
+	}
+	return Walk(root, func(p string, info *FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != root && n.w.filter(p, true) {
+			return filepath.SkipDir
+		}
+		return n.watch(p)
+	}, FollowOpt(false))
+}
+
+// close closes the inotify instance which removes all watches
+func (n *inotify) close() error {
+	return n.file.Close()
+}
+
+// remove stops watching the given root and all paths under it
+func (n *inotify) remove(root string) (err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for p, wd := range n.paths {
+		if p == root || strings.HasPrefix(p, root+"/") {
+			unix.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.paths, p)
+			delete(n.wds, wd)
+		}
+	}
+	return
+}
+
+// watch adds an inotify watch for the given path
+func (n *inotify) watch(target string) (err error) {
+	var wd int
+	if wd, err = unix.InotifyAddWatch(n.fd, target, gInotifyMask); err != nil {
+		err = errors.Wrapf(err, "failed to watch %s", target)
+		return
+	}
+	n.mutex.Lock()
+	n.wds[wd] = target
+	n.paths[target] = wd
+	n.mutex.Unlock()
+	return
+}
+
+// read reads events from the inotify instance until it is closed
+func (n *inotify) read() {
+	defer n.w.wg.Done()
+	buf := make([]byte, 4096*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				n.w.error(errors.Wrap(err, "failed reading inotify events"))
+			}
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= count; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			n.handle(int(event.Wd), event.Mask, strings.TrimRight(string(buf[start:offset]), "\x00"))
+		}
+	}
+}
+
+// handle converts the given inotify event into a watch event
+func (n *inotify) handle(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		n.w.error(ErrWatchOverflow)
+		return
+	}
+
+	n.mutex.Lock()
+	dir, ok := n.wds[wd]
+	if ok && mask&unix.IN_IGNORED != 0 {
+		delete(n.wds, wd)
+		if n.paths[dir] == wd {
+			delete(n.paths, dir)
+		}
+	}
+	n.mutex.Unlock()
+	if !ok || mask&unix.IN_IGNORED != 0 {
+		return
+	}
+
+	target := dir
+	if name != "" {
+		target = path.Join(dir, name)
+	}
+	isDir := mask&unix.IN_ISDIR != 0
+
+	var op Op
+	switch {
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		op = OpCreate
+	case mask&unix.IN_MODIFY != 0:
+		op = OpWrite
+	case mask&unix.IN_ATTRIB != 0:
+		op = OpChmod
+	case mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0:
+		op = OpRemove
+	case mask&(unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0:
+		op = OpRename
+	}
+	n.w.send(target, op, isDir)
+
+	if !isDir || !n.w.recurse {
+		return
+	}
+
+	// Stop watching directories moved away as their paths are no longer valid
+	if mask&unix.IN_MOVED_FROM != 0 {
+		n.remove(target)
+		return
+	}
+
+	// Watch new directories reporting anything created in them before the watch was added
+	if op == OpCreate && !n.w.filter(target, true) {
+		Walk(target, func(p string, info *FileInfo, e error) error {
+			if e != nil {
+				return nil
+			}
+			if p != target {
+				if n.w.filter(p, info.IsDir()) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				n.w.send(p, OpCreate, info.IsDir())
+			}
+			if info.IsDir() {
+				if e = n.watch(p); e != nil {
+					n.w.error(e)
+				}
+			}
+			return nil
+		}, FollowOpt(false))
+	}
+}
@@ -0,0 +1,141 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// watchEvents collects events from the watcher keyed by path relative to the given dir until
+// the given number of paths have been seen or the timeout occurs
+func watchEvents(w *Watcher, dir string, count int, wait ...time.Duration) map[string]Op {
+	dir, _ = Abs(dir)
+	result := map[string]Op{}
+	timeout := time.After(5 * time.Second)
+	if len(wait) > 0 {
+		timeout = time.After(wait[0])
+	}
+	for len(result) < count {
+		select {
+		case e := <-w.Events():
+			result[TrimShared(e.Path, dir)] |= e.Op
+		case <-timeout:
+			return result
+		}
+	}
+
+	// Catch any trailing events
+	select {
+	case e := <-w.Events():
+		result[TrimShared(e.Path, dir)] |= e.Op
+	case <-time.After(200 * time.Millisecond):
+	}
+	return result
+}
+
+func TestOp_String(t *testing.T) {
+	assert.Equal(t, "", Op(0).String())
+	assert.Equal(t, "create|write", (OpCreate | OpWrite).String())
+	assert.Equal(t, "remove|rename|chmod", (OpRemove | OpRename | OpChmod).String())
+	assert.Equal(t, "write /foo", WatchEvent{Path: "/foo", Op: OpWrite}.String())
+}
+
+func TestWatcher(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		resetTest()
+		dir := path.Join(tmpDir, "watch")
+		writeTestFile(t, path.Join(dir, "existing"), "data")
+
+		opts := []*opt.Opt{RecurseOpt(true), DebounceOpt(50 * time.Millisecond), ExcludeOpt("*.swp", "skip")}
+		if poll {
+			opts = append(opts, PollOpt(50*time.Millisecond))
+		}
+		w, err := NewWatcher(opts...)
+		assert.Nil(t, err)
+		assert.Nil(t, w.Add(dir))
+		assert.Nil(t, w.Add(dir))
+
+		// create, write and nested directories
+		{
+			writeTestFile(t, path.Join(dir, "a.txt"), "a")
+			writeTestFile(t, path.Join(dir, "a.swp"), "a")
+			writeTestFile(t, path.Join(dir, "skip", "b.txt"), "b")
+			writeTestFile(t, path.Join(dir, "sub", "c.txt"), "c")
+			events := watchEvents(w, dir, 3)
+			assert.Equal(t, 3, len(events), poll)
+			assert.True(t, events["a.txt"]&OpCreate != 0, poll)
+			assert.True(t, events["sub"]&OpCreate != 0, poll)
+			assert.True(t, events["sub/c.txt"]&OpCreate != 0, poll)
+		}
+
+		// write to a nested directory created after watching
+		{
+			time.Sleep(100 * time.Millisecond)
+			assert.Nil(t, WriteString(path.Join(dir, "sub", "c.txt"), "more"))
+			events := watchEvents(w, dir, 1)
+			assert.Equal(t, map[string]Op{"sub/c.txt": OpWrite}, events, poll)
+		}
+
+		// chmod, rename and remove
+		{
+			assert.Nil(t, os.Chmod(path.Join(dir, "existing"), 0600))
+			assert.Nil(t, os.Rename(path.Join(dir, "a.txt"), path.Join(dir, "d.txt")))
+			assert.Nil(t, os.Remove(path.Join(dir, "sub", "c.txt")))
+			events := watchEvents(w, dir, 4)
+			assert.True(t, events["existing"]&OpChmod != 0, poll)
+			assert.True(t, events["a.txt"]&OpRename != 0, poll)
+			assert.True(t, events["d.txt"]&OpCreate != 0, poll)
+			assert.True(t, events["sub/c.txt"]&OpRemove != 0, poll)
+		}
+
+		// removed paths are no longer watched
+		{
+			assert.Nil(t, w.Remove(dir))
+			abs, _ := Abs(dir)
+			assert.Equal(t, "failed to remove "+abs+" as it isn't being watched", w.Remove(dir).Error())
+			writeTestFile(t, path.Join(dir, "e.txt"), "e")
+			assert.Equal(t, map[string]Op{}, watchEvents(w, dir, 1, 500*time.Millisecond), poll)
+		}
+
+		assert.Nil(t, w.Close())
+		assert.Nil(t, w.Close())
+		_, ok := <-w.Events()
+		assert.False(t, ok)
+	}
+}
+
+func TestWatcher_Include(t *testing.T) {
+	resetTest()
+	dir := path.Join(tmpDir, "watch")
+	_, err := MkdirP(dir)
+	assert.Nil(t, err)
+
+	w, err := NewWatcher(IncludeOpt("*.yaml"), DebounceOpt(20*time.Millisecond))
+	assert.Nil(t, err)
+	defer w.Close()
+	assert.Nil(t, w.Add(dir))
+
+	writeTestFile(t, path.Join(dir, "a.txt"), "a")
+	writeTestFile(t, path.Join(dir, "b.yaml"), "b")
+	writeTestFile(t, path.Join(dir, "sub", "c.yaml"), "c")
+	events := watchEvents(w, dir, 2)
+	assert.Equal(t, 2, len(events))
+	assert.True(t, events["b.yaml"]&OpCreate != 0)
+	assert.Equal(t, OpCreate, events["sub"])
+}
+
+func TestWatcher_Errors(t *testing.T) {
+	w, err := NewWatcher()
+	assert.Nil(t, err)
+	defer w.Close()
+
+	err = w.Add(path.Join(tmpDir, "bogus"))
+	assert.True(t, strings.HasPrefix(err.Error(), "failed to watch "))
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+}
@@ -0,0 +1,704 @@
+package sys
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phR0ze/n/pkg/opt"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// XattrACLAccess is the extended attribute holding a path's POSIX access ACL
+	XattrACLAccess = "system.posix_acl_access"
+
+	// XattrACLDefault is the extended attribute holding a directory's POSIX default ACL
+	XattrACLDefault = "system.posix_acl_default"
+
+	// XattrCaps is the extended attribute holding a file's capabilities
+	XattrCaps = "security.capability"
+)
+
+// ErrNoXattr is returned when the requested extended attribute doesn't exist
+var ErrNoXattr = errors.New("no such extended attribute")
+
+// GetXattr returns the value of the given extended attribute of the target.
+// * Follows links by default but can be turned off by passing in FollowOpt(false)
+// * Returns an error wrapping ErrNoXattr when the attribute doesn't exist
+func GetXattr(target, name string, opts ...*opt.Opt) (val []byte, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	defaultFollowOpt(&opts, true)
+	if val, err = getXattr(target, name, getFollowOpt(opts)); err != nil {
+		err = errors.Wrapf(err, "failed to get xattr %s of %s", name, target)
+	}
+	return
+}
+
+// GetXattrs returns all the extended attributes of the target keyed by name.
+// * Follows links by default but can be turned off by passing in FollowOpt(false)
+func GetXattrs(target string, opts ...*opt.Opt) (xattrs map[string][]byte, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	defaultFollowOpt(&opts, true)
+	return getXattrs(target, getFollowOpt(opts))
+}
+
+// ListXattrs returns the sorted names of the extended attributes of the target.
+// * Follows links by default but can be turned off by passing in FollowOpt(false)
+func ListXattrs(target string, opts ...*opt.Opt) (names []string, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	defaultFollowOpt(&opts, true)
+	return listXattrs(target, getFollowOpt(opts))
+}
+
+// RemoveXattr removes the given extended attribute from the target.
+// * Follows links by default but can be turned off by passing in FollowOpt(false)
+// * Returns an error wrapping ErrNoXattr when the attribute doesn't exist
+func RemoveXattr(target, name string, opts ...*opt.Opt) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	defaultFollowOpt(&opts, true)
+	if getFollowOpt(opts) {
+		err = unix.Removexattr(target, name)
+	} else {
+		err = unix.Lremovexattr(target, name)
+	}
+	if err != nil {
+		err = errors.Wrapf(xattrError(err), "failed to remove xattr %s of %s", name, target)
+	}
+	return
+}
+
+// SetXattr sets the given extended attribute of the target creating or replacing it. Only root
+// may set trusted.* and security.* attributes.
+// * Follows links by default but can be turned off by passing in FollowOpt(false)
+func SetXattr(target, name string, val []byte, opts ...*opt.Opt) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	defaultFollowOpt(&opts, true)
+	if getFollowOpt(opts) {
+		err = unix.Setxattr(target, name, val, 0)
+	} else {
+		err = unix.Lsetxattr(target, name, val, 0)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set xattr %s of %s", name, target)
+	}
+	return
+}
+
+// Xattr returns the value of the given extended attribute of the path the info was created for
+// without following links. Returns an error wrapping ErrNoXattr when it doesn't exist.
+func (info *FileInfo) Xattr(name string) (val []byte, err error) {
+	var target string
+	if target, err = info.osPath(); err != nil {
+		return
+	}
+	if val, err = getXattr(target, name, false); err != nil {
+		err = errors.Wrapf(err, "failed to get xattr %s of %s", name, target)
+	}
+	return
+}
+
+// Xattrs returns all the extended attributes of the path the info was created for keyed by name
+// without following links
+func (info *FileInfo) Xattrs() (xattrs map[string][]byte, err error) {
+	var target string
+	if target, err = info.osPath(); err != nil {
+		return
+	}
+	return getXattrs(target, false)
+}
+
+// ListXattrs returns the sorted names of the extended attributes of the path the info was
+// created for without following links
+func (info *FileInfo) ListXattrs() (names []string, err error) {
+	var target string
+	if target, err = info.osPath(); err != nil {
+		return
+	}
+	return listXattrs(target, false)
+}
+
+// osPath returns the OS path for the info or an error if it didn't come from the OS
+func (info *FileInfo) osPath() (string, error) {
+	if target, ok := fsOSPath(info.fsys, info.Path, false); ok {
+		return target, nil
+	}
+	return "", errors.Wrapf(unix.ENOTSUP, "extended attributes of %s are only supported on the OS filesystem", info.Path)
+}
+
+// getXattr reads the given extended attribute growing the buffer if it changes size in between
+func getXattr(target, name string, follow bool) (val []byte, err error) {
+	get := unix.Lgetxattr
+	if follow {
+		get = unix.Getxattr
+	}
+	for {
+		var size int
+		if size, err = get(target, name, nil); err != nil {
+			return nil, xattrError(err)
+		}
+		val = make([]byte, size)
+		if size, err = get(target, name, val); err != unix.ERANGE {
+			if err != nil {
+				return nil, xattrError(err)
+			}
+			return val[:size], nil
+		}
+	}
+}
+
+// getXattrs reads all extended attributes of the target
+func getXattrs(target string, follow bool) (xattrs map[string][]byte, err error) {
+	var names []string
+	if names, err = listXattrs(target, follow); err != nil {
+		return
+	}
+	xattrs = map[string][]byte{}
+	for _, name := range names {
+		var val []byte
+		if val, err = getXattr(target, name, follow); err != nil {
+			return nil, errors.Wrapf(err, "failed to get xattr %s of %s", name, target)
+		}
+		xattrs[name] = val
+	}
+	return
+}
+
+// listXattrs lists the names of the extended attributes of the target
+func listXattrs(target string, follow bool) (names []string, err error) {
+	list := unix.Llistxattr
+	if follow {
+		list = unix.Listxattr
+	}
+	names = []string{}
+	for {
+		var size int
+		if size, err = list(target, nil); err != nil || size == 0 {
+			break
+		}
+		buf := make([]byte, size)
+		if size, err = list(target, buf); err != unix.ERANGE {
+			if err == nil {
+				for _, name := range strings.Split(string(buf[:size]), "\x00") {
+					if name != "" {
+						names = append(names, name)
+					}
+				}
+			}
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list xattrs of %s", target)
+	}
+	sort.Strings(names)
+	return
+}
+
+// xattrError converts the platform's missing attribute error into ErrNoXattr
+func xattrError(err error) error {
+	if err == gErrNoXattr {
+		return ErrNoXattr
+	}
+	return err
+}
+
+// ACLTag identifies the type of an ACL entry using the values of the xattr encoding
+type ACLTag uint16
+
+const (
+	// ACLUserObj is the entry for the owning user
+	ACLUserObj ACLTag = 0x01
+
+	// ACLUser is the entry for a named user
+	ACLUser ACLTag = 0x02
+
+	// ACLGroupObj is the entry for the owning group
+	ACLGroupObj ACLTag = 0x04
+
+	// ACLGroup is the entry for a named group
+	ACLGroup ACLTag = 0x08
+
+	// ACLMask is the entry limiting the permissions of named entries and the owning group
+	ACLMask ACLTag = 0x10
+
+	// ACLOther is the entry for everyone else
+	ACLOther ACLTag = 0x20
+)
+
+// gACLVersion is the version of the xattr encoding of ACLs
+const gACLVersion = 2
+
+// gACLUndefinedID is the id of ACL entries that aren't for a named user or group
+const gACLUndefinedID = 0xffffffff
+
+// ACLEntry is a single entry of a POSIX ACL
+type ACLEntry struct {
+	Tag  ACLTag      // type of entry
+	ID   int         // uid or gid for named entries or -1
+	Perm os.FileMode // read, write and execute bits i.e. 0 to 7
+}
+
+// ACL is a POSIX access control list
+type ACL []ACLEntry
+
+// String returns the ACL in the short text form accepted by setfacl e.g. user::rw-,group::r--
+func (a ACL) String() string {
+	entries := []string{}
+	for _, e := range a {
+		id := ""
+		if e.Tag == ACLUser || e.Tag == ACLGroup {
+			id = strconv.Itoa(e.ID)
+		}
+		perm := []byte("---")
+		for i, c := range "rwx" {
+			if e.Perm&(4>>i) != 0 {
+				perm[i] = byte(c)
+			}
+		}
+		entries = append(entries, fmt.Sprintf("%s:%s:%s", gACLTagNames[e.Tag], id, perm))
+	}
+	return strings.Join(entries, ",")
+}
+
+// gACLTagNames maps the ACL tags to their text form
+var gACLTagNames = map[ACLTag]string{
+	ACLUserObj: "user", ACLUser: "user", ACLGroupObj: "group", ACLGroup: "group", ACLMask: "mask", ACLOther: "other",
+}
+
+// ParseACL parses the text form of an ACL i.e. comma or newline separated entries of the form
+// tag:qualifier:perms as used by getfacl and setfacl. Tags may be abbreviated to u, g, m and o,
+// qualifiers may be names or ids and comments are ignored.
+func ParseACL(text string) (acl ACL, err error) {
+	acl = ACL{}
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) == 2 && (fields[0] == "m" || fields[0] == "mask" || fields[0] == "o" || fields[0] == "other") {
+			fields = []string{fields[0], "", fields[1]}
+		}
+		if len(fields) != 3 {
+			return nil, errors.Errorf("invalid ACL entry %q", line)
+		}
+		entry := ACLEntry{ID: -1}
+		named := fields[1] != ""
+		switch fields[0] {
+		case "u", "user":
+			if entry.Tag = ACLUserObj; named {
+				entry.Tag = ACLUser
+				if entry.ID, err = strconv.Atoi(fields[1]); err != nil {
+					var u *User
+					if u, err = LookupUser(fields[1]); err != nil {
+						return nil, errors.Wrapf(err, "invalid ACL entry %q", line)
+					}
+					entry.ID = u.UID
+				}
+			}
+		case "g", "group":
+			if entry.Tag = ACLGroupObj; named {
+				entry.Tag = ACLGroup
+				if entry.ID, err = strconv.Atoi(fields[1]); err != nil {
+					var g *Group
+					if g, err = LookupGroup(fields[1]); err != nil {
+						return nil, errors.Wrapf(err, "invalid ACL entry %q", line)
+					}
+					entry.ID = g.GID
+				}
+			}
+		case "m", "mask":
+			entry.Tag = ACLMask
+		case "o", "other":
+			entry.Tag = ACLOther
+		default:
+			return nil, errors.Errorf("invalid ACL entry %q", line)
+		}
+		if (entry.Tag == ACLMask || entry.Tag == ACLOther) && named {
+			return nil, errors.Errorf("invalid ACL entry %q", line)
+		}
+		for _, c := range fields[2] {
+			switch c {
+			case 'r':
+				entry.Perm |= 4
+			case 'w':
+				entry.Perm |= 2
+			case 'x':
+				entry.Perm |= 1
+			case '-':
+			default:
+				return nil, errors.Errorf("invalid ACL entry %q", line)
+			}
+		}
+		acl = append(acl, entry)
+	}
+	return
+}
+
+// GetACL returns the POSIX access ACL of the target following links. Paths without an ACL
+// return the equivalent of their mode bits.
+func GetACL(target string) (acl ACL, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = getXattr(target, XattrACLAccess, true); err != nil {
+		if err != ErrNoXattr && err != unix.ENOTSUP {
+			return nil, errors.Wrapf(err, "failed to get ACL of %s", target)
+		}
+		var info os.FileInfo
+		if info, err = os.Stat(target); err != nil {
+			return nil, errors.Wrapf(err, "failed to get ACL of %s", target)
+		}
+		mode := info.Mode().Perm()
+		return ACL{{Tag: ACLUserObj, ID: -1, Perm: mode >> 6}, {Tag: ACLGroupObj, ID: -1, Perm: mode >> 3 & 7},
+			{Tag: ACLOther, ID: -1, Perm: mode & 7}}, nil
+	}
+	if acl, err = decodeACL(data); err != nil {
+		err = errors.Wrapf(err, "failed to get ACL of %s", target)
+	}
+	return
+}
+
+// GetDefaultACL returns the POSIX default ACL of the target directory following links which is
+// inherited by new children. Directories without a default ACL return an empty ACL.
+func GetDefaultACL(target string) (acl ACL, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = getXattr(target, XattrACLDefault, true); err != nil {
+		if err == ErrNoXattr || err == unix.ENOTSUP {
+			return ACL{}, nil
+		}
+		return nil, errors.Wrapf(err, "failed to get default ACL of %s", target)
+	}
+	if acl, err = decodeACL(data); err != nil {
+		err = errors.Wrapf(err, "failed to get default ACL of %s", target)
+	}
+	return
+}
+
+// SetACL sets the POSIX access ACL of the target following links. The owning user, group and
+// other entries are required as is a mask when there are named entries. The kernel updates the
+// mode bits to match.
+func SetACL(target string, acl ACL) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = encodeACL(acl); err == nil {
+		err = unix.Setxattr(target, XattrACLAccess, data, 0)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set ACL of %s", target)
+	}
+	return
+}
+
+// SetDefaultACL sets the POSIX default ACL of the target directory following links. An empty
+// ACL removes the default ACL.
+func SetDefaultACL(target string, acl ACL) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	if len(acl) == 0 {
+		if err = unix.Removexattr(target, XattrACLDefault); err != nil && xattrError(err) != ErrNoXattr {
+			return errors.Wrapf(err, "failed to remove default ACL of %s", target)
+		}
+		return nil
+	}
+	var data []byte
+	if data, err = encodeACL(acl); err == nil {
+		err = unix.Setxattr(target, XattrACLDefault, data, 0)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set default ACL of %s", target)
+	}
+	return
+}
+
+// decodeACL decodes the xattr encoding of an ACL i.e. a little endian version header followed
+// by tag, perm and id entries
+func decodeACL(data []byte) (acl ACL, err error) {
+	if len(data) < 4 || (len(data)-4)%8 != 0 || binary.LittleEndian.Uint32(data) != gACLVersion {
+		return nil, errors.New("invalid ACL encoding")
+	}
+	acl = ACL{}
+	for i := 4; i < len(data); i += 8 {
+		entry := ACLEntry{
+			Tag:  ACLTag(binary.LittleEndian.Uint16(data[i:])),
+			Perm: os.FileMode(binary.LittleEndian.Uint16(data[i+2:])),
+			ID:   -1,
+		}
+		if entry.Tag == ACLUser || entry.Tag == ACLGroup {
+			entry.ID = int(binary.LittleEndian.Uint32(data[i+4:]))
+		}
+		acl = append(acl, entry)
+	}
+	return
+}
+
+// encodeACL validates the ACL and encodes it in the xattr encoding sorted as the kernel expects
+func encodeACL(acl ACL) (data []byte, err error) {
+	entries := append(ACL{}, acl...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Tag != entries[j].Tag {
+			return entries[i].Tag < entries[j].Tag
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	counts := map[ACLTag]int{}
+	for i, e := range entries {
+		if _, ok := gACLTagNames[e.Tag]; !ok {
+			return nil, errors.Errorf("invalid ACL: unknown tag %#x", uint16(e.Tag))
+		}
+		if e.Perm&^7 != 0 {
+			return nil, errors.Errorf("invalid ACL: invalid permissions %o", e.Perm)
+		}
+		named := e.Tag == ACLUser || e.Tag == ACLGroup
+		if named && e.ID < 0 {
+			return nil, errors.Errorf("invalid ACL: %s entry without an id", gACLTagNames[e.Tag])
+		}
+		if i > 0 && named && entries[i-1].Tag == e.Tag && entries[i-1].ID == e.ID {
+			return nil, errors.Errorf("invalid ACL: duplicate %s entry for %d", gACLTagNames[e.Tag], e.ID)
+		}
+		counts[e.Tag]++
+	}
+	for _, tag := range []ACLTag{ACLUserObj, ACLGroupObj, ACLOther} {
+		if counts[tag] != 1 {
+			return nil, errors.New("invalid ACL: requires exactly one owning user, owning group and other entry")
+		}
+	}
+	if counts[ACLMask] > 1 || (counts[ACLMask] == 0 && counts[ACLUser]+counts[ACLGroup] > 0) {
+		return nil, errors.New("invalid ACL: requires a single mask entry when there are named entries")
+	}
+
+	data = make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(data, gACLVersion)
+	for _, e := range entries {
+		id := uint32(gACLUndefinedID)
+		if e.Tag == ACLUser || e.Tag == ACLGroup {
+			id = uint32(e.ID)
+		}
+		data = binary.LittleEndian.AppendUint16(data, uint16(e.Tag))
+		data = binary.LittleEndian.AppendUint16(data, uint16(e.Perm))
+		data = binary.LittleEndian.AppendUint32(data, id)
+	}
+	return
+}
+
+// ACL returns the POSIX access ACL of the path the info was created for
+func (info *FileInfo) ACL() (acl ACL, err error) {
+	var target string
+	if target, err = info.osPath(); err != nil {
+		return
+	}
+	return GetACL(target)
+}
+
+// gCapNames are the names of the Linux capabilities indexed by their number
+var gCapNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner", "cap_fsetid", "cap_kill",
+	"cap_setgid", "cap_setuid", "cap_setpcap", "cap_linux_immutable", "cap_net_bind_service",
+	"cap_net_broadcast", "cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner", "cap_sys_module",
+	"cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace", "cap_sys_pacct", "cap_sys_admin", "cap_sys_boot",
+	"cap_sys_nice", "cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod", "cap_lease",
+	"cap_audit_write", "cap_audit_control", "cap_setfcap", "cap_mac_override", "cap_mac_admin", "cap_syslog",
+	"cap_wake_alarm", "cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf", "cap_checkpoint_restore",
+}
+
+const (
+	gCapRevision1     = 0x01000000 // single 32 bit set
+	gCapRevision2     = 0x02000000 // two 32 bit sets
+	gCapRevision3     = 0x03000000 // two 32 bit sets and the namespace root uid
+	gCapRevisionMask  = 0xff000000
+	gCapFlagEffective = 0x000001
+)
+
+// FileCaps are the capabilities of an executable as stored in the security.capability xattr
+type FileCaps struct {
+	Effective   bool   // raise the permitted capabilities into the effective set on exec
+	Permitted   uint64 // capabilities permitted regardless of the process's inheritable set
+	Inheritable uint64 // capabilities permitted if also in the process's inheritable set
+	RootID      int    // uid of root in the user namespace the capabilities are for or 0
+}
+
+// NewFileCaps creates new file capabilities permitting the given capabilities by name e.g.
+// cap_net_bind_service or net_bind_service
+func NewFileCaps(effective bool, names ...string) (caps *FileCaps, err error) {
+	caps = &FileCaps{Effective: effective}
+	for _, name := range names {
+		n := capNumber(name)
+		if n == -1 {
+			return nil, errors.Errorf("invalid capability %s", name)
+		}
+		caps.Permitted |= 1 << uint(n)
+	}
+	return
+}
+
+// Has returns true if the given capability by name is permitted
+func (c *FileCaps) Has(name string) bool {
+	n := capNumber(name)
+	return n != -1 && c.Permitted&(1<<uint(n)) != 0
+}
+
+// String returns the capabilities in the text form used by getcap and setcap e.g.
+// cap_net_bind_service,cap_net_raw=ep
+func (c *FileCaps) String() string {
+	clauses, flags := []string{}, map[string]int{}
+	for n := range gCapNames {
+		flag := ""
+		if c.Effective && c.Permitted&(1<<uint(n)) != 0 {
+			flag += "e"
+		}
+		if c.Inheritable&(1<<uint(n)) != 0 {
+			flag += "i"
+		}
+		if c.Permitted&(1<<uint(n)) != 0 {
+			flag += "p"
+		}
+		if flag == "" {
+			continue
+		}
+		if i, ok := flags[flag]; ok {
+			clauses[i] = strings.Replace(clauses[i], "=", ","+gCapNames[n]+"=", 1)
+		} else {
+			flags[flag] = len(clauses)
+			clauses = append(clauses, gCapNames[n]+"="+flag)
+		}
+	}
+	return strings.Join(clauses, " ")
+}
+
+// capNumber returns the number of the given capability by name or -1
+func capNumber(name string) int {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "cap_") {
+		name = "cap_" + name
+	}
+	for n, x := range gCapNames {
+		if x == name {
+			return n
+		}
+	}
+	return -1
+}
+
+// GetCaps returns the file capabilities of the target following links. Files without
+// capabilities return an error wrapping ErrNoXattr.
+func GetCaps(target string) (caps *FileCaps, err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = getXattr(target, XattrCaps, true); err == nil {
+		caps, err = decodeCaps(data)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get capabilities of %s", target)
+	}
+	return
+}
+
+// RemoveCaps removes the file capabilities of the target following links
+func RemoveCaps(target string) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	if err = unix.Removexattr(target, XattrCaps); err != nil && xattrError(err) != ErrNoXattr {
+		return errors.Wrapf(err, "failed to remove capabilities of %s", target)
+	}
+	return nil
+}
+
+// SetCaps sets the file capabilities of the target following links. Requires CAP_SETFCAP and
+// note that changing the owner or content of the file afterwards clears them.
+func SetCaps(target string, caps *FileCaps) (err error) {
+	if target, err = Abs(target); err != nil {
+		return
+	}
+	if err = unix.Setxattr(target, XattrCaps, encodeCaps(caps), 0); err != nil {
+		err = errors.Wrapf(err, "failed to set capabilities of %s", target)
+	}
+	return
+}
+
+// Caps returns the file capabilities of the path the info was created for
+func (info *FileInfo) Caps() (caps *FileCaps, err error) {
+	var target string
+	if target, err = info.osPath(); err != nil {
+		return
+	}
+	return GetCaps(target)
+}
+
+// decodeCaps decodes the vfs_cap_data encoding of file capabilities
+func decodeCaps(data []byte) (caps *FileCaps, err error) {
+	if len(data) < 4 {
+		return nil, errors.New("invalid capabilities encoding")
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	caps = &FileCaps{Effective: magic&gCapFlagEffective != 0}
+	sets := 2
+	switch magic & gCapRevisionMask {
+	case gCapRevision1:
+		sets = 1
+	case gCapRevision2:
+	case gCapRevision3:
+		if len(data) != 4+8*sets+4 {
+			return nil, errors.New("invalid capabilities encoding")
+		}
+		caps.RootID = int(binary.LittleEndian.Uint32(data[4+8*sets:]))
+		data = data[:4+8*sets]
+	default:
+		return nil, errors.Errorf("unsupported capabilities revision %#x", magic&gCapRevisionMask)
+	}
+	if len(data) != 4+8*sets {
+		return nil, errors.New("invalid capabilities encoding")
+	}
+	for i := 0; i < sets; i++ {
+		caps.Permitted |= uint64(binary.LittleEndian.Uint32(data[4+8*i:])) << uint(32*i)
+		caps.Inheritable |= uint64(binary.LittleEndian.Uint32(data[8+8*i:])) << uint(32*i)
+	}
+	return
+}
+
+// encodeCaps encodes the file capabilities as revision 2 or 3 when a namespace root is set
+func encodeCaps(caps *FileCaps) (data []byte) {
+	magic := uint32(gCapRevision2)
+	if caps.RootID != 0 {
+		magic = gCapRevision3
+	}
+	if caps.Effective {
+		magic |= gCapFlagEffective
+	}
+	data = binary.LittleEndian.AppendUint32(nil, magic)
+	for i := 0; i < 2; i++ {
+		data = binary.LittleEndian.AppendUint32(data, uint32(caps.Permitted>>uint(32*i)))
+		data = binary.LittleEndian.AppendUint32(data, uint32(caps.Inheritable>>uint(32*i)))
+	}
+	if caps.RootID != 0 {
+		data = binary.LittleEndian.AppendUint32(data, uint32(caps.RootID))
+	}
+	return
+}
@@ -0,0 +1,11 @@
+//go:build linux
+// +build linux
+
+package sys
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// gErrNoXattr is the error the OS returns for a missing extended attribute
+var gErrNoXattr error = unix.ENODATA
@@ -0,0 +1,212 @@
+package sys
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXattrs(t *testing.T) {
+	resetTest()
+	file := path.Join(tmpDir, "file")
+	link := path.Join(tmpDir, "link")
+	assert.Nil(t, WriteString(file, "data"))
+	assert.Nil(t, os.Symlink("file", link))
+	if err := SetXattr(file, "user.test", []byte("value")); err != nil {
+		return
+	}
+
+	// set, get and list through links
+	{
+		assert.Nil(t, SetXattr(link, "user.other", []byte{}))
+		val, err := GetXattr(link, "user.test")
+		assert.Nil(t, err)
+		assert.Equal(t, "value", string(val))
+		names, err := ListXattrs(file)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"user.other", "user.test"}, names)
+		xattrs, err := GetXattrs(file)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{"user.other": {}, "user.test": []byte("value")}, xattrs)
+
+		names, err = ListXattrs(link, FollowOpt(false))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, names)
+	}
+
+	// file info
+	{
+		info, err := Lstat(file)
+		assert.Nil(t, err)
+		val, err := info.Xattr("user.test")
+		assert.Nil(t, err)
+		assert.Equal(t, "value", string(val))
+		names, err := info.ListXattrs()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(names))
+
+		info, _ = LstatFS(memTree(t), "/src/file")
+		_, err = info.Xattrs()
+		assert.Equal(t, "extended attributes of /src/file are only supported on the OS filesystem: operation not supported", err.Error())
+	}
+
+	// remove
+	{
+		assert.Nil(t, RemoveXattr(file, "user.other"))
+		err := RemoveXattr(file, "user.other")
+		assert.ErrorIs(t, err, ErrNoXattr)
+		_, err = GetXattr(file, "user.bogus")
+		assert.ErrorIs(t, err, ErrNoXattr)
+		abs, _ := Abs(file)
+		assert.Equal(t, "failed to get xattr user.bogus of "+abs+": no such extended attribute", err.Error())
+	}
+
+	// preserved by copy when asked
+	{
+		dst := path.Join(tmpDir, "copy")
+		assert.Nil(t, Copy(file, dst, PreserveOpt(PreserveXattrs)))
+		val, err := GetXattr(dst, "user.test")
+		assert.Nil(t, err)
+		assert.Equal(t, "value", string(val))
+	}
+}
+
+func TestACL(t *testing.T) {
+
+	// text form
+	{
+		acl, err := ParseACL("u::rw-,user:1000:r-x,g::r--,group:0:rw-\nmask::rwx\no::--- # comment")
+		assert.Nil(t, err)
+		assert.Equal(t, ACL{{ACLUserObj, -1, 6}, {ACLUser, 1000, 5}, {ACLGroupObj, -1, 4}, {ACLGroup, 0, 6},
+			{ACLMask, -1, 7}, {ACLOther, -1, 0}}, acl)
+		assert.Equal(t, "user::rw-,user:1000:r-x,group::r--,group:0:rw-,mask::rwx,other::---", acl.String())
+		acl, err = ParseACL("user:root:rwx,m:r")
+		assert.Nil(t, err)
+		assert.Equal(t, ACL{{ACLUser, 0, 7}, {ACLMask, -1, 4}}, acl)
+
+		_, err = ParseACL("bogus::rwx")
+		assert.Equal(t, `invalid ACL entry "bogus::rwx"`, err.Error())
+		_, err = ParseACL("other:0:rwx")
+		assert.Equal(t, `invalid ACL entry "other:0:rwx"`, err.Error())
+	}
+
+	// encoding
+	{
+		acl := ACL{{ACLOther, -1, 4}, {ACLGroup, 10, 6}, {ACLUserObj, -1, 6}, {ACLMask, -1, 6}, {ACLGroupObj, -1, 4}}
+		data, err := encodeACL(acl)
+		assert.Nil(t, err)
+		assert.Equal(t, 4+8*5, len(data))
+		decoded, err := decodeACL(data)
+		assert.Nil(t, err)
+		assert.Equal(t, ACL{{ACLUserObj, -1, 6}, {ACLGroupObj, -1, 4}, {ACLGroup, 10, 6}, {ACLMask, -1, 6},
+			{ACLOther, -1, 4}}, decoded)
+
+		_, err = encodeACL(ACL{{ACLUserObj, -1, 6}, {ACLOther, -1, 4}})
+		assert.Equal(t, "invalid ACL: requires exactly one owning user, owning group and other entry", err.Error())
+		_, err = encodeACL(ACL{{ACLUserObj, -1, 6}, {ACLGroupObj, -1, 4}, {ACLUser, 5, 4}, {ACLOther, -1, 4}})
+		assert.Equal(t, "invalid ACL: requires a single mask entry when there are named entries", err.Error())
+		_, err = decodeACL([]byte{1, 0, 0, 0})
+		assert.Equal(t, "invalid ACL encoding", err.Error())
+	}
+
+	// files
+	{
+		resetTest()
+		dir := path.Join(tmpDir, "dir")
+		assert.Nil(t, os.MkdirAll(dir, 0750))
+		assert.Nil(t, os.Chmod(dir, 0750))
+		acl, err := GetACL(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, "user::rwx,group::r-x,other::---", acl.String())
+
+		acl, _ = ParseACL("u::rwx,u:65534:r-x,g::r-x,m::r-x,o::---")
+		if err = SetACL(dir, acl); err != nil {
+			return
+		}
+		result, err := GetACL(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, acl, result)
+		info, _ := Lstat(dir)
+		result, err = info.ACL()
+		assert.Nil(t, err)
+		assert.Equal(t, acl, result)
+
+		def, err := GetDefaultACL(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, ACL{}, def)
+		assert.Nil(t, SetDefaultACL(dir, acl))
+		assert.Nil(t, WriteString(path.Join(dir, "file"), "data"))
+		result, err = GetACL(path.Join(dir, "file"))
+		assert.Nil(t, err)
+		assert.Equal(t, "user::rw-,user:65534:r-x,group::r-x,mask::r--,other::---", result.String())
+		assert.Nil(t, SetDefaultACL(dir, ACL{}))
+		assert.Nil(t, SetDefaultACL(dir, ACL{}))
+	}
+}
+
+func TestFileCaps(t *testing.T) {
+
+	// construction and text form
+	{
+		caps, err := NewFileCaps(true, "cap_net_bind_service", "NET_RAW")
+		assert.Nil(t, err)
+		assert.True(t, caps.Has("net_raw"))
+		assert.False(t, caps.Has("cap_sys_admin"))
+		caps.Inheritable = 1 << 21
+		assert.Equal(t, "cap_net_bind_service,cap_net_raw=ep cap_sys_admin=i", caps.String())
+		_, err = NewFileCaps(false, "bogus")
+		assert.Equal(t, "invalid capability bogus", err.Error())
+	}
+
+	// encoding
+	{
+		caps := &FileCaps{Effective: true, Permitted: 1<<10 | 1<<40, Inheritable: 1}
+		decoded, err := decodeCaps(encodeCaps(caps))
+		assert.Nil(t, err)
+		assert.Equal(t, caps, decoded)
+		assert.Equal(t, 20, len(encodeCaps(caps)))
+
+		caps.RootID = 100000
+		assert.Equal(t, 24, len(encodeCaps(caps)))
+		decoded, err = decodeCaps(encodeCaps(caps))
+		assert.Nil(t, err)
+		assert.Equal(t, caps, decoded)
+
+		_, err = decodeCaps([]byte{0, 0, 0, 9})
+		assert.Equal(t, "unsupported capabilities revision 0x9000000", err.Error())
+	}
+
+	// files
+	{
+		resetTest()
+		file := path.Join(tmpDir, "file")
+		assert.Nil(t, WriteString(file, "data"))
+		_, err := GetCaps(file)
+		assert.ErrorIs(t, err, ErrNoXattr)
+
+		caps, _ := NewFileCaps(true, "net_bind_service")
+		if err = SetCaps(file, caps); err != nil {
+			return
+		}
+		result, err := GetCaps(file)
+		assert.Nil(t, err)
+		assert.Equal(t, caps, result)
+		info, _ := Lstat(file)
+		result, err = info.Caps()
+		assert.Nil(t, err)
+		assert.Equal(t, "cap_net_bind_service=ep", result.String())
+
+		dst := path.Join(tmpDir, "copy")
+		assert.Nil(t, Copy(file, dst, PreserveOpt(PreserveAll)))
+		result, err = GetCaps(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, caps, result)
+
+		assert.Nil(t, RemoveCaps(file))
+		assert.Nil(t, RemoveCaps(file))
+		_, err = GetCaps(file)
+		assert.ErrorIs(t, err, ErrNoXattr)
+	}
+}
@@ -0,0 +1,84 @@
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// CacheDir returns the XDG cache directory for the given app i.e. $XDG_CACHE_HOME/app
+// defaulting to ~/.cache/app. The home directory is the real user's behind the sudo mask.
+// An empty app returns the base cache directory.
+func CacheDir(app string) (string, error) {
+	return xdgDir("XDG_CACHE_HOME", ".cache", app)
+}
+
+// ConfigDir returns the XDG config directory for the given app i.e. $XDG_CONFIG_HOME/app
+// defaulting to ~/.config/app. The home directory is the real user's behind the sudo mask.
+// An empty app returns the base config directory.
+func ConfigDir(app string) (string, error) {
+	return xdgDir("XDG_CONFIG_HOME", ".config", app)
+}
+
+// DataDir returns the XDG data directory for the given app i.e. $XDG_DATA_HOME/app
+// defaulting to ~/.local/share/app. The home directory is the real user's behind the sudo
+// mask. An empty app returns the base data directory.
+func DataDir(app string) (string, error) {
+	return xdgDir("XDG_DATA_HOME", ".local/share", app)
+}
+
+// RuntimeDir returns the XDG runtime directory i.e. $XDG_RUNTIME_DIR defaulting to
+// /run/user/UID for the real user behind the sudo mask when it exists
+func RuntimeDir() (dir string, err error) {
+	if dir = os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		dir = filepath.Clean(dir)
+		return
+	}
+
+	var u *User
+	if u, err = CurrentUser(); err != nil {
+		return "", errors.Wrap(err, "failed to compute the runtime directory")
+	}
+	uid := u.RealUID
+	if uid == -1 {
+		uid = u.UID
+	}
+	dir = fmt.Sprintf("/run/user/%d", uid)
+	if !IsDir(dir) {
+		return "", errors.Errorf("failed to compute the runtime directory: $XDG_RUNTIME_DIR is not defined and %s doesn't exist", dir)
+	}
+	return
+}
+
+// xdgDir returns the app directory under the given XDG environment variable's value
+// defaulting to the given directory relative to the real user's home. Relative values are
+// ignored as required by the XDG base directory specification.
+func xdgDir(key, fallback, app string) (dir string, err error) {
+	if dir = os.Getenv(key); !filepath.IsAbs(dir) {
+		var home string
+		if home, err = realHome(); err != nil {
+			return "", errors.Wrapf(err, "failed to compute the %s directory", key)
+		}
+		dir = path.Join(home, fallback)
+	}
+	dir = path.Join(dir, app)
+	return
+}
+
+// realHome returns the real user's home directory behind the sudo mask
+func realHome() (home string, err error) {
+	var u *User
+	if u, err = CurrentUser(); err != nil {
+		return
+	}
+	if home = u.RealHome; home == "" {
+		home = u.Home
+	}
+	if home == "" {
+		err = errors.Errorf("user %s has no home directory", u.Name)
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package sys
+
+import (
+	"os"
+	"os/user"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setenvTest sets the given environment variable restoring it when the test completes
+func setenvTest(t *testing.T, key, val string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, val)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestXDGDirs(t *testing.T) {
+	u, err := CurrentUser()
+	assert.Nil(t, err)
+	home := u.RealHome
+	if home == "" {
+		home = u.Home
+	}
+
+	// environment
+	{
+		setenvTest(t, "XDG_CONFIG_HOME", "/xdg/config/")
+		setenvTest(t, "XDG_CACHE_HOME", "/xdg/cache")
+		setenvTest(t, "XDG_DATA_HOME", "/xdg/data")
+		setenvTest(t, "XDG_RUNTIME_DIR", "/xdg/runtime/")
+
+		dir, err := ConfigDir("app")
+		assert.Nil(t, err)
+		assert.Equal(t, "/xdg/config/app", dir)
+		dir, err = CacheDir("app")
+		assert.Nil(t, err)
+		assert.Equal(t, "/xdg/cache/app", dir)
+		dir, err = DataDir("")
+		assert.Nil(t, err)
+		assert.Equal(t, "/xdg/data", dir)
+		dir, err = RuntimeDir()
+		assert.Nil(t, err)
+		assert.Equal(t, "/xdg/runtime", dir)
+	}
+
+	// defaults ignoring relative values
+	{
+		setenvTest(t, "XDG_CONFIG_HOME", "relative")
+		setenvTest(t, "XDG_CACHE_HOME", "")
+		setenvTest(t, "XDG_DATA_HOME", "")
+
+		dir, err := ConfigDir("app")
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(home, ".config/app"), dir)
+		dir, err = CacheDir("app")
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(home, ".cache/app"), dir)
+		dir, err = DataDir("app")
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(home, ".local/share/app"), dir)
+	}
+
+	// real user behind sudo
+	if nobody, err := user.LookupId("65534"); err == nil && UserIsRoot() {
+		setenvTest(t, "SUDO_UID", "65534")
+		setenvTest(t, "XDG_CONFIG_HOME", "")
+		setenvTest(t, "XDG_RUNTIME_DIR", "")
+
+		dir, err := ConfigDir("app")
+		assert.Nil(t, err)
+		assert.Equal(t, path.Join(nobody.HomeDir, ".config/app"), dir)
+		_, err = RuntimeDir()
+		assert.Equal(t, "failed to compute the runtime directory: $XDG_RUNTIME_DIR is not defined and /run/user/65534 doesn't exist", err.Error())
+	}
+}
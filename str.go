@@ -6,6 +6,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/phR0ze/n/pkg/sys"
 	"github.com/pkg/errors"
 )
 
@@ -911,6 +912,16 @@ func (p *Str) Prepend(elem interface{}) ISlice {
 	return p.Insert(0, elem)
 }
 
+// Quote returns a new Str that is safe to use as a single word in a POSIX shell. Strs made
+// up entirely of safe characters are returned as is, all others are wrapped in single quotes
+// with embedded single quotes escaped. Pass through to sys.Quote
+func (p *Str) Quote() (new *Str) {
+	if p == nil {
+		return ToStr(sys.Quote(""))
+	}
+	return ToStr(sys.Quote(p.A()))
+}
+
 // RefSlice returns true if the underlying implementation is a RefSlice
 func (p *Str) RefSlice() bool {
 	return false
@@ -1209,6 +1220,26 @@ func (p *Str) SplitQuotes() (slice *StringSlice, err error) {
 	return
 }
 
+// SplitWords splits this Str into words following POSIX sh rules for whitespace, backslash
+// escapes, single and double quotes. Adjacent quoted segments form a single word and empty
+// quotes produce an empty word. When an env map is given $VAR, ${VAR} and ${VAR:-default}
+// forms are expanded against it. Unterminated quotes throw an error. Pass through to
+// sys.SplitWords
+func (p *Str) SplitWords(env ...map[string]string) (slice *StringSlice, err error) {
+	if p == nil || len(*p) == 0 {
+		slice = NewStringSliceV()
+		return
+	}
+
+	var words []string
+	if words, err = sys.SplitWords(p.A(), env...); err != nil {
+		slice = NewStringSliceV()
+		return
+	}
+	slice = ToStringSlice(words)
+	return
+}
+
 // String returns a string representation of this Slice, implements the Stringer interface
 func (p *Str) String() string {
 	if p == nil {
@@ -3253,6 +3253,29 @@ func TestStr_Prepend(t *testing.T) {
 	}
 }
 
+// Quote
+// --------------------------------------------------------------------------------------------------
+func ExampleStr_Quote() {
+	fmt.Println(NewStr("it's here").Quote())
+	// Output: 'it'"'"'s here'
+}
+
+func TestStr_Quote(t *testing.T) {
+
+	// nil or empty
+	{
+		assert.Equal(t, NewStr("''"), (*Str)(nil).Quote())
+		assert.Equal(t, NewStr("''"), NewStrV().Quote())
+	}
+
+	// safe and unsafe
+	{
+		assert.Equal(t, NewStr("foo/bar.go"), NewStr("foo/bar.go").Quote())
+		assert.Equal(t, NewStr("'foo bar'"), NewStr("foo bar").Quote())
+		assert.Equal(t, NewStr(`'$HOME'`), NewStr("$HOME").Quote())
+	}
+}
+
 // Replace
 // --------------------------------------------------------------------------------------------------
 func BenchmarkStr_Replace_Go(t *testing.B) {
@@ -4222,6 +4245,50 @@ func TestStr_SplitQuotes(t *testing.T) {
 	}
 }
 
+// SplitWords
+// --------------------------------------------------------------------------------------------------
+func ExampleStr_SplitWords() {
+	slice, _ := NewStr(`cp "my file" 'your file'`).SplitWords()
+	fmt.Println(slice.Len())
+	// Output: 3
+}
+
+func TestStr_SplitWords(t *testing.T) {
+
+	// nil or empty
+	{
+		slice, err := (*Str)(nil).SplitWords()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, slice.O())
+
+		slice, err = NewStrV().SplitWords()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, slice.O())
+	}
+
+	// quotes and escapes
+	{
+		slice, err := NewStr(` a"b c"d 'e f' g\ h "" `).SplitWords()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"ab cd", "e f", "g h", ""}, slice.O())
+	}
+
+	// expansion
+	{
+		env := map[string]string{"FOO": "foo"}
+		slice, err := NewStr(`$FOO "${BAR:-bar} baz" '$FOO'`).SplitWords(env)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"foo", "bar baz", "$FOO"}, slice.O())
+	}
+
+	// error cases
+	{
+		slice, err := NewStr(`foo "bar`).SplitWords()
+		assert.Equal(t, "unterminated double quote at offset 4", err.Error())
+		assert.Equal(t, []string{}, slice.O())
+	}
+}
+
 // String
 // --------------------------------------------------------------------------------------------------
 func BenchmarkStr_String_Go(t *testing.B) {
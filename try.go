@@ -0,0 +1,134 @@
+package n
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pipe provides a typed pipeline of steps returning (T, error) that short-circuits on the
+// first error encountered. Start a pipeline with Try and chain further steps with Then.
+// Unlike IF.Do the step signatures are checked at compile time.
+type Pipe[T any] struct {
+	Val   T        // Value returned by the last successful step
+	Err   error    // First error encountered wrapped with its step context
+	Steps []string // Names of the steps executed in order
+}
+
+// Retry provides a retry policy with exponential backoff for pipeline steps
+type Retry struct {
+	Attempts int              // Total attempts including the first, defaults to 1
+	Delay    time.Duration    // Delay before the first retry
+	Factor   float64          // Multiplier applied to the delay after each retry, defaults to 1
+	MaxDelay time.Duration    // Maximum delay between retries, 0 means no maximum
+	If       func(error) bool // Only retry errors this returns true for, nil retries all errors
+}
+
+// Try starts a new pipeline by executing the given step f. The optional retry policy will
+// be used to re-execute f on failure. Errors are wrapped with the step name for context.
+func Try[T any](name string, f func() (T, error), retry ...*Retry) *Pipe[T] {
+	p := &Pipe[T]{Steps: []string{}}
+	p.Val, p.Err = run(len(p.Steps)+1, name, f, retry)
+	p.Steps = append(p.Steps, name)
+	return p
+}
+
+// Then executes the given step f with the value of the previous step if the pipeline has not
+// yet failed, returning a new pipeline of the step's type. Once failed all following steps are
+// skipped and the original error is carried through. The optional retry policy will be used
+// to re-execute f on failure.
+func Then[T, U any](p *Pipe[T], name string, f func(T) (U, error), retry ...*Retry) *Pipe[U] {
+	if p == nil {
+		p = &Pipe[T]{}
+	}
+	next := &Pipe[U]{Err: p.Err, Steps: append([]string{}, p.Steps...)}
+	if p.Err != nil {
+		return next
+	}
+	next.Val, next.Err = run(len(next.Steps)+1, name, func() (U, error) { return f(p.Val) }, retry)
+	next.Steps = append(next.Steps, name)
+	return next
+}
+
+// run executes the given step according to the retry policy and wraps any errors with context
+func run[T any](step int, name string, f func() (T, error), retry []*Retry) (val T, err error) {
+	policy := &Retry{}
+	if len(retry) > 0 && retry[0] != nil {
+		policy = retry[0]
+	}
+	attempts := policy.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	factor := policy.Factor
+	if factor <= 0 {
+		factor = 1
+	}
+
+	delay := policy.Delay
+	attempt := 1
+	for ; attempt <= attempts; attempt++ {
+		if val, err = f(); err == nil {
+			return
+		}
+		if attempt == attempts || (policy.If != nil && !policy.If(err)) {
+			break
+		}
+
+		// Backoff before the next attempt
+		time.Sleep(delay)
+		delay = time.Duration(float64(delay) * factor)
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+
+	if attempt > 1 {
+		err = errors.Wrapf(err, "failed step %d %s after %d attempts", step, name, attempt)
+	} else {
+		err = errors.Wrapf(err, "failed step %d %s", step, name)
+	}
+	return
+}
+
+// Else executes the given function f with the pipeline's error if the pipeline has failed
+// allowing for recovery. When f succeeds the pipeline's error is cleared and its value set
+// to the value returned from f. When f fails its error replaces the pipeline's error.
+func (p *Pipe[T]) Else(f func(err error) (T, error)) *Pipe[T] {
+	if p == nil || p.Err == nil {
+		return p
+	}
+	var val T
+	var err error
+	if val, err = f(p.Err); err != nil {
+		p.Err = err
+		return p
+	}
+	p.Val, p.Err = val, nil
+	return p
+}
+
+// Finally executes the given function f with the pipeline's value and error regardless of
+// whether the pipeline has failed or not. Useful for cleanup.
+func (p *Pipe[T]) Finally(f func(val T, err error)) *Pipe[T] {
+	if p == nil {
+		var val T
+		f(val, nil)
+		return p
+	}
+	f(p.Val, p.Err)
+	return p
+}
+
+// Ok returns true if the pipeline has not failed
+func (p *Pipe[T]) Ok() bool {
+	return p == nil || p.Err == nil
+}
+
+// Result returns the pipeline's value and error
+func (p *Pipe[T]) Result() (val T, err error) {
+	if p == nil {
+		return
+	}
+	return p.Val, p.Err
+}
@@ -0,0 +1,176 @@
+package n
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleTry() {
+	p := Then(Try("read", func() (string, error) { return "42", nil }), "parse", strconv.Atoi)
+	fmt.Println(p.Result())
+	// Output: 42 <nil>
+}
+
+func TestTry(t *testing.T) {
+
+	// success
+	{
+		p := Try("foo", func() (string, error) { return "foo", nil })
+		assert.True(t, p.Ok())
+		assert.Equal(t, "foo", p.Val)
+		assert.Nil(t, p.Err)
+		assert.Equal(t, []string{"foo"}, p.Steps)
+	}
+
+	// failure is wrapped with step context
+	{
+		p := Try("foo", func() (string, error) { return "", errors.New("boom") })
+		assert.False(t, p.Ok())
+		assert.Equal(t, "failed step 1 foo: boom", p.Err.Error())
+		assert.Equal(t, "boom", errors.Cause(p.Err).Error())
+	}
+}
+
+func TestThen(t *testing.T) {
+
+	// chain of differing types
+	{
+		p1 := Try("read", func() (string, error) { return "42", nil })
+		p2 := Then(p1, "parse", strconv.Atoi)
+		p3 := Then(p2, "double", func(x int) (float64, error) { return float64(x * 2), nil })
+		val, err := p3.Result()
+		assert.Nil(t, err)
+		assert.Equal(t, float64(84), val)
+		assert.Equal(t, []string{"read", "parse", "double"}, p3.Steps)
+	}
+
+	// short circuit on error
+	{
+		called := false
+		p1 := Try("read", func() (string, error) { return "bob", nil })
+		p2 := Then(p1, "parse", strconv.Atoi)
+		p3 := Then(p2, "double", func(x int) (int, error) { called = true; return x * 2, nil })
+		val, err := p3.Result()
+		assert.False(t, called)
+		assert.Equal(t, 0, val)
+		assert.Equal(t, `failed step 2 parse: strconv.Atoi: parsing "bob": invalid syntax`, err.Error())
+		assert.Equal(t, []string{"read", "parse"}, p3.Steps)
+	}
+
+	// nil pipe
+	{
+		var p *Pipe[int]
+		val, err := Then(p, "inc", func(x int) (int, error) { return x + 1, nil }).Result()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, val)
+	}
+}
+
+func TestPipe_Else(t *testing.T) {
+
+	// not called on success
+	{
+		p := Try("foo", func() (int, error) { return 1, nil }).Else(func(err error) (int, error) { return 2, nil })
+		assert.Equal(t, 1, p.Val)
+	}
+
+	// recovers from failure
+	{
+		var given error
+		p := Try("foo", func() (int, error) { return 0, errors.New("boom") }).Else(func(err error) (int, error) {
+			given = err
+			return 2, nil
+		})
+		assert.Equal(t, "failed step 1 foo: boom", given.Error())
+		assert.True(t, p.Ok())
+		assert.Equal(t, 2, p.Val)
+	}
+
+	// replaces the error
+	{
+		p := Try("foo", func() (int, error) { return 0, errors.New("boom") }).Else(func(err error) (int, error) {
+			return 0, errors.Wrap(err, "still broken")
+		})
+		assert.Equal(t, "still broken: failed step 1 foo: boom", p.Err.Error())
+	}
+
+	// nil
+	{
+		var p *Pipe[int]
+		assert.Nil(t, p.Else(func(err error) (int, error) { return 1, nil }))
+	}
+}
+
+func TestPipe_Finally(t *testing.T) {
+
+	// called on success
+	{
+		var got int
+		Try("foo", func() (int, error) { return 1, nil }).Finally(func(val int, err error) { got = val })
+		assert.Equal(t, 1, got)
+	}
+
+	// called on failure
+	{
+		var got error
+		p1 := Try("foo", func() (int, error) { return 0, errors.New("boom") })
+		Then(p1, "bar", func(x int) (int, error) { return x, nil }).Finally(func(val int, err error) { got = err })
+		assert.Equal(t, "failed step 1 foo: boom", got.Error())
+	}
+
+	// nil
+	{
+		called := false
+		var p *Pipe[int]
+		p.Finally(func(val int, err error) { called = true })
+		assert.True(t, called)
+		assert.True(t, p.Ok())
+	}
+}
+
+func TestRetry(t *testing.T) {
+
+	// succeeds after retries
+	{
+		attempts := 0
+		p := Try("flaky", func() (int, error) {
+			attempts++
+			if attempts < 3 {
+				return 0, errors.New("boom")
+			}
+			return attempts, nil
+		}, &Retry{Attempts: 5, Delay: time.Millisecond, Factor: 2})
+		assert.True(t, p.Ok())
+		assert.Equal(t, 3, p.Val)
+	}
+
+	// exhausts attempts with capped backoff
+	{
+		attempts := 0
+		start := time.Now()
+		p := Then(Try("foo", func() (int, error) { return 1, nil }), "flaky", func(x int) (int, error) {
+			attempts++
+			return 0, errors.New("boom")
+		}, &Retry{Attempts: 4, Delay: time.Millisecond, Factor: 10, MaxDelay: 5 * time.Millisecond})
+		assert.Equal(t, 4, attempts)
+		assert.True(t, time.Since(start) >= 11*time.Millisecond)
+		assert.Equal(t, "failed step 2 flaky after 4 attempts: boom", p.Err.Error())
+	}
+
+	// only retries matching errors
+	{
+		attempts := 0
+		fatal := errors.New("fatal")
+		p := Try("foo", func() (int, error) {
+			attempts++
+			return 0, fatal
+		}, &Retry{Attempts: 3, If: func(err error) bool { return err != fatal }})
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, "failed step 1 foo: fatal", p.Err.Error())
+	}
+}